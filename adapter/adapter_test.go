@@ -0,0 +1,352 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// TestCommitGeneration has a zombie keep committing for its old
+// generation after the new owner has taken over
+func TestCommitGeneration(t *testing.T) {
+	broker := NewSimBroker(0, 0)
+	if err := broker.CommitGeneration(context.Background(), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.CommitGeneration(context.Background(), 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.CommitGeneration(context.Background(), 1, 5); !errors.Is(err, tracker.ErrStaleGeneration) {
+		t.Errorf("zombie commit = %v, want ErrStaleGeneration", err)
+	}
+	if got := broker.Committed(); got != 1 {
+		t.Errorf("broker committed = %d, want 1", got)
+	}
+}
+
+// TestBreakerGeneration fences a zombie's commits through a circuit
+// breaker, which passes the generation on to the broker it wraps
+func TestBreakerGeneration(t *testing.T) {
+	broker := NewSimBroker(0, 0)
+	if err := broker.CommitGeneration(context.Background(), 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	breaker := &CircuitBreaker{Broker: broker, Threshold: 1, Cooldown: time.Hour}
+	zombie := tracker.New(backend.NewMap(0), -1)
+	c := NewCommitter(zombie, breaker, time.Hour)
+	if err := zombie.AckGeneration(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for i := 0; i < 2; i++ {
+		if err := c.Flush(context.Background(), ctx); !errors.Is(err, tracker.ErrStaleGeneration) {
+			t.Fatalf("zombie flush = %v, want ErrStaleGeneration", err)
+		}
+	}
+	if trips, _, _ := breaker.Stats(); trips != 0 {
+		t.Errorf("breaker tripped %d times on stale generations", trips)
+	}
+	if err := zombie.Fence(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(context.Background(), ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := broker.Committed(); got != 0 {
+		t.Errorf("broker committed = %d, want 0", got)
+	}
+}
+
+// TestCommitterClock commits on the committer's clock, not the wall's
+func TestCommitterClock(t *testing.T) {
+	clock := tracker.NewFakeClock(time.Unix(0, 0))
+	b, _ := backend.New("map", 0)
+	tr := tracker.New(b, -1)
+	broker := NewSimBroker(time.Second, 0)
+	broker.Clock = clock
+	c := NewCommitter(tr, broker, time.Minute)
+	c.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for offset := int64(0); offset < 10; offset++ {
+		if err := tr.Ack(offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the ticker, then the broker's latency
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	clock.BlockUntil(2)
+	if got := broker.Committed(); got != -1 {
+		t.Fatalf("broker has %d before its latency is up", got)
+	}
+	clock.Advance(time.Second)
+	for deadline := time.Now().Add(5 * time.Second); broker.Committed() != 9; {
+		if time.Now().After(deadline) {
+			t.Fatalf("broker has %d, want 9", broker.Committed())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestCommitterMaxAdvance commits once the watermark has moved far enough,
+// long before the interval is up
+func TestCommitterMaxAdvance(t *testing.T) {
+	clock := tracker.NewFakeClock(time.Unix(0, 0))
+	tr := tracker.New(backend.NewMap(0), -1)
+	broker := NewSimBroker(0, 0)
+	c := NewCommitter(tr, broker, time.Hour)
+	c.Clock, c.MaxAdvance = clock, 100
+	tr.Hooks = tracker.Hooks{AdvanceBy: 10, OnAdvance: c.Advanced}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for offset := int64(0); offset <= 150; offset++ {
+		if err := tr.Ack(offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); broker.Committed() <= 100; {
+		if time.Now().After(deadline) {
+			t.Fatalf("broker has %d, want over 100 without the interval passing", broker.Committed())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// heldBroker holds the commits of the offsets in held until they are sent
+// their outcome, the rest go through right away
+type heldBroker struct {
+	mu   sync.Mutex
+	held map[int64]chan error
+	last int64
+	// started gets the offset of every held commit as it starts
+	started chan int64
+}
+
+func (b *heldBroker) Commit(ctx context.Context, offset int64) error {
+	b.mu.Lock()
+	ch := b.held[offset]
+	b.mu.Unlock()
+	if ch != nil {
+		b.started <- offset
+		if err := <-ch; err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	b.last = offset
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *heldBroker) committed() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
+
+// TestCommitterInFlight finishes commits out of order: an older one
+// failing late is superseded, an older one going through late has the
+// newer one committed again
+func TestCommitterInFlight(t *testing.T) {
+	tr := tracker.New(backend.NewMap(0), -1)
+	broker := &heldBroker{held: map[int64]chan error{9: make(chan error), 29: make(chan error)}, last: -1, started: make(chan int64, 1)}
+	c := NewCommitter(tr, broker, time.Hour)
+	c.MaxInFlight = 2
+	var mu sync.Mutex
+	var reported []error
+	c.Report = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	ackTo := func(to int64) {
+		for offset := tr.Committed() + 1; offset <= to; offset++ {
+			if err := tr.Ack(offset); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the flush of 9 waits for its commit, the one of 19 overtakes it
+	flush := func() chan error {
+		flushed := make(chan error, 1)
+		go func() { flushed <- c.Flush(context.Background(), ctx) }()
+		return flushed
+	}
+	ackTo(9)
+	older := flush()
+	<-broker.started
+	ackTo(19)
+	if err := <-flush(); err != nil {
+		t.Fatal(err)
+	}
+	broker.held[9] <- errors.New("late failure")
+	if err := <-older; err != nil {
+		t.Errorf("superseded flush = %v, want nil", err)
+	}
+	if got := broker.committed(); got != 19 {
+		t.Errorf("broker has %d, want 19", got)
+	}
+
+	ackTo(29)
+	older = flush()
+	<-broker.started
+	ackTo(39)
+	if err := <-flush(); err != nil {
+		t.Fatal(err)
+	}
+	broker.held[29] <- nil
+	if err := <-older; err != nil {
+		t.Errorf("superseded flush = %v, want nil", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); broker.committed() != 39; {
+		if time.Now().After(deadline) {
+			t.Fatalf("broker has %d, want 39 committed again", broker.committed())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := c.Superseded(); got != 2 {
+		t.Errorf("superseded %d, want 2", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, err := range reported {
+		if err != nil {
+			t.Errorf("reported %v, superseded commits must not be", err)
+		}
+	}
+}
+
+// TestCheckpoint takes a checkpoint while acks carry on past the barrier:
+// the snapshot and the broker must both see the barrier, not the acks
+func TestCheckpoint(t *testing.T) {
+	tr := tracker.New(backend.NewMap(0), -1)
+	st := tracker.NewSync(tr)
+	broker := NewSimBroker(0, 0)
+	c := NewCommitter(tr, broker, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	cp := &Checkpointer{
+		Tracker: st,
+		Flush:   func(fctx context.Context) error { return c.Flush(fctx, ctx) },
+		Poll:    time.Millisecond,
+	}
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+		// the barrier's gap is filled last
+		for offset := int64(99); offset >= 0; offset-- {
+			st.Ack(offset)
+		}
+	}()
+	var snapshotAt int64 = -1
+	err := cp.Checkpoint(context.Background(), 49, func(_ context.Context, barrier int64) error {
+		snapshotAt = st.Committed()
+		return nil
+	})
+	<-acked
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshotAt != 49 || broker.Committed() != 49 {
+		t.Errorf("snapshot at %d, broker has %d, want both at the barrier 49", snapshotAt, broker.Committed())
+	}
+	if got := st.Committed(); got != 99 {
+		t.Errorf("committed %d after the checkpoint, want 99", got)
+	}
+	if err := cp.Checkpoint(context.Background(), 10, nil); !errors.Is(err, tracker.ErrOffsetBelowWatermark) {
+		t.Errorf("checkpoint behind the watermark = %v, want ErrOffsetBelowWatermark", err)
+	}
+}
+
+// TestPreCommitVeto commits nothing while the sink isn't durable, and the
+// same watermark once it is
+func TestPreCommitVeto(t *testing.T) {
+	tr := tracker.New(backend.NewMap(0), -1)
+	broker := NewSimBroker(0, 0)
+	c := NewCommitter(tr, broker, time.Hour)
+	notYet := errors.New("sink not flushed")
+	var prepared []int64
+	c.PreCommit = func(_ context.Context, offset int64) error {
+		prepared = append(prepared, offset)
+		if len(prepared) == 1 {
+			return notYet
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for offset := int64(0); offset < 10; offset++ {
+		if err := tr.Ack(offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Flush(context.Background(), ctx); !errors.Is(err, ErrVetoed) || !errors.Is(err, notYet) {
+		t.Errorf("vetoed flush = %v, want ErrVetoed and the sink's error", err)
+	}
+	if got := broker.Committed(); got != -1 {
+		t.Errorf("broker has %d after the veto", got)
+	}
+	if err := c.Flush(context.Background(), ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := broker.Committed(); got != 9 || c.Vetoes() != 1 || len(prepared) != 2 || prepared[1] != 9 {
+		t.Errorf("broker has %d, %d vetoes, prepared %v, want 9 after one veto", got, c.Vetoes(), prepared)
+	}
+}
@@ -0,0 +1,121 @@
+package adapter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// pipeline is the state of a committer's asynchronous commits, see
+// MaxInFlight
+type pipeline struct {
+	// slots holds a token for every commit on its way
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu sync.Mutex
+	// acked is the newest offset the broker confirmed and sent the
+	// newest one on its way or confirmed.  sent falls back to acked
+	// when its commit fails, so that Run sends it again.
+	acked, sent int64
+}
+
+func newPipeline(maxInFlight int, committed int64) *pipeline {
+	return &pipeline{slots: make(chan struct{}, maxInFlight), acked: committed, sent: committed}
+}
+
+func (p *pipeline) lastSent() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sent
+}
+
+func (p *pipeline) isAcked(offset int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.acked >= offset
+}
+
+// dispatch commits offset in the background once there is a slot for it,
+// and sends the reconciled outcome to flushed if that is not nil.  It
+// returns false if ctx was done before there was a slot.
+//
+// Commits can finish in any order, so their outcomes are reconciled with
+// the newest offset the broker confirmed: a commit that fails after a
+// newer one went through is superseded rather than a failure, nothing is
+// reported and no flush sees its error.  One that goes through after a
+// newer one may have put the broker back, so the newer one is committed
+// again.
+func (c *Committer) dispatch(ctx context.Context, p *pipeline, offset int64, flushed chan error) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	p.mu.Lock()
+	if offset > p.sent {
+		p.sent = offset
+	}
+	p.mu.Unlock()
+	c.send(ctx, p, offset, flushed)
+	return true
+}
+
+// send commits offset in the background in a slot already taken
+func (c *Committer) send(ctx context.Context, p *pipeline, offset int64, flushed chan error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		err := c.commit(ctx, offset)
+		<-p.slots
+		if c.OnDone != nil {
+			c.OnDone(offset, err)
+		}
+		err = c.settle(ctx, p, offset, err)
+		if flushed != nil {
+			flushed <- err
+		}
+	}()
+}
+
+// settle reconciles the outcome of the commit of offset, see dispatch, and
+// returns what is left of its error
+func (c *Committer) settle(ctx context.Context, p *pipeline, offset int64, err error) error {
+	p.mu.Lock()
+	old := p.acked
+	switch {
+	case err == nil && offset > old:
+		p.acked = offset
+		if c.OnCommit != nil {
+			c.OnCommit(old, offset)
+		}
+	case err == nil && offset == old:
+		// a flush sending the watermark again
+	case err == nil:
+		atomic.AddInt64(&c.superseded, 1)
+		p.mu.Unlock()
+		select {
+		case p.slots <- struct{}{}:
+			c.send(ctx, p, old, nil)
+		case <-ctx.Done():
+		}
+		return nil
+	case offset <= old:
+		atomic.AddInt64(&c.superseded, 1)
+		p.mu.Unlock()
+		return nil
+	case offset == p.sent:
+		p.sent = old
+	}
+	if c.Report != nil {
+		c.Report(err)
+	}
+	p.mu.Unlock()
+	return err
+}
+
+// Superseded returns the number of asynchronous commits that finished after
+// a newer one had gone through, see dispatch
+func (c *Committer) Superseded() int64 {
+	return atomic.LoadInt64(&c.superseded)
+}
@@ -0,0 +1,94 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// ErrBreakerOpen is returned by CircuitBreaker while it is open
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// CircuitBreaker stops commits reaching a broker that keeps failing.  After
+// Threshold failures in a row it opens and fails every commit straight
+// away for Cooldown.  The first commit after that is let through as a
+// probe: if it succeeds the breaker closes, otherwise it stays open for
+// another cooldown.  The tracker keeps moving the whole time, so the probe
+// commits the latest watermark and nothing acked while open is lost.
+//
+// Unlike SimBroker it must not be called concurrently, so it doesn't go
+// with a Committer's MaxInFlight.
+type CircuitBreaker struct {
+	Broker
+	Threshold int
+	Cooldown  time.Duration
+	// Clock times the cooldown, the real clock if nil
+	Clock tracker.Clock
+
+	failures int
+	open     bool
+	openedAt time.Time
+	// trips counts the times the breaker opened, openFor is the time it
+	// spent open, rejected the commits it failed without trying
+	trips    int64
+	openFor  time.Duration
+	rejected int64
+}
+
+// Commit implements Broker
+func (b *CircuitBreaker) Commit(ctx context.Context, offset int64) error {
+	return b.try(func() error { return b.Broker.Commit(ctx, offset) })
+}
+
+// CommitGeneration implements GenerationBroker, passing the generation on
+// if the broker it wraps fences commits and committing without it if not.
+// A stale generation is the broker working as it should, it neither opens
+// nor closes the breaker.
+func (b *CircuitBreaker) CommitGeneration(ctx context.Context, generation, offset int64) error {
+	return b.try(func() error { return commitGeneration(ctx, b.Broker, generation, offset) })
+}
+
+// try runs commit unless the breaker is open, and opens or closes it
+// according to the result
+func (b *CircuitBreaker) try(commit func() error) error {
+	if b.open && since(b.Clock, b.openedAt) < b.Cooldown {
+		b.rejected++
+		return ErrBreakerOpen
+	}
+	err := commit()
+	if errors.Is(err, tracker.ErrStaleGeneration) {
+		return err
+	}
+	if err == nil {
+		if b.open {
+			b.open = false
+			b.openFor += since(b.Clock, b.openedAt)
+		}
+		b.failures = 0
+		return nil
+	}
+	b.failures++
+	switch {
+	case b.open:
+		// the probe failed
+		b.openFor += since(b.Clock, b.openedAt)
+		b.openedAt = orReal(b.Clock).Now()
+	case b.failures >= b.Threshold:
+		b.open = true
+		b.openedAt = orReal(b.Clock).Now()
+		b.trips++
+	}
+	return err
+}
+
+// Stats returns trips, time spent open and rejected commits, including the
+// current open spell
+func (b *CircuitBreaker) Stats() (int64, time.Duration, int64) {
+	openFor := b.openFor
+	if b.open {
+		openFor += since(b.Clock, b.openedAt)
+	}
+	return b.trips, openFor, b.rejected
+}
@@ -0,0 +1,110 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+var errDown = errors.New("broker down")
+
+// flakyBroker fails every commit while down, and counts the commits that
+// reach it
+type flakyBroker struct {
+	down    bool
+	commits int
+}
+
+func (b *flakyBroker) Commit(ctx context.Context, offset int64) error {
+	b.commits++
+	if b.down {
+		return errDown
+	}
+	return nil
+}
+
+// TestBreakerTrips opens the breaker after Threshold failures in a row and
+// rejects every commit without trying it until the cooldown is up
+func TestBreakerTrips(t *testing.T) {
+	clock := tracker.NewFakeClock(time.Unix(0, 0))
+	broker := &flakyBroker{down: true}
+	breaker := &CircuitBreaker{Broker: broker, Threshold: 3, Cooldown: time.Minute, Clock: clock}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := breaker.Commit(ctx, 0); !errors.Is(err, errDown) {
+			t.Fatalf("commit %d = %v, want the broker's error", i, err)
+		}
+	}
+	if trips, _, _ := breaker.Stats(); trips != 1 {
+		t.Fatalf("tripped %d times, want once", trips)
+	}
+	clock.Advance(time.Minute - time.Second)
+	for i := 0; i < 2; i++ {
+		if err := breaker.Commit(ctx, 0); !errors.Is(err, ErrBreakerOpen) {
+			t.Errorf("commit during the cooldown = %v, want ErrBreakerOpen", err)
+		}
+	}
+	if broker.commits != 3 {
+		t.Errorf("broker saw %d commits, want 3", broker.commits)
+	}
+	trips, open, rejected := breaker.Stats()
+	if trips != 1 || open != time.Minute-time.Second || rejected != 2 {
+		t.Errorf("Stats = %d, %v, %d, want 1, %v, 2", trips, open, rejected, time.Minute-time.Second)
+	}
+}
+
+// TestBreakerProbe lets a single probe through once the cooldown is up: a
+// failed one keeps the breaker open for another cooldown, a successful one
+// closes it
+func TestBreakerProbe(t *testing.T) {
+	clock := tracker.NewFakeClock(time.Unix(0, 0))
+	broker := &flakyBroker{down: true}
+	breaker := &CircuitBreaker{Broker: broker, Threshold: 1, Cooldown: time.Minute, Clock: clock}
+	ctx := context.Background()
+	if err := breaker.Commit(ctx, 0); !errors.Is(err, errDown) {
+		t.Fatalf("commit = %v, want the broker's error", err)
+	}
+	clock.Advance(time.Minute)
+	if err := breaker.Commit(ctx, 1); !errors.Is(err, errDown) {
+		t.Fatalf("probe = %v, want the broker's error", err)
+	}
+	if err := breaker.Commit(ctx, 1); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("commit after a failed probe = %v, want ErrBreakerOpen", err)
+	}
+	if broker.commits != 2 {
+		t.Errorf("broker saw %d commits, want 2", broker.commits)
+	}
+
+	broker.down = false
+	clock.Advance(time.Minute)
+	if err := breaker.Commit(ctx, 2); err != nil {
+		t.Fatalf("probe = %v", err)
+	}
+	// closed again, commits go straight through
+	for i := 0; i < 3; i++ {
+		if err := breaker.Commit(ctx, 3); err != nil {
+			t.Errorf("commit after the probe = %v", err)
+		}
+	}
+	if broker.commits != 6 {
+		t.Errorf("broker saw %d commits, want 6", broker.commits)
+	}
+	trips, open, rejected := breaker.Stats()
+	if trips != 1 || open != 2*time.Minute || rejected != 1 {
+		t.Errorf("Stats = %d, %v, %d, want 1, %v, 1", trips, open, rejected, 2*time.Minute)
+	}
+	// and it takes Threshold failures to trip it again
+	broker.down = true
+	if err := breaker.Commit(ctx, 4); !errors.Is(err, errDown) {
+		t.Fatalf("commit = %v, want the broker's error", err)
+	}
+	if err := breaker.Commit(ctx, 4); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("commit after tripping again = %v, want ErrBreakerOpen", err)
+	}
+	if trips, _, _ := breaker.Stats(); trips != 2 {
+		t.Errorf("tripped %d times, want twice", trips)
+	}
+}
@@ -0,0 +1,90 @@
+// Package adapter connects a tracker to the broker its watermark is
+// committed to: the Broker interface, a simulated broker, the committer
+// that commits every interval with retries, and checkpoints that line the
+// commits up with an application's own state.
+package adapter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// Broker is where the watermark ends up, in real life this is a Kafka
+// OffsetCommit request.
+type Broker interface {
+	// Commit durably stores offset as the largest processed offset, or
+	// gives up with ctx's error once it is done
+	Commit(ctx context.Context, offset int64) error
+}
+
+// SimBroker models the commit call to a broker.  Every commit takes
+// latency, and commits are spaced out so that there are never more than
+// rate commits per second.
+type SimBroker struct {
+	latency time.Duration
+	// minInterval is the minimum time between the start of two commits,
+	// zero means no limit
+	minInterval time.Duration
+	// mu spaces out concurrent commits, it guards last
+	mu   sync.Mutex
+	last time.Time
+
+	// committed and commits are accessed atomically so they can be
+	// watched while commits are made
+	committed int64
+	commits   int64
+	// generation is the newest CommitGeneration has seen, it is
+	// accessed atomically
+	generation int64
+	// Clock is what the latency and rate are waited out on, the real
+	// clock if nil
+	Clock tracker.Clock
+}
+
+// NewSimBroker returns a broker whose commits take latency and are
+// limited to rate per second, zero means no limit
+func NewSimBroker(latency time.Duration, rate float64) *SimBroker {
+	b := &SimBroker{latency: latency, committed: -1}
+	if rate > 0 {
+		b.minInterval = time.Duration(float64(time.Second) / rate)
+	}
+	return b
+}
+
+// Commit blocks for as long as the simulated broker would take.  Concurrent
+// commits, from a committer with MaxInFlight, are still spaced out but
+// their latencies overlap, and whichever finishes last is what the broker
+// has.
+func (b *SimBroker) Commit(ctx context.Context, offset int64) error {
+	if b.minInterval > 0 {
+		b.mu.Lock()
+		err := sleep(ctx, b.Clock, b.minInterval-since(b.Clock, b.last))
+		if err == nil {
+			b.last = orReal(b.Clock).Now()
+		}
+		b.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if err := sleep(ctx, b.Clock, b.latency); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&b.committed, offset)
+	atomic.AddInt64(&b.commits, 1)
+	return nil
+}
+
+// Committed returns the last offset the broker accepted
+func (b *SimBroker) Committed() int64 {
+	return atomic.LoadInt64(&b.committed)
+}
+
+// Commits returns the number of commits the broker accepted
+func (b *SimBroker) Commits() int64 {
+	return atomic.LoadInt64(&b.commits)
+}
@@ -0,0 +1,73 @@
+package adapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// A checkpoint aligns a stream processor's own state with the committed
+// watermark, the way Flink's barriers do: the watermark is held at the
+// barrier offset, the state is snapshotted once every offset up to the
+// barrier has been acked, and the barrier is committed before the hold is
+// released.  A restart from the state then resumes from exactly the offset
+// after it, neither losing nor repeating anything the state covers.
+
+// Holder is what a Checkpointer needs of a tracker.  *tracker.SyncTracker
+// is one, a tracker fed by a single goroutine needs its Hold and Release
+// run on that goroutine.
+type Holder interface {
+	Hold(offset int64) (*tracker.Hold, error)
+	Release(h *tracker.Hold)
+	Committed() int64
+}
+
+// Checkpointer runs checkpoints on a tracker.  The fields must be set
+// before the first Checkpoint.
+type Checkpointer struct {
+	Tracker Holder
+	// Flush commits the watermark right away, e.g. a Committer's Flush
+	// with its Run context
+	Flush func(ctx context.Context) error
+	// Poll is how often Checkpoint looks whether the acks have reached
+	// the barrier, 10ms if zero.  Clock is what it waits on, the real
+	// clock if nil.
+	Poll  time.Duration
+	Clock tracker.Clock
+}
+
+// Checkpoint takes a checkpoint at barrier: it waits for every offset up
+// to barrier to be acked, calls snapshot to save the state as of barrier,
+// and commits barrier.  The watermark doesn't move past barrier until
+// Checkpoint returns, so snapshot and the commit agree on it.  Nothing is
+// committed if snapshot fails, and a barrier the watermark is already
+// past fails with tracker.ErrOffsetBelowWatermark.  If ctx is done first
+// Checkpoint gives up and returns its error.
+func (c *Checkpointer) Checkpoint(ctx context.Context, barrier int64, snapshot func(ctx context.Context, barrier int64) error) error {
+	h, err := c.Tracker.Hold(barrier)
+	if err != nil {
+		return err
+	}
+	defer c.Tracker.Release(h)
+	if c.Tracker.Committed() < barrier {
+		poll := c.Poll
+		if poll <= 0 {
+			poll = 10 * time.Millisecond
+		}
+		ticker := orReal(c.Clock).NewTicker(poll)
+		defer ticker.Stop()
+		// held at barrier, the watermark can't go past it
+		for c.Tracker.Committed() < barrier {
+			select {
+			case <-ticker.C():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if err := snapshot(ctx, barrier); err != nil {
+		return err
+	}
+	return c.Flush(ctx)
+}
@@ -0,0 +1,316 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// RetryPolicy says how a failed commit is retried.  The offset being
+// committed is held until the commit succeeds or the attempts run out, then
+// the next interval tries again with whatever the watermark is by then, so
+// a commit is never dropped, only superseded by a later one.
+type RetryPolicy struct {
+	// Attempts is the total number of tries per commit, values below 2
+	// mean no retries
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles for every
+	// retry after that up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Delay returns how long to wait before the given retry, starting at 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Committer commits a tracker's watermark to a broker.  The fields may
+// be set between NewCommitter and Run, but not once it is running.
+type Committer struct {
+	Tracker  *tracker.Tracker
+	Broker   Broker
+	Interval time.Duration
+	Retry    RetryPolicy
+	// Paused, if set, says when commits are frozen
+	Paused func() bool
+	// Report, if set, gets the outcome of every commit
+	Report func(err error)
+	// Clock runs the interval and the retry backoff, the real clock if
+	// nil
+	Clock tracker.Clock
+	// OnCommit, if set, is called after every commit that went through,
+	// with the watermark committed before.  It is called from Run, or
+	// with MaxInFlight from the commit's goroutine, never concurrently
+	// and never with a watermark older than the last.
+	OnCommit func(old, new int64)
+	// Last is the watermark when the committer was made, Run commits
+	// once the watermark moves on from it.  Reading it when Run starts
+	// instead would miss acks the tracker saw before the goroutine got
+	// going.
+	Last int64
+	// MaxAdvance, if set, coalesces commits by watermark as well as by
+	// time: once the watermark is more than MaxAdvance past the last
+	// commit it is committed without waiting for the interval, which
+	// then starts over.  Run only looks when Advanced tells it to.
+	MaxAdvance int64
+	// MaxInFlight, if set, makes the commits asynchronous: Run sends
+	// the watermark without waiting for the broker, with up to
+	// MaxInFlight commits on their way, and only waits once they all
+	// are.  See dispatch for how their outcomes are reconciled.  The
+	// broker must take concurrent commits.
+	MaxInFlight int
+	// OnDone, if set, is called with the outcome of every asynchronous
+	// commit as the broker replied, before it is reconciled
+	OnDone func(offset int64, err error)
+	// PreCommit, if set, is the first phase of every commit: it is
+	// called with the offset about to be committed, and an error vetoes
+	// the commit.  It is for a sink whose side effects must be durable,
+	// a DB transaction or an S3 upload, before the offsets that caused
+	// them are committed, so that a crash in between replays them rather
+	// than losing them.  A vetoed commit is tried again at the next
+	// interval.
+	PreCommit func(ctx context.Context, offset int64) error
+
+	// flush asks Run to commit right away, see Flush, and intervals
+	// changes the interval, see SetInterval
+	flush     chan chan error
+	intervals chan time.Duration
+	// advanced is signalled by Advanced
+	advanced chan struct{}
+	// counters are accessed atomically
+	failures   int64
+	retries    int64
+	superseded int64
+	vetoes     int64
+}
+
+// NewCommitter returns a committer of t's watermark to b every interval,
+// starting from the watermark t has now
+func NewCommitter(t *tracker.Tracker, b Broker, interval time.Duration) *Committer {
+	return &Committer{
+		Tracker:   t,
+		Broker:    b,
+		Interval:  interval,
+		flush:     make(chan chan error),
+		intervals: make(chan time.Duration),
+		advanced:  make(chan struct{}, 1),
+		Last:      t.Committed(),
+	}
+}
+
+// Run checks the tracker every interval, or sooner for MaxAdvance, and
+// commits its watermark to the broker whenever it has moved.  Commits are
+// synchronous unless MaxInFlight is set, so a slow broker makes the loop
+// fall behind the tracker, which is the lag we want to measure.  It
+// returns once ctx is done, which also interrupts the commits on their
+// way.
+func (c *Committer) Run(ctx context.Context) {
+	interval := c.Interval
+	ticker := orReal(c.Clock).NewTicker(interval)
+	defer ticker.Stop()
+	last := c.Last
+	var pipe *pipeline
+	if c.MaxInFlight > 0 {
+		pipe = newPipeline(c.MaxInFlight, last)
+		// no callback may come after Run has returned
+		defer pipe.wg.Wait()
+	}
+	for {
+		if pipe != nil {
+			last = pipe.lastSent()
+		}
+		var flushed chan error
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if c.Paused != nil && c.Paused() {
+				continue
+			}
+		case flushed = <-c.flush:
+		case d := <-c.intervals:
+			interval = d
+			ticker.Reset(d)
+			continue
+		case <-c.advanced:
+			if c.MaxAdvance <= 0 || c.Tracker.Committed()-last <= c.MaxAdvance || c.Paused != nil && c.Paused() {
+				continue
+			}
+			// the interval counts from this commit
+			ticker.Reset(interval)
+		}
+		offset := c.Tracker.Committed()
+		if pipe != nil {
+			// a flush must not return before the broker has the
+			// watermark, so it sends it again while it's on its way
+			if offset != last || flushed != nil && !pipe.isAcked(offset) {
+				if !c.dispatch(ctx, pipe, offset, flushed) {
+					return
+				}
+			} else if flushed != nil {
+				flushed <- nil
+			}
+			continue
+		}
+		var err error
+		if offset != last {
+			if err = c.commit(ctx, offset); err == nil {
+				if c.OnCommit != nil {
+					c.OnCommit(last, offset)
+				}
+				last = offset
+			}
+			if c.Report != nil {
+				c.Report(err)
+			}
+		}
+		if flushed != nil {
+			flushed <- err
+		}
+	}
+}
+
+// Flush commits the watermark now instead of at the next interval, even
+// while paused, and returns the error of the last attempt if it failed.
+// It is safe to call from any goroutine while Run is running, stopped is
+// Run's context and ctx the caller's.
+func (c *Committer) Flush(ctx, stopped context.Context) error {
+	flushed := make(chan error, 1)
+	select {
+	case c.flush <- flushed:
+	case <-stopped.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-flushed:
+		return err
+	case <-stopped.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetInterval changes how often Run commits, it is safe to call from any
+// goroutine while Run is running
+func (c *Committer) SetInterval(d time.Duration, stopped context.Context) error {
+	select {
+	case c.intervals <- d:
+		return nil
+	case <-stopped.Done():
+		return ErrClosed
+	}
+}
+
+// Failures returns the number of commit attempts that failed
+func (c *Committer) Failures() int64 {
+	return atomic.LoadInt64(&c.failures)
+}
+
+// Advanced tells Run the watermark has moved, so it can commit early for
+// MaxAdvance.  It has the signature of tracker.Hooks.OnAdvance, to be
+// called from there, and never blocks.
+func (c *Committer) Advanced(from, to int64) {
+	select {
+	case c.advanced <- struct{}{}:
+	default:
+	}
+}
+
+// Vetoes returns the number of commits PreCommit vetoed
+func (c *Committer) Vetoes() int64 {
+	return atomic.LoadInt64(&c.vetoes)
+}
+
+// ErrVetoed is what the error of a commit PreCommit vetoed is to
+// errors.Is, alongside PreCommit's own error
+var ErrVetoed = errors.New("commit vetoed")
+
+// ErrClosed is returned for requests to a consumer or partition that has
+// been torn down
+var ErrClosed = errors.New("consumer closed")
+
+// commit tries to commit offset according to the retry policy and returns
+// the error of the last attempt if none succeeded.  PreCommit is asked
+// once, not for every attempt.
+func (c *Committer) commit(ctx context.Context, offset int64) error {
+	if c.PreCommit != nil {
+		if err := c.PreCommit(ctx, offset); err != nil {
+			atomic.AddInt64(&c.vetoes, 1)
+			return fmt.Errorf("%w at %d: %w", ErrVetoed, offset, err)
+		}
+	}
+	gb, fenced := c.Broker.(GenerationBroker)
+	for attempt := 1; ; attempt++ {
+		var err error
+		// the same label as the goroutines the broker's client starts,
+		// so a profile can tell the commits apart from the committer's
+		// own work
+		pprof.Do(ctx, pprof.Labels("stage", "adapter"), func(ctx context.Context) {
+			if fenced {
+				err = gb.CommitGeneration(ctx, c.Tracker.Generation(), offset)
+			} else {
+				err = c.Broker.Commit(ctx, offset)
+			}
+		})
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrBreakerOpen) || errors.Is(err, tracker.ErrStaleGeneration) {
+			// retrying would only be rejected too, the next
+			// interval commits whatever the watermark is then
+			return err
+		}
+		atomic.AddInt64(&c.failures, 1)
+		if attempt >= c.Retry.Attempts {
+			return err
+		}
+		atomic.AddInt64(&c.retries, 1)
+		if sleep(ctx, c.Clock, c.Retry.Delay(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// since is time.Since on c
+func since(c tracker.Clock, t time.Time) time.Duration {
+	return orReal(c).Now().Sub(t)
+}
+
+// sleep waits for d on clock, or returns ctx's error if it is done first
+func sleep(ctx context.Context, clock tracker.Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := orReal(clock).NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// orReal returns c, or tracker.RealClock if c is nil
+func orReal(c tracker.Clock) tracker.Clock {
+	if c == nil {
+		return tracker.RealClock
+	}
+	return c
+}
@@ -0,0 +1,34 @@
+package adapter_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// The committer commits the tracker's watermark in the background, Flush
+// makes it commit now, e.g. before shutting down
+func ExampleCommitter() {
+	t := tracker.New(backend.NewMap(0), -1)
+	broker := adapter.NewSimBroker(0, 0)
+	c := adapter.NewCommitter(t, broker, time.Second)
+	c.Retry = adapter.RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go c.Run(ctx)
+
+	for _, offset := range []int64{0, 2, 1, 5} {
+		t.Ack(offset)
+	}
+	if err := c.Flush(context.Background(), ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(broker.Committed())
+	// Output: 2
+}
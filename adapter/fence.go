@@ -0,0 +1,40 @@
+package adapter
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// GenerationBroker is a Broker that fences commits.  CommitGeneration
+// fails with a *tracker.StaleGenerationError for a generation older than the
+// newest it has seen, the Committer uses it instead of Commit with the
+// tracker's generation if the broker has it.
+type GenerationBroker interface {
+	Broker
+	CommitGeneration(ctx context.Context, generation, offset int64) error
+}
+
+// CommitGeneration implements GenerationBroker
+func (b *SimBroker) CommitGeneration(ctx context.Context, generation, offset int64) error {
+	for {
+		cur := atomic.LoadInt64(&b.generation)
+		if generation < cur {
+			return &tracker.StaleGenerationError{Generation: generation, Current: cur}
+		}
+		if atomic.CompareAndSwapInt64(&b.generation, cur, generation) {
+			break
+		}
+	}
+	return b.Commit(ctx, offset)
+}
+
+// commitGeneration commits offset to b for generation if b fences commits,
+// and plainly if it doesn't, for brokers that wrap another
+func commitGeneration(ctx context.Context, b Broker, generation, offset int64) error {
+	if gb, ok := b.(GenerationBroker); ok {
+		return gb.CommitGeneration(ctx, generation, offset)
+	}
+	return b.Commit(ctx, offset)
+}
@@ -0,0 +1,176 @@
+// Package backend has the sets the tracker keeps its pending offsets in:
+// a map, a bitset ring and a tree of ranges, which trade memory for speed
+// differently depending on how the acks arrive.
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Backend stores the offsets that have been acked but can't be committed
+// yet because some lower offset is still outstanding.  Backends are not
+// safe for concurrent use, the tracker owns them.
+type Backend interface {
+	// Add records offset as acked.  It returns false if offset was
+	// already present.
+	Add(offset int64) bool
+	// Advance removes the run of sequential offsets starting at next
+	// and returns the first offset that is not present.
+	Advance(next int64) int64
+	// Has reports whether offset is stored
+	Has(offset int64) bool
+	// Lowest returns the smallest stored offset, it is only called
+	// when Len is not zero
+	Lowest() int64
+	// Len returns the number of offsets currently stored
+	Len() int
+	// Offsets returns every stored offset in no particular order
+	Offsets() []int64
+	// Memory estimates how many bytes the backend holds on to, which
+	// can be more than the offsets it stores need
+	Memory() int64
+	// Live estimates how many bytes the offsets stored now need, which
+	// unlike Memory comes down as soon as they are advanced past
+	Live() int64
+	// Clone returns a copy that shares nothing with the original
+	Clone() Backend
+}
+
+// Compacter is implemented by backends that hold on to memory after the
+// pending set shrinks.  Compact gives back what it can and reports whether
+// it did anything, it may be slow so it is only called now and then.
+type Compacter interface {
+	Compact() bool
+}
+
+// backends maps the names New accepts to constructors.  sizeHint is the
+// number of offsets the caller expects to be pending at once, i.e. its
+// reorder window, so the backend can allocate for it up front instead of
+// growing during the hot phase.  Zero means no idea.
+var backends = map[string]func(sizeHint int) Backend{
+	"map":      NewMap,
+	"bitset":   NewBitset,
+	"rangeset": NewRangeSet,
+}
+
+// Names returns the names New accepts, sorted
+func Names() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the backend called name, see backends for sizeHint
+func New(name string, sizeHint int) (Backend, error) {
+	newFn, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return newFn(sizeHint), nil
+}
+
+// mapBackend is the original implementation: the keys of a map are a set.
+// We don't care about the map value.
+type mapBackend struct {
+	commits map[int64]struct{}
+	// peak is the most keys the map has held since it was made, a Go map
+	// never shrinks so it still has room for that many
+	peak int
+}
+
+// NewMap returns the map backend
+func NewMap(sizeHint int) Backend {
+	return &mapBackend{commits: make(map[int64]struct{}, sizeHint)}
+}
+
+func (m *mapBackend) Clone() Backend {
+	c := &mapBackend{commits: make(map[int64]struct{}, len(m.commits)), peak: len(m.commits)}
+	for o := range m.commits {
+		c.commits[o] = struct{}{}
+	}
+	return c
+}
+
+func (m *mapBackend) Add(offset int64) bool {
+	if _, ok := m.commits[offset]; ok {
+		return false
+	}
+	m.commits[offset] = struct{}{}
+	if len(m.commits) > m.peak {
+		m.peak = len(m.commits)
+	}
+	return true
+}
+
+func (m *mapBackend) Advance(next int64) int64 {
+	_, ok := m.commits[next]
+	for ok {
+		// don't keep sequentially committed values in the set
+		delete(m.commits, next)
+		next++
+		_, ok = m.commits[next]
+	}
+	return next
+}
+
+// compact copies the keys into a new map once most of the old one's
+// buckets are empty, small maps aren't worth the trouble
+func (m *mapBackend) Compact() bool {
+	if m.peak < 4096 || len(m.commits) > m.peak/4 {
+		return false
+	}
+	commits := make(map[int64]struct{}, len(m.commits))
+	for o := range m.commits {
+		commits[o] = struct{}{}
+	}
+	m.commits, m.peak = commits, len(commits)
+	return true
+}
+
+func (m *mapBackend) Has(offset int64) bool {
+	_, ok := m.commits[offset]
+	return ok
+}
+
+// lowest has to look at every key, it is only used when a gap is given up
+// on
+func (m *mapBackend) Lowest() int64 {
+	first := true
+	var min int64
+	for o := range m.commits {
+		if first || o < min {
+			min, first = o, false
+		}
+	}
+	return min
+}
+
+func (m *mapBackend) Len() int {
+	return len(m.commits)
+}
+
+// mapEntryBytes is roughly what a map[int64]struct{} costs per key: the
+// key, a control byte, and the slack kept free so lookups stay fast
+const mapEntryBytes = 12
+
+func (m *mapBackend) Memory() int64 {
+	// the map still has room for its peak
+	return int64(m.peak) * mapEntryBytes
+}
+
+func (m *mapBackend) Live() int64 {
+	return int64(len(m.commits)) * mapEntryBytes
+}
+
+func (m *mapBackend) Offsets() []int64 {
+	offsets := make([]int64, 0, len(m.commits))
+	for o := range m.commits {
+		offsets = append(offsets, o)
+	}
+	return offsets
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"pgregory.net/rapid"
+)
+
+// modelBackend is the reference every backend is held to: a sorted slice,
+// scanned for everything
+type modelBackend struct {
+	sorted []int64
+}
+
+func (m *modelBackend) find(offset int64) (int, bool) {
+	i := sort.Search(len(m.sorted), func(i int) bool { return m.sorted[i] >= offset })
+	return i, i < len(m.sorted) && m.sorted[i] == offset
+}
+
+func (m *modelBackend) add(offset int64) bool {
+	i, ok := m.find(offset)
+	if ok {
+		return false
+	}
+	m.sorted = append(m.sorted, 0)
+	copy(m.sorted[i+1:], m.sorted[i:])
+	m.sorted[i] = offset
+	return true
+}
+
+func (m *modelBackend) advance(next int64) int64 {
+	i, _ := m.find(next)
+	j := i
+	for j < len(m.sorted) && m.sorted[j] == next {
+		j++
+		next++
+	}
+	m.sorted = append(m.sorted[:i], m.sorted[j:]...)
+	return next
+}
+
+func (m *modelBackend) has(offset int64) bool {
+	_, ok := m.find(offset)
+	return ok
+}
+
+func (m *modelBackend) lowest() int64 { return m.sorted[0] }
+func (m *modelBackend) len() int      { return len(m.sorted) }
+
+// TestBackendsMatchModel runs random sequences of backend operations on
+// every backend and the model side by side, and fails on the first result
+// that differs
+func TestBackendsMatchModel(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			rapid.Check(t, func(rt *rapid.T) {
+				b, _ := New(name, rapid.IntRange(0, 64).Draw(rt, "sizeHint"))
+				m := &modelBackend{}
+				// the tracker only stores offsets above the watermark and
+				// only advances from the offset after it, so base is
+				// the watermark + 1, and offsets stay in a window above
+				// it so that runs and repeats are common
+				base := int64(0)
+				offset := func() int64 { return base + rapid.Int64Range(0, 200).Draw(rt, "offset") }
+				rt.Repeat(map[string]func(*rapid.T){
+					"add": func(rt *rapid.T) {
+						o := offset()
+						if got, want := b.Add(o), m.add(o); got != want {
+							rt.Fatalf("add(%d) = %v, want %v", o, got, want)
+						}
+					},
+					"advance": func(rt *rapid.T) {
+						// from anywhere up to the lowest offset, as
+						// skipping a gap does
+						o := offset()
+						if m.len() > 0 && o > m.lowest() {
+							o = m.lowest()
+						}
+						got, want := b.Advance(o), m.advance(o)
+						if got != want {
+							rt.Fatalf("advance(%d) = %d, want %d", o, got, want)
+						}
+						base = got
+					},
+					"has": func(rt *rapid.T) {
+						o := offset()
+						if got, want := b.Has(o), m.has(o); got != want {
+							rt.Fatalf("has(%d) = %v, want %v", o, got, want)
+						}
+					},
+					"compact": func(rt *rapid.T) {
+						if c, ok := b.(Compacter); ok {
+							c.Compact()
+						}
+					},
+					"": func(rt *rapid.T) {
+						if got, want := b.Len(), m.len(); got != want {
+							rt.Fatalf("len() = %d, want %d", got, want)
+						}
+						// what is live is held on to, and nothing
+						// stored needs nothing
+						if live := b.Live(); live > b.Memory() || (live == 0) != (m.len() == 0) {
+							rt.Fatalf("live() = %d with memory %d and %d stored", live, b.Memory(), m.len())
+						}
+						if m.len() > 0 {
+							if got, want := b.Lowest(), m.lowest(); got != want {
+								rt.Fatalf("lowest() = %d, want %d", got, want)
+							}
+						}
+						offsets := b.Offsets()
+						sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+						if len(offsets) != 0 || len(m.sorted) != 0 {
+							if !reflect.DeepEqual(offsets, m.sorted) {
+								rt.Fatalf("offsets() = %v, want %v", offsets, m.sorted)
+							}
+						}
+					},
+				})
+			})
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package backend
+
+import "math/bits"
+
+// bitsetBackend stores one bit per offset in a ring of 64 bit words
+// starting at the word holding the watermark, so a reorder window of a
+// million offsets costs 128KB rather than tens of MB for a map.  The ring
+// doubles whenever an offset lands beyond its end.
+type bitsetBackend struct {
+	// words is a ring whose length is a power of two, words[head] holds
+	// offsets [base, base+64)
+	words []uint64
+	head  int
+	// base is always a multiple of 64
+	base int64
+	n    int
+	// empty is true until the first add, which picks the base
+	empty bool
+	// minWords is the size asked for by the size hint, compact doesn't
+	// shrink the ring below it
+	minWords int
+}
+
+// NewBitset returns the bitset backend
+func NewBitset(sizeHint int) Backend {
+	size := 1
+	for size*64 < sizeHint {
+		size *= 2
+	}
+	return &bitsetBackend{words: make([]uint64, size), empty: true, minWords: size}
+}
+
+func (b *bitsetBackend) Clone() Backend {
+	c := *b
+	c.words = append([]uint64(nil), b.words...)
+	return &c
+}
+
+// word returns the index in words of the word holding offset, which must be
+// inside the ring
+func (b *bitsetBackend) word(offset int64) int {
+	return (b.head + int((offset-b.base)/64)) & (len(b.words) - 1)
+}
+
+func (b *bitsetBackend) Add(offset int64) bool {
+	if b.empty {
+		b.base = offset &^ 63
+		b.empty = false
+	}
+	if offset < b.base {
+		// only before the watermark has caught up with the first ack
+		b.lower(offset &^ 63)
+	}
+	for offset >= b.base+int64(len(b.words))*64 {
+		b.resize(b.base, len(b.words)*2)
+	}
+	w, bit := b.word(offset), uint64(1)<<(uint64(offset)&63)
+	if b.words[w]&bit != 0 {
+		return false
+	}
+	b.words[w] |= bit
+	b.n++
+	return true
+}
+
+// lower moves the start of the ring down to base.  If the words in use
+// still fit, the ring is just rotated, otherwise it grows.
+func (b *bitsetBackend) lower(base int64) {
+	shift := int((b.base - base) / 64)
+	used := b.used()
+	if shift+used <= len(b.words) {
+		// the words in front of head are past the last one in use, so
+		// they are zero
+		b.head = (b.head - shift) & (len(b.words) - 1)
+		b.base = base
+		return
+	}
+	size := len(b.words) * 2
+	for size < shift+used {
+		size *= 2
+	}
+	b.resize(base, size)
+}
+
+// used returns the number of words from head up to the last one that isn't
+// zero
+func (b *bitsetBackend) used() int {
+	used := len(b.words)
+	for used > 0 && b.words[(b.head+used-1)&(len(b.words)-1)] == 0 {
+		used--
+	}
+	return used
+}
+
+// compact shrinks the ring once it could be a quarter of its size and
+// still have room to double, any less and it would just grow again
+func (b *bitsetBackend) Compact() bool {
+	size := len(b.words)
+	for size/2 >= b.minWords && size/2 >= 2*b.used() {
+		size /= 2
+	}
+	if size > len(b.words)/4 {
+		return false
+	}
+	b.resize(b.base, size)
+	return true
+}
+
+// resize copies the ring into one of size words starting at base, which
+// must hold every word in use
+func (b *bitsetBackend) resize(base int64, size int) {
+	shift := int((b.base - base) / 64)
+	words := make([]uint64, size)
+	for i := range b.words {
+		if w := b.words[(b.head+i)&(len(b.words)-1)]; w != 0 {
+			words[shift+i] = w
+		}
+	}
+	b.words, b.head, b.base = words, 0, base
+}
+
+func (b *bitsetBackend) Advance(next int64) int64 {
+	if b.empty || next < b.base {
+		return next
+	}
+	for b.n > 0 && next < b.base+int64(len(b.words))*64 {
+		w := b.word(next)
+		shift := uint64(next) & 63
+		// count the run of set bits from next within this word
+		run := bits.TrailingZeros64(^(b.words[w] >> shift))
+		if run > 64-int(shift) {
+			run = 64 - int(shift)
+		}
+		if run > 0 {
+			b.words[w] &^= (1<<uint(run) - 1) << shift
+			b.n -= run
+			next += int64(run)
+		}
+		if run == 0 || next&63 != 0 {
+			// the run ended inside the word
+			break
+		}
+	}
+	// slide the ring past the words that are now below the watermark,
+	// they are all zero
+	for b.base+64 <= next {
+		b.head = (b.head + 1) & (len(b.words) - 1)
+		b.base += 64
+	}
+	if b.n == 0 {
+		// nothing is pending, start again from wherever the next
+		// ack lands
+		b.empty = true
+		b.head = 0
+	}
+	return next
+}
+
+func (b *bitsetBackend) Has(offset int64) bool {
+	if b.empty || offset < b.base || offset >= b.base+int64(len(b.words))*64 {
+		return false
+	}
+	return b.words[b.word(offset)]&(1<<(uint64(offset)&63)) != 0
+}
+
+func (b *bitsetBackend) Lowest() int64 {
+	for i := range b.words {
+		if word := b.words[(b.head+i)&(len(b.words)-1)]; word != 0 {
+			return b.base + int64(i)*64 + int64(bits.TrailingZeros64(word))
+		}
+	}
+	return b.base
+}
+
+func (b *bitsetBackend) Len() int {
+	return b.n
+}
+
+func (b *bitsetBackend) Memory() int64 {
+	return int64(len(b.words)) * 8
+}
+
+// Live is the whole ring while anything is pending, the offsets can be
+// anywhere in it
+func (b *bitsetBackend) Live() int64 {
+	if b.n == 0 {
+		return 0
+	}
+	return b.Memory()
+}
+
+func (b *bitsetBackend) Offsets() []int64 {
+	offsets := make([]int64, 0, b.n)
+	for i := range b.words {
+		word := b.words[(b.head+i)&(len(b.words)-1)]
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			offsets = append(offsets, b.base+int64(i)*64+int64(bit))
+			word &= word - 1
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,295 @@
+package backend
+
+import "unsafe"
+
+// rangeSetBackend keeps the pending offsets as disjoint, non-adjacent
+// ranges in a treap ordered by the start of the range.  Acks that arrive
+// in runs collapse into a handful of nodes, and the lowest range is always
+// the one the watermark is waiting on.
+//
+// The nodes hold no pointers, children are indexes into slabs of nodes.  A
+// long stall can leave millions of ranges behind the watermark, and as
+// separately allocated objects the GC would have to scan every one of them
+// on every cycle.  Slabs are large pointer-free arrays, so the GC skips
+// their contents entirely, and freed nodes are reused from a free list.
+type rangeSetBackend struct {
+	root  int32
+	nodes nodeSlab
+	n     int
+	// ranges is the number of nodes in the tree
+	ranges int
+	// prio is the state of a xorshift generator for treap priorities
+	prio uint32
+}
+
+const (
+	slabBits = 12
+	slabSize = 1 << slabBits
+)
+
+type rangeNode struct {
+	from, to    int64
+	left, right int32
+	prio        uint32
+}
+
+// nodeSlab allocates rangeNodes.  Index 0 is never handed out so that it
+// can mean "no node".
+type nodeSlab struct {
+	slabs [][]rangeNode
+	free  []int32
+	next  int32
+}
+
+func (s *nodeSlab) alloc() int32 {
+	if n := len(s.free); n > 0 {
+		i := s.free[n-1]
+		s.free = s.free[:n-1]
+		return i
+	}
+	if s.next == 0 {
+		s.next = 1
+	}
+	if int(s.next>>slabBits) == len(s.slabs) {
+		s.slabs = append(s.slabs, make([]rangeNode, slabSize))
+	}
+	i := s.next
+	s.next++
+	return i
+}
+
+func (s *nodeSlab) release(i int32) {
+	*s.node(i) = rangeNode{}
+	s.free = append(s.free, i)
+}
+
+func (s *nodeSlab) node(i int32) *rangeNode {
+	return &s.slabs[i>>slabBits][i&(slabSize-1)]
+}
+
+// NewRangeSet returns the range set backend
+func NewRangeSet(sizeHint int) Backend {
+	// every pending offset could be its own range, but that is the worst
+	// case, so only make room for the slab list up front
+	return &rangeSetBackend{
+		nodes: nodeSlab{slabs: make([][]rangeNode, 0, sizeHint/slabSize+1)},
+		prio:  2463534242,
+	}
+}
+
+func (b *rangeSetBackend) Clone() Backend {
+	c := *b
+	c.nodes.slabs = make([][]rangeNode, len(b.nodes.slabs), cap(b.nodes.slabs))
+	for i, slab := range b.nodes.slabs {
+		c.nodes.slabs[i] = append([]rangeNode(nil), slab...)
+	}
+	c.nodes.free = append([]int32(nil), b.nodes.free...)
+	return &c
+}
+
+func (b *rangeSetBackend) nextPrio() uint32 {
+	b.prio ^= b.prio << 13
+	b.prio ^= b.prio >> 17
+	b.prio ^= b.prio << 5
+	return b.prio
+}
+
+// around returns the range starting at or before offset and the range
+// starting after it, either can be 0
+func (b *rangeSetBackend) around(offset int64) (pred, succ int32) {
+	for i := b.root; i != 0; {
+		n := b.nodes.node(i)
+		if n.from <= offset {
+			pred, i = i, n.right
+		} else {
+			succ, i = i, n.left
+		}
+	}
+	return pred, succ
+}
+
+func (b *rangeSetBackend) Add(offset int64) bool {
+	pred, succ := b.around(offset)
+	var p, s *rangeNode
+	if pred != 0 {
+		p = b.nodes.node(pred)
+		if p.to >= offset {
+			return false
+		}
+	}
+	if succ != 0 {
+		s = b.nodes.node(succ)
+	}
+	b.n++
+	joinsPred := p != nil && p.to+1 == offset
+	joinsSucc := s != nil && s.from-1 == offset
+	switch {
+	case joinsPred && joinsSucc:
+		// offset fills the gap between two ranges
+		p.to = s.to
+		b.root = b.remove(b.root, s.from)
+		b.ranges--
+	case joinsPred:
+		p.to = offset
+	case joinsSucc:
+		// nothing lies between pred and succ, so moving the start of
+		// succ down keeps the tree ordered
+		s.from = offset
+	default:
+		i := b.nodes.alloc()
+		*b.nodes.node(i) = rangeNode{from: offset, to: offset, prio: b.nextPrio()}
+		b.root = b.insert(b.root, i)
+		b.ranges++
+	}
+	return true
+}
+
+func (b *rangeSetBackend) insert(root, i int32) int32 {
+	if root == 0 {
+		return i
+	}
+	r, n := b.nodes.node(root), b.nodes.node(i)
+	if n.from < r.from {
+		r.left = b.insert(r.left, i)
+		if l := b.nodes.node(r.left); l.prio > r.prio {
+			// rotate right
+			top := r.left
+			r.left, l.right = l.right, root
+			return top
+		}
+	} else {
+		r.right = b.insert(r.right, i)
+		if rt := b.nodes.node(r.right); rt.prio > r.prio {
+			// rotate left
+			top := r.right
+			r.right, rt.left = rt.left, root
+			return top
+		}
+	}
+	return root
+}
+
+// remove deletes the range starting at from and frees its node
+func (b *rangeSetBackend) remove(root int32, from int64) int32 {
+	if root == 0 {
+		return 0
+	}
+	r := b.nodes.node(root)
+	switch {
+	case from < r.from:
+		r.left = b.remove(r.left, from)
+	case from > r.from:
+		r.right = b.remove(r.right, from)
+	default:
+		merged := b.merge(r.left, r.right)
+		b.nodes.release(root)
+		return merged
+	}
+	return root
+}
+
+// merge joins two treaps where every key in left is below every key in
+// right
+func (b *rangeSetBackend) merge(left, right int32) int32 {
+	if left == 0 {
+		return right
+	}
+	if right == 0 {
+		return left
+	}
+	l, r := b.nodes.node(left), b.nodes.node(right)
+	if l.prio > r.prio {
+		l.right = b.merge(l.right, right)
+		return left
+	}
+	r.left = b.merge(left, r.left)
+	return right
+}
+
+func (b *rangeSetBackend) Advance(next int64) int64 {
+	if b.root == 0 {
+		return next
+	}
+	// ranges are never adjacent, so only the lowest one can continue
+	// the watermark
+	n := b.nodes.node(b.first())
+	if n.from != next {
+		return next
+	}
+	next = n.to + 1
+	b.n -= int(n.to - n.from + 1)
+	b.root = b.remove(b.root, n.from)
+	b.ranges--
+	return next
+}
+
+// first returns the node holding the lowest range, the tree must not be
+// empty
+func (b *rangeSetBackend) first() int32 {
+	min := b.root
+	for l := b.nodes.node(min).left; l != 0; l = b.nodes.node(min).left {
+		min = l
+	}
+	return min
+}
+
+func (b *rangeSetBackend) Has(offset int64) bool {
+	pred, _ := b.around(offset)
+	return pred != 0 && b.nodes.node(pred).to >= offset
+}
+
+func (b *rangeSetBackend) Lowest() int64 {
+	return b.nodes.node(b.first()).from
+}
+
+// compact moves the ranges into fresh slabs once most of the nodes that
+// have been allocated are sitting on the free list.  Freed nodes are
+// scattered across every slab, so none of them can be dropped in place.
+func (b *rangeSetBackend) Compact() bool {
+	if b.nodes.next <= slabSize || b.ranges > int(b.nodes.next)/4 {
+		return false
+	}
+	ranges := make([]rangeNode, 0, b.ranges)
+	b.walk(b.root, func(n *rangeNode) { ranges = append(ranges, *n) })
+	b.nodes = nodeSlab{}
+	b.root = 0
+	for _, r := range ranges {
+		i := b.nodes.alloc()
+		*b.nodes.node(i) = rangeNode{from: r.from, to: r.to, prio: b.nextPrio()}
+		b.root = b.insert(b.root, i)
+	}
+	return true
+}
+
+// walk calls fn with every node in order
+func (b *rangeSetBackend) walk(i int32, fn func(n *rangeNode)) {
+	if i == 0 {
+		return
+	}
+	n := b.nodes.node(i)
+	b.walk(n.left, fn)
+	fn(n)
+	b.walk(n.right, fn)
+}
+
+func (b *rangeSetBackend) Len() int {
+	return b.n
+}
+
+func (b *rangeSetBackend) Memory() int64 {
+	return int64(len(b.nodes.slabs))*slabSize*int64(unsafe.Sizeof(rangeNode{})) + int64(cap(b.nodes.free))*4
+}
+
+func (b *rangeSetBackend) Live() int64 {
+	return int64(b.ranges) * int64(unsafe.Sizeof(rangeNode{}))
+}
+
+func (b *rangeSetBackend) Offsets() []int64 {
+	offsets := make([]int64, 0, b.n)
+	b.walk(b.root, func(n *rangeNode) {
+		for o := n.from; o <= n.to; o++ {
+			offsets = append(offsets, o)
+		}
+	})
+	return offsets
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ackBuffer is how acks are buffered between the workers and the ack loop.
+// The original sizes the channel for every message so a worker never
+// blocks, which costs 8 bytes per message up front.
+type ackBuffer struct {
+	// mode is "full", "bounded" or "adaptive"
+	mode string
+	// size is the channel capacity of a bounded buffer, workers block
+	// when it's full
+	size int
+}
+
+// parseAckBuffer accepts "full", "adaptive" or the capacity of a bounded
+// buffer
+func parseAckBuffer(s string) (ackBuffer, error) {
+	switch s {
+	case "", "full":
+		return ackBuffer{mode: "full"}, nil
+	case "adaptive":
+		return ackBuffer{mode: "adaptive"}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return ackBuffer{}, fmt.Errorf("bad ack buffer %q (want full, adaptive or a capacity)", s)
+	}
+	return ackBuffer{mode: "bounded", size: n}, nil
+}
+
+func (b ackBuffer) String() string {
+	if b.mode == "bounded" {
+		return strconv.Itoa(b.size)
+	}
+	return b.mode
+}
+
+// minQueue is the capacity an adaptive queue never shrinks below
+const minQueue = 1024
+
+// ackQueue is the adaptive buffer: an unbounded queue that grows while
+// the ack loop falls behind and gives the memory back once it catches up.
+// Workers never block on it for longer than a mutex.
+type ackQueue struct {
+	mu  sync.Mutex
+	buf []int64
+	// spare is the batch the ack loop had last, reused as buf on the
+	// next take
+	spare []int64
+	// peakBytes is the most memory both slices held at once
+	peakBytes int64
+	// ready has room for one wakeup, pushes don't block on it
+	ready chan struct{}
+}
+
+func newAckQueue() *ackQueue {
+	return &ackQueue{
+		buf:   make([]int64, 0, minQueue),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (q *ackQueue) push(offset int64) {
+	q.mu.Lock()
+	q.buf = append(q.buf, offset)
+	if b := int64(cap(q.buf)+cap(q.spare)) * 8; b > q.peakBytes {
+		q.peakBytes = b
+	}
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns every ack queued so far.  The slice is only valid until the
+// next call.
+func (q *ackQueue) take() []int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	batch, next := q.buf, q.spare[:0]
+	// a batch much smaller than the buffer left over from a backlog
+	// means we've caught up, so let the big one go
+	if cap(next) > minQueue && len(batch) < cap(next)/4 {
+		next = make([]int64, 0, minQueue)
+	}
+	q.buf, q.spare = next, batch
+	return batch
+}
+
+func (q *ackQueue) peak() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.peakBytes
+}
+
+// one ack in 1<<latencyShift has the time it spent between the worker and
+// the tracker measured, timing every ack would slow the ack path down
+// more than the buffer does
+const latencyShift = 6
+
+// ackTimes holds the delivery time of the sampled offsets
+type ackTimes struct {
+	epoch time.Time
+	// sent is indexed by offset>>latencyShift, each entry is accessed
+	// atomically and is zero when nothing is in flight
+	sent []int64
+}
+
+func newAckTimes(numMsgs int64) *ackTimes {
+	return &ackTimes{epoch: time.Now(), sent: make([]int64, numMsgs>>latencyShift+1)}
+}
+
+func (t *ackTimes) delivered(offset int64) {
+	if offset&(1<<latencyShift-1) == 0 {
+		atomic.StoreInt64(&t.sent[offset>>latencyShift], int64(time.Since(t.epoch)))
+	}
+}
+
+// received returns how long offset took to arrive, if it was sampled
+func (t *ackTimes) received(offset int64) (time.Duration, bool) {
+	if offset&(1<<latencyShift-1) != 0 {
+		return 0, false
+	}
+	sent := atomic.SwapInt64(&t.sent[offset>>latencyShift], 0)
+	if sent == 0 {
+		return 0, false
+	}
+	return time.Since(t.epoch) - time.Duration(sent), true
+}
+
+// percentiles sorts ds and returns the median, 99th percentile and maximum
+func percentiles(ds []time.Duration) (p50, p99, max time.Duration) {
+	if len(ds) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)/2], ds[len(ds)*99/100], ds[len(ds)-1]
+}
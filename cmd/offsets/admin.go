@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// Partition is one partition's consumer as the admin API and the gRPC
+// service see it.  Unlike the tracker's, its methods are called from
+// request handlers and must be safe to call from any goroutine.  Those
+// that wait on the ack loop or the broker give up with ctx's error once it
+// is done.
+type Partition interface {
+	Status(ctx context.Context) (PartitionStatus, error)
+	// Committed is the tracker's watermark, it must be cheap enough to
+	// poll
+	Committed() int64
+	// Ack queues an ack for the tracker
+	Ack(ctx context.Context, offset int64) error
+	Pause()
+	Resume()
+	// SeekTo moves the watermark, see Tracker.SeekTo
+	SeekTo(ctx context.Context, committed int64) error
+	// Flush commits the watermark right away and persists the snapshot,
+	// for before a deploy or at an application checkpoint
+	Flush(ctx context.Context) error
+	// Oldest returns the first n offsets the watermark is waiting on
+	Oldest(ctx context.Context, n int) ([]Outstanding, error)
+}
+
+// fencedPartition is a Partition whose acks can be fenced by generation,
+// see Tracker.Fence
+type fencedPartition interface {
+	Partition
+	Fence(ctx context.Context, generation int64) error
+	AckGeneration(ctx context.Context, generation, offset int64) error
+}
+
+// Outstanding is an offset the watermark is waiting on
+type Outstanding struct {
+	Offset int64 `json:"offset"`
+	// Age is how long ago the offset was handed out for processing and
+	// Worker what it was handed to, both are only set if the consumer
+	// keeps track
+	Age    time.Duration `json:"age_ns,omitempty"`
+	Worker string        `json:"worker,omitempty"`
+}
+
+// oldestOffsets returns the first n offsets missing from s, see Snapshot.Gaps
+func oldestOffsets(s tracker.Snapshot, n int) []int64 {
+	gaps, _ := s.Gaps(n)
+	var offsets []int64
+	for _, g := range gaps {
+		for o := g.From; o <= g.To && len(offsets) < n; o++ {
+			offsets = append(offsets, o)
+		}
+	}
+	return offsets
+}
+
+// PartitionStatus is what the admin API reports for a partition
+type PartitionStatus struct {
+	// Topic is empty for the brokers that don't have them
+	Topic     string `json:"topic,omitempty"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"`
+	Pending   int    `json:"pending"`
+	// Gaps are the offsets above the watermark still waiting for an ack,
+	// up to the highest acked one.  Only the first maxAdminGaps are
+	// listed, GapCount counts them all.
+	Gaps     []tracker.Range `json:"gaps,omitempty"`
+	GapCount int             `json:"gap_count"`
+	Paused   bool            `json:"paused"`
+}
+
+// maxAdminGaps bounds the gaps listed per partition, a long stall can leave
+// millions
+const maxAdminGaps = 1000
+
+// NewAdminHandler returns a handler for inspecting and controlling the
+// partitions returned by partitions, which is called on every request so
+// the set can change with rebalances.  A partition is addressed by its
+// number, with ?topic= if the number is tracked for several topics:
+//
+//	GET  /partitions               status of every partition
+//	GET  /partitions/{n}           status of partition n
+//	POST /partitions/{n}/pause     pause partition n
+//	POST /partitions/{n}/resume    resume partition n
+//	POST /partitions/{n}/flush     commit and persist partition n's
+//	                               watermark now
+//	POST /partitions/{n}/ack       ack ?offset= on partition n, for
+//	                               ?generation= if it is given
+//	POST /partitions/{n}/fence     move partition n on to ?generation=
+//	GET  /partitions/{n}/oldest    the offsets holding up partition n, ?n=
+//	                               sets how many (10 by default)
+//	GET  /safepoint                the lowest watermark of all partitions
+//	                               of the topic, or of those in
+//	                               ?partitions=0,2
+func NewAdminHandler(partitions func() map[TrackerKey]Partition) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.Trim(req.URL.Path, "/")
+		if path == "safepoint" {
+			serveSafePoint(w, req, partitions())
+			return
+		}
+		if path != "partitions" && !strings.HasPrefix(path, "partitions/") {
+			http.NotFound(w, req)
+			return
+		}
+		parts := partitions()
+		if path == "partitions" {
+			if req.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			keys := sortedKeys(parts)
+			statuses := make([]PartitionStatus, 0, len(keys))
+			for _, k := range keys {
+				s, err := partitionStatus(req.Context(), k, parts[k])
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+				statuses = append(statuses, s)
+			}
+			writeAdminJSON(w, statuses)
+			return
+		}
+
+		fields := strings.Split(strings.TrimPrefix(path, "partitions/"), "/")
+		id, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil || len(fields) > 2 {
+			http.NotFound(w, req)
+			return
+		}
+		key, p, err := findPartition(parts, req.URL.Query().Get("topic"), int32(id))
+		if err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, errNoPartition) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if len(fields) == 1 {
+			if req.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s, err := partitionStatus(req.Context(), key, p)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			writeAdminJSON(w, s)
+			return
+		}
+		if fields[1] == "oldest" {
+			if req.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			n := 10
+			if s := req.URL.Query().Get("n"); s != "" {
+				if n, err = strconv.Atoi(s); err != nil || n <= 0 {
+					http.Error(w, fmt.Sprintf("bad n %q", s), http.StatusBadRequest)
+					return
+				}
+			}
+			if n > maxAdminGaps {
+				n = maxAdminGaps
+			}
+			oldest, err := p.Oldest(req.Context(), n)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			writeAdminJSON(w, oldest)
+			return
+		}
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch fields[1] {
+		case "pause":
+			p.Pause()
+		case "resume":
+			p.Resume()
+		case "flush":
+			if err := p.Flush(req.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		case "ack":
+			s := req.URL.Query().Get("offset")
+			offset, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || offset < 0 {
+				http.Error(w, fmt.Sprintf("bad offset %q", s), http.StatusBadRequest)
+				return
+			}
+			if s := req.URL.Query().Get("generation"); s != "" {
+				fp, generation, ok := fenced(w, p, s)
+				if !ok {
+					return
+				}
+				err = fp.AckGeneration(req.Context(), generation, offset)
+			} else {
+				err = p.Ack(req.Context(), offset)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), generationStatus(err))
+				return
+			}
+		case "fence":
+			fp, generation, ok := fenced(w, p, req.URL.Query().Get("generation"))
+			if !ok {
+				return
+			}
+			if err := fp.Fence(req.Context(), generation); err != nil {
+				http.Error(w, err.Error(), generationStatus(err))
+				return
+			}
+		default:
+			http.NotFound(w, req)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// fenced parses the generation s for p, or writes the error if p has no
+// generations or s is no good
+func fenced(w http.ResponseWriter, p Partition, s string) (fencedPartition, int64, bool) {
+	fp, ok := p.(fencedPartition)
+	if !ok {
+		http.Error(w, "the partition has no generations", http.StatusNotImplemented)
+		return nil, 0, false
+	}
+	generation, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || generation < 0 {
+		http.Error(w, fmt.Sprintf("bad generation %q", s), http.StatusBadRequest)
+		return nil, 0, false
+	}
+	return fp, generation, true
+}
+
+// generationStatus is the status for an error from Ack or Fence: a stale
+// generation is the caller's conflict, anything else the partition being
+// unavailable
+func generationStatus(err error) int {
+	if errors.Is(err, tracker.ErrStaleGeneration) {
+		return http.StatusConflict
+	}
+	return http.StatusServiceUnavailable
+}
+
+// errNoPartition is what the error for a partition that isn't tracked is
+// to errors.Is
+var errNoPartition = errors.New("no partition")
+
+// findPartition returns the partition numbered id in parts, of topic
+// unless that is empty, in which case the number must only be tracked for
+// one topic
+func findPartition(parts map[TrackerKey]Partition, topic string, id int32) (TrackerKey, Partition, error) {
+	var found []TrackerKey
+	for k := range parts {
+		if k.Partition == id && (topic == "" || k.Topic == topic) {
+			found = append(found, k)
+		}
+	}
+	switch len(found) {
+	case 0:
+		if topic != "" {
+			return TrackerKey{}, nil, fmt.Errorf("%w %d of topic %s", errNoPartition, id, topic)
+		}
+		return TrackerKey{}, nil, fmt.Errorf("%w %d", errNoPartition, id)
+	case 1:
+		return found[0], parts[found[0]], nil
+	}
+	sortKeys(found)
+	return TrackerKey{}, nil, fmt.Errorf("partition %d is tracked as %v, say which topic", id, found)
+}
+
+// sortedKeys returns the keys of parts sorted, see sortKeys
+func sortedKeys(parts map[TrackerKey]Partition) []TrackerKey {
+	keys := make([]TrackerKey, 0, len(parts))
+	for k := range parts {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	return keys
+}
+
+// livePartitions returns whichever consumer live holds as the single
+// partition 0
+func livePartitions(live *atomic.Value) func() map[TrackerKey]Partition {
+	return func() map[TrackerKey]Partition {
+		c, ok := live.Load().(*consumer)
+		if !ok {
+			return nil
+		}
+		return map[TrackerKey]Partition{{}: c}
+	}
+}
+
+// serveAdmin serves the admin API on addr in the background, along with
+// the health and events endpoints for whichever of cfg's are set
+func serveAdmin(addr string, cfg benchConfig) error {
+	return listenAdmin(addr, livePartitions(cfg.live), cfg.health, cfg.feed)
+}
+
+// listenAdmin serves the admin API for partitions on addr in the
+// background, along with /metrics, /ws, and the health and events
+// endpoints if health and feed are set
+func listenAdmin(addr string, partitions func() map[TrackerKey]Partition, health *Health, feed *progressFeed) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", NewAdminHandler(partitions))
+	mux.Handle("/metrics", NewMetricsHandler(partitions))
+	if health != nil {
+		mux.HandleFunc("/healthz", health.Healthz)
+		mux.HandleFunc("/readyz", health.Readyz)
+	}
+	if feed != nil {
+		mux.Handle("/events", feed)
+	}
+	mux.Handle("/ws", NewControlHandler(partitions, time.Second))
+	fmt.Printf("admin API listening on %v\n", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			fmt.Printf("admin API: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+func partitionStatus(ctx context.Context, key TrackerKey, p Partition) (PartitionStatus, error) {
+	s, err := p.Status(ctx)
+	s.Topic, s.Partition = key.Topic, key.Partition
+	return s, err
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// SafePoint is what /safepoint returns
+type SafePoint struct {
+	SafePoint  int64   `json:"safe_point"`
+	Partitions []int32 `json:"partitions"`
+}
+
+func serveSafePoint(w http.ResponseWriter, req *http.Request, all map[TrackerKey]Partition) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// a safe point is over the partitions of one topic
+	topic := req.URL.Query().Get("topic")
+	if topic == "" {
+		topics := make(map[string]bool)
+		for k := range all {
+			topic, topics[k.Topic] = k.Topic, true
+		}
+		if len(topics) > 1 {
+			http.Error(w, "partitions of several topics are tracked, say which topic", http.StatusBadRequest)
+			return
+		}
+	}
+	parts := make(map[int32]Partition, len(all))
+	for k, p := range all {
+		if k.Topic == topic {
+			parts[k.Partition] = p
+		}
+	}
+	var ids []int32
+	if s := req.URL.Query().Get("partitions"); s != "" {
+		for _, f := range strings.Split(s, ",") {
+			id, err := strconv.ParseInt(f, 10, 32)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("bad partition %q", f), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, int32(id))
+		}
+	} else {
+		for id := range parts {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	agg := NewAggregator(len(parts))
+	for id, p := range parts {
+		agg.Update(id, p.Committed())
+	}
+	safe, ok := agg.SafePointOf(ids...)
+	if !ok {
+		http.Error(w, "not every partition is tracked", http.StatusServiceUnavailable)
+		return
+	}
+	writeAdminJSON(w, SafePoint{SafePoint: safe, Partitions: ids})
+}
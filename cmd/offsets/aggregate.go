@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+)
+
+// Aggregator combines the watermarks of a topic's partitions into a safe
+// point: a stream processor can emit what it computed from everything up
+// to the safe point knowing nothing more should come in for it.  What that
+// means across partitions is up to Combine, by default it is the lowest
+// watermark.  It is safe for concurrent use.
+type Aggregator struct {
+	// Combine folds the partitions' watermarks into the safe point, nil
+	// is MinWatermark
+	Combine func(watermarks map[int32]int64) int64
+
+	mu         sync.Mutex
+	partitions int
+	watermarks map[int32]int64
+}
+
+// NewAggregator returns an aggregator over partitions partitions, which has
+// no safe point until each of them has a watermark
+func NewAggregator(partitions int) *Aggregator {
+	return &Aggregator{partitions: partitions, watermarks: make(map[int32]int64, partitions)}
+}
+
+// Update sets a partition's watermark and returns the safe point after it
+func (a *Aggregator) Update(partition int32, watermark int64) (int64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.watermarks[partition] = watermark
+	return a.safePoint(a.watermarks)
+}
+
+// SafePoint returns the safe point, ok is false until every partition has
+// a watermark
+func (a *Aggregator) SafePoint() (safe int64, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.safePoint(a.watermarks)
+}
+
+// SafePointOf returns the safe point of some of the partitions only, like
+// those a key's records can be in.  Its ok is false until all of them have
+// a watermark.
+func (a *Aggregator) SafePointOf(partitions ...int32) (safe int64, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	some := make(map[int32]int64, len(partitions))
+	for _, p := range partitions {
+		w, ok := a.watermarks[p]
+		if !ok {
+			return 0, false
+		}
+		some[p] = w
+	}
+	return a.combine(some), len(some) > 0
+}
+
+func (a *Aggregator) safePoint(watermarks map[int32]int64) (int64, bool) {
+	if len(watermarks) < a.partitions || len(watermarks) == 0 {
+		return 0, false
+	}
+	return a.combine(watermarks), true
+}
+
+func (a *Aggregator) combine(watermarks map[int32]int64) int64 {
+	if a.Combine != nil {
+		return a.Combine(watermarks)
+	}
+	return MinWatermark(watermarks)
+}
+
+// MinWatermark is the lowest of watermarks: everything up to it is
+// committed on every partition
+func MinWatermark(watermarks map[int32]int64) int64 {
+	first := true
+	var min int64
+	for _, w := range watermarks {
+		if first || w < min {
+			min, first = w, false
+		}
+	}
+	return min
+}
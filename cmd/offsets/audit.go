@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// CommitAudit is what the audit trail records of every commit serve makes
+type CommitAudit struct {
+	Group     string `json:"group,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Partition int32  `json:"partition"`
+	// Old is the watermark committed before, New the one committed now
+	Old       int64     `json:"old_watermark"`
+	New       int64     `json:"new_watermark"`
+	Timestamp time.Time `json:"timestamp"`
+	Host      string    `json:"host"`
+}
+
+// auditLog produces a CommitAudit to a Kafka topic for every commit, keyed
+// by partition so that each partition's history stays in order and can be
+// replayed.  Records are produced in the background, one that fails is
+// reported and missing from the trail but doesn't hold up commits.
+type auditLog struct {
+	cl    *kgo.Client
+	topic string
+	host  string
+}
+
+func newAuditLog(brokers, topic string) (*auditLog, error) {
+	if brokers == "" {
+		return nil, fmt.Errorf("-audit-topic needs -kafka-brokers")
+	}
+	cl, err := kgo.NewClient(kgo.SeedBrokers(strings.Split(brokers, ",")...), kgo.DefaultProduceTopic(topic))
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	return &auditLog{cl: cl, topic: topic, host: host}, nil
+}
+
+// committed returns a committer's onCommit that records the commits of key
+func (a *auditLog) committed(key TrackerKey) func(old, new int64) {
+	return func(old, new int64) {
+		value, err := json.Marshal(CommitAudit{
+			Group:     key.Group,
+			Topic:     key.Topic,
+			Partition: key.Partition,
+			Old:       old,
+			New:       new,
+			Timestamp: time.Now().UTC(),
+			Host:      a.host,
+		})
+		if err != nil {
+			fmt.Printf("%v: auditing commit of %v: %v\n", key, new, err)
+			return
+		}
+		a.cl.Produce(context.Background(), &kgo.Record{Key: []byte(key.String()), Value: value}, func(_ *kgo.Record, err error) {
+			if err != nil {
+				fmt.Printf("%v: auditing commit of %v to %v: %v\n", key, new, a.topic, err)
+			}
+		})
+	}
+}
+
+// close waits up to timeout for the records still on their way
+func (a *auditLog) close(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := a.cl.Flush(ctx); err != nil {
+		fmt.Printf("flushing the audit trail: %v\n", err)
+	}
+	a.cl.Close()
+}
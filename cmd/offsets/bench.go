@@ -0,0 +1,1316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/store"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// benchConfig describes a single run of the commit simulation
+type benchConfig struct {
+	// name labels the run in reports, it defaults to the backend name
+	name    string
+	backend string
+	// sizeHint is passed to the backend, see backends
+	sizeHint int
+	// seed determines every random choice of the run
+	seed         int64
+	distribution string
+	workers      string
+	numMsgs      int64
+	maxDelay     time.Duration
+	// tick is how often progress is checked and printed
+	tick time.Duration
+	// broker commits are only simulated when latency or rate is set,
+	// otherwise the run measures bookkeeping alone
+	brokerLatency  time.Duration
+	brokerRate     float64
+	commitInterval time.Duration
+	// commitMaxAdvance commits early once the watermark is this far past
+	// the last commit, zero means only every commitInterval
+	commitMaxAdvance int64
+	// commitInFlight makes the commits asynchronous with up to this many
+	// on their way, zero waits for each
+	commitInFlight int
+	// commitFailRate is the probability of a broker commit failing
+	commitFailRate float64
+	retry          adapter.RetryPolicy
+	// breakerFailures is the number of failed commits in a row that open
+	// the circuit breaker, zero means no breaker
+	breakerFailures int
+	breakerCooldown time.Duration
+	chaos           chaosConfig
+	restart         restartConfig
+	hol             holConfig
+	// envelopes sends acks to the committer as pooled ackEnvelopes
+	// instead of bare offsets
+	envelopes bool
+	// ackBuffer is parsed by parseAckBuffer
+	ackBuffer string
+	// compactInterval is how often the tracker is compacted, zero never
+	compactInterval time.Duration
+	// budget is the tracker's Budget in KiB
+	budget      int64
+	maxInFlight int64
+	// pressurePending and pressureLag are the tracker's PendingHigh and
+	// LagHigh
+	pressurePending int
+	pressureLag     int64
+	pause           pauseConfig
+	// stuckDeadline and stuckPolicy set the tracker's Deadline and
+	// StuckPolicy, the policy is a key of stuckPolicies
+	stuckDeadline time.Duration
+	stuckPolicy   string
+	// maxLag and maxStall set the tracker's MaxLag and MaxStall
+	maxLag   int64
+	maxStall time.Duration
+	// absentRate is the fraction of offsets that are never delivered,
+	// like transaction markers, which the consumer declares absent
+	absentRate float64
+	// nackRate is the fraction of messages that fail for good and are
+	// nacked instead of acked, dlqLatency is how long publishing one to
+	// the dead letter queue takes
+	nackRate   float64
+	dlqLatency time.Duration
+	// nackRetry redelivers failed messages before they are nacked, each
+	// attempt fails with nackRate.  nackJitter is the random fraction of
+	// every backoff.
+	nackRetry  adapter.RetryPolicy
+	nackJitter float64
+	// ballast is the size in MiB of a GC ballast held for the whole run,
+	// which makes the GC target at least twice that
+	ballast int
+	// baseline names the run without ballast that this one is compared
+	// to in the report
+	baseline string
+	// record is a path to save the trace of this run to
+	record string
+	// replay, when set, replaces the worker model and distribution with
+	// a recorded trace
+	replay []traceEvent
+	// live, when set, is kept pointing at the running consumer for the
+	// admin API and state dumps
+	live *atomic.Value
+	// health, when set, is where the consumer reports its health, with a
+	// watchdog failing it once the watermark is stuck for stallAfter
+	health     *Health
+	stallAfter time.Duration
+	// clock runs the run's timers and the trackers, nil is RealClock
+	clock tracker.Clock
+	// feed, when set, gets the progress of every tick
+	feed *progressFeed
+	// tunables, when set, override the settings they have and may change
+	// during the run
+	tunables *liveTunables
+	// shutdown is closed to stop the run early, see benchRun.shutdown
+	shutdown <-chan struct{}
+	// bundleDir, when set, is where a repro bundle is written if the run
+	// stalls or breaks an invariant, args is the command line that goes
+	// in it
+	bundleDir string
+	args      []string
+	// groups is how many consumer groups run the workload at once, see
+	// runGroups, and quiet keeps a group's progress from being printed
+	groups int
+	quiet  bool
+	// logLevel is how much of its progress the run prints, see
+	// logLevels, the config file's log_level overrides it
+	logLevel string
+	// events, when set, gets the run's watermark advances, stalls and
+	// skips as cloudevents
+	events *cloudEmitter
+	// dash, when set, shows the run on the -tui dashboard
+	dash *dashboard
+	// timeline keeps the committed offset, pending acks and heap of
+	// every tick and the ack latencies in the result, for -report, and
+	// chart draws them once the run is over
+	timeline bool
+	chart    bool
+	// heatmap, when set, samples where the gaps are every tick and draws
+	// them once the run is over, see writeRunHeatmap
+	heatmap string
+	// cpuProfile, when set, is where a CPU profile of the measured part
+	// of the run is written
+	cpuProfile string
+	// heapProfiles, when its dir is set, writes heap profiles as the
+	// run goes
+	heapProfiles heapSchedule
+}
+
+// benchResult holds what we measured during a run
+type benchResult struct {
+	name     string
+	backend  string
+	sizeHint int
+	numMsgs  int64
+	duration time.Duration
+	ballast  int
+	baseline string
+	// ackBuffer describes the buffer between workers and tracker,
+	// bufferBytes is the most memory it held and ackLatency the time
+	// sampled acks spent in it
+	ackBuffer   string
+	bufferBytes int64
+	ackLatency  struct{ p50, p99, max time.Duration }
+	// compactions counts the compactions that freed something
+	compactions int
+	budget      int64
+	maxInFlight int64
+	// refused counts acks the tracker turned away for being over budget,
+	// peakHeld is the most that were waiting to be retried at once
+	refused  int64
+	peakHeld int
+	// paused is how long the consumer was paused, pause is false when no
+	// pause was configured
+	pause  bool
+	paused time.Duration
+	// expired counts offsets that held up the watermark past the
+	// deadline and skipped those that were given up on, nacked counts
+	// failed messages and deadLettered those that went to the dead
+	// letter queue for either reason
+	stuckPolicy  string
+	expired      int64
+	skipped      int64
+	nacked       int64
+	deadLettered int64
+	// retried counts redeliveries of failed messages
+	retried int64
+	// gapsSkipped counts the gaps given up on for lagging and gapOffsets
+	// the offsets in them
+	gapsSkipped int64
+	gapOffsets  int64
+	// absent counts the offsets declared absent
+	absent int64
+
+	pressure bool
+	// pressureEpisodes counts the times the tracker signalled pressure,
+	// underPressure is how long it lasted in total
+	pressureEpisodes int
+	underPressure    time.Duration
+	// peakHeap is the largest HeapAlloc seen at any tick, not counting
+	// the ballast
+	peakHeap uint64
+	numGC    uint32
+	allocs   uint64
+	// broker is false when broker commits weren't simulated
+	broker         bool
+	brokerCommits  int64
+	commitFailures int64
+	// breaker is false when there was no circuit breaker
+	breaker         bool
+	breakerTrips    int64
+	breakerOpen     time.Duration
+	breakerRejected int64
+	// maxLag is the largest distance between the tracker's watermark
+	// and the broker's committed offset seen at any tick
+	maxLag int64
+	// longestStall is the longest time the watermark didn't move
+	longestStall time.Duration
+	// peakPending is the largest number of acked offsets waiting on a
+	// gap at any point
+	peakPending int
+	// committed is the final watermark, which is below numMsgs-1 when
+	// the run stalled
+	committed  int64
+	stalled    bool
+	duplicates int64
+	// interrupted is set when the run was cut short by a shutdown
+	interrupted bool
+	// group is the name of the run this was one consumer group of, and
+	// groups how many there were
+	group  string
+	groups int
+
+	chaos bool
+	// copied from chaosStats at the end of the run
+	dropped, duplicated, reordered int64
+
+	restart      bool
+	restoredFrom string
+	// restoredAt is the watermark the restarted consumer started from
+	restoredAt  int64
+	redelivered int64
+	// reprocessed counts the messages that were processed more than once
+	reprocessed int64
+	// lost counts offsets that were committed without ever being
+	// processed, anything but zero is a bug
+	lost int64
+	// timeline is the committed offset, pending acks and heap of every
+	// tick and latencies the histogram of the sampled ack latencies,
+	// kept with benchConfig.timeline
+	timeline  []timelinePoint
+	latencies []latencyBucket
+	// settings is how the run was configured, for the report
+	settings [][2]string
+	// heat is where the gaps were at every tick, kept with -heatmap
+	heat []heatSample
+}
+
+// throughput returns committed messages per second
+func (r benchResult) throughput() float64 {
+	if r.duration <= 0 {
+		return 0
+	}
+	return float64(r.numMsgs) / r.duration.Seconds()
+}
+
+// benchRun is the state of a single call to runBench
+type benchRun struct {
+	cfg     benchConfig
+	numMsgs int64
+	backend backend.Backend
+	spawn   workerModel
+	delay   func(offset int64) time.Duration
+	start   time.Time
+	res     benchResult
+
+	// cur is the live consumer, only a restart replaces it
+	cur *consumer
+	// consumers counts the consumers started so far
+	consumers int
+	broker    *adapter.SimBroker
+	store     store.Store
+
+	trace []traceEvent
+	// ring keeps the latest acks for a repro bundle, nil when bundles
+	// are off
+	ring  *eventRing
+	chaos *chaosStats
+	// processed counts acks handled by the tracker so that a chaos run
+	// knows when everything has arrived
+	processed int64
+	// seen counts how many times each offset was processed, so that a
+	// restart can be checked for lost offsets
+	seen      []uint8
+	restarted bool
+	// pausedConsumer is set once the configured pause has started
+	pausedConsumer *consumer
+	// peakPending is only touched by the ack loop until the run is over
+	peakPending int
+	// tempStore is removed at the end of the run
+	tempStore string
+
+	buffer ackBuffer
+	times  *ackTimes
+	// latencies is only touched by the ack loop until the run is over
+	latencies   []time.Duration
+	bufferBytes int64
+	// compactions, refused, peakHeld and the stuck offset counters are
+	// only touched by the ack loop until the run is over
+	compactions              int
+	refused                  int64
+	peakHeld                 int
+	expired, skipped, nacked int64
+	gapsSkipped, gapOffsets  int64
+	// declared is how many offsets the first consumer declared absent
+	declared int64
+
+	dlq *simDLQ
+}
+
+// consumer is one incarnation of the simulated consumer: the tracker, the
+// goroutine feeding it acks and the committer pushing its watermark to the
+// broker
+type consumer struct {
+	tracker *tracker.Tracker
+	// acks arrive on one of these depending on benchConfig.envelopes
+	acks chan int64
+	envs chan *ackEnvelope
+	// queue replaces the channels with an adaptive buffer
+	queue *ackQueue
+	times *ackTimes
+	// held are acks refused by the tracker, waiting to be retried
+	held offsetHeap
+	// retry is nil unless failed messages are retried
+	retry *retrier
+	// breaker is nil unless commits go through a circuit breaker
+	breaker *adapter.CircuitBreaker
+	*pauser
+	cmt *adapter.Committer
+	// store is the run's, nil when snapshots aren't persisted
+	store store.Store
+	// calls are run by the ack loop, which is the only goroutine that
+	// may touch the tracker
+	calls chan func()
+	// startedAt is when the consumer's messages were handed out, worker
+	// names what processes an offset
+	startedAt time.Time
+	worker    func(offset int64) string
+	// stopped is done once stop tears the consumer down
+	stopped context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func runBench(cfg benchConfig) (benchResult, error) {
+	r, err := newBenchRun(cfg)
+	if err != nil {
+		return benchResult{}, err
+	}
+	return r.run()
+}
+
+// delayFunc returns the processing time of every offset of cfg's workload
+func (cfg benchConfig) delayFunc() (func(offset int64) time.Duration, error) {
+	dist, ok := distributions[cfg.distribution]
+	if !ok {
+		return nil, fmt.Errorf("unknown distribution %q (available: %s)", cfg.distribution, strings.Join(names(distributions), ", "))
+	}
+	return func(offset int64) time.Duration {
+		if cfg.hol.delay > 0 && offset == cfg.hol.offset {
+			return cfg.hol.delay
+		}
+		return dist(offsetFloat(cfg.seed, offset), cfg.maxDelay)
+	}, nil
+}
+
+func newBenchRun(cfg benchConfig) (*benchRun, error) {
+	b, err := backend.New(cfg.backend, cfg.sizeHint)
+	if err != nil {
+		return nil, err
+	}
+	delay, err := cfg.delayFunc()
+	if err != nil {
+		return nil, err
+	}
+	spawn, ok := workerModels[cfg.workers]
+	if !ok {
+		return nil, fmt.Errorf("unknown worker model %q (available: %s)", cfg.workers, strings.Join(names(workerModels), ", "))
+	}
+	r := &benchRun{
+		cfg:     cfg,
+		numMsgs: cfg.numMsgs,
+		backend: b,
+		spawn:   spawn,
+		delay:   delay,
+	}
+	if cfg.replay != nil {
+		r.spawn = replayWorkers(cfg.replay)
+		r.numMsgs = int64(len(cfg.replay))
+	}
+	if r.buffer, err = parseAckBuffer(cfg.ackBuffer); err != nil {
+		return nil, err
+	}
+	if r.buffer.mode == "adaptive" && cfg.envelopes {
+		return nil, fmt.Errorf("the adaptive ack buffer only carries bare offsets, not envelopes")
+	}
+	if cfg.stuckDeadline > 0 {
+		if _, ok := stuckPolicies[cfg.stuckPolicy]; !ok {
+			return nil, fmt.Errorf("unknown stuck policy %q (available: %s)", cfg.stuckPolicy, strings.Join(names(stuckPolicies), ", "))
+		}
+	}
+	if cfg.nackRate > 0 || (cfg.stuckDeadline > 0 && cfg.stuckPolicy == "deadletter") {
+		r.dlq = newSimDLQ(cfg.dlqLatency)
+	}
+	r.times = newAckTimes(r.numMsgs)
+	r.latencies = make([]time.Duration, 0, r.numMsgs>>latencyShift+1)
+	if cfg.record != "" {
+		if cfg.chaos.enabled() {
+			return nil, fmt.Errorf("can't record a trace of a chaos run")
+		}
+		// allocate up front so recording doesn't show up as growth
+		// during the run
+		r.trace = make([]traceEvent, 0, r.numMsgs)
+	}
+	if cfg.chaos.enabled() {
+		r.chaos = &chaosStats{}
+	}
+	if cfg.bundleDir != "" {
+		r.ring = &eventRing{}
+		// a trace makes the bundle replay exactly, but chaos, restarts
+		// and redeliveries can't be replayed
+		if r.trace == nil && !cfg.chaos.enabled() && !cfg.restart.enabled() && cfg.nackRetry.Attempts <= 1 {
+			r.trace = make([]traceEvent, 0, r.numMsgs)
+		}
+	}
+	if cfg.restart.enabled() {
+		if err := r.setupRestart(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.brokerLatency > 0 || cfg.brokerRate > 0 || cfg.commitFailRate > 0 || cfg.restart.from == "broker" {
+		r.broker = adapter.NewSimBroker(cfg.brokerLatency, cfg.brokerRate)
+	}
+	return r, nil
+}
+
+func (r *benchRun) run() (benchResult, error) {
+	cfg, numMsgs := r.cfg, r.numMsgs
+	fmt.Printf("running %v with %v messages\n", cfg.name, numMsgs)
+	task := newRunTask(cfg.name)
+	defer task.end()
+	task.phase("setup")
+	// the ballast is never touched, so its pages are never faulted in
+	// and it costs address space rather than memory.  It holds no
+	// pointers either, so the GC doesn't scan it, all it does is raise
+	// the heap size the GC paces itself against.
+	ballast := make([]byte, cfg.ballast<<20)
+	defer runtime.KeepAlive(ballast)
+	PrintMemUsage()
+
+	// If each goroutine commits to the set directly, we'll need
+	// a mutex and we'll have 10 million goroutines competing for
+	// that mutex. So make a channel and do the commit single threaded.
+	r.cur = r.startConsumer(tracker.New(r.backend, -1), numMsgs)
+	deliver := r.skipAbsent(r.cur.deliver)
+
+	// create a WaitGroup so all workers will start running together
+	waitStart := sync.WaitGroup{}
+	waitStart.Add(1)
+	// with chaos on, workers ack into the chaos stage which decides what
+	// actually reaches the tracker
+	inStage(stageWorker, func() {
+		if r.chaos != nil {
+			workerChan := make(chan int64, numMsgs)
+			r.spawn(numMsgs, r.delay, func(offset int64) { workerChan <- offset }, &waitStart)
+			go runChaos(cfg.chaos, newRand(cfg.seed, chaosStream), numMsgs, workerChan, deliver, r.chaos)
+		} else {
+			r.spawn(numMsgs, r.delay, deliver, &waitStart)
+		}
+	})
+
+	fmt.Printf("waking %v workers\n", cfg.workers)
+	PrintMemUsage()
+	var before, m runtime.MemStats
+	runtime.ReadMemStats(&before)
+	res := &r.res
+	*res = benchResult{
+		name:        cfg.name,
+		backend:     cfg.backend,
+		sizeHint:    cfg.sizeHint,
+		numMsgs:     numMsgs,
+		ballast:     cfg.ballast,
+		baseline:    cfg.baseline,
+		ackBuffer:   r.buffer.String(),
+		budget:      cfg.budget,
+		maxInFlight: cfg.maxInFlight,
+		pressure:    cfg.pressurePending > 0 || cfg.pressureLag > 0,
+		pause:       cfg.pause.enabled(),
+		breaker:     cfg.breakerFailures > 0,
+		peakHeap:    before.HeapAlloc,
+		broker:      r.broker != nil,
+		chaos:       r.chaos != nil,
+		restart:     cfg.restart.enabled(),
+	}
+	if res.restart {
+		res.restoredFrom = cfg.restart.from
+	}
+	task.phase("measure")
+	// the profile stops with the measured part, or when a run that
+	// fails returns
+	stopProfile := func() {}
+	if cfg.cpuProfile != "" {
+		stop, err := startCPUProfile(cfg.cpuProfile)
+		if err != nil {
+			return *res, err
+		}
+		stopProfile = stop
+	}
+	defer func() { stopProfile() }()
+	clock := orReal(cfg.clock)
+	r.start = clock.Now()
+	waitStart.Done()
+	fmt.Printf("starting commit test\n")
+	PrintMemUsage()
+	// check the max committed value every tick
+	tick := cfg.tick
+	ticker := clock.NewTicker(tick)
+	defer ticker.Stop()
+	lastCommitted, lastProgress := r.committed(), r.start
+	bar := newProgressBar(numMsgs)
+	var heap *heapProfiler
+	if cfg.heapProfiles.dir != "" {
+		heap = &heapProfiler{heapSchedule: cfg.heapProfiles, run: cfg.name, numMsgs: numMsgs}
+	}
+ticks:
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C():
+		case <-cfg.shutdown:
+			res.interrupted = true
+			if err := r.shutdown(); err != nil {
+				return *res, err
+			}
+			break ticks
+		}
+		var tn tunables
+		if cfg.tunables != nil {
+			tn = cfg.tunables.get()
+			if d := tn.ProgressInterval; d > 0 && d != tick {
+				tick = d
+				ticker.Reset(tick)
+			}
+		}
+		level := tn.logLevel(cfg.logLevel)
+		quiet := cfg.quiet || level == "warn"
+		detailed := !quiet && level == "debug"
+		c := r.committed()
+		if c != lastCommitted {
+			if cfg.events != nil {
+				cfg.events.emit(eventAdvanced, cfg.name, advancedData{Old: lastCommitted, New: c})
+			}
+			lastCommitted, lastProgress = c, now
+		} else if stall := now.Sub(lastProgress); stall > res.longestStall {
+			res.longestStall = stall
+		}
+		runtime.ReadMemStats(&m)
+		if m.HeapAlloc > res.peakHeap {
+			res.peakHeap = m.HeapAlloc
+		}
+		if cfg.timeline {
+			pt := timelinePoint{at: now.Sub(r.start), committed: c, heap: m.HeapAlloc}
+			r.cur.call(context.Background(), func() { pt.pending = r.cur.tracker.Pending() })
+			res.timeline = append(res.timeline, pt)
+		}
+		if cfg.heatmap != "" {
+			var snap tracker.Snapshot
+			r.cur.call(context.Background(), func() { snap = r.cur.tracker.Snapshot() })
+			res.heat = append(res.heat, sampleGaps(snap, numMsgs, now.Sub(r.start)))
+		}
+		if cfg.feed != nil && cfg.feed.active() {
+			p := Progress{Run: cfg.name, Elapsed: now.Sub(r.start), Committed: c, HeapBytes: m.HeapAlloc}
+			r.cur.call(context.Background(), func() { p.Pending = r.cur.tracker.Pending() })
+			cfg.feed.publish(p)
+		}
+		if r.broker != nil {
+			lag := r.cur.tracker.Committed() - c
+			if lag > res.maxLag {
+				res.maxLag = lag
+			}
+			if detailed {
+				fmt.Printf("Committed %v (tracker %v, lag %v)\n", c, c+lag, lag)
+			}
+		} else if detailed {
+			fmt.Printf("Committed %v\n", c)
+		}
+		if !quiet && !detailed {
+			bar.update(c, now.Sub(r.start))
+		}
+		if heap != nil {
+			if err := heap.tick(c, now.Sub(r.start)); err != nil {
+				return *res, err
+			}
+		}
+		if c >= numMsgs-1 {
+			break
+		}
+		// some acks were dropped and the watermark can never finish,
+		// stop once everything that can be committed has been
+		if r.chaos != nil {
+			done, forwarded := r.chaos.finished()
+			// a policy that gives up on stuck offsets can still move
+			// the watermark, so give it a couple of deadlines
+			skipping := cfg.stuckDeadline > 0 && cfg.stuckPolicy != "block" && now.Sub(lastProgress) < 2*cfg.stuckDeadline ||
+				cfg.maxStall > 0 && now.Sub(lastProgress) < 2*cfg.maxStall
+			if done && atomic.LoadInt64(&r.processed) == forwarded && c == r.cur.tracker.Committed() && !skipping {
+				bar.finish()
+				fmt.Printf("watermark stalled at %v, every ack has been delivered\n", c)
+				res.stalled = true
+				break
+			}
+		}
+		if cfg.pause.enabled() && r.pausedConsumer == nil && now.Sub(r.start) >= cfg.pause.at {
+			c := r.cur
+			r.pausedConsumer = c
+			c.Pause()
+			fmt.Printf("consumer paused at watermark %v\n", c.tracker.Committed())
+			afterFunc(clock, cfg.pause.length, func() {
+				c.Resume()
+				fmt.Printf("consumer resumed\n")
+			})
+		}
+		if r.shouldRestart(now) {
+			if err := r.restart(); err != nil {
+				return *res, err
+			}
+		}
+		if detailed {
+			PrintMemUsage()
+		}
+	}
+	bar.finish()
+	res.duration = clock.Now().Sub(r.start)
+	task.phase("teardown")
+	stopProfile()
+	stopProfile = func() {}
+	res.peakHeap -= uint64(len(ballast))
+	res.numGC = m.NumGC - before.NumGC
+	res.allocs = m.Mallocs - before.Mallocs
+	res.committed = r.committed()
+	r.stopConsumer()
+	res.duplicates = r.cur.tracker.Duplicates()
+	if cfg.stuckDeadline > 0 {
+		res.stuckPolicy = cfg.stuckPolicy
+	}
+	res.expired, res.skipped, res.nacked = r.expired, r.skipped, r.nacked
+	res.gapsSkipped, res.gapOffsets = r.gapsSkipped, r.gapOffsets
+	res.absent = r.declared
+	if r.dlq != nil {
+		res.deadLettered = r.dlq.published[tracker.ReasonNack] + r.dlq.published[tracker.ReasonDeadline]
+	}
+	if r.pausedConsumer != nil {
+		res.paused = r.pausedConsumer.PausedFor()
+	}
+	res.bufferBytes = r.bufferBytes
+	res.compactions = r.compactions
+	res.refused, res.peakHeld = r.refused, r.peakHeld
+	if cfg.compactInterval > 0 {
+		fmt.Printf("compacted the pending set %v times\n", res.compactions)
+	}
+	p50, p99, max := percentiles(r.latencies)
+	if cfg.timeline {
+		res.latencies = latencyHistogram(r.latencies)
+		res.settings = cfg.settings()
+	}
+	res.ackLatency.p50, res.ackLatency.p99, res.ackLatency.max = p50, p99, max
+	res.peakPending = r.peakPending
+	if r.chaos != nil {
+		res.dropped = atomic.LoadInt64(&r.chaos.dropped)
+		res.duplicated = atomic.LoadInt64(&r.chaos.duplicated)
+		res.reordered = atomic.LoadInt64(&r.chaos.reordered)
+	}
+	if r.broker != nil {
+		res.brokerCommits = r.broker.Commits()
+	}
+	if res.restart {
+		r.verifyRestart()
+	}
+	if r.ring != nil {
+		switch {
+		case res.stalled:
+			r.writeBundle(r.cur.tracker, fmt.Sprintf("watermark stalled at %v", res.committed))
+		case res.lost > 0:
+			r.writeBundle(r.cur.tracker, fmt.Sprintf("%v offsets lost", res.lost))
+		}
+	}
+	runtime.GC()
+	PrintMemUsage()
+	fmt.Printf("finished test in %v\n", res.duration)
+	if cfg.chart {
+		writeTimelineCharts(os.Stdout, res.timeline)
+	}
+	if cfg.heatmap != "" {
+		if err := writeRunHeatmap(cfg.heatmap, cfg.name, res.heat, numMsgs); err != nil {
+			return *res, err
+		}
+	}
+	if cfg.record != "" {
+		if err := writeTrace(cfg.record, r.trace); err != nil {
+			return *res, err
+		}
+		fmt.Printf("recorded trace of %v acks to %v\n", len(r.trace), cfg.record)
+	}
+	return *res, nil
+}
+
+// committed is what the run waits for: the broker's view when it is
+// simulated, otherwise the tracker's
+func (r *benchRun) committed() int64 {
+	if r.broker != nil {
+		return r.broker.Committed()
+	}
+	return r.cur.tracker.Committed()
+}
+
+// startConsumer starts feeding acks to t, and committing its watermark if
+// the broker is simulated.  A full ack buffer is sized for numMsgs acks.
+func (r *benchRun) startConsumer(t *tracker.Tracker, numMsgs int64) *consumer {
+	t.Budget = r.cfg.budget << 10
+	t.MaxInFlight = r.cfg.maxInFlight
+	t.PendingHigh, t.LagHigh = r.cfg.pressurePending, r.cfg.pressureLag
+	if r.cfg.stuckDeadline > 0 {
+		t.Deadline = r.cfg.stuckDeadline
+		t.StuckPolicy = stuckPolicies[r.cfg.stuckPolicy]
+		t.OnExpire = func(offset int64, stuck time.Duration) {
+			r.expired++
+			fmt.Printf("offset %v has held up the watermark for %v\n", offset, stuck.Round(time.Millisecond))
+			if r.cfg.events != nil && t.StuckPolicy == tracker.StuckSkip {
+				r.cfg.events.emit(eventSkipped, r.cfg.name, skippedData{From: offset, To: offset})
+			}
+		}
+	}
+	if r.cfg.maxLag > 0 || r.cfg.maxStall > 0 {
+		t.MaxLag, t.MaxStall = r.cfg.maxLag, r.cfg.maxStall
+		t.OnGapSkip = func(gap tracker.Range) {
+			r.gapsSkipped++
+			r.gapOffsets += gap.To - gap.From + 1
+			fmt.Printf("skipped offsets %v-%v\n", gap.From, gap.To)
+			if r.cfg.events != nil {
+				r.cfg.events.emit(eventSkipped, r.cfg.name, skippedData{From: gap.From, To: gap.To})
+			}
+		}
+	}
+	if r.dlq != nil {
+		t.DLQ = r.dlq
+	}
+	if r.cfg.clock != nil {
+		t.SetClock(r.cfg.clock)
+	}
+	if r.cfg.absentRate > 0 {
+		r.declareAbsent(t)
+	}
+	if r.cfg.tunables != nil {
+		r.cfg.tunables.get().setTracker(t)
+	}
+	c := &consumer{tracker: t, times: r.times, pauser: newPauser(), store: r.store, calls: make(chan func())}
+	c.stopped, c.stop = context.WithCancel(context.Background())
+	c.pauser.clock = orReal(r.cfg.clock)
+	c.startedAt, c.worker = c.pauser.clock.Now(), r.workerName
+	if r.cfg.nackRetry.Attempts > 1 {
+		rng := newRand(r.cfg.seed, retryStream+int64(r.consumers)<<8)
+		c.retry = newRetrier(r.cfg.nackRetry, r.cfg.nackJitter, rng, func(offset int64, after time.Duration) {
+			// the message is processed again once the backoff is up
+			afterFunc(orReal(r.cfg.clock), after+r.delay(offset), func() { c.deliver(offset) })
+		})
+	}
+	size := int(numMsgs)
+	switch r.buffer.mode {
+	case "bounded":
+		size = r.buffer.size
+	case "adaptive":
+		c.queue = newAckQueue()
+	}
+	switch {
+	case c.queue != nil:
+	case r.cfg.envelopes:
+		c.envs = make(chan *ackEnvelope, size)
+	default:
+		c.acks = make(chan int64, size)
+	}
+	if c.queue == nil && int64(size)*8 > r.bufferBytes {
+		// both an int64 and a pointer are 8 bytes
+		r.bufferBytes = int64(size) * 8
+	}
+	r.consumers++
+	// every consumer is a new assignment, commits its predecessor still
+	// makes are fenced off; a new generation is never stale
+	t.Fence(int64(r.consumers))
+	if r.cfg.live != nil {
+		r.cfg.live.Store(c)
+	}
+	if r.cfg.dash != nil {
+		r.cfg.dash.track(r.cfg.name, c)
+	}
+	if r.cfg.health != nil {
+		r.cfg.health.SetReady(true)
+	}
+	if (r.cfg.health != nil || r.cfg.events != nil) && r.cfg.stallAfter > 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			r.watchStall(c, r.cfg.stallAfter)
+		}()
+	}
+	c.wg.Add(1)
+	go inStage(stageTracker, func() {
+		defer c.wg.Done()
+		r.ackLoop(c)
+	})
+	if t.PendingHigh > 0 || t.LagHigh > 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			r.watchPressure(c)
+		}()
+	}
+	if r.broker != nil {
+		c.cmt = adapter.NewCommitter(t, r.broker, r.cfg.commitInterval)
+		c.cmt.Retry, c.cmt.Paused = r.cfg.retry, c.isPaused
+		if r.cfg.commitMaxAdvance > 0 {
+			coalesce(t, c.cmt, r.cfg.commitMaxAdvance)
+		}
+		c.cmt.MaxInFlight = r.cfg.commitInFlight
+		if h := r.cfg.health; h != nil {
+			c.cmt.Report = func(err error) { h.Set(checkCommit, err) }
+		}
+		if r.cfg.tunables != nil {
+			if d := r.cfg.tunables.get().CommitInterval; d > 0 {
+				c.cmt.Interval = d
+			}
+		}
+		if r.cfg.commitFailRate > 0 {
+			c.cmt.Broker = flakyBroker{
+				Broker:   r.broker,
+				failRate: r.cfg.commitFailRate,
+				// a restarted consumer must not repeat the
+				// failures of the previous one
+				rng: newRand(r.cfg.seed, brokerStream+int64(r.consumers)<<8),
+			}
+		}
+		if r.cfg.breakerFailures > 0 {
+			c.breaker = &adapter.CircuitBreaker{
+				Broker:    c.cmt.Broker,
+				Threshold: r.cfg.breakerFailures,
+				Cooldown:  r.cfg.breakerCooldown,
+			}
+			c.cmt.Broker = c.breaker
+		}
+		c.wg.Add(1)
+		go inStage(stageCommitter, func() {
+			defer c.wg.Done()
+			c.cmt.Run(c.stopped)
+		})
+	}
+	return c
+}
+
+// absent reports whether offset is one the simulated broker never hands
+// out
+func (r *benchRun) absent(offset int64) bool {
+	return r.cfg.absentRate > 0 && offsetFloat(r.cfg.seed^absentStream, offset) < r.cfg.absentRate
+}
+
+// skipAbsent wraps deliver so that absent offsets are never delivered
+func (r *benchRun) skipAbsent(deliver func(offset int64)) func(offset int64) {
+	if r.cfg.absentRate == 0 {
+		return deliver
+	}
+	return func(offset int64) {
+		if !r.absent(offset) {
+			deliver(offset)
+		}
+	}
+}
+
+// declareAbsent tells t about every absent offset above its watermark.  A
+// real adapter learns of them as it fetches, the bench knows them all up
+// front.
+func (r *benchRun) declareAbsent(t *tracker.Tracker) {
+	var n int64
+	for o := t.Committed() + 1; o < r.numMsgs; o++ {
+		if !r.absent(o) {
+			continue
+		}
+		if err := t.Absent(tracker.Range{From: o, To: o}); err != nil {
+			fmt.Printf("declaring offset %v absent: %v\n", o, err)
+			continue
+		}
+		n++
+	}
+	if r.declared == 0 {
+		r.declared = n
+	}
+}
+
+// deliver sends an ack to the consumer, it is safe to call from any
+// goroutine.  Once the consumer is stopped the ack is dropped.
+func (c *consumer) deliver(offset int64) {
+	c.send(context.Background(), offset)
+}
+
+// send is deliver giving up with ctx's error once it is done, and with
+// ErrClosed once the consumer is stopped, while the ack buffer is full
+func (c *consumer) send(ctx context.Context, offset int64) error {
+	c.times.delivered(offset)
+	if c.queue != nil {
+		c.queue.push(offset)
+		return nil
+	}
+	if c.envs != nil {
+		// a single partition for now, which is all the bench simulates
+		env := getAck(0, offset, nil)
+		select {
+		case c.envs <- env:
+			return nil
+		case <-c.stopped.Done():
+			env.release()
+			return adapter.ErrClosed
+		case <-ctx.Done():
+			env.release()
+			return ctx.Err()
+		}
+	}
+	select {
+	case c.acks <- offset:
+		return nil
+	case <-c.stopped.Done():
+		return adapter.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// call runs fn on the ack loop and waits for it.  ctx only bounds the
+// wait for the loop to take fn, once it has fn runs to the end.
+func (c *consumer) call(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case c.calls <- func() { fn(); close(done) }:
+	case <-c.stopped.Done():
+		return adapter.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+// Status implements Partition
+func (c *consumer) Status(ctx context.Context) (PartitionStatus, error) {
+	var s PartitionStatus
+	err := c.call(ctx, func() {
+		snap := c.tracker.Snapshot()
+		s.Committed = snap.Committed
+		s.Pending = c.tracker.Pending()
+		s.Gaps, s.GapCount = snap.Gaps(maxAdminGaps)
+	})
+	s.Paused = c.isPaused()
+	return s, err
+}
+
+// Committed implements Partition
+func (c *consumer) Committed() int64 {
+	return c.tracker.Committed()
+}
+
+// Ack implements Partition, it blocks while the ack buffer is full until
+// ctx is done
+func (c *consumer) Ack(ctx context.Context, offset int64) error {
+	select {
+	case <-c.stopped.Done():
+		return adapter.ErrClosed
+	default:
+	}
+	return c.send(ctx, offset)
+}
+
+// SeekTo implements Partition
+func (c *consumer) SeekTo(ctx context.Context, committed int64) error {
+	return c.call(ctx, func() { c.tracker.SeekTo(committed) })
+}
+
+// Oldest implements Partition.  Every message of the bench is handed out
+// when the consumer starts, so that's how old they all are.
+func (c *consumer) Oldest(ctx context.Context, n int) ([]Outstanding, error) {
+	var offsets []int64
+	if err := c.call(ctx, func() { offsets = oldestOffsets(c.tracker.Snapshot(), n) }); err != nil {
+		return nil, err
+	}
+	age := orReal(c.tracker.Clock).Now().Sub(c.startedAt)
+	out := make([]Outstanding, len(offsets))
+	for i, o := range offsets {
+		out[i] = Outstanding{Offset: o, Age: age, Worker: c.worker(o)}
+	}
+	return out, nil
+}
+
+// Flush implements Partition, without a simulated broker there is nothing
+// to commit to and without a store nothing to save
+func (c *consumer) Flush(ctx context.Context) error {
+	if c.cmt != nil {
+		if err := c.cmt.Flush(ctx, c.stopped); err != nil {
+			return err
+		}
+	}
+	if c.store == nil {
+		return nil
+	}
+	// on the ack loop, so that a periodic save can't overwrite it with
+	// an older snapshot
+	var err error
+	if cerr := c.call(ctx, func() {
+		inStage(stageFlusher, func() { err = c.store.Save(ctx, c.tracker.Snapshot()) })
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// stopConsumer tears down the live consumer and waits until it's gone.
+// The acks already in its buffer are applied and the watermark committed
+// first, only the messages still being processed are lost.
+func (r *benchRun) stopConsumer() {
+	if r.cfg.health != nil {
+		r.cfg.health.SetReady(false)
+	}
+	c := r.cur
+	ctx := context.Background()
+	if err := c.call(ctx, func() { r.drain(c) }); err != nil {
+		fmt.Printf("draining acks: %v\n", err)
+	}
+	if c.cmt != nil {
+		if err := c.cmt.Flush(ctx, c.stopped); err != nil {
+			fmt.Printf("flushing commits: %v\n", err)
+		}
+	}
+	r.cur.stop()
+	r.cur.wg.Wait()
+	if q := r.cur.queue; q != nil && q.peak() > r.bufferBytes {
+		r.bufferBytes = q.peak()
+	}
+	if r.cur.retry != nil {
+		r.res.retried += r.cur.retry.retries
+	}
+	if b := r.cur.breaker; b != nil {
+		trips, open, rejected := b.Stats()
+		r.res.breakerTrips += trips
+		r.res.breakerOpen += open
+		r.res.breakerRejected += rejected
+	}
+	if r.cur.cmt != nil {
+		r.res.commitFailures += r.cur.cmt.Failures()
+	}
+}
+
+// drain acks everything in c's buffer, paused or not, it runs on the ack
+// loop
+func (r *benchRun) drain(c *consumer) {
+	for {
+		select {
+		case env := <-c.envs:
+			offset := env.offset
+			env.release()
+			r.ack(c, offset)
+		case offset := <-c.acks:
+			r.ack(c, offset)
+		default:
+			if c.queue != nil {
+				for _, offset := range c.queue.take() {
+					r.ack(c, offset)
+				}
+			}
+			return
+		}
+	}
+}
+
+func (r *benchRun) ackLoop(c *consumer) {
+	if r.ring != nil {
+		defer r.bundleRecovery(c)
+	}
+	clock := orReal(r.cfg.clock)
+	var snapshots <-chan time.Time
+	var compactions <-chan time.Time
+	if r.cfg.compactInterval > 0 {
+		ticker := clock.NewTicker(r.cfg.compactInterval)
+		defer ticker.Stop()
+		compactions = ticker.C()
+	}
+	var expiries <-chan time.Time
+	d := r.cfg.stuckDeadline
+	if s := r.cfg.maxStall; s > 0 && (d == 0 || s < d) {
+		d = s
+	}
+	if d > 0 {
+		// check often enough that an offset doesn't overstay its
+		// deadline by much
+		ticker := clock.NewTicker(d/4 + time.Millisecond)
+		defer ticker.Stop()
+		expiries = ticker.C()
+	}
+	var queued <-chan struct{}
+	if c.queue != nil {
+		queued = c.queue.ready
+	}
+	if r.store != nil {
+		snapshots = clock.NewTimer(r.cfg.restart.snapshotInterval).C()
+	}
+	for {
+		// while paused, leave the acks where they are
+		acks, envs, queued := c.acks, c.envs, queued
+		if c.isPaused() {
+			acks, envs, queued = nil, nil, nil
+		}
+		select {
+		case <-c.stopped.Done():
+			return
+		case fn := <-c.calls:
+			fn()
+		case <-c.wake:
+		case <-snapshots:
+			var err error
+			inStage(stageFlusher, func() { err = r.store.Save(c.stopped, c.tracker.Snapshot()) })
+			if err != nil {
+				fmt.Printf("saving snapshot: %v\n", err)
+			}
+			if r.cfg.health != nil {
+				r.cfg.health.Set(checkPersist, err)
+			}
+			// count the interval from the end of the save: with a
+			// lot pending a save can take longer than the interval,
+			// and a ticker would always be ready, starving the acks
+			snapshots = clock.NewTimer(r.cfg.restart.snapshotInterval).C()
+		case now := <-expiries:
+			skipped, err := c.tracker.Expire(now)
+			if err != nil {
+				fmt.Printf("expiring stuck offset: %v\n", err)
+			}
+			if skipped {
+				r.skipped++
+			}
+			// the new watermark may let held acks in
+			if c.tracker.SkipStalled(now) || skipped {
+				r.retryHeld(c)
+			}
+		case <-compactions:
+			if c.tracker.Compact() {
+				r.compactions++
+			}
+		case env := <-envs:
+			offset := env.offset
+			env.release()
+			r.ack(c, offset)
+		case offset := <-acks:
+			r.ack(c, offset)
+		case <-queued:
+			for _, offset := range c.queue.take() {
+				r.ack(c, offset)
+			}
+		}
+	}
+}
+
+// settle acks offset, or if processing it failed, has it retried or nacks
+// it.  Every attempt at a message fails with nackRate.
+func (r *benchRun) settle(c *consumer, offset int64) error {
+	if r.cfg.nackRate == 0 {
+		return c.tracker.Ack(offset)
+	}
+	attempt := int64(0)
+	if c.retry != nil {
+		attempt = int64(c.retry.attempt(offset))
+	}
+	if offsetFloat(r.cfg.seed^nackStream^attempt<<8, offset) >= r.cfg.nackRate {
+		err := c.tracker.Ack(offset)
+		if err == nil && c.retry != nil {
+			c.retry.succeeded(offset)
+		}
+		return err
+	}
+	if c.retry != nil && c.retry.failed(offset) {
+		return nil
+	}
+	err := c.tracker.Nack(offset, nil)
+	if err == nil {
+		r.nacked++
+		if c.retry != nil {
+			c.retry.succeeded(offset)
+		}
+	} else if !errors.Is(err, tracker.ErrTryAgain) {
+		fmt.Printf("nacking offset %v: %v\n", offset, err)
+	}
+	return err
+}
+
+// ack hands a single ack to the consumer's tracker, it is only called from
+// the ack loop
+func (r *benchRun) ack(c *consumer, val int64) {
+	if r.trace != nil || r.ring != nil {
+		ev := traceEvent{offset: val, at: orReal(r.cfg.clock).Now().Sub(r.start)}
+		if r.trace != nil {
+			r.trace = append(r.trace, ev)
+		}
+		if r.ring != nil {
+			r.ring.add(ev)
+		}
+	}
+	if d, ok := c.times.received(val); ok {
+		r.latencies = append(r.latencies, d)
+	}
+	if r.seen != nil && r.seen[val] < math.MaxUint8 {
+		r.seen[val]++
+	}
+	// here, we could commit tracker.Committed() back to kafka
+	// as the largest sequential offset already processed
+	if errors.Is(r.settle(c, val), tracker.ErrTryAgain) {
+		r.hold(c, val)
+	} else {
+		r.retryHeld(c)
+	}
+	if p := c.tracker.Pending(); p > r.peakPending {
+		r.peakPending = p
+	}
+	if r.chaos != nil {
+		// count after the ack so the watermark is final by
+		// the time processed reaches the forwarded count
+		atomic.AddInt64(&r.processed, 1)
+	}
+}
+
+// a distribution turns u, a uniform random value in [0, 1), into the
+// simulated processing time of a single message, which is always less than
+// max
+type distribution func(u float64, max time.Duration) time.Duration
+
+var distributions = map[string]distribution{
+	"uniform": func(u float64, max time.Duration) time.Duration {
+		return time.Duration(u * float64(max))
+	},
+	// most messages are quick but there is a long tail, the mean is a
+	// quarter of max
+	"exponential": func(u float64, max time.Duration) time.Duration {
+		d := -math.Log1p(-u) * float64(max) / 4
+		return time.Duration(math.Min(d, float64(max-1)))
+	},
+}
+
+// a workerModel simulates processing of offsets [0, numMsgs), calling
+// deliver with each offset after delay(offset) has passed.  Nothing may be
+// processed until start is released.
+type workerModel func(numMsgs int64, delay func(offset int64) time.Duration, deliver func(offset int64), start *sync.WaitGroup)
+
+var workerModels = map[string]workerModel{
+	"goroutine": goroutinePerMessage,
+	"wheel":     wheelWorkers,
+}
+
+// workerName says which worker processes offset
+func (r *benchRun) workerName(offset int64) string {
+	switch {
+	case r.cfg.replay != nil:
+		return "replay"
+	case r.cfg.workers == "wheel":
+		// wheelWorkers deals the offsets out round robin
+		return fmt.Sprintf("wheel %d", offset%int64(runtime.GOMAXPROCS(0)))
+	}
+	return "goroutine"
+}
+
+// goroutinePerMessage is the original model, start a goroutine for each msg
+func goroutinePerMessage(numMsgs int64, delay func(offset int64) time.Duration, deliver func(offset int64), start *sync.WaitGroup) {
+	for i := int64(0); i < numMsgs; i++ {
+		go func(offset int64) {
+			start.Wait()
+			// sleep for the simulated processing time
+			time.Sleep(delay(offset))
+			// commit the message offset to the local committer
+			deliver(offset)
+		}(i)
+	}
+	fmt.Printf("finished creating %v goroutines\n", numMsgs)
+}
+
+// names returns the sorted keys of one of the registries above
+func names(registry interface{}) []string {
+	var names []string
+	for _, k := range reflect.ValueOf(registry).MapKeys() {
+		names = append(names, k.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// holConfig sets up head-of-line blocking: one early offset takes far
+// longer than everything else, so the watermark is stuck below it while
+// every later ack piles up in the pending set.  This is the pathological
+// case for a design that can only commit sequentially.
+type holConfig struct {
+	offset int64
+	// delay replaces the offset's random processing time, zero disables
+	delay time.Duration
+}
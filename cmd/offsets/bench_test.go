@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// TestOffsetMap translates watermarks with a mirror that dropped records
+// and so runs behind the source
+func TestOffsetMap(t *testing.T) {
+	m := &OffsetMap{}
+	for _, s := range []OffsetSync{{10, 5}, {20, 12}, {30, 20}} {
+		if err := m.Record(s.Source, s.Target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Record(25, 25); err == nil {
+		t.Error("recorded a sync going backwards")
+	}
+	for _, c := range []struct{ source, target int64 }{
+		{-1, -1}, {5, -1}, {9, 4}, {10, 5}, {15, 5}, {19, 11}, {29, 19}, {100, 20},
+	} {
+		if got := m.Translate(c.source); got != c.target {
+			t.Errorf("Translate(%d) = %d, want %d", c.source, got, c.target)
+		}
+	}
+	if got := m.Reverse(15); got != 20 {
+		t.Errorf("Reverse(15) = %d, want 20", got)
+	}
+
+	m = &OffsetMap{Max: 8}
+	for o := int64(0); o < 100; o++ {
+		m.Record(o*2, o)
+	}
+	syncs := m.Syncs()
+	if len(syncs) > 8 || syncs[len(syncs)-1] != (OffsetSync{198, 99}) {
+		t.Fatalf("thinned to %v, want at most 8 ending with the last", syncs)
+	}
+	for o := int64(0); o < 200; o++ {
+		if got := m.Translate(o); got > o/2 {
+			t.Fatalf("Translate(%d) = %d, past where the source was", o, got)
+		}
+	}
+}
+
+// TestAggregator has no safe point until every partition has a watermark,
+// then follows the slowest
+func TestAggregator(t *testing.T) {
+	agg := NewAggregator(3)
+	agg.Update(0, 10)
+	if _, ok := agg.Update(1, 5); ok {
+		t.Error("a safe point with partition 2 missing")
+	}
+	if safe, ok := agg.Update(2, 7); !ok || safe != 5 {
+		t.Errorf("safe point %d, %v, want 5", safe, ok)
+	}
+	if safe, _ := agg.Update(1, 20); safe != 7 {
+		t.Errorf("safe point %d once partition 1 caught up, want 7", safe)
+	}
+	if safe, ok := agg.SafePointOf(0, 1); !ok || safe != 10 {
+		t.Errorf("safe point of 0 and 1 %d, %v, want 10", safe, ok)
+	}
+	agg.Combine = func(w map[int32]int64) int64 { return w[0] + w[1] + w[2] }
+	if safe, _ := agg.SafePoint(); safe != 37 {
+		t.Errorf("combined safe point %d, want 37", safe)
+	}
+}
+
+// TestWriteChart draws a plateau as a flat line and the top value at the
+// top
+func TestWriteChart(t *testing.T) {
+	values := make([]float64, 120)
+	for i := range values {
+		values[i] = 50
+		if i >= 60 {
+			values[i] = 100
+		}
+	}
+	var b strings.Builder
+	writeChart(&b, values, time.Second)
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != chartHeight+2 {
+		t.Fatalf("%d lines, want %d:\n%s", len(lines), chartHeight+2, b.String())
+	}
+	if !strings.HasPrefix(lines[0], "       100 |") || !strings.HasSuffix(lines[0], strings.Repeat("*", chartWidth/2)) {
+		t.Errorf("top line %q", lines[0])
+	}
+	if strings.Count(b.String(), "*") != chartWidth {
+		t.Errorf("want a point per column:\n%s", b.String())
+	}
+}
+
+// TestSampleGaps finds the gaps between the watermark and the acks above it,
+// split over the buckets they fall in
+func TestSampleGaps(t *testing.T) {
+	s := tracker.Snapshot{Committed: 99, Pending: []tracker.Range{{From: 105, To: 349}}}
+	sample := sampleGaps(s, 1000, time.Second)
+	for b, d := range sample.density {
+		want := 0.0
+		if b == 10 {
+			want = 0.5
+		}
+		if d != want {
+			t.Errorf("bucket %d density %v, want %v", b, d, want)
+		}
+	}
+}
+
+// TestLatencyHistogram buckets by powers of two, keeping the empty buckets
+// in between
+func TestLatencyHistogram(t *testing.T) {
+	got := latencyHistogram([]time.Duration{500, time.Microsecond, 3 * time.Microsecond, 3 * time.Microsecond})
+	want := []latencyBucket{{time.Microsecond, 2}, {2 * time.Microsecond, 0}, {4 * time.Microsecond, 2}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("histogram %v, want %v", got, want)
+	}
+}
+
+// TestHeapProfiler writes a profile each time the run reaches a step, named
+// after the step
+func TestHeapProfiler(t *testing.T) {
+	s, err := parseHeapSchedule(t.TempDir(), "25%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &heapProfiler{heapSchedule: s, run: "map/group-0", numMsgs: 100}
+	for _, c := range []int64{10, 30, 40, 99} {
+		if err := h.tick(c, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, step := range []string{"25pct", "100pct"} {
+		if _, err := os.Stat(filepath.Join(s.dir, "map_group-0.heap."+step+".pprof")); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := parseHeapSchedule("", "0%"); err == nil {
+		t.Error("a step of 0% accepted")
+	}
+}
+
+// TestConsumerAckFull gives up on an ack that doesn't fit in the buffer
+// once its context is done or the consumer stops, rather than hang
+func TestConsumerAckFull(t *testing.T) {
+	c := &consumer{times: newAckTimes(10), acks: make(chan int64, 1)}
+	c.stopped, c.stop = context.WithCancel(context.Background())
+	if err := c.Ack(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Ack(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ack into a full buffer = %v, want the context's error", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Ack(context.Background(), 1) }()
+	c.stop()
+	if err := <-done; !errors.Is(err, adapter.ErrClosed) {
+		t.Errorf("ack on a stopped consumer = %v, want ErrClosed", err)
+	}
+}
+
+// TestStopConsumerDrainsAcks stops a paused consumer with its buffer full
+// of acks, none of them may be lost and the broker must have the
+// watermark they make
+func TestStopConsumerDrainsAcks(t *testing.T) {
+	r := &benchRun{
+		cfg:     benchConfig{commitInterval: time.Hour, retry: adapter.RetryPolicy{Attempts: 1}},
+		numMsgs: 100,
+		broker:  adapter.NewSimBroker(0, 0),
+		times:   newAckTimes(100),
+	}
+	r.cur = r.startConsumer(tracker.New(backend.NewMap(0), -1), r.numMsgs)
+	r.cur.Pause()
+	for o := int64(99); o >= 0; o-- {
+		if err := r.cur.Ack(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.stopConsumer()
+	if got := r.cur.tracker.Committed(); got != 99 {
+		t.Errorf("tracker has %d, want 99", got)
+	}
+	if got := r.broker.Committed(); got != 99 {
+		t.Errorf("broker has %d, want 99", got)
+	}
+}
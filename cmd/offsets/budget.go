@@ -0,0 +1,46 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// offsetHeap is a min-heap of offsets for container/heap
+type offsetHeap []int64
+
+func (h offsetHeap) Len() int            { return len(h) }
+func (h offsetHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h offsetHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *offsetHeap) Push(x interface{}) { *h = append(*h, x.(int64)) }
+func (h *offsetHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// hold keeps an ack the tracker refused because its pending set is over
+// budget or the offset is too far ahead of the watermark.  A real
+// consumer would stop fetching at this point, the bench can't stop its
+// workers so it just holds on to the acks.
+func (r *benchRun) hold(c *consumer, offset int64) {
+	heap.Push(&c.held, offset)
+	r.refused++
+	if len(c.held) > r.peakHeld {
+		r.peakHeld = len(c.held)
+	}
+}
+
+// retryHeld acks held offsets again, lowest first, until the tracker
+// refuses one.  The lowest is the next the watermark needs whenever it
+// could be, so the held acks always drain.
+func (r *benchRun) retryHeld(c *consumer) {
+	for len(c.held) > 0 {
+		if errors.Is(r.settle(c, c.held[0]), tracker.ErrTryAgain) {
+			return
+		}
+		heap.Pop(&c.held)
+	}
+}
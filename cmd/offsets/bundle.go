@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// A repro bundle is what a bug report needs to reproduce a run that went
+// wrong: a directory holding bundle.json, with the seed, the command line,
+// the tracker snapshot and the last acks before things broke, and the
+// run's complete ack trace when it could be recorded.  bench -replay with
+// the directory runs it again.
+
+const (
+	bundleFile      = "bundle.json"
+	bundleTraceFile = "trace"
+	// bundleRecent is how many of the latest acks a bundle keeps
+	bundleRecent = 1024
+)
+
+type reproBundle struct {
+	// Reason says what went wrong
+	Reason string `json:"reason"`
+	// Run is the name of the run, Args the bench command line it was
+	// part of
+	Run      string           `json:"run"`
+	Seed     int64            `json:"seed"`
+	Args     []string         `json:"args"`
+	Snapshot tracker.Snapshot `json:"snapshot"`
+	// Recent are the last acks the tracker saw, oldest first
+	Recent []bundleEvent `json:"recent"`
+	// Trace is set when the bundle has the trace of every ack, without
+	// it a replay only has the seed to go on
+	Trace bool `json:"trace"`
+}
+
+type bundleEvent struct {
+	Offset int64         `json:"offset"`
+	At     time.Duration `json:"at_ns"`
+}
+
+// eventRing keeps the last bundleRecent acks of a run, it is only touched
+// by the ack loop
+type eventRing struct {
+	events []traceEvent
+	next   int
+}
+
+func (e *eventRing) add(ev traceEvent) {
+	if len(e.events) < bundleRecent {
+		e.events = append(e.events, ev)
+		return
+	}
+	e.events[e.next] = ev
+	e.next = (e.next + 1) % bundleRecent
+}
+
+// recent returns the events oldest first
+func (e *eventRing) recent() []bundleEvent {
+	out := make([]bundleEvent, 0, len(e.events))
+	for i := range e.events {
+		ev := e.events[(e.next+i)%len(e.events)]
+		out = append(out, bundleEvent{Offset: ev.offset, At: ev.at})
+	}
+	return out
+}
+
+// writeBundle saves a bundle of the run under cfg.bundleDir.  It must be
+// called from the ack loop or once it has stopped, as it reads the tracker
+// and the recorded acks.
+func (r *benchRun) writeBundle(t *tracker.Tracker, reason string) {
+	dir, err := os.MkdirTemp(r.cfg.bundleDir, "offsets-bundle-")
+	if err == nil {
+		err = r.saveBundle(dir, t, reason)
+	}
+	if err != nil {
+		fmt.Printf("writing repro bundle: %v\n", err)
+		return
+	}
+	fmt.Printf("%v, wrote repro bundle to %v, rerun it with -replay %v\n", reason, dir, dir)
+}
+
+func (r *benchRun) saveBundle(dir string, t *tracker.Tracker, reason string) error {
+	b := reproBundle{
+		Reason:   reason,
+		Run:      r.cfg.name,
+		Seed:     r.cfg.seed,
+		Args:     r.cfg.args,
+		Snapshot: t.Snapshot(),
+		Recent:   r.ring.recent(),
+		Trace:    r.trace != nil,
+	}
+	if b.Trace {
+		if err := writeTrace(filepath.Join(dir, bundleTraceFile), completeTrace(r.trace, r.numMsgs)); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, bundleFile), append(data, '\n'), 0o644)
+}
+
+// completeTrace appends every offset below n that events lack, after the
+// last of them, so that a trace cut short by a panic, or missing offsets
+// that were never delivered, still replays the whole workload
+func completeTrace(events []traceEvent, n int64) []traceEvent {
+	if int64(len(events)) >= n {
+		return events
+	}
+	seen := make([]bool, n)
+	var last time.Duration
+	for _, e := range events {
+		seen[e.offset] = true
+		last = e.at
+	}
+	out := append([]traceEvent{}, events...)
+	for o := int64(0); o < n; o++ {
+		if !seen[o] {
+			out = append(out, traceEvent{offset: o, at: last})
+		}
+	}
+	return out
+}
+
+// bundleArgs returns the bench command line that reruns the bundle in dir:
+// its own, pinned to its seed and run and replaying its trace if it has
+// one, followed by extra
+func bundleArgs(dir string, extra []string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, bundleFile))
+	if err != nil {
+		return nil, err
+	}
+	var b reproBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	fmt.Printf("replaying bundle of %v: %v\n", b.Run, b.Reason)
+	args := append([]string{}, b.Args...)
+	// later flags win, so these override whatever the original command
+	// line said
+	args = append(args, fmt.Sprintf("-seed=%d", b.Seed), "-run="+b.Run, "-record=", "-bundle-dir=", "-replay=")
+	if b.Trace {
+		args = append(args, "-replay="+filepath.Join(dir, bundleTraceFile))
+	}
+	return append(args, extra...), nil
+}
+
+// bundleRecovery writes a bundle if the ack loop panics, which is how a
+// tracker built with -tags invariants reports a broken invariant, then
+// lets the panic carry on
+func (r *benchRun) bundleRecovery(c *consumer) {
+	if p := recover(); p != nil {
+		r.writeBundle(c.tracker, fmt.Sprint(p))
+		panic(p)
+	}
+}
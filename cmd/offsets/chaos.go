@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+)
+
+// chaosConfig describes how acks get mangled on their way to the tracker,
+// the kind of thing real consumers see after rebalances and retries
+type chaosConfig struct {
+	// dropRate is the fraction of acks that never arrive
+	dropRate float64
+	// dupRate is the fraction of acks that arrive twice, the copy after a
+	// random delay of up to reorderDelay
+	dupRate float64
+	// reorderRate is the fraction of acks held back for a random extra
+	// delay of up to reorderDelay
+	reorderRate  float64
+	reorderDelay time.Duration
+}
+
+func (c chaosConfig) enabled() bool {
+	return c.dropRate > 0 || c.dupRate > 0 || c.reorderRate > 0
+}
+
+// chaosStats counts what the chaos stage did, all fields are accessed
+// atomically
+type chaosStats struct {
+	dropped    int64
+	duplicated int64
+	reordered  int64
+	// forwarded is the number of acks passed on so far
+	forwarded int64
+	// done is set to 1 once every input has been forwarded or dropped
+	done int32
+}
+
+// finished reports whether the chaos stage has forwarded everything it is
+// ever going to, and how many acks that was
+func (s *chaosStats) finished() (bool, int64) {
+	if atomic.LoadInt32(&s.done) == 0 {
+		return false, 0
+	}
+	return true, atomic.LoadInt64(&s.forwarded)
+}
+
+// runChaos reads numMsgs acks from in and forwards them to out after
+// applying cfg.  rng must not be shared with other goroutines.
+func runChaos(cfg chaosConfig, rng *rand.Rand, numMsgs int64, in <-chan int64, out func(offset int64), stats *chaosStats) {
+	// delayed sends are still in flight until this reaches zero
+	var inFlight sync.WaitGroup
+	later := func(offset int64) {
+		inFlight.Add(1)
+		var d time.Duration
+		if cfg.reorderDelay > 0 {
+			d = time.Duration(rng.Int63n(int64(cfg.reorderDelay)))
+		}
+		time.AfterFunc(d, func() {
+			out(offset)
+			atomic.AddInt64(&stats.forwarded, 1)
+			inFlight.Done()
+		})
+	}
+
+	for i := int64(0); i < numMsgs; i++ {
+		offset := <-in
+		switch r := rng.Float64(); {
+		case r < cfg.dropRate:
+			atomic.AddInt64(&stats.dropped, 1)
+			continue
+		case r < cfg.dropRate+cfg.reorderRate:
+			atomic.AddInt64(&stats.reordered, 1)
+			later(offset)
+		default:
+			out(offset)
+			atomic.AddInt64(&stats.forwarded, 1)
+		}
+		if rng.Float64() < cfg.dupRate {
+			atomic.AddInt64(&stats.duplicated, 1)
+			later(offset)
+		}
+	}
+	inFlight.Wait()
+	atomic.StoreInt32(&stats.done, 1)
+}
+
+// errInjectedFailure is returned by flakyBroker
+var errInjectedFailure = errors.New("injected commit failure")
+
+// flakyBroker wraps another broker and fails a fraction of commits without
+// passing them on, to exercise the retry path.
+type flakyBroker struct {
+	adapter.Broker
+	failRate float64
+	// rng is only used from the committing goroutine
+	rng *rand.Rand
+}
+
+func (b flakyBroker) Commit(ctx context.Context, offset int64) error {
+	if b.rng.Float64() < b.failRate {
+		return errInjectedFailure
+	}
+	return b.Broker.Commit(ctx, offset)
+}
+
+// CommitGeneration implements adapter.GenerationBroker, failing as often
+// as Commit does
+func (b flakyBroker) CommitGeneration(ctx context.Context, generation, offset int64) error {
+	gb, ok := b.Broker.(adapter.GenerationBroker)
+	if !ok {
+		return b.Commit(ctx, offset)
+	}
+	if b.rng.Float64() < b.failRate {
+		return errInjectedFailure
+	}
+	return gb.CommitGeneration(ctx, generation, offset)
+}
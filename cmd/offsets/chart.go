@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// timelinePoint is a run at one tick
+type timelinePoint struct {
+	at        time.Duration
+	committed int64
+	pending   int
+	heap      uint64
+}
+
+const (
+	chartWidth  = 60
+	chartHeight = 12
+)
+
+// writeTimelineCharts draws the committed offset and the pending acks of
+// a run over time, one under the other
+func writeTimelineCharts(w io.Writer, timeline []timelinePoint) {
+	if len(timeline) == 0 {
+		return
+	}
+	committed := make([]float64, len(timeline))
+	pending := make([]float64, len(timeline))
+	for i, pt := range timeline {
+		committed[i], pending[i] = float64(pt.committed+1), float64(pt.pending)
+	}
+	end := timeline[len(timeline)-1].at
+	fmt.Fprintf(w, "committed offsets\n")
+	writeChart(w, committed, end)
+	fmt.Fprintf(w, "pending acks\n")
+	writeChart(w, pending, end)
+}
+
+// writeChart draws values, sampled evenly over end, as a chart of
+// chartWidth columns.  A column is the last value of its samples, so a
+// plateau stays flat and a burst shows as a step.
+func writeChart(w io.Writer, values []float64, end time.Duration) {
+	width := chartWidth
+	if len(values) < width {
+		width = len(values)
+	}
+	cols := make([]float64, width)
+	var top float64
+	for i := range cols {
+		cols[i] = values[(i+1)*len(values)/width-1]
+		if cols[i] > top {
+			top = cols[i]
+		}
+	}
+	// the row each column reaches, zero being the bottom line
+	level := make([]int, width)
+	for i, v := range cols {
+		if top > 0 {
+			level[i] = int(v / top * float64(chartHeight-1))
+		}
+	}
+	for row := chartHeight - 1; row >= 0; row-- {
+		label := ""
+		switch row {
+		case chartHeight - 1:
+			label = fmt.Sprintf("%.0f", top)
+		case 0:
+			label = "0"
+		}
+		var b strings.Builder
+		for i := range cols {
+			switch {
+			case level[i] == row:
+				b.WriteByte('*')
+			case level[i] > row:
+				b.WriteByte('.')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		fmt.Fprintf(w, "%10s |%s\n", label, b.String())
+	}
+	fmt.Fprintf(w, "%10s +%s\n", "", strings.Repeat("-", width))
+	fmt.Fprintf(w, "%10s  0 %*v\n", "", width-2, end.Round(time.Millisecond))
+}
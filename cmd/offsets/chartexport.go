@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// timelineSeries are the lines of a run's charts: the committed offset
+// with the pending acks, and the heap in MiB
+func timelineSeries(timeline []timelinePoint) (offsets, heap []svgSeries) {
+	committed := svgSeries{name: "committed offset", color: "#4878a8"}
+	pending := svgSeries{name: "pending acks", color: "#d0703c"}
+	h := svgSeries{name: "heap", color: "#6a9f58"}
+	for _, pt := range timeline {
+		at := pt.at.Seconds()
+		committed.points = append(committed.points, [2]float64{at, float64(pt.committed + 1)})
+		pending.points = append(pending.points, [2]float64{at, float64(pt.pending)})
+		h.points = append(h.points, [2]float64{at, float64(pt.heap) / 1024 / 1024})
+	}
+	return []svgSeries{committed, pending}, []svgSeries{h}
+}
+
+// latencyBars are the bars of a run's latency histogram
+func latencyBars(buckets []latencyBucket) (labels []string, counts []float64) {
+	for _, b := range buckets {
+		labels = append(labels, "≤"+b.upTo.String())
+		counts = append(counts, float64(b.count))
+	}
+	return labels, counts
+}
+
+// chartFormats are what -chart-format can be
+var chartFormats = map[string]bool{"svg": true, "png": true}
+
+// writeRunCharts saves the charts of a run to dir as <run>-offsets,
+// <run>-heap and <run>-latency in format
+func writeRunCharts(dir, format string, r benchResult) error {
+	offsets, heap := timelineSeries(r.timeline)
+	labels, counts := latencyBars(r.latencies)
+	base := filepath.Join(dir, strings.ReplaceAll(r.name, "/", "_"))
+	charts := []struct {
+		name string
+		svg  func() string
+		png  func() image.Image
+	}{{
+		"offsets",
+		func() string {
+			return svgLineChart(r.name+": committed offset and pending acks", "seconds", "offsets", offsets)
+		},
+		func() image.Image { return pngLineChart(offsets) },
+	}, {
+		"heap",
+		func() string { return svgLineChart(r.name+": heap", "seconds", "MiB", heap) },
+		func() image.Image { return pngLineChart(heap) },
+	}, {
+		"latency",
+		func() string { return svgBarChart(r.name+": ack latency (sampled)", "acks", labels, counts) },
+		func() image.Image { return pngBarChart(counts) },
+	}}
+	for _, c := range charts {
+		path := base + "-" + c.name + "." + format
+		if format == "svg" {
+			if err := os.WriteFile(path, []byte(c.svg()), 0o644); err != nil {
+				return err
+			}
+			continue
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, c.png()); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("wrote the charts of %v to %v-*.%v\n", r.name, base, format)
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// The tracker's notable events go out as CloudEvents 1.0 in binary mode,
+// the attributes in headers and the data as the JSON body, so alerting and
+// workflow systems can take them as they are.
+
+const (
+	eventAdvanced = "io.github.ideasculptor.offsets_test.watermark.advanced"
+	eventStalled  = "io.github.ideasculptor.offsets_test.watermark.stalled"
+	eventSkipped  = "io.github.ideasculptor.offsets_test.gap.skipped"
+)
+
+// CloudEvent is an event with the attributes we set
+type CloudEvent struct {
+	ID      string
+	Source  string
+	Type    string
+	Subject string
+	Time    time.Time
+	Data    interface{}
+}
+
+// the data of the events
+type (
+	advancedData struct {
+		Old int64 `json:"old_watermark"`
+		New int64 `json:"new_watermark"`
+	}
+	stalledData struct {
+		Committed int64         `json:"committed"`
+		Pending   int           `json:"pending"`
+		For       time.Duration `json:"stalled_for_ns"`
+	}
+	skippedData struct {
+		From int64 `json:"from"`
+		To   int64 `json:"to"`
+	}
+)
+
+// eventSink delivers CloudEvents somewhere
+type eventSink interface {
+	send(e CloudEvent, data []byte) error
+	close()
+}
+
+// eventSinks build the sinks of -cloudevents by the scheme of its URL
+var eventSinks = map[string]func(u *url.URL) (eventSink, error){
+	// http and https POST every event to the URL
+	"http":  newHTTPSink,
+	"https": newHTTPSink,
+	// kafka://broker1,broker2/topic produces them to topic
+	"kafka": newKafkaSink,
+}
+
+// cloudEmitter sends events through a sink in the background.  While the
+// sink can't keep up events are dropped rather than holding up the
+// tracker, and counted.
+type cloudEmitter struct {
+	sink   eventSink
+	source string
+	events chan CloudEvent
+	done   chan struct{}
+	// mu guards closed, emit holds it for reading while it queues
+	mu     sync.RWMutex
+	closed bool
+	// counters are accessed atomically
+	next    int64
+	dropped int64
+}
+
+// newCloudEmitter sends the events of source to rawURL
+func newCloudEmitter(rawURL, source string) (*cloudEmitter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	newSink, ok := eventSinks[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("can't send cloudevents to %q (schemes: %s)", rawURL, strings.Join(names(eventSinks), ", "))
+	}
+	sink, err := newSink(u)
+	if err != nil {
+		return nil, err
+	}
+	e := &cloudEmitter{sink: sink, source: source, events: make(chan CloudEvent, 1024), done: make(chan struct{})}
+	go e.run()
+	return e, nil
+}
+
+func (e *cloudEmitter) run() {
+	defer close(e.done)
+	for ev := range e.events {
+		data, err := json.Marshal(ev.Data)
+		if err == nil {
+			err = e.sink.send(ev, data)
+		}
+		if err != nil {
+			fmt.Printf("sending %v event: %v\n", ev.Type, err)
+		}
+	}
+}
+
+// emit queues an event about subject, it is safe to call from any
+// goroutine, events emitted after close go nowhere
+func (e *cloudEmitter) emit(typ, subject string, data interface{}) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	ev := CloudEvent{
+		ID:      fmt.Sprint(atomic.AddInt64(&e.next, 1)),
+		Source:  e.source,
+		Type:    typ,
+		Subject: subject,
+		Time:    time.Now().UTC(),
+		Data:    data,
+	}
+	select {
+	case e.events <- ev:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+// close sends what is queued, giving up after timeout
+func (e *cloudEmitter) close(timeout time.Duration) {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.closed = true
+	close(e.events)
+	e.mu.Unlock()
+	select {
+	case <-e.done:
+	case <-time.After(timeout):
+	}
+	e.sink.close()
+	if n := atomic.LoadInt64(&e.dropped); n > 0 {
+		fmt.Printf("dropped %v cloudevents the sink couldn't keep up with\n", n)
+	}
+}
+
+// ceHeaders are the binary mode attributes of e, with prefix in front of
+// every name but the content type
+func ceHeaders(e CloudEvent, prefix string) [][2]string {
+	return [][2]string{
+		{prefix + "specversion", "1.0"},
+		{prefix + "id", e.ID},
+		{prefix + "source", e.Source},
+		{prefix + "type", e.Type},
+		{prefix + "subject", e.Subject},
+		{prefix + "time", e.Time.Format(time.RFC3339Nano)},
+		{"content-type", "application/json"},
+	}
+}
+
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSink(u *url.URL) (eventSink, error) {
+	return &httpSink{url: u.String(), client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (s *httpSink) send(e CloudEvent, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for _, h := range ceHeaders(e, "ce-") {
+		req.Header.Set(h[0], h[1])
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) close() {}
+
+type kafkaSink struct {
+	cl *kgo.Client
+}
+
+func newKafkaSink(u *url.URL) (eventSink, error) {
+	topic := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || topic == "" {
+		return nil, fmt.Errorf("want kafka://brokers/topic, got %v", u)
+	}
+	cl, err := kgo.NewClient(kgo.SeedBrokers(strings.Split(u.Host, ",")...), kgo.DefaultProduceTopic(topic))
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSink{cl: cl}, nil
+}
+
+// send produces e keyed by its subject, so each partition's events stay in
+// order
+func (s *kafkaSink) send(e CloudEvent, data []byte) error {
+	r := &kgo.Record{Key: []byte(e.Subject), Value: data}
+	for _, h := range ceHeaders(e, "ce_") {
+		r.Headers = append(r.Headers, kgo.RecordHeader{Key: h[0], Value: []byte(h[1])})
+	}
+	return s.cl.ProduceSync(context.Background(), r).FirstErr()
+}
+
+func (s *kafkaSink) close() {
+	s.cl.Close()
+}
+
+// advanced returns a committer's onCommit that announces every commit of
+// subject as the watermark advancing
+func (e *cloudEmitter) advanced(subject string) func(old, new int64) {
+	return func(old, new int64) {
+		e.emit(eventAdvanced, subject, advancedData{Old: old, New: new})
+	}
+}
+
+// watchStalls announces every partition whose watermark hasn't moved for
+// after with acks pending, once per stall, checking every quarter of after
+// until stop is closed.  The event's subject is the partition's key.
+func (e *cloudEmitter) watchStalls(partitions func() map[TrackerKey]Partition, after time.Duration, stop <-chan struct{}) {
+	type watch struct {
+		committed int64
+		movedAt   time.Time
+		stalled   bool
+	}
+	watches := make(map[TrackerKey]*watch)
+	ticker := time.NewTicker(after/4 + time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for k, p := range partitions() {
+				w, ok := watches[k]
+				c := p.Committed()
+				if !ok || c != w.committed {
+					watches[k] = &watch{committed: c, movedAt: now}
+					continue
+				}
+				s, err := p.Status(context.Background())
+				if err != nil || s.Pending == 0 || s.Paused {
+					// nothing to do isn't a stall
+					w.movedAt = now
+					continue
+				}
+				if stuck := now.Sub(w.movedAt); stuck >= after && !w.stalled {
+					w.stalled = true
+					e.emit(eventStalled, k.String(), stalledData{Committed: c, Pending: s.Pending, For: stuck})
+				}
+			}
+		}
+	}
+}
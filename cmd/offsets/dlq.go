@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// simDLQ is the bench's dead letter queue, it only counts what it gets.
+// Like the tracker it is only used from the ack loop.
+type simDLQ struct {
+	latency time.Duration
+	// published counts dead letters by reason
+	published map[string]int64
+}
+
+func newSimDLQ(latency time.Duration) *simDLQ {
+	return &simDLQ{latency: latency, published: make(map[string]int64)}
+}
+
+func (q *simDLQ) Publish(l tracker.DeadLetter) error {
+	time.Sleep(q.latency)
+	q.published[l.Reason]++
+	return nil
+}
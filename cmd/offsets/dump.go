@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// stateDumper writes a human readable summary of every partition, for a
+// look at a consumer without going through HTTP.  It isn't safe for
+// concurrent use.
+type stateDumper struct {
+	partitions func() map[TrackerKey]Partition
+	// last is the watermark of each partition at the previous dump, for
+	// the throughput since then
+	last map[TrackerKey]dumpSample
+}
+
+type dumpSample struct {
+	committed int64
+	at        time.Time
+}
+
+func newStateDumper(partitions func() map[TrackerKey]Partition) *stateDumper {
+	return &stateDumper{partitions: partitions, last: make(map[TrackerKey]dumpSample)}
+}
+
+func (d *stateDumper) dump(w io.Writer) {
+	parts := d.partitions()
+	keys := sortedKeys(parts)
+	now := time.Now()
+	fmt.Fprintf(w, "state of %d partitions at %v\n", len(keys), now.Format(time.RFC3339))
+	for _, k := range keys {
+		p := parts[k]
+		s, err := p.Status(context.Background())
+		if err != nil {
+			fmt.Fprintf(w, "  %v: %v\n", k, err)
+			continue
+		}
+		gap := "none"
+		if len(s.Gaps) > 0 {
+			g := s.Gaps[0]
+			gap = fmt.Sprintf("%d-%d", g.From, g.To)
+			if oldest, err := p.Oldest(context.Background(), 1); err == nil && len(oldest) > 0 && oldest[0].Age > 0 {
+				gap += fmt.Sprintf(" for %v", oldest[0].Age.Round(time.Millisecond))
+			}
+		}
+		rate := "-"
+		if last, ok := d.last[k]; ok && now.After(last.at) {
+			rate = fmt.Sprintf("%.0f/s", float64(s.Committed-last.committed)/now.Sub(last.at).Seconds())
+		}
+		d.last[k] = dumpSample{committed: s.Committed, at: now}
+		paused := ""
+		if s.Paused {
+			paused = ", paused"
+		}
+		fmt.Fprintf(w, "  %v: watermark %d, %d pending in %d gaps, oldest gap %s, watermark moving %s%s\n",
+			k, s.Committed, s.Pending, s.GapCount, gap, rate, paused)
+	}
+}
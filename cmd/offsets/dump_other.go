@@ -0,0 +1,7 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+// dumpOnSignal does nothing, there is no SIGUSR1 on this platform
+func dumpOnSignal(d *stateDumper) {}
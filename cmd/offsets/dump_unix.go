@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// dumpOnSignal writes d's summary to stdout on every SIGUSR1
+func dumpOnSignal(d *stateDumper) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			d.dump(os.Stdout)
+		}
+	}()
+}
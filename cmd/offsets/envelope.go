@@ -0,0 +1,32 @@
+package main
+
+import "sync"
+
+// ackEnvelope is an ack that carries more than its offset.  At millions of
+// acks per second allocating one per message shows up as GC work, so they
+// come from ackPool and go back once the committer is done with them.
+type ackEnvelope struct {
+	partition int32
+	offset    int64
+	// meta is whatever the application wants to attach to the message,
+	// e.g. the worker that processed it
+	meta interface{}
+}
+
+var ackPool = sync.Pool{
+	New: func() interface{} { return new(ackEnvelope) },
+}
+
+// getAck returns an envelope from the pool
+func getAck(partition int32, offset int64, meta interface{}) *ackEnvelope {
+	a := ackPool.Get().(*ackEnvelope)
+	a.partition, a.offset, a.meta = partition, offset, meta
+	return a
+}
+
+// release returns a to the pool, it must not be used afterwards
+func (a *ackEnvelope) release() {
+	// don't keep the metadata alive while the envelope sits in the pool
+	a.meta = nil
+	ackPool.Put(a)
+}
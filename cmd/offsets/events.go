@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Progress is one tick of a run, as streamed by the events endpoint
+type Progress struct {
+	Run       string        `json:"run"`
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	Committed int64         `json:"committed"`
+	Pending   int           `json:"pending"`
+	HeapBytes uint64        `json:"heap_bytes"`
+}
+
+// progressFeed fans progress out to every client of the events endpoint.
+// A client that can't keep up misses ticks rather than holding up the run.
+type progressFeed struct {
+	mu   sync.Mutex
+	subs map[chan Progress]struct{}
+}
+
+func newProgressFeed() *progressFeed {
+	return &progressFeed{subs: make(map[chan Progress]struct{})}
+}
+
+// active reports whether anyone is listening, so the run can skip working
+// out what nobody will see
+func (f *progressFeed) active() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs) > 0
+}
+
+func (f *progressFeed) publish(p Progress) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+func (f *progressFeed) subscribe() chan Progress {
+	ch := make(chan Progress, 16)
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *progressFeed) unsubscribe(ch chan Progress) {
+	f.mu.Lock()
+	delete(f.subs, ch)
+	f.mu.Unlock()
+}
+
+// ServeHTTP streams progress as server-sent events until the client goes
+// away, e.g. curl -N localhost:8080/events
+func (f *progressFeed) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ch := f.subscribe()
+	defer f.unsubscribe(ch)
+	flusher.Flush()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case p := <-ch:
+			data, err := json.Marshal(p)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// startExecTrace writes an execution trace of everything until stop to
+// path, for go tool trace
+func startExecTrace(path string) (stop func(), err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		trace.Stop()
+		if err := f.Close(); err != nil {
+			fmt.Printf("writing the execution trace: %v\n", err)
+		}
+	}, nil
+}
+
+// runTask marks a run as a task of the execution trace and its phases as
+// regions of it, so go tool trace can pick out the measured part of each
+// run.  Without a trace going it costs next to nothing.
+type runTask struct {
+	ctx    context.Context
+	task   *trace.Task
+	region *trace.Region
+}
+
+func newRunTask(name string) *runTask {
+	ctx, task := trace.NewTask(context.Background(), "bench "+name)
+	return &runTask{ctx: ctx, task: task}
+}
+
+// phase ends the phase the run was in and starts the one called name, on
+// the goroutine that started the run
+func (t *runTask) phase(name string) {
+	if t.region != nil {
+		t.region.End()
+	}
+	t.region = trace.StartRegion(t.ctx, name)
+}
+
+func (t *runTask) end() {
+	if t.region != nil {
+		t.region.End()
+		t.region = nil
+	}
+	t.task.End()
+}
+
+// startCPUProfile profiles the CPU until stop, writing the profile to path
+func startCPUProfile(path string) (stop func(), err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		if err := f.Close(); err != nil {
+			fmt.Printf("writing the CPU profile: %v\n", err)
+		}
+		fmt.Printf("wrote the CPU profile to %v\n", path)
+	}, nil
+}
+
+// runProfilePath is where the profile of run goes when several runs share
+// path: cpu.pprof becomes cpu.<run>.pprof
+func runProfilePath(path, run string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + strings.ReplaceAll(run, "/", "_") + ext
+}
+
+// heapSchedule is when a run writes heap profiles: every percent of the
+// messages committed, or every interval
+type heapSchedule struct {
+	dir      string
+	percent  float64
+	interval time.Duration
+}
+
+// parseHeapSchedule reads -heap-profile-every, a percentage like 10% or a
+// duration
+func parseHeapSchedule(dir, every string) (heapSchedule, error) {
+	s := heapSchedule{dir: dir}
+	if p, ok := strings.CutSuffix(every, "%"); ok {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f <= 0 || f > 100 {
+			return s, fmt.Errorf("bad heap profile step %q, want a percentage up to 100%%", every)
+		}
+		s.percent = f
+		return s, nil
+	}
+	d, err := time.ParseDuration(every)
+	if err != nil || d <= 0 {
+		return s, fmt.Errorf("bad heap profile interval %q, want a duration or a percentage", every)
+	}
+	s.interval = d
+	return s, nil
+}
+
+// heapProfiler writes the heap profiles of a run as its schedule says
+type heapProfiler struct {
+	heapSchedule
+	run     string
+	numMsgs int64
+	// step is the last step a profile was written for
+	step int64
+}
+
+// tick writes a profile if the run has reached a step it has none for,
+// named after the run and the step: map.heap.30pct.pprof or
+// map.heap.5s.pprof
+func (h *heapProfiler) tick(committed int64, elapsed time.Duration) error {
+	var step int64
+	var name string
+	if h.percent > 0 {
+		step = int64(float64(committed+1) / float64(h.numMsgs) * 100 / h.percent)
+		name = fmt.Sprintf("%gpct", float64(step)*h.percent)
+	} else {
+		step = int64(elapsed / h.interval)
+		name = (time.Duration(step) * h.interval).String()
+	}
+	if step <= h.step {
+		return nil
+	}
+	h.step = step
+	path := filepath.Join(h.dir, strings.ReplaceAll(h.run, "/", "_")+".heap."+name+".pprof")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// the profile is as of the last GC, forcing one would disturb what
+	// is being measured
+	if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
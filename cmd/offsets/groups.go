@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// runGroups runs cfg once per consumer group, all at the same time over the
+// same workload, as groups reading one topic do.  Each group has its own
+// tracker, committer and workers, what they share is the process: the
+// scheduler, the allocator and the GC.  Only the first group prints its
+// progress.
+func runGroups(cfg benchConfig) ([]benchResult, error) {
+	if cfg.groups <= 1 {
+		res, err := runBench(cfg)
+		return []benchResult{res}, err
+	}
+	if cfg.ballast > 0 {
+		// every group would hold a ballast of its own, and each
+		// group's heap would count the others'
+		return nil, fmt.Errorf("%v: consumer groups can't be run with a ballast", cfg.name)
+	}
+	if cfg.record != "" || cfg.restart.storePath != "" {
+		return nil, fmt.Errorf("%v: consumer groups can't share a trace or snapshot file", cfg.name)
+	}
+	runs := make([]*benchRun, cfg.groups)
+	for i := range runs {
+		gcfg := cfg
+		gcfg.name = fmt.Sprintf("%s/group-%d", cfg.name, i)
+		gcfg.quiet = cfg.quiet || i > 0
+		if i > 0 {
+			// only one CPU profile can be taken at a time, and the
+			// first group's has every group in it anyway
+			gcfg.cpuProfile = ""
+		}
+		r, err := newBenchRun(gcfg)
+		if err != nil {
+			return nil, err
+		}
+		runs[i] = r
+	}
+
+	results := make([]benchResult, len(runs))
+	errs := make([]error, len(runs))
+	var wg sync.WaitGroup
+	for i, r := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = r.run()
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return results, err
+	}
+
+	var fastest, slowest time.Duration
+	var peakHeap uint64
+	for i := range results {
+		results[i].group, results[i].groups = cfg.name, cfg.groups
+		d := results[i].duration
+		if i == 0 || d < fastest {
+			fastest = d
+		}
+		if d > slowest {
+			slowest = d
+		}
+		// the heap is the process's, so every group saw all of it
+		if results[i].peakHeap > peakHeap {
+			peakHeap = results[i].peakHeap
+		}
+	}
+	// the groups start together, so together they took as long as the
+	// slowest
+	total := float64(cfg.numMsgs) * float64(cfg.groups)
+	fmt.Printf("%v groups of %v did %.0f msg/s together, the fastest took %v and the slowest %v, peak heap %.1f MiB\n",
+		cfg.groups, cfg.name, total/slowest.Seconds(),
+		fastest.Round(time.Millisecond), slowest.Round(time.Millisecond), float64(peakHeap)/1024/1024)
+	return results, nil
+}
+
+// writeGroupTable sums up the runs made of several consumer groups: how
+// far apart the groups finished, which is what they cost each other, and
+// what they held together
+func writeGroupTable(w io.Writer, results []benchResult) error {
+	type groups struct {
+		n                int
+		fastest, slowest time.Duration
+		msgs             int64
+		peakHeap         uint64
+		peakPending      int
+	}
+	var order []string
+	byName := make(map[string]*groups)
+	for _, r := range results {
+		if r.group == "" {
+			continue
+		}
+		g, ok := byName[r.group]
+		if !ok {
+			g = &groups{n: r.groups, fastest: r.duration}
+			byName[r.group] = g
+			order = append(order, r.group)
+		}
+		if r.duration < g.fastest {
+			g.fastest = r.duration
+		}
+		if r.duration > g.slowest {
+			g.slowest = r.duration
+		}
+		g.msgs += r.numMsgs
+		if r.peakHeap > g.peakHeap {
+			g.peakHeap = r.peakHeap
+		}
+		g.peakPending += r.peakPending
+	}
+	rows := [][]string{
+		{"Run", "Groups", "Fastest group", "Slowest group", "Throughput together (msg/s)", "Peak heap (MiB)", "Peak pending together"},
+	}
+	for _, name := range order {
+		g := byName[name]
+		throughput := 0.0
+		if g.slowest > 0 {
+			throughput = float64(g.msgs) / g.slowest.Seconds()
+		}
+		rows = append(rows, []string{
+			name,
+			fmt.Sprint(g.n),
+			g.fastest.Round(time.Millisecond).String(),
+			g.slowest.Round(time.Millisecond).String(),
+			fmt.Sprintf("%.0f", throughput),
+			fmt.Sprintf("%.1f", float64(g.peakHeap)/1024/1024),
+			fmt.Sprint(g.peakPending),
+		})
+	}
+	return writeExtraTable(w, rows)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/offsetspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements offsetspb.OffsetsServer for the partitions returned
+// by partitions, which is called on every request like for the admin API.
+// The service only knows partitions by number, asking for a number
+// tracked for several topics is an error.
+type grpcServer struct {
+	offsetspb.UnimplementedOffsetsServer
+	partitions func() map[TrackerKey]Partition
+	// poll is how often StreamWatermarks looks for watermarks that moved
+	poll time.Duration
+}
+
+// NewGRPCServer returns a gRPC server with the Offsets service registered
+func NewGRPCServer(partitions func() map[TrackerKey]Partition) *grpc.Server {
+	s := grpc.NewServer()
+	offsetspb.RegisterOffsetsServer(s, &grpcServer{partitions: partitions, poll: 100 * time.Millisecond})
+	return s
+}
+
+// pick returns the partitions asked for, or all of them if ids is empty
+func (s *grpcServer) pick(ids []int32) (map[TrackerKey]Partition, error) {
+	parts := s.partitions()
+	if len(ids) == 0 {
+		return parts, nil
+	}
+	picked := make(map[TrackerKey]Partition, len(ids))
+	for _, id := range ids {
+		k, p, err := find(parts, id)
+		if err != nil {
+			return nil, err
+		}
+		picked[k] = p
+	}
+	return picked, nil
+}
+
+// find is findPartition with the gRPC status for its error
+func find(parts map[TrackerKey]Partition, id int32) (TrackerKey, Partition, error) {
+	k, p, err := findPartition(parts, "", id)
+	if errors.Is(err, errNoPartition) {
+		return k, nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return k, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return k, p, nil
+}
+
+// watermarks returns the watermark of every partition in parts, sorted by
+// key
+func watermarks(parts map[TrackerKey]Partition) []*offsetspb.Watermark {
+	marks := make([]*offsetspb.Watermark, 0, len(parts))
+	for _, k := range sortedKeys(parts) {
+		marks = append(marks, &offsetspb.Watermark{Partition: k.Partition, Committed: parts[k].Committed()})
+	}
+	return marks
+}
+
+func (s *grpcServer) GetWatermarks(ctx context.Context, req *offsetspb.WatermarksRequest) (*offsetspb.WatermarksResponse, error) {
+	parts, err := s.pick(req.Partitions)
+	if err != nil {
+		return nil, err
+	}
+	return &offsetspb.WatermarksResponse{Watermarks: watermarks(parts)}, nil
+}
+
+func (s *grpcServer) StreamWatermarks(req *offsetspb.WatermarksRequest, stream offsetspb.Offsets_StreamWatermarksServer) error {
+	sent := make(map[TrackerKey]int64)
+	ticker := time.NewTicker(s.poll)
+	defer ticker.Stop()
+	for {
+		// partitions that come and go with rebalances are picked up on
+		// the next poll, unless specific ones were asked for
+		parts, err := s.pick(req.Partitions)
+		if err != nil {
+			return err
+		}
+		for _, k := range sortedKeys(parts) {
+			c := parts[k].Committed()
+			if last, ok := sent[k]; ok && last == c {
+				continue
+			}
+			if err := stream.Send(&offsetspb.Watermark{Partition: k.Partition, Committed: c}); err != nil {
+				return err
+			}
+			sent[k] = c
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *grpcServer) Ack(ctx context.Context, req *offsetspb.AckRequest) (*offsetspb.AckResponse, error) {
+	k, p, err := find(s.partitions(), req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range req.Offsets {
+		if err := p.Ack(ctx, o); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "%v: %v", k, err)
+		}
+	}
+	return &offsetspb.AckResponse{}, nil
+}
+
+func (s *grpcServer) Flush(ctx context.Context, req *offsetspb.FlushRequest) (*offsetspb.FlushResponse, error) {
+	parts, err := s.pick(req.Partitions)
+	if err != nil {
+		return nil, err
+	}
+	for k, p := range parts {
+		if err := p.Flush(ctx); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "%v: %v", k, err)
+		}
+	}
+	return &offsetspb.FlushResponse{}, nil
+}
+
+// serveGRPC serves the Offsets service for partitions on addr in the
+// background
+func serveGRPC(addr string, partitions func() map[TrackerKey]Partition) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := NewGRPCServer(partitions)
+	fmt.Printf("gRPC service listening on %v\n", ln.Addr())
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			fmt.Printf("gRPC service: %v\n", err)
+		}
+	}()
+	return nil
+}
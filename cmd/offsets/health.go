@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health collects what /healthz and /readyz report on.  The parts of a
+// consumer that can fail each report under a check name of their own, any
+// check with an error makes the consumer unhealthy.  It is safe to use from
+// any goroutine.
+type Health struct {
+	mu     sync.Mutex
+	ready  bool
+	failed map[string]error
+}
+
+// the checks the bench reports
+const (
+	checkStall   = "stall"
+	checkCommit  = "commit"
+	checkPersist = "persist"
+)
+
+func NewHealth() *Health {
+	return &Health{failed: make(map[string]error)}
+}
+
+// Set records the outcome of check, nil means it passed
+func (h *Health) Set(check string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		delete(h.failed, check)
+	} else {
+		h.failed[check] = err
+	}
+}
+
+// SetReady says whether the consumer is up and has its partitions, e.g.
+// false while it restores its state
+func (h *Health) SetReady(ready bool) {
+	h.mu.Lock()
+	h.ready = ready
+	h.mu.Unlock()
+}
+
+// problems returns every failing check, sorted
+func (h *Health) problems() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var problems []string
+	for check, err := range h.failed {
+		problems = append(problems, fmt.Sprintf("%s: %v", check, err))
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Healthz answers 503 with the failing checks if there are any
+func (h *Health) Healthz(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, h.problems())
+}
+
+// Readyz is Healthz, and also fails until SetReady(true)
+func (h *Health) Readyz(w http.ResponseWriter, req *http.Request) {
+	problems := h.problems()
+	h.mu.Lock()
+	if !h.ready {
+		problems = append(problems, "not ready")
+	}
+	h.mu.Unlock()
+	writeHealth(w, problems)
+}
+
+func writeHealth(w http.ResponseWriter, problems []string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// errStalled is reported by the watchdog
+type errStalled struct {
+	committed int64
+	pending   int
+	since     time.Duration
+}
+
+func (e errStalled) Error() string {
+	return fmt.Sprintf("watermark stuck at %d for %v with %d acks pending", e.committed, e.since.Round(time.Second), e.pending)
+}
+
+// watchStall is the watchdog: it fails the stall check while the
+// watermark hasn't moved for stallAfter even though acks are piling up
+// behind it, and sends a stalled event when that starts.  A paused
+// consumer isn't stalled.
+func (r *benchRun) watchStall(c *consumer, stallAfter time.Duration) {
+	clock := orReal(r.cfg.clock)
+	ticker := clock.NewTicker(stallAfter/4 + time.Millisecond)
+	defer ticker.Stop()
+	last, movedAt := c.Committed(), clock.Now()
+	stalled := false
+	set := func(err error) {
+		if r.cfg.health != nil {
+			r.cfg.health.Set(checkStall, err)
+		}
+		if err == nil {
+			stalled = false
+		}
+	}
+	for {
+		select {
+		case <-c.stopped.Done():
+			return
+		case now := <-ticker.C():
+			committed := c.Committed()
+			if committed != last || c.isPaused() {
+				last, movedAt = committed, now
+				set(nil)
+				continue
+			}
+			var pending int
+			if c.call(context.Background(), func() { pending = c.tracker.Pending() }) != nil {
+				return
+			}
+			if pending == 0 {
+				// nothing to do isn't a stall
+				movedAt = now
+				set(nil)
+				continue
+			}
+			if stuck := now.Sub(movedAt); stuck >= stallAfter {
+				set(errStalled{committed: committed, pending: pending, since: stuck})
+				if r.cfg.events != nil && !stalled {
+					r.cfg.events.emit(eventStalled, r.cfg.name, stalledData{Committed: committed, Pending: pending, For: stuck})
+				}
+				stalled = true
+			}
+		}
+	}
+}
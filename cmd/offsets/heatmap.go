@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// heatBuckets is how many ranges of offsets a heatmap sample is split into
+const heatBuckets = 100
+
+// heatSample is the gap density of every bucket of offsets at one tick:
+// the fraction of the bucket's offsets above the watermark still waiting
+// for an ack while a later one has been acked
+type heatSample struct {
+	at      time.Duration
+	density [heatBuckets]float64
+}
+
+// sampleGaps buckets the gaps of s over numMsgs offsets
+func sampleGaps(s tracker.Snapshot, numMsgs int64, at time.Duration) heatSample {
+	sample := heatSample{at: at}
+	size := (numMsgs + heatBuckets - 1) / heatBuckets
+	gaps, _ := s.Gaps(math.MaxInt)
+	for _, g := range gaps {
+		for b := g.From / size; b <= g.To/size && b < heatBuckets; b++ {
+			from, to := max(g.From, b*size), min(g.To, (b+1)*size-1)
+			sample.density[b] += float64(to - from + 1)
+		}
+	}
+	for b := range sample.density {
+		sample.density[b] /= float64(size)
+	}
+	return sample
+}
+
+var heatShades = []rune(" ░▒▓█")
+
+// writeHeatmap draws the samples in the terminal with time going right and
+// offsets going down, the darker the more of a bucket are gaps
+func writeHeatmap(w io.Writer, samples []heatSample, numMsgs int64) {
+	if len(samples) == 0 {
+		return
+	}
+	const rows = 20
+	width := min(len(samples), chartWidth)
+	fmt.Fprintf(w, "gap density by offset over time\n")
+	for row := 0; row < rows; row++ {
+		var b strings.Builder
+		for col := 0; col < width; col++ {
+			s := samples[(col+1)*len(samples)/width-1]
+			var d float64
+			for i := row * heatBuckets / rows; i < (row+1)*heatBuckets/rows; i++ {
+				d += s.density[i]
+			}
+			d /= heatBuckets / rows
+			b.WriteRune(heatShades[min(int(math.Ceil(d*float64(len(heatShades)-1))), len(heatShades)-1)])
+		}
+		fmt.Fprintf(w, "%10v |%s|\n", int64(row)*numMsgs/rows, b.String())
+	}
+	fmt.Fprintf(w, "%10s +%s+\n", "", strings.Repeat("-", width))
+	fmt.Fprintf(w, "%10s  0 %*v\n", "", width-2, samples[len(samples)-1].at.Round(time.Millisecond))
+}
+
+// writeHeatmapPNG saves the samples as an image in the same layout, a few
+// pixels per sample and bucket, white for no gaps to dark red for nothing
+// but gaps
+func writeHeatmapPNG(path string, samples []heatSample) error {
+	const cell = 4
+	img := image.NewRGBA(image.Rect(0, 0, len(samples)*cell, heatBuckets*cell))
+	for x, s := range samples {
+		for y, d := range s.density {
+			c := color.RGBA{R: uint8(255 - 120*d), G: uint8(255 * (1 - d)), B: uint8(255 * (1 - d)), A: 255}
+			for dx := 0; dx < cell; dx++ {
+				for dy := 0; dy < cell; dy++ {
+					img.Set(x*cell+dx, y*cell+dy, c)
+				}
+			}
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeRunHeatmap is -heatmap at the end of a run: drawn in the terminal
+// for -, otherwise saved to the run's PNG in that directory
+func writeRunHeatmap(to, run string, samples []heatSample, numMsgs int64) error {
+	if to == "-" {
+		writeHeatmap(os.Stdout, samples, numMsgs)
+		return nil
+	}
+	path := filepath.Join(to, strings.ReplaceAll(run, "/", "_")+".png")
+	if err := writeHeatmapPNG(path, samples); err != nil {
+		return err
+	}
+	fmt.Printf("wrote the gap heatmap to %v\n", path)
+	return nil
+}
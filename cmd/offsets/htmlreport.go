@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// latencyBucket counts the sampled acks that spent up to upTo in the ack
+// buffer, and more than the bucket before
+type latencyBucket struct {
+	upTo  time.Duration
+	count int
+}
+
+// latencyHistogram buckets ds by powers of two, ds must be sorted
+func latencyHistogram(ds []time.Duration) []latencyBucket {
+	if len(ds) == 0 {
+		return nil
+	}
+	buckets := []latencyBucket{{upTo: time.Microsecond}}
+	for _, d := range ds {
+		for d > buckets[len(buckets)-1].upTo {
+			buckets = append(buckets, latencyBucket{upTo: 2 * buckets[len(buckets)-1].upTo})
+		}
+		buckets[len(buckets)-1].count++
+	}
+	return buckets
+}
+
+// settings lists what shapes the run, for someone reading the report who
+// wasn't there
+func (cfg benchConfig) settings() [][2]string {
+	s := [][2]string{
+		{"backend", cfg.backend},
+		{"messages", fmt.Sprint(cfg.numMsgs)},
+		{"distribution", cfg.distribution},
+		{"max delay", cfg.maxDelay.String()},
+		{"workers", cfg.workers},
+		{"ack buffer", cfg.ackBuffer},
+		{"commit interval", cfg.commitInterval.String()},
+		{"seed", fmt.Sprint(cfg.seed)},
+	}
+	if cfg.commitMaxAdvance > 0 {
+		s = append(s, [2]string{"commit max advance", fmt.Sprint(cfg.commitMaxAdvance)})
+	}
+	if cfg.commitInFlight > 0 {
+		s = append(s, [2]string{"commits in flight", fmt.Sprint(cfg.commitInFlight)})
+	}
+	if cfg.sizeHint > 0 {
+		s = append(s, [2]string{"size hint", fmt.Sprint(cfg.sizeHint)})
+	}
+	if cfg.ballast > 0 {
+		s = append(s, [2]string{"ballast", fmt.Sprintf("%v MiB", cfg.ballast)})
+	}
+	if cfg.groups > 1 {
+		s = append(s, [2]string{"consumer groups", fmt.Sprint(cfg.groups)})
+	}
+	return s
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>offsets bench report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+code { background: #f4f4f4; padding: 2px 4px; }
+section { margin-top: 3em; }
+</style>
+</head>
+<body>
+<h1>offsets bench report</h1>
+<p>Generated {{.Generated}} from <code>offsets bench {{.Args}}</code></p>
+<table>
+<tr><th>Run</th><th>Duration</th><th>Throughput (msg/s)</th><th>Peak heap (MiB)</th><th>Peak pending</th><th>Longest stall</th><th>Ack latency p99</th><th>Committed</th></tr>
+{{range .Runs}}<tr><td><a href="#{{.ID}}">{{.Name}}</a></td><td>{{.Duration}}</td><td>{{.Throughput}}</td><td>{{.PeakHeap}}</td><td>{{.PeakPending}}</td><td>{{.LongestStall}}</td><td>{{.P99}}</td><td>{{.Committed}}</td></tr>
+{{end}}</table>
+{{range .Runs}}<section id="{{.ID}}">
+<h2>{{.Name}}</h2>
+<table>
+{{range .Settings}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>
+{{end}}</table>
+{{.CommittedChart}}
+{{.Heap}}
+{{.Latency}}
+</section>
+{{end}}</body>
+</html>
+`))
+
+type reportRun struct {
+	ID, Name, Duration, Throughput, PeakHeap, LongestStall, P99 string
+	PeakPending                                                 int
+	Committed                                                   int64
+	Settings                                                    [][2]string
+	// the charts, drawn by us
+	CommittedChart, Heap, Latency template.HTML
+}
+
+// writeHTMLReport writes a page that needs nothing else to be read: the
+// command line, a summary of every run and each run's settings, its
+// committed offset, pending acks and heap over time and its ack latencies
+func writeHTMLReport(w io.Writer, args []string, results []benchResult) error {
+	var runs []reportRun
+	for i, r := range results {
+		run := reportRun{
+			ID:           fmt.Sprintf("run-%d", i),
+			Name:         r.name,
+			Duration:     r.duration.Round(time.Millisecond).String(),
+			Throughput:   fmt.Sprintf("%.0f", r.throughput()),
+			PeakHeap:     fmt.Sprintf("%.1f", float64(r.peakHeap)/1024/1024),
+			PeakPending:  r.peakPending,
+			LongestStall: r.longestStall.Round(time.Millisecond).String(),
+			P99:          r.ackLatency.p99.String(),
+			Committed:    r.committed,
+			Settings:     r.settings,
+		}
+		offsets, heap := timelineSeries(r.timeline)
+		labels, counts := latencyBars(r.latencies)
+		run.CommittedChart = template.HTML(svgLineChart("Committed offset and pending acks", "seconds", "offsets", offsets))
+		run.Heap = template.HTML(svgLineChart("Heap", "seconds", "MiB", heap))
+		run.Latency = template.HTML(svgBarChart("Ack latency (sampled)", "acks", labels, counts))
+		runs = append(runs, run)
+	}
+	return reportTemplate.Execute(w, struct {
+		Generated string
+		Args      string
+		Runs      []reportRun
+	}{time.Now().Format(time.RFC1123), strings.Join(args, " "), runs})
+}
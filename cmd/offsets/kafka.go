@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaOptions is where the kafka broker adapter commits
+type kafkaOptions struct {
+	brokers string
+	topic   string
+	group   string
+	// timeout bounds every request to the cluster
+	timeout time.Duration
+}
+
+func (o kafkaOptions) client() (*kgo.Client, error) {
+	if o.brokers == "" || o.topic == "" || o.group == "" {
+		return nil, fmt.Errorf("the kafka broker needs -kafka-brokers, -kafka-topic and -kafka-group")
+	}
+	return kgo.NewClient(kgo.SeedBrokers(strings.Split(o.brokers, ",")...))
+}
+
+// kafkaBroker commits a partition's watermark as a consumer group's offset,
+// without joining the group: the sidecar assigns itself partitions.  Kafka
+// commits the next offset to consume, which is one past the watermark.
+type kafkaBroker struct {
+	adm       *kadm.Client
+	opts      kafkaOptions
+	partition int32
+	// committed is accessed atomically
+	committed int64
+	// fence stands in for the group's generations, which only members
+	// get to commit with
+	fence commitFence
+}
+
+// newKafkaBroker returns a broker for partition of opts.topic, starting
+// from what the group has committed so far
+func newKafkaBroker(cl *kgo.Client, opts kafkaOptions, partition int32) (*kafkaBroker, error) {
+	b := &kafkaBroker{adm: kadm.NewClient(cl), opts: opts, partition: partition, committed: -1}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	offsets, err := b.adm.FetchOffsets(ctx, opts.group)
+	if err != nil {
+		return nil, fmt.Errorf("fetching offsets of group %s: %w", opts.group, err)
+	}
+	if o, ok := offsets.Lookup(opts.topic, partition); ok {
+		if o.Err != nil {
+			return nil, fmt.Errorf("fetching offset of %s/%d: %w", opts.topic, partition, o.Err)
+		}
+		if o.At >= 0 {
+			b.committed = o.At - 1
+		}
+	}
+	return b, nil
+}
+
+func (b *kafkaBroker) Commit(ctx context.Context, offset int64) error {
+	ctx, cancel := context.WithTimeout(ctx, b.opts.timeout)
+	defer cancel()
+	var offsets kadm.Offsets
+	offsets.AddOffset(b.opts.topic, b.partition, offset+1, -1)
+	resps, err := b.adm.CommitOffsets(ctx, b.opts.group, offsets)
+	if err == nil {
+		err = resps.Error()
+	}
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&b.committed, offset)
+	return nil
+}
+
+// CommitGeneration implements adapter.GenerationBroker, fencing the
+// commits made through this broker: other instances committing for the
+// group aren't fenced off
+func (b *kafkaBroker) CommitGeneration(ctx context.Context, generation, offset int64) error {
+	if err := b.fence.admit(generation); err != nil {
+		return err
+	}
+	return b.Commit(ctx, offset)
+}
+
+func (b *kafkaBroker) Committed() int64 {
+	return atomic.LoadInt64(&b.committed)
+}
+
+// logBounds implements logBounder
+func (b *kafkaBroker) logBounds() (start, end int64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.opts.timeout)
+	defer cancel()
+	lookup := func(list func(context.Context, ...string) (kadm.ListedOffsets, error)) (int64, error) {
+		offsets, err := list(ctx, b.opts.topic)
+		if err != nil {
+			return 0, err
+		}
+		o, ok := offsets.Lookup(b.opts.topic, b.partition)
+		if !ok {
+			return 0, fmt.Errorf("no offsets listed for %s/%d", b.opts.topic, b.partition)
+		}
+		return o.Offset, o.Err
+	}
+	if start, err = lookup(b.adm.ListStartOffsets); err != nil {
+		return 0, 0, err
+	}
+	end, err = lookup(b.adm.ListEndOffsets)
+	return start, end, err
+}
+
+// offsetAt implements timeLooker with Kafka's offsets for times
+func (b *kafkaBroker) offsetAt(t time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.opts.timeout)
+	defer cancel()
+	offsets, err := b.adm.ListOffsetsAfterMilli(ctx, t.UnixMilli(), b.opts.topic)
+	if err != nil {
+		return 0, err
+	}
+	o, ok := offsets.Lookup(b.opts.topic, b.partition)
+	if !ok {
+		return 0, fmt.Errorf("no offsets listed for %s/%d", b.opts.topic, b.partition)
+	}
+	return o.Offset, o.Err
+}
@@ -0,0 +1,148 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/redpanda"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// The tests in this file need Docker, they start a Redpanda container and
+// run against it:
+//
+//	go test -tags integration -run Kafka ./...
+
+// TestKafkaRestart consumes a topic through a served partition committing
+// to Kafka, kills the consumer halfway without a final commit and starts
+// another from the group's committed offset.  Nothing may be committed
+// without having been processed, and the second consumer must finish the
+// job.
+func TestKafkaRestart(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+	ctr, err := redpanda.Run(ctx, "docker.redpanda.com/redpandadata/redpanda:v24.2.4", redpanda.WithAutoCreateTopics())
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := ctr.KafkaSeedBroker(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := kafkaOptions{brokers: seed, topic: "offsets-test", group: "offsets-test", timeout: 10 * time.Second}
+
+	const n = 2000
+	producer, err := kgo.NewClient(kgo.SeedBrokers(seed), kgo.AllowAutoTopicCreation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]*kgo.Record, n)
+	for i := range records {
+		records[i] = &kgo.Record{Topic: opts.topic, Partition: 0, Value: []byte("x")}
+	}
+	err = producer.ProduceSync(ctx, records...).FirstErr()
+	producer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// processed counts how often each offset was processed, it is
+	// accessed atomically
+	processed := make([]int32, n)
+	crashedAt := consumeKafka(t, opts, processed, n/2, true)
+	restartedAt := consumeKafka(t, opts, processed, n, false)
+	if restartedAt > crashedAt {
+		t.Errorf("restarted at %d, past the watermark %d at the crash", restartedAt, crashedAt)
+	}
+
+	cl, err := opts.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	b, err := newKafkaBroker(cl, opts, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Committed(); got != n-1 {
+		t.Errorf("group committed %d, want %d", got, n-1)
+	}
+	for o, times := range processed {
+		if times == 0 {
+			t.Fatalf("offset %d was never processed", o)
+		}
+	}
+}
+
+// consumeKafka runs a consumer from the group's committed offset until the
+// watermark reaches until-1.  Records are processed out of order, each
+// after a random delay.  With crash the consumer is torn down right there,
+// otherwise it shuts down cleanly.  It returns the watermark it started
+// from, and fails the test if that was past anything unprocessed.
+func consumeKafka(t *testing.T, opts kafkaOptions, processed []int32, until int64, crash bool) int64 {
+	t.Helper()
+	cl, err := opts.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	broker, err := newKafkaBroker(cl, opts, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := broker.Committed()
+	for o := int64(0); o <= start; o++ {
+		if atomic.LoadInt32(&processed[o]) == 0 {
+			t.Fatalf("offset %d was committed without being processed", o)
+		}
+	}
+	p, err := startPartition(0, backend.NewMap(0), broker, nil, NewHealth(), servedConfig{
+		commitInterval:   20 * time.Millisecond,
+		retry:            adapter.RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond},
+		snapshotInterval: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consumer, err := kgo.NewClient(kgo.SeedBrokers(opts.brokers),
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{opts.topic: {0: kgo.NewOffset().At(start + 1)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	rng := rand.New(rand.NewSource(start))
+	for p.Committed() < until-1 {
+		fetches := consumer.PollFetches(ctx)
+		if err := fetches.Err(); err != nil {
+			t.Fatal(err)
+		}
+		fetches.EachRecord(func(r *kgo.Record) {
+			delay := time.Duration(rng.Int63n(int64(5 * time.Millisecond)))
+			time.AfterFunc(delay, func() {
+				atomic.AddInt32(&processed[r.Offset], 1)
+				p.Ack(context.Background(), r.Offset)
+			})
+		})
+	}
+	if crash {
+		// no final commit, whatever is in flight is lost
+		p.stop()
+		p.wg.Wait()
+	} else if _, err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return start
+}
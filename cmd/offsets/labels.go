@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// Every goroutine of the pipeline carries a pprof label saying which stage
+// it is, so a CPU or goroutine profile of bench or serve can be split by it,
+// e.g. go tool pprof -tagfocus stage=committer.  Heap profiles don't carry
+// labels, the runtime only records them for samples taken on a goroutine.
+const (
+	// stageWorker processes the messages, and the chaos stage between
+	// them and the tracker
+	stageWorker = "worker"
+	// stageTracker is the ack loop feeding the tracker
+	stageTracker = "tracker"
+	// stageCommitter commits the watermark every interval
+	stageCommitter = "committer"
+	// stageFlusher saves snapshots
+	stageFlusher = "flusher"
+	// stageAdapter talks to the broker, both the commits and whatever
+	// goroutines the broker's client starts
+	stageAdapter = "adapter"
+)
+
+// inStage runs f labelled stage, goroutines started by f keep the label
+func inStage(stage string, f func()) {
+	pprof.Do(context.Background(), pprof.Labels("stage", stage), func(context.Context) { f() })
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/store"
+)
+
+// Several replicas of serve can run hot/standby off the same -dir: they
+// campaign for leadership, the leader tracks and commits, and the others
+// tail its snapshots until they take over.
+
+// elector is a leader election serve campaigns in
+type elector interface {
+	// Campaign blocks until this instance is the leader, or ctx is done
+	Campaign(ctx context.Context) error
+	// Lost is closed once leadership is lost, after which the instance
+	// mustn't commit anything
+	Lost() <-chan struct{}
+	Resign(ctx context.Context) error
+	Close() error
+}
+
+type electionOptions struct {
+	endpoints string
+	key       string
+	// ttl is how long leadership outlives a leader that stops renewing
+	// it
+	ttl time.Duration
+	// id identifies this instance to the others
+	id string
+	// bind, peers and dir are raft's: the address it listens on, the
+	// addresses of every replica and where it keeps its snapshots
+	bind  string
+	peers string
+	dir   string
+}
+
+// electors build the leader elections serve can campaign in, keyed by
+// -leader-election, etcd is added unless built with noetcd
+var electors = map[string]func(opts electionOptions) (elector, error){
+	"raft": newRaftElector,
+}
+
+// tailSnapshots is what a standby does: it reads the leader's snapshots of
+// partitions [0, n) in dir every interval and reports how far they have
+// got, until stop is closed
+func tailSnapshots(dir string, n int, interval time.Duration, stop <-chan struct{}) {
+	last := make([]int64, n)
+	for i := range last {
+		last[i] = -2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for id := 0; id < n; id++ {
+			store := store.File{Path: snapshotPath(dir, int32(id))}
+			snap, ok, err := store.Load(context.Background())
+			if err != nil {
+				fmt.Printf("standby: partition %v: %v\n", id, err)
+				continue
+			}
+			if ok && snap.Committed != last[id] {
+				last[id] = snap.Committed
+				fmt.Printf("standby: partition %v is at watermark %v\n", id, snap.Committed)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//go:build !noetcd
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+func init() {
+	electors["etcd"] = newEtcdElector
+}
+
+// etcdElector campaigns with an etcd election, leadership is held by a
+// lease the session keeps alive
+type etcdElector struct {
+	cl       *clientv3.Client
+	session  *concurrency.Session
+	election *concurrency.Election
+	id       string
+}
+
+func newEtcdElector(opts electionOptions) (elector, error) {
+	if opts.endpoints == "" {
+		return nil, fmt.Errorf("etcd leader election needs -etcd-endpoints")
+	}
+	cl, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(opts.endpoints, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, err := concurrency.NewSession(cl, concurrency.WithTTL(int((opts.ttl+time.Second-1)/time.Second)))
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+	return &etcdElector{cl: cl, session: session, election: concurrency.NewElection(session, opts.key), id: opts.id}, nil
+}
+
+func (e *etcdElector) Campaign(ctx context.Context) error {
+	return e.election.Campaign(ctx, e.id)
+}
+
+// Lost implements elector, the session ends when its lease can't be
+// renewed
+func (e *etcdElector) Lost() <-chan struct{} {
+	return e.session.Done()
+}
+
+func (e *etcdElector) Resign(ctx context.Context) error {
+	return e.election.Resign(ctx)
+}
+
+func (e *etcdElector) Close() error {
+	e.session.Close()
+	return e.cl.Close()
+}
@@ -0,0 +1,474 @@
+// Command offsets benchmarks the tracker and serves it to consumers: bench
+// (the default), sweep, oldest, serve and translate.
+//
+// The etcd leader election and the zookeeper broker are left out, with
+// their dependencies, when built with -tags noetcd and nozookeeper.  The
+// tracker, backend, adapter and store packages depend on nothing but the
+// standard library.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+func PrintMemUsage() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	// For info on each, see: https://golang.org/pkg/runtime/#MemStats
+	fmt.Printf("Alloc = %v MiB", bToMb(m.Alloc))
+	fmt.Printf("\tTotalAlloc = %v MiB", bToMb(m.TotalAlloc))
+	fmt.Printf("\tSys = %v MiB", bToMb(m.Sys))
+	fmt.Printf("\tNumGC = %v\n", m.NumGC)
+}
+
+func bToMb(b uint64) uint64 {
+	return b / 1024 / 1024
+}
+
+func main() {
+	// the first argument picks a command, with bench as the default so
+	// plain flags keep working
+	args := os.Args[1:]
+	cmd := "bench"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "bench":
+		err = benchCmd(args)
+	case "sweep":
+		err = sweepCmd(args)
+	case "oldest":
+		err = oldestCmd(args)
+	case "serve":
+		err = serveCmd(args)
+	case "translate":
+		err = translateCmd(args)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func benchCmd(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	numMsgs := fs.Int64("n", 1000000, "number of messages to simulate")
+	maxDelay := fs.Duration("max-delay", time.Second, "upper bound of the random processing time of each message")
+	backendList := fs.String("backends", "map", "comma separated list of backends to run ("+strings.Join(backend.Names(), ", ")+")")
+	sizeHint := fs.Int("size-hint", 0, "expected number of pending offsets, backends preallocate for it")
+	dist := fs.String("distribution", "uniform", "processing time distribution ("+strings.Join(names(distributions), ", ")+")")
+	workers := fs.String("workers", "goroutine", "worker model ("+strings.Join(names(workerModels), ", ")+")")
+	scenarios := fs.String("scenarios", "", "run every scenario defined in this YAML file instead of -backends")
+	commitLatency := fs.Duration("commit-latency", 0, "simulated latency of each broker commit, enables broker simulation")
+	commitRate := fs.Float64("commit-rate", 0, "maximum broker commits per second, enables broker simulation")
+	commitInterval := fs.Duration("commit-interval", 10*time.Millisecond, "how often the watermark is committed to the simulated broker")
+	commitMaxAdvance := fs.Int64("commit-max-advance", 0, "commit before -commit-interval is up once the watermark is this far past the last commit, 0 disables")
+	commitInFlight := fs.Int("commit-in-flight", 0, "commits to the simulated broker that may be on their way at once, 0 waits for each")
+	commitFail := fs.Float64("commit-fail", 0, "probability of a broker commit failing, enables broker simulation")
+	retries := fs.Int("commit-attempts", 5, "attempts per broker commit before waiting for the next interval")
+	backoff := fs.Duration("commit-backoff", 10*time.Millisecond, "delay before retrying a failed commit, doubled on every retry")
+	maxBackoff := fs.Duration("commit-max-backoff", time.Second, "upper bound of the retry delay")
+	breakerFailures := fs.Int("breaker-failures", 0, "failed commits in a row that open the circuit breaker, zero disables it")
+	breakerCooldown := fs.Duration("breaker-cooldown", time.Second, "how long the circuit breaker stays open before letting a commit through")
+	chaosDrop := fs.Float64("chaos-drop", 0, "chaos: fraction of acks that are dropped")
+	chaosDup := fs.Float64("chaos-dup", 0, "chaos: fraction of acks that are delivered twice")
+	chaosReorder := fs.Float64("chaos-reorder", 0, "chaos: fraction of acks that are held back to exaggerate reordering")
+	chaosDelay := fs.Duration("chaos-delay", time.Second, "chaos: upper bound of the extra delay of reordered and duplicated acks")
+	restartAfter := fs.Duration("restart-after", 0, "restart the consumer this long into the run")
+	restoreFrom := fs.String("restore-from", "snapshot", "state a restarted consumer resumes from (snapshot, broker)")
+	snapshotInterval := fs.Duration("snapshot-interval", 100*time.Millisecond, "how often the tracker snapshot is persisted for restarts")
+	snapshotFile := fs.String("snapshot-file", "", "where snapshots are persisted, a temporary file if empty")
+	holOffset := fs.Int64("hol-offset", 0, "head-of-line blocking: the offset that takes -hol-delay to process")
+	holDelay := fs.Duration("hol-delay", 0, "head-of-line blocking: processing time of -hol-offset, zero disables")
+	record := fs.String("record", "", "record the ack sequence of the run to this trace file")
+	replay := fs.String("replay", "", "replay the ack sequence from this trace file instead of simulating processing")
+	markdown := fs.String("markdown", "", "write a markdown comparison table of all runs to this file (- for stdout)")
+	envelopes := fs.Bool("envelopes", false, "send acks to the committer in pooled envelopes instead of as bare offsets")
+	ackBuffer := fs.String("ack-buffer", "full", "buffer between workers and tracker: full (one slot per message), adaptive, or a capacity workers block on")
+	compact := fs.Duration("compact-interval", 0, "how often the pending set gives back memory it no longer needs, zero never")
+	budget := fs.Int64("pending-budget", 0, "most memory in KiB the pending set may hold, acks beyond it are held back and retried (0 is no limit)")
+	maxInFlight := fs.Int64("max-in-flight", 0, "acks more than this far above the watermark are held back and retried (0 is no limit)")
+	pressurePending := fs.Int("pressure-pending", 0, "signal backpressure once this many acks are pending (0 never)")
+	pressureLag := fs.Int64("pressure-lag", 0, "signal backpressure once the highest ack is this far above the watermark (0 never)")
+	pauseAt := fs.Duration("pause-at", 0, "pause the consumer this long into the run")
+	pauseFor := fs.Duration("pause-for", 0, "how long the consumer stays paused, zero disables pausing")
+	stuckDeadline := fs.Duration("stuck-deadline", 0, "how long an offset may hold up the watermark before -stuck-policy applies, zero is forever")
+	stuckPolicy := fs.String("stuck-policy", "block", "what happens to an offset past -stuck-deadline ("+strings.Join(names(stuckPolicies), ", ")+")")
+	maxLag := fs.Int64("max-lag", 0, "skip the gap holding up the watermark once the highest ack is this far above it, losing those messages (0 never)")
+	maxStall := fs.Duration("max-stall", 0, "skip the gap holding up the watermark once it hasn't moved for this long, losing those messages (0 never)")
+	absentRate := fs.Float64("absent-rate", 0, "fraction of offsets that are never delivered, like transaction markers, and are declared absent")
+	nackRate := fs.Float64("nack-rate", 0, "fraction of messages that fail for good and go to the dead letter queue")
+	dlqLatency := fs.Duration("dlq-latency", 0, "how long publishing to the dead letter queue takes")
+	nackAttempts := fs.Int("nack-attempts", 1, "attempts at a failing message before it is nacked, each fails with -nack-rate")
+	nackBackoff := fs.Duration("nack-backoff", 100*time.Millisecond, "delay before a failed message is retried, doubled on every retry")
+	nackMaxBackoff := fs.Duration("nack-max-backoff", 5*time.Second, "upper bound of the delay before a failed message is retried")
+	nackJitter := fs.Float64("nack-jitter", 0.5, "random fraction taken off every retry delay")
+	ballastList := fs.String("ballast", "0", "comma separated list of GC ballast sizes in MiB, every run is repeated with each")
+	seed := fs.Int64("seed", 0, "seed for every random choice, runs with the same seed process messages identically (0 picks one)")
+	admin := fs.String("admin", "", "serve the admin API, /metrics, /healthz, /readyz, /events and /ws on this address while the runs go on, e.g. localhost:8080")
+	stallAfter := fs.Duration("stall-after", 30*time.Second, "with -admin, /healthz fails once the watermark has been stuck this long with acks pending, and with -cloudevents that sends a stalled event (0 never)")
+	cloudEvents := fs.String("cloudevents", "", "send watermark advances, stalls and skips as cloudevents to this http(s):// URL or kafka://brokers/topic")
+	grpcAddr := fs.String("grpc", "", "serve the gRPC Offsets service on this address while the runs go on")
+	configFile := fs.String("config", "", "YAML file of settings that can change during a run, reread on SIGHUP (commit_interval, max_in_flight, pending_budget, progress_interval, log_level)")
+	jsonOut := fs.String("json", "", "write the results of all runs as JSON to this file (- for stdout)")
+	bundleDir := fs.String("bundle-dir", "", "write a repro bundle to this directory when a run stalls, loses offsets or breaks an invariant, runs record their ack trace for it")
+	only := fs.String("run", "", "only do the run with this name")
+	groups := fs.Int("groups", 1, "consumer groups that consume the same messages at once, each with its own tracker")
+	chart := fs.Bool("chart", false, "draw the committed offset and pending acks over time at the end of every run")
+	heatmap := fs.String("heatmap", "", "draw where the gaps were over time at the end of every run, - in the terminal or a directory to save a PNG per run to")
+	logLevel := fs.String("log-level", "info", "how much of its progress a run prints: debug prints every tick, info a progress bar, warn only what goes wrong")
+	report := fs.String("report", "", "write an HTML page with the settings, charts and results of all runs to this file")
+	chartDir := fs.String("charts", "", "save the charts of every run to this directory: committed offset and pending acks, heap and ack latency")
+	chartFormat := fs.String("chart-format", "svg", "format of the -charts files (png, svg)")
+	traceOut := fs.String("trace", "", "write an execution trace of the runs to this file for go tool trace, each run is a task with its setup, measure and teardown as regions")
+	cpuProfile := fs.String("cpuprofile", "", "write a CPU profile of the measured part of the run to this file, with several runs each gets its own with the run's name before the extension")
+	heapProfiles := fs.String("heap-profiles", "", "write heap profiles of every run to this directory as it goes, see -heap-profile-every")
+	heapEvery := fs.String("heap-profile-every", "10%", "when -heap-profiles are written: every this much of the messages committed, or a duration")
+	tui := fs.Bool("tui", false, "show the runs on a live dashboard instead of printing their progress")
+	fs.Parse(args)
+
+	// -replay with a repro bundle reruns the bundle's command line, the
+	// flags given here on top
+	if fi, err := os.Stat(*replay); err == nil && fi.IsDir() {
+		var extra []string
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name != "replay" {
+				extra = append(extra, "-"+f.Name+"="+f.Value.String())
+			}
+		})
+		bargs, err := bundleArgs(*replay, extra)
+		if err != nil {
+			return err
+		}
+		return benchCmd(bargs)
+	}
+
+	if !chartFormats[*chartFormat] {
+		return fmt.Errorf("unknown chart format %q (available: %s)", *chartFormat, strings.Join(names(chartFormats), ", "))
+	}
+	if _, ok := logLevels[*logLevel]; !ok {
+		return fmt.Errorf("unknown log level %q (available: %s)", *logLevel, strings.Join(names(logLevels), ", "))
+	}
+	if *groups <= 0 {
+		return fmt.Errorf("-groups must be positive")
+	}
+	if *numMsgs <= 0 || *maxDelay <= 0 {
+		return fmt.Errorf("-n and -max-delay must be positive")
+	}
+	if *commitInterval <= 0 {
+		return fmt.Errorf("-commit-interval must be positive")
+	}
+	if *commitInFlight > 0 && (*commitFail > 0 || *breakerFailures > 0) {
+		// neither the failures nor the breaker take concurrent commits
+		return fmt.Errorf("-commit-in-flight doesn't go with -commit-fail or -breaker-failures")
+	}
+	base := benchConfig{
+		sizeHint:     *sizeHint,
+		distribution: *dist,
+		workers:      *workers,
+		numMsgs:      *numMsgs,
+		maxDelay:     *maxDelay,
+		tick:         250 * time.Millisecond,
+
+		brokerLatency:    *commitLatency,
+		brokerRate:       *commitRate,
+		commitInterval:   *commitInterval,
+		commitMaxAdvance: *commitMaxAdvance,
+		commitInFlight:   *commitInFlight,
+		commitFailRate:   *commitFail,
+		retry: adapter.RetryPolicy{
+			Attempts:   *retries,
+			Backoff:    *backoff,
+			MaxBackoff: *maxBackoff,
+		},
+		breakerFailures: *breakerFailures,
+		breakerCooldown: *breakerCooldown,
+		chaos: chaosConfig{
+			dropRate:     *chaosDrop,
+			dupRate:      *chaosDup,
+			reorderRate:  *chaosReorder,
+			reorderDelay: *chaosDelay,
+		},
+		restart: restartConfig{
+			after:            *restartAfter,
+			from:             *restoreFrom,
+			snapshotInterval: *snapshotInterval,
+			storePath:        *snapshotFile,
+		},
+		hol: holConfig{
+			offset: *holOffset,
+			delay:  *holDelay,
+		},
+		envelopes: *envelopes,
+		ackBuffer: *ackBuffer,
+
+		compactInterval: *compact,
+		budget:          *budget,
+		maxInFlight:     *maxInFlight,
+		pressurePending: *pressurePending,
+		pressureLag:     *pressureLag,
+		pause:           pauseConfig{at: *pauseAt, length: *pauseFor},
+		stuckDeadline:   *stuckDeadline,
+		stuckPolicy:     *stuckPolicy,
+		maxLag:          *maxLag,
+		maxStall:        *maxStall,
+		absentRate:      *absentRate,
+		nackRate:        *nackRate,
+		dlqLatency:      *dlqLatency,
+		nackRetry: adapter.RetryPolicy{
+			Attempts:   *nackAttempts,
+			Backoff:    *nackBackoff,
+			MaxBackoff: *nackMaxBackoff,
+		},
+		nackJitter: *nackJitter,
+		bundleDir:  *bundleDir,
+		args:       args,
+		groups:     *groups,
+		timeline:   *chart || *report != "" || *chartDir != "",
+		chart:      *chart,
+		heatmap:    *heatmap,
+		logLevel:   *logLevel,
+	}
+	if *cloudEvents != "" {
+		host, _ := os.Hostname()
+		events, err := newCloudEmitter(*cloudEvents, "/offsets_test/bench/"+host)
+		if err != nil {
+			return err
+		}
+		defer events.close(10 * time.Second)
+		base.events, base.stallAfter = events, *stallAfter
+	}
+	if *traceOut != "" {
+		stop, err := startExecTrace(*traceOut)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	if *tui {
+		dash, stop, err := startDashboard()
+		if err != nil {
+			return err
+		}
+		defer stop()
+		base.dash, base.quiet = dash, true
+	}
+	if *heapProfiles != "" {
+		s, err := parseHeapSchedule(*heapProfiles, *heapEvery)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(*heapProfiles, 0o755); err != nil {
+			return err
+		}
+		base.heapProfiles = s
+	}
+	base.shutdown = shutdownOnSignal()
+	// kill -USR1 prints the state of the running consumer
+	base.live = &atomic.Value{}
+	dumpOnSignal(newStateDumper(livePartitions(base.live)))
+	if *configFile != "" {
+		lt, err := loadTunables(*configFile)
+		if err != nil {
+			return err
+		}
+		base.tunables = lt
+		reloadOnSignal(lt, base.live)
+	}
+	if *admin != "" {
+		base.health, base.stallAfter = NewHealth(), *stallAfter
+		base.feed = newProgressFeed()
+		if err := serveAdmin(*admin, base); err != nil {
+			return err
+		}
+	}
+	if *grpcAddr != "" {
+		if err := serveGRPC(*grpcAddr, livePartitions(base.live)); err != nil {
+			return err
+		}
+	}
+	if *replay != "" {
+		events, err := readTrace(*replay)
+		if err != nil {
+			return err
+		}
+		base.replay = events
+	}
+
+	var cfgs []benchConfig
+	if *scenarios != "" {
+		var err error
+		if cfgs, err = loadScenarios(*scenarios, base); err != nil {
+			return err
+		}
+		// the point of a scenario file is the consolidated report
+		if *markdown == "" {
+			*markdown = "-"
+		}
+	} else {
+		for _, name := range strings.Split(*backendList, ",") {
+			cfg := base
+			cfg.name = strings.TrimSpace(name)
+			cfg.backend = cfg.name
+			cfgs = append(cfgs, cfg)
+		}
+	}
+
+	ballasts, err := parseBallasts(*ballastList)
+	if err != nil {
+		return err
+	}
+	cfgs = withBallasts(cfgs, ballasts)
+	if *only != "" {
+		var picked []benchConfig
+		for _, cfg := range cfgs {
+			if cfg.name == *only {
+				picked = append(picked, cfg)
+			}
+		}
+		if len(picked) == 0 {
+			return fmt.Errorf("no run is called %q", *only)
+		}
+		cfgs = picked
+	}
+
+	if *record != "" {
+		// a trace is one workload, recording several runs into one
+		// file would just keep the last
+		if len(cfgs) != 1 {
+			return fmt.Errorf("-record needs exactly one run, got %v", len(cfgs))
+		}
+		cfgs[0].record = *record
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+		fmt.Printf("using seed %v\n", *seed)
+	}
+	for i := range cfgs {
+		if cfgs[i].seed == 0 {
+			cfgs[i].seed = *seed
+		}
+	}
+	if *cpuProfile != "" {
+		for i := range cfgs {
+			cfgs[i].cpuProfile = *cpuProfile
+			if len(cfgs) > 1 {
+				cfgs[i].cpuProfile = runProfilePath(*cpuProfile, cfgs[i].name)
+			}
+		}
+	}
+	var results []benchResult
+	for i, cfg := range cfgs {
+		res, err := runGroups(cfg)
+		if err != nil {
+			return err
+		}
+		results = append(results, res...)
+		if res[0].interrupted {
+			if left := len(cfgs) - i - 1; left > 0 {
+				fmt.Printf("skipping the %v remaining runs\n", left)
+			}
+			break
+		}
+	}
+
+	if *markdown != "" {
+		err := writeOutput(*markdown, func(w io.Writer) error { return writeMarkdown(w, results) })
+		if err != nil {
+			return err
+		}
+	}
+	if *chartDir != "" {
+		for _, r := range results {
+			if err := writeRunCharts(*chartDir, *chartFormat, r); err != nil {
+				return err
+			}
+		}
+	}
+	if *report != "" {
+		err := writeOutput(*report, func(w io.Writer) error { return writeHTMLReport(w, args, results) })
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote the report to %v\n", *report)
+	}
+	if *jsonOut != "" {
+		return writeOutput(*jsonOut, func(w io.Writer) error { return writeJSON(w, results) })
+	}
+	return nil
+}
+
+func parseBallasts(list string) ([]int, error) {
+	var sizes []int
+	for _, s := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad ballast size %q, want MiB", s)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
+
+// withBallasts repeats every config once per ballast size.  With a single
+// size the configs keep their names, and scenarios that set their own
+// ballast keep it.
+func withBallasts(cfgs []benchConfig, sizes []int) []benchConfig {
+	if len(sizes) == 1 {
+		for i := range cfgs {
+			if cfgs[i].ballast == 0 {
+				cfgs[i].ballast = sizes[0]
+			}
+		}
+		return cfgs
+	}
+	out := make([]benchConfig, 0, len(cfgs)*len(sizes))
+	for _, cfg := range cfgs {
+		name := cfg.name
+		for _, size := range sizes {
+			cfg.ballast = size
+			cfg.name = name
+			if size > 0 {
+				cfg.name = fmt.Sprintf("%s/ballast-%dMiB", name, size)
+				cfg.baseline = name
+			}
+			out = append(out, cfg)
+		}
+	}
+	return out
+}
+
+// writeOutput calls write with the file at path, or with stdout when path
+// is "-"
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		fmt.Println()
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// NewMetricsHandler returns a handler serving the state of partitions in
+// the Prometheus text format, one series per partition
+func NewMetricsHandler(partitions func() map[TrackerKey]Partition) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parts := partitions()
+		keys := sortedKeys(parts)
+		statuses := make([]PartitionStatus, 0, len(keys))
+		for _, k := range keys {
+			s, err := partitionStatus(req.Context(), k, parts[k])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			statuses = append(statuses, s)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics := []struct {
+			name, help string
+			value      func(s PartitionStatus) int64
+		}{
+			{"offsets_committed", "the watermark of the partition", func(s PartitionStatus) int64 { return s.Committed }},
+			{"offsets_pending", "acked offsets waiting on a gap", func(s PartitionStatus) int64 { return int64(s.Pending) }},
+			{"offsets_gaps", "ranges of offsets the watermark is waiting on", func(s PartitionStatus) int64 { return int64(s.GapCount) }},
+			{"offsets_paused", "1 while the partition is paused", func(s PartitionStatus) int64 {
+				if s.Paused {
+					return 1
+				}
+				return 0
+			}},
+		}
+		for _, m := range metrics {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+			for _, s := range statuses {
+				fmt.Fprintf(w, "%s{%s} %d\n", m.name, metricLabels(s), m.value(s))
+			}
+		}
+	})
+}
+
+// metricLabels are the labels of s's series, the topic only if it has one
+func metricLabels(s PartitionStatus) string {
+	if s.Topic == "" {
+		return fmt.Sprintf(`partition="%d"`, s.Partition)
+	}
+	return fmt.Sprintf(`topic=%q,partition="%d"`, s.Topic, s.Partition)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// oldestCmd asks a running consumer's admin API what is holding up its
+// watermark, e.g. offsets oldest -addr localhost:8080 -n 20
+func oldestCmd(args []string) error {
+	fs := flag.NewFlagSet("oldest", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address of the admin API")
+	partition := fs.Int("partition", 0, "partition to look at")
+	n := fs.Int("n", 10, "number of offsets to list")
+	fs.Parse(args)
+
+	base := *addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	var status PartitionStatus
+	if err := getJSON(fmt.Sprintf("%s/partitions/%d", base, *partition), &status); err != nil {
+		return err
+	}
+	var oldest []Outstanding
+	if err := getJSON(fmt.Sprintf("%s/partitions/%d/oldest?n=%d", base, *partition, *n), &oldest); err != nil {
+		return err
+	}
+	fmt.Printf("partition %d: watermark %d, %d acks pending in %d gaps\n", *partition, status.Committed, status.Pending, status.GapCount)
+	if len(oldest) == 0 {
+		fmt.Println("nothing is holding up the watermark")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OFFSET\tAGE\tWORKER")
+	for _, o := range oldest {
+		age, worker := "-", o.Worker
+		if o.Age > 0 {
+			age = o.Age.Round(time.Millisecond).String()
+		}
+		if worker == "" {
+			worker = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", o.Offset, age, worker)
+	}
+	return w.Flush()
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
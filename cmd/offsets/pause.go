@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// pauser is the pause state of a consumer.  While paused the ack loop
+// leaves acks in the buffer and the committer skips its commits, so
+// nothing is lost and the tracker picks up exactly where it stopped.
+type pauser struct {
+	// paused is accessed atomically so the ack loop and the committer
+	// can check it cheaply
+	paused int32
+	// wake tells the ack loop to look at paused again
+	wake chan struct{}
+
+	mu       sync.Mutex
+	pausedAt time.Time
+	total    time.Duration
+	// clock times the pauses
+	clock tracker.Clock
+}
+
+func newPauser() *pauser {
+	return &pauser{wake: make(chan struct{}, 1), clock: tracker.RealClock}
+}
+
+// Pause stops acks being ingested and commits being made, e.g. during a
+// deploy or while something downstream is down.  It is safe to call from
+// any goroutine, pausing a paused consumer does nothing.
+func (p *pauser) Pause() {
+	p.set(true)
+}
+
+// Resume undoes Pause
+func (p *pauser) Resume() {
+	p.set(false)
+}
+
+func (p *pauser) set(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.isPaused() == paused {
+		return
+	}
+	if paused {
+		p.pausedAt = p.clock.Now()
+		atomic.StoreInt32(&p.paused, 1)
+	} else {
+		p.total += p.clock.Now().Sub(p.pausedAt)
+		atomic.StoreInt32(&p.paused, 0)
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pauser) isPaused() bool {
+	return atomic.LoadInt32(&p.paused) != 0
+}
+
+// PausedFor returns the total time spent paused so far
+func (p *pauser) PausedFor() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.isPaused() {
+		return p.total + p.clock.Now().Sub(p.pausedAt)
+	}
+	return p.total
+}
+
+// pauseConfig pauses the consumer once during a run
+type pauseConfig struct {
+	// at is how long into the run the pause starts, for is how long it
+	// lasts.  A zero for disables it.
+	at, length time.Duration
+}
+
+func (c pauseConfig) enabled() bool {
+	return c.length > 0
+}
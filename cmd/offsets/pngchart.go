@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// The PNG charts have the same layout as the SVG ones, see svgX and svgY,
+// with the axes labelled in a tiny bitmap font since the standard library
+// has none.
+
+var (
+	pngWhite = color.RGBA{255, 255, 255, 255}
+	pngBlack = color.RGBA{0, 0, 0, 255}
+	pngGrid  = color.RGBA{221, 221, 221, 255}
+)
+
+// pngLineChart draws series like svgLineChart
+func pngLineChart(series []svgSeries) *image.RGBA {
+	var maxX, maxY float64
+	for _, s := range series {
+		for _, p := range s.points {
+			maxX, maxY = max(maxX, p[0]), max(maxY, p[1])
+		}
+	}
+	img := pngCanvas()
+	pngAxes(img, maxX, maxY)
+	for i, s := range series {
+		c := pngColor(s.color)
+		for j := 1; j < len(s.points); j++ {
+			a, b := s.points[j-1], s.points[j]
+			pngLine(img, svgX(a[0], maxX), svgY(a[1], maxY), svgX(b[0], maxX), svgY(b[1], maxY), c)
+		}
+		// a swatch for each series where the SVG has its name
+		pngRect(img, svgLeft+10+120*i, svgTop-14, svgLeft+40+120*i, svgTop-8, c)
+	}
+	return img
+}
+
+// pngBarChart draws values like svgBarChart, without the labels of the
+// bars
+func pngBarChart(values []float64) *image.RGBA {
+	var maxY float64
+	for _, v := range values {
+		maxY = max(maxY, v)
+	}
+	img := pngCanvas()
+	pngAxes(img, 0, maxY)
+	slot := float64(svgWidth-svgLeft-svgRight) / float64(max(len(values), 1))
+	for i, v := range values {
+		x := float64(svgLeft) + slot*float64(i)
+		pngRect(img, int(x)+1, int(svgY(v, maxY)), int(x+slot)-1, svgHeight-svgBottom, pngColor(svgBar))
+	}
+	return img
+}
+
+func pngCanvas() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, svgWidth, svgHeight))
+	pngRect(img, 0, 0, svgWidth, svgHeight, pngWhite)
+	return img
+}
+
+func pngAxes(img *image.RGBA, maxX, maxY float64) {
+	const grid = 4
+	for i := 0; i <= grid; i++ {
+		v := maxY * float64(i) / grid
+		y := svgY(v, maxY)
+		pngLine(img, svgLeft, y, svgWidth-svgRight, y, pngGrid)
+		label := compactNumber(v)
+		pngText(img, svgLeft-6-pngTextWidth(label), int(y)-5, label)
+		if maxX > 0 {
+			label := compactNumber(maxX * float64(i) / grid)
+			x := int(svgX(maxX*float64(i)/grid, maxX))
+			pngText(img, x-pngTextWidth(label)/2, svgHeight-svgBottom+6, label)
+		}
+	}
+	pngLine(img, svgLeft, svgHeight-svgBottom, svgWidth-svgRight, svgHeight-svgBottom, pngBlack)
+	pngLine(img, svgLeft, svgTop, svgLeft, svgHeight-svgBottom, pngBlack)
+}
+
+func pngRect(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	for x := x0; x < x1; x++ {
+		for y := y0; y < y1; y++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// pngLine draws a line a pixel wide, stepping along its longer side
+func pngLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	dx, dy := x1-x0, y1-y0
+	steps := int(max(abs(dx), abs(dy))) + 1
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		img.SetRGBA(int(x0+dx*t+0.5), int(y0+dy*t+0.5), c)
+	}
+}
+
+// pngColor reads the #rrggbb colors the charts are given
+func pngColor(hex string) color.RGBA {
+	c := color.RGBA{A: 255}
+	fmt.Sscanf(hex, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return c
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// pngGlyphs are the characters compactNumber writes, three pixels wide and
+// five high
+var pngGlyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'.': {"...", "...", "...", "...", ".#."},
+	'-': {"...", "...", "###", "...", "..."},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	'e': {"...", "###", "###", "#..", "###"},
+	'k': {"#..", "#.#", "##.", "#.#", "#.#"},
+	'M': {"#.#", "###", "###", "#.#", "#.#"},
+	'G': {"###", "#..", "#.#", "#.#", "###"},
+}
+
+// pngScale is how many image pixels a pixel of a glyph takes
+const pngScale = 2
+
+func pngTextWidth(s string) int {
+	return len(s) * 4 * pngScale
+}
+
+// pngText writes s with its top left corner at x, y
+func pngText(img *image.RGBA, x, y int, s string) {
+	for i, r := range s {
+		g, ok := pngGlyphs[r]
+		if !ok {
+			continue
+		}
+		for row, line := range g {
+			for col := range line {
+				if line[col] == '#' {
+					gx, gy := x+(i*4+col)*pngScale, y+row*pngScale
+					pngRect(img, gx, gy, gx+pngScale, gy+pngScale, pngBlack)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// watchPressure is where an adapter would pause and resume fetching.  The
+// bench has nothing to pause, so it just measures how long the consumer
+// would have spent paused.
+func (r *benchRun) watchPressure(c *consumer) {
+	clock := orReal(r.cfg.clock)
+	var since time.Time
+	for {
+		select {
+		case <-c.stopped.Done():
+			if !since.IsZero() {
+				r.res.underPressure += clock.Now().Sub(since)
+			}
+			return
+		case ev := <-c.tracker.Pressure():
+			if ev.On == !since.IsZero() {
+				// replaced events can leave us seeing the same
+				// state twice
+				continue
+			}
+			if ev.On {
+				since = clock.Now()
+				r.res.pressureEpisodes++
+				fmt.Printf("pressure: pausing with %v pending, lag %v\n", ev.Pending, ev.Lag)
+			} else {
+				r.res.underPressure += clock.Now().Sub(since)
+				since = time.Time{}
+				fmt.Printf("pressure cleared: %v pending, lag %v\n", ev.Pending, ev.Lag)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevels are what -log-level and the config file's log_level can be
+var logLevels = map[string]string{
+	"debug": "the details of every tick",
+	"info":  "a progress bar",
+	"warn":  "only what goes wrong",
+}
+
+// progressBar shows how far a run has committed, how fast it is going and
+// when it should be done.  On a terminal the bar is redrawn in place, piped
+// somewhere else it is printed a line every tenth of the way.
+type progressBar struct {
+	w        io.Writer
+	terminal bool
+	total    int64
+	// rate is the msg/s, averaged over the last few updates
+	rate   float64
+	last   int64
+	lastAt time.Duration
+	// tenths is how many tenths of the way were printed when not on a
+	// terminal
+	tenths int64
+	drawn  bool
+}
+
+const barWidth = 30
+
+func newProgressBar(total int64) *progressBar {
+	fi, err := os.Stdout.Stat()
+	return &progressBar{w: os.Stdout, terminal: err == nil && fi.Mode()&os.ModeCharDevice != 0, total: total, last: -1}
+}
+
+// update shows committed at elapsed
+func (b *progressBar) update(committed int64, elapsed time.Duration) {
+	done := committed + 1
+	if dt := (elapsed - b.lastAt).Seconds(); dt > 0 {
+		rate := float64(committed-b.last) / dt
+		if b.lastAt == 0 {
+			b.rate = rate
+		} else {
+			b.rate = 0.3*rate + 0.7*b.rate
+		}
+	}
+	b.last, b.lastAt = committed, elapsed
+	if !b.terminal {
+		if t := done * 10 / b.total; t > b.tenths {
+			b.tenths = t
+			fmt.Fprintln(b.w, b.line(done, elapsed))
+		}
+		return
+	}
+	// back to the start of the line and clear it
+	fmt.Fprint(b.w, "\r\x1b[K"+b.line(done, elapsed))
+	b.drawn = true
+}
+
+func (b *progressBar) line(done int64, elapsed time.Duration) string {
+	frac := float64(done) / float64(b.total)
+	filled := int(frac * barWidth)
+	// a watermark that barely moves says nothing about when it's done
+	eta := "-"
+	if b.rate >= 1 {
+		eta = (time.Duration(float64(b.total-done) / b.rate * float64(time.Second))).Round(time.Second).String()
+	}
+	return fmt.Sprintf("[%s%s] %5.1f%%  %.0f msg/s  elapsed %v  ETA %v",
+		strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled), 100*frac, b.rate, elapsed.Round(time.Second), eta)
+}
+
+// finish ends the bar's line so what is printed next starts on its own
+func (b *progressBar) finish() {
+	if b.drawn {
+		fmt.Fprintln(b.w)
+		b.drawn = false
+	}
+}
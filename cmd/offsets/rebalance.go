@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// A cooperative rebalance, as with Kafka's cooperative sticky assignor,
+// only stops the partitions that move: their owner commits and gives them
+// up, and after the handoff their new owner resumes them from the broker.
+// simulateRebalance plays that out on a fake clock with a tracker per
+// owned partition.
+
+// rebalanceConfig describes the consumers of a simulated rebalance
+type rebalanceConfig struct {
+	partitions int
+	// members is how many consumers there are to begin with
+	members int
+	// changes are when consumers join or leave, in order
+	changes []membershipChange
+	// handoff is how long a moving partition goes without an owner
+	// between being revoked and being assigned again
+	handoff time.Duration
+}
+
+// membershipChange has delta consumers join at, or the -delta newest
+// leave
+type membershipChange struct {
+	at    time.Duration
+	delta int
+}
+
+type rebalanceResult struct {
+	// rebalances counts the changes of membership, moved the partitions
+	// that changed owner in them and kept those that didn't
+	rebalances, moved, kept int
+	// redelivered counts the messages a new owner got that had been
+	// processed before
+	redelivered int64
+	// progress is the committed offsets of all partitions together,
+	// every tick
+	progress []int64
+	// committed is what each partition ended up with on the broker
+	committed []int64
+	elapsed   time.Duration
+}
+
+// ownership is a partition held by a consumer, from assignment to
+// revocation
+type ownership struct {
+	partition, member int
+	tracker           *tracker.Tracker
+	revoked           bool
+}
+
+// handoff is a revoked partition on its way to member, which gets it at
+// at
+type handoff struct {
+	partition, member int
+	at                time.Duration
+}
+
+// simulateRebalance runs cfg's workload on every partition of rc, each
+// partition's messages processed in the time cfg's distribution gives them
+// and committed every cfg.commitInterval, with the consumers changing as
+// rc says.  It fails as soon as a partition's committed offset or the
+// progress of all of them goes back, or an offset is committed that nobody
+// processed.
+func simulateRebalance(cfg benchConfig, rc rebalanceConfig) (rebalanceResult, error) {
+	var res rebalanceResult
+	if rc.partitions <= 0 || rc.members <= 0 {
+		return res, fmt.Errorf("a rebalance needs partitions and consumers")
+	}
+	delays := make([]func(offset int64) time.Duration, rc.partitions)
+	for p := range delays {
+		pcfg := cfg
+		// every partition gets a workload of its own
+		pcfg.seed = cfg.seed + int64(p)
+		d, err := pcfg.delayFunc()
+		if err != nil {
+			return res, err
+		}
+		delays[p] = d
+	}
+	interval := cfg.commitInterval
+	if interval <= 0 {
+		interval = 10 * time.Millisecond
+	}
+	clock := tracker.NewFakeClock(time.Unix(0, 0))
+	n := cfg.numMsgs
+	brokers := make([]*adapter.SimBroker, rc.partitions)
+	processed := make([][]bool, rc.partitions)
+	for p := range brokers {
+		brokers[p] = adapter.NewSimBroker(0, 0)
+		processed[p] = make([]bool, n)
+	}
+	// owned is every ownership there has been, a message in the wheel is
+	// keyed by the index of the one it was delivered to and its offset
+	var owned []*ownership
+	current := make([]int, rc.partitions)
+	w := &timerWheel{}
+	assign := func(p, member int) {
+		from := brokers[p].Committed()
+		o := &ownership{partition: p, member: member, tracker: tracker.New(backend.NewMap(0), from)}
+		o.tracker.Clock = clock
+		current[p] = len(owned)
+		owned = append(owned, o)
+		for offset := from + 1; offset < n; offset++ {
+			if processed[p][offset] {
+				res.redelivered++
+			}
+			d := delays[p](offset)
+			w.schedule(int64(current[p])*n+offset, int64((d+wheelResolution-1)/wheelResolution))
+		}
+	}
+	commit := func(o *ownership) error {
+		c := o.tracker.Committed()
+		b := brokers[o.partition]
+		if c == b.Committed() {
+			return nil
+		}
+		if c < b.Committed() {
+			return fmt.Errorf("partition %v: consumer %v committing %v, behind the broker's %v: %w", o.partition, o.member, c, b.Committed(), tracker.ErrOffsetBelowWatermark)
+		}
+		for offset := b.Committed() + 1; offset <= c; offset++ {
+			if !processed[o.partition][offset] {
+				return fmt.Errorf("partition %v: consumer %v committing %v, %v was never processed", o.partition, o.member, c, offset)
+			}
+		}
+		return b.Commit(context.Background(), c)
+	}
+
+	members := make([]int, rc.members)
+	for i := range members {
+		members[i] = i
+	}
+	nextMember := rc.members
+	none := make([]int, rc.partitions)
+	for p := range none {
+		none[p] = -1
+	}
+	owners := stickyAssign(none, members)
+	for p, m := range owners {
+		assign(p, m)
+	}
+	// pending are the partitions revoked and waiting out the handoff to
+	// their new owner
+	var pending []handoff
+	changes := rc.changes
+	var last int64 = -int64(rc.partitions)
+	var committedAt time.Duration
+	for ticks := int64(1); ; ticks++ {
+		clock.Advance(wheelResolution)
+		res.elapsed = time.Duration(ticks) * wheelResolution
+		w.advance(ticks, func(key int64) {
+			o, offset := owned[key/n], key%n
+			processed[o.partition][offset] = true
+			// a revoked consumer still finishes what it was doing,
+			// but its acks go nowhere
+			if !o.revoked {
+				o.tracker.Ack(offset)
+			}
+		})
+
+		if len(changes) > 0 && res.elapsed >= changes[0].at {
+			ch := changes[0]
+			changes = changes[1:]
+			if ch.delta > 0 {
+				for i := 0; i < ch.delta; i++ {
+					members = append(members, nextMember)
+					nextMember++
+				}
+			} else if left := len(members) + ch.delta; left > 0 {
+				members = members[:left]
+			} else {
+				return res, fmt.Errorf("at %v: %v consumers can't leave a group of %v", ch.at, -ch.delta, len(members))
+			}
+			res.rebalances++
+			// a partition whose handoff hasn't finished is with the
+			// consumer it is on its way to
+			for _, h := range pending {
+				owners[h.partition] = h.member
+			}
+			next := stickyAssign(owners, members)
+			for p, m := range next {
+				if m == owners[p] {
+					res.kept++
+					continue
+				}
+				res.moved++
+				if o := owned[current[p]]; !o.revoked {
+					// the first phase: the owner commits what it has
+					// and lets go
+					if err := commit(o); err != nil {
+						return res, err
+					}
+					o.revoked = true
+				}
+				for i, h := range pending {
+					if h.partition == p {
+						pending = append(pending[:i], pending[i+1:]...)
+						break
+					}
+				}
+				pending = append(pending, handoff{partition: p, member: m, at: res.elapsed + rc.handoff})
+			}
+			owners = next
+		}
+		// the second phase: the new owners take over
+		kept := pending[:0]
+		for _, h := range pending {
+			if res.elapsed >= h.at {
+				assign(h.partition, h.member)
+			} else {
+				kept = append(kept, h)
+			}
+		}
+		pending = kept
+
+		if res.elapsed-committedAt >= interval {
+			committedAt = res.elapsed
+			for _, i := range current {
+				if o := owned[i]; !o.revoked {
+					if err := commit(o); err != nil {
+						return res, err
+					}
+				}
+			}
+		}
+		var total int64
+		done := len(pending) == 0 && len(changes) == 0
+		for _, b := range brokers {
+			total += b.Committed()
+			done = done && b.Committed() == n-1
+		}
+		if total < last {
+			return res, fmt.Errorf("at %v the partitions together went back from %v to %v", res.elapsed, last, total)
+		}
+		last = total
+		res.progress = append(res.progress, total)
+		if done {
+			break
+		}
+		if w.len() == 0 && len(pending) == 0 && len(changes) == 0 {
+			stuck := true
+			for _, i := range current {
+				stuck = stuck && owned[i].tracker.Committed() == brokers[owned[i].partition].Committed()
+			}
+			if stuck {
+				return res, fmt.Errorf("at %v nothing is left that could finish the partitions", res.elapsed)
+			}
+		}
+	}
+	for _, b := range brokers {
+		res.committed = append(res.committed, b.Committed())
+	}
+	return res, nil
+}
+
+// stickyAssign spreads the partitions evenly over members, moving as few
+// as it can: owners[p] is who has partition p, -1 or a consumer that isn't
+// in members if nobody does
+func stickyAssign(owners []int, members []int) []int {
+	present := make(map[int]bool, len(members))
+	for _, m := range members {
+		present[m] = true
+	}
+	held := make(map[int][]int)
+	for p, m := range owners {
+		if present[m] {
+			held[m] = append(held[m], p)
+		}
+	}
+	// whoever holds the most gets the odd partitions, so they needn't
+	// give them up
+	order := append([]int{}, members...)
+	sort.SliceStable(order, func(i, j int) bool { return len(held[order[i]]) > len(held[order[j]]) })
+	quota := make(map[int]int, len(members))
+	for i, m := range order {
+		quota[m] = len(owners) / len(members)
+		if i < len(owners)%len(members) {
+			quota[m]++
+		}
+	}
+	next := make([]int, len(owners))
+	for p := range next {
+		next[p] = -1
+	}
+	count := make(map[int]int, len(members))
+	for _, m := range order {
+		for _, p := range held[m] {
+			if count[m] < quota[m] {
+				next[p] = m
+				count[m]++
+			}
+		}
+	}
+	var free []int
+	for p, m := range next {
+		if m == -1 {
+			free = append(free, p)
+		}
+	}
+	for _, m := range order {
+		for count[m] < quota[m] {
+			next[free[0]] = m
+			free = free[1:]
+			count[m]++
+		}
+	}
+	return next
+}
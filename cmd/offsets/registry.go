@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// TrackerKey names a tracked partition.  Group and Topic are empty for the
+// brokers that don't have them.
+type TrackerKey struct {
+	Group     string
+	Topic     string
+	Partition int32
+}
+
+func (k TrackerKey) String() string {
+	if k.Group == "" && k.Topic == "" {
+		return fmt.Sprintf("partition %d", k.Partition)
+	}
+	return fmt.Sprintf("%s/%s/%d", k.Group, k.Topic, k.Partition)
+}
+
+// sortKeys sorts keys by group, then topic, then partition
+func sortKeys(keys []TrackerKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+}
+
+// LifecycleKind says what happened to a tracked partition
+type LifecycleKind int
+
+const (
+	// Opened is sent once a partition is restored and tracking
+	Opened LifecycleKind = iota
+	// Closed is sent once a partition has committed, saved its snapshot
+	// and stopped
+	Closed
+	// Expired is sent instead of Closed for a partition closed for
+	// getting no acks for the registry's IdleTTL
+	Expired
+)
+
+func (k LifecycleKind) String() string {
+	switch k {
+	case Opened:
+		return "opened"
+	case Closed:
+		return "closed"
+	case Expired:
+		return "expired"
+	}
+	return fmt.Sprintf("LifecycleKind(%d)", int(k))
+}
+
+// LifecycleEvent is passed to Registry.OnEvent
+type LifecycleEvent struct {
+	Key  TrackerKey
+	Kind LifecycleKind
+	// Committed is the tracker's watermark and Broker the broker's
+	// committed offset, when the partition opened or closed
+	Committed int64
+	Broker    int64
+	// Pending is how many offsets were left pending at Closed and
+	// Expired
+	Pending int
+	// Err is what went wrong closing the partition, if anything
+	Err error
+}
+
+// Registry creates, looks up and closes the partitions of a consumer, so
+// that adapters don't each keep their own books.  It is safe for
+// concurrent use.
+type Registry struct {
+	open func(key TrackerKey) (*servedPartition, error)
+	// OnEvent, if set, is called with every lifecycle event on the
+	// goroutine opening or closing the partition
+	OnEvent func(LifecycleEvent)
+	// IdleTTL is how long a partition may go without acks before
+	// ExpireIdle closes it, which is what becomes of a partition whose
+	// revocation was missed.  Zero is forever.
+	IdleTTL time.Duration
+	// Clock times ExpireIdle, the real clock if nil
+	Clock tracker.Clock
+
+	mu    sync.Mutex
+	parts map[TrackerKey]*servedPartition
+}
+
+// NewRegistry returns a registry that starts partitions with open
+func NewRegistry(open func(key TrackerKey) (*servedPartition, error)) *Registry {
+	return &Registry{open: open, parts: make(map[TrackerKey]*servedPartition)}
+}
+
+// Open returns the partition for key, starting it if it isn't tracked yet
+func (r *Registry) Open(key TrackerKey) (*servedPartition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.parts[key]; ok {
+		return p, nil
+	}
+	p, err := r.open(key)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", key, err)
+	}
+	r.parts[key] = p
+	r.emit(LifecycleEvent{Key: key, Kind: Opened, Committed: p.tracker.Committed(), Broker: p.broker.Committed()})
+	return p, nil
+}
+
+// Get returns the partition for key if it is tracked
+func (r *Registry) Get(key TrackerKey) (*servedPartition, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.parts[key]
+	return p, ok
+}
+
+// Close stops tracking key, draining its acks, committing its watermark
+// and saving its snapshot first, see servedPartition.Close.  Closing a
+// partition that isn't tracked does nothing.
+func (r *Registry) Close(ctx context.Context, key TrackerKey) error {
+	r.mu.Lock()
+	p, ok := r.parts[key]
+	delete(r.parts, key)
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return r.closePartition(ctx, key, p, Closed)
+}
+
+// CloseAll closes every partition in order of their keys, those left when
+// ctx is done are torn down without draining
+func (r *Registry) CloseAll(ctx context.Context) {
+	r.mu.Lock()
+	parts := r.parts
+	r.parts = make(map[TrackerKey]*servedPartition)
+	r.mu.Unlock()
+	keys := make([]TrackerKey, 0, len(parts))
+	for k := range parts {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	for _, k := range keys {
+		r.closePartition(ctx, k, parts[k], Closed)
+	}
+}
+
+// ExpireIdle closes every partition that has gone IdleTTL without an ack,
+// checking every quarter of IdleTTL until stop is closed.  A closed
+// partition's pending set and metrics go with it, a later Open resumes it
+// from what was persisted.
+func (r *Registry) ExpireIdle(stop <-chan struct{}) {
+	if r.IdleTTL <= 0 {
+		return
+	}
+	ticker := orReal(r.Clock).NewTicker(r.IdleTTL / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C():
+			r.expireIdle(now)
+		}
+	}
+}
+
+func (r *Registry) expireIdle(now time.Time) {
+	idle := make(map[TrackerKey]*servedPartition)
+	r.mu.Lock()
+	for k, p := range r.parts {
+		if now.Sub(p.lastAck()) >= r.IdleTTL {
+			idle[k] = p
+			delete(r.parts, k)
+		}
+	}
+	r.mu.Unlock()
+	for k, p := range idle {
+		r.closePartition(context.Background(), k, p, Expired)
+	}
+}
+
+func (r *Registry) closePartition(ctx context.Context, key TrackerKey, p *servedPartition, kind LifecycleKind) error {
+	pending, err := p.Close(ctx)
+	r.emit(LifecycleEvent{Key: key, Kind: kind, Committed: p.tracker.Committed(), Broker: p.broker.Committed(), Pending: pending, Err: err})
+	return err
+}
+
+func (r *Registry) emit(e LifecycleEvent) {
+	if r.OnEvent != nil {
+		r.OnEvent(e)
+	}
+}
+
+// timeLooker is implemented by the brokers that can find offsets by time:
+// offsetAt returns the first offset of a record at or after t, or the end
+// of the log if there is none
+type timeLooker interface {
+	offsetAt(t time.Time) (int64, error)
+}
+
+// SeekToTime moves every partition's watermark to just below the first
+// offset at or after t, for reprocessing everything since then.  The
+// broker gets the new watermark with its next commit.  The registry isn't
+// locked while the brokers are asked, a partition that goes meanwhile
+// fails its seek with ErrClosed.
+func (r *Registry) SeekToTime(ctx context.Context, t time.Time) error {
+	r.mu.Lock()
+	parts := make(map[TrackerKey]*servedPartition, len(r.parts))
+	for k, p := range r.parts {
+		parts[k] = p
+	}
+	r.mu.Unlock()
+	for k, p := range parts {
+		tl, ok := p.broker.(timeLooker)
+		if !ok {
+			return fmt.Errorf("%v: a %T broker can't look offsets up by time", k, p.broker)
+		}
+		o, err := tl.offsetAt(t)
+		if err != nil {
+			return fmt.Errorf("%v: %w", k, err)
+		}
+		if err := p.SeekTo(ctx, o-1); err != nil {
+			return fmt.Errorf("%v: %w", k, err)
+		}
+		fmt.Printf("%v seeked to offset %v, the first at or after %v\n", k, o, t.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// parseSeekTime accepts a time as RFC 3339, or a duration for that long
+// before now
+func parseSeekTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad seek time %q, want a duration ago or an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// Partitions returns the tracked partitions for the admin API and the
+// other views of them
+func (r *Registry) Partitions() map[TrackerKey]Partition {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	parts := make(map[TrackerKey]Partition, len(r.parts))
+	for k, p := range r.parts {
+		parts[k] = p
+	}
+	return parts
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+	"gopkg.in/yaml.v3"
+)
+
+// tunables are the settings that can change in the middle of a run.  bench
+// -config reads them from a YAML file at startup and again on every SIGHUP,
+// e.g.
+//
+//	commit_interval: 50ms
+//	max_in_flight: 100000
+//	pending_budget: 4096
+//	progress_interval: 1s
+//	log_level: warn
+//
+// Settings missing from the file keep the values of the flags or scenario.
+type tunables struct {
+	CommitInterval time.Duration `yaml:"commit_interval"`
+	// MaxInFlight and PendingBudget are pointers because zero turns the
+	// limit off, PendingBudget is in KiB
+	MaxInFlight   *int64 `yaml:"max_in_flight"`
+	PendingBudget *int64 `yaml:"pending_budget"`
+	// ProgressInterval is how often progress is checked, printed and
+	// streamed
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+	// LogLevel is debug, which prints the details of every tick, info,
+	// which keeps a progress bar, or warn, which only prints what goes
+	// wrong
+	LogLevel string `yaml:"log_level"`
+}
+
+func readTunables(path string) (tunables, error) {
+	var tn tunables
+	f, err := os.Open(path)
+	if err != nil {
+		return tn, err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&tn); err != nil {
+		return tn, fmt.Errorf("%s: %w", path, err)
+	}
+	if tn.CommitInterval < 0 || tn.ProgressInterval < 0 {
+		return tn, fmt.Errorf("%s: intervals must be positive", path)
+	}
+	switch tn.LogLevel {
+	case "", "debug", "info", "warn":
+	default:
+		return tn, fmt.Errorf("%s: bad log level %q (want debug, info or warn)", path, tn.LogLevel)
+	}
+	return tn, nil
+}
+
+// setTracker applies the tunables that belong to the tracker, from the
+// acking goroutine
+func (tn tunables) setTracker(t *tracker.Tracker) {
+	if tn.MaxInFlight != nil {
+		t.MaxInFlight = *tn.MaxInFlight
+	}
+	if tn.PendingBudget != nil {
+		t.Budget = *tn.PendingBudget << 10
+	}
+}
+
+// logLevel is the log level the tunables set, or def if they don't
+func (tn tunables) logLevel(def string) string {
+	if tn.LogLevel != "" {
+		return tn.LogLevel
+	}
+	return def
+}
+
+// liveTunables is the config file of a bench and what was last read from
+// it, shared by every run
+type liveTunables struct {
+	path string
+	mu   sync.Mutex
+	cur  tunables
+}
+
+func loadTunables(path string) (*liveTunables, error) {
+	tn, err := readTunables(path)
+	if err != nil {
+		return nil, err
+	}
+	return &liveTunables{path: path, cur: tn}, nil
+}
+
+func (lt *liveTunables) get() tunables {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.cur
+}
+
+// reload reads the file again, on error the old tunables stay
+func (lt *liveTunables) reload() (tunables, error) {
+	tn, err := readTunables(lt.path)
+	if err != nil {
+		return lt.get(), err
+	}
+	lt.mu.Lock()
+	lt.cur = tn
+	lt.mu.Unlock()
+	return tn, nil
+}
+
+// retune applies tn to a running consumer, it is safe to call from any
+// goroutine
+func (c *consumer) retune(tn tunables) error {
+	if c.cmt != nil && tn.CommitInterval > 0 {
+		if err := c.cmt.SetInterval(tn.CommitInterval, c.stopped); err != nil {
+			return err
+		}
+	}
+	return c.call(context.Background(), func() { tn.setTracker(c.tracker) })
+}
+
+// reloadOnSignal rereads lt on every SIGHUP and applies it to the consumer
+// in live, the progress loop picks up the rest on its next tick
+func reloadOnSignal(lt *liveTunables, live *atomic.Value) {
+	sigs := make(chan os.Signal, 1)
+	notifyReload(sigs)
+	go func() {
+		for range sigs {
+			tn, err := lt.reload()
+			if err != nil {
+				fmt.Printf("reloading %v: %v, keeping the old settings\n", lt.path, err)
+				continue
+			}
+			if c, ok := live.Load().(*consumer); ok {
+				if err := c.retune(tn); err != nil {
+					fmt.Printf("reloading %v: %v\n", lt.path, err)
+					continue
+				}
+			}
+			fmt.Printf("reloaded %v\n", lt.path)
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+// notifyReload does nothing, there is no SIGHUP on this platform
+func notifyReload(sigs chan<- os.Signal) {}
@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload relays SIGHUP to sigs
+func notifyReload(sigs chan<- os.Signal) {
+	signal.Notify(sigs, syscall.SIGHUP)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/store"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// With -leader-election raft the replicas of serve elect their leader with
+// Raft and the leader replicates every ack, seek and fence through the Raft
+// log before taking it.  Each replica applies the log to its own copy of the trackers,
+// so the one taking over resumes with the whole pending set rather than
+// from the broker's last commit.  It is experimental: every ack waits for
+// a quorum, and the log is only kept in memory, a replica that restarts
+// catches up from the others.
+
+// replicator is an elector that also replicates the trackers' state
+type replicator interface {
+	elector
+	// replicate applies op to the replicas, it fails unless this
+	// instance is the leader
+	replicate(ctx context.Context, op raftOp) error
+	// storeFor returns a store that restores partition from the
+	// replicated state and saves to inner, which may be nil
+	storeFor(partition int32, inner store.Store) store.Store
+}
+
+// raftOp is an entry of the Raft log
+type raftOp struct {
+	Kind      string `json:"kind"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset,omitempty"`
+	// Snapshot is what a restore starts the partition's tracker from
+	Snapshot *tracker.Snapshot `json:"snapshot,omitempty"`
+	// Generation is what a fence moves the partition on to, and the
+	// one a restore starts it at
+	Generation int64 `json:"generation,omitempty"`
+}
+
+const (
+	opRestore = "restore"
+	opAck     = "ack"
+	opSeek    = "seek"
+	opFence   = "fence"
+)
+
+// partitionState is what a Raft snapshot keeps of a partition
+type partitionState struct {
+	tracker.Snapshot
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// generationStore is a store that also knows the generation the partition
+// was fenced at
+type generationStore interface {
+	generation() int64
+}
+
+// trackerFSM applies the log to a tracker per partition
+type trackerFSM struct {
+	mu       sync.Mutex
+	trackers map[int32]*tracker.Tracker
+}
+
+func newTrackerFSM() *trackerFSM {
+	return &trackerFSM{trackers: make(map[int32]*tracker.Tracker)}
+}
+
+func (f *trackerFSM) Apply(l *raft.Log) interface{} {
+	var op raftOp
+	if err := json.Unmarshal(l.Data, &op); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := f.trackers[op.Partition]
+	switch {
+	case op.Kind == opRestore && op.Snapshot != nil:
+		t = tracker.Restore(backend.NewMap(0), *op.Snapshot)
+		f.trackers[op.Partition] = t
+		return t.Fence(op.Generation)
+	case t == nil:
+		return fmt.Errorf("partition %v %v before it was restored", op.Partition, op.Kind)
+	case op.Kind == opAck:
+		return t.Ack(op.Offset)
+	case op.Kind == opSeek:
+		t.SeekTo(op.Offset)
+	case op.Kind == opFence:
+		return t.Fence(op.Generation)
+	default:
+		return fmt.Errorf("unknown raft op %q", op.Kind)
+	}
+	return nil
+}
+
+// snapshot returns the replicated state of partition
+func (f *trackerFSM) snapshot(partition int32) (tracker.Snapshot, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.trackers[partition]
+	if !ok {
+		return tracker.Snapshot{}, false
+	}
+	return t.Snapshot(), true
+}
+
+// generation returns the generation partition is fenced at, zero if the
+// log doesn't have it
+func (f *trackerFSM) generation(partition int32) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.trackers[partition]; ok {
+		return t.Generation()
+	}
+	return 0
+}
+
+func (f *trackerFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snaps := make(map[int32]partitionState, len(f.trackers))
+	for id, t := range f.trackers {
+		snaps[id] = partitionState{Snapshot: t.Snapshot(), Generation: t.Generation()}
+	}
+	return fsmSnapshot(snaps), nil
+}
+
+func (f *trackerFSM) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	var snaps map[int32]partitionState
+	if err := json.NewDecoder(r).Decode(&snaps); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.trackers = make(map[int32]*tracker.Tracker, len(snaps))
+	for id, s := range snaps {
+		t := tracker.Restore(backend.NewMap(0), s.Snapshot)
+		if err := t.Fence(s.Generation); err != nil {
+			return err
+		}
+		f.trackers[id] = t
+	}
+	return nil
+}
+
+type fsmSnapshot map[int32]partitionState
+
+func (s fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(map[int32]partitionState(s)); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s fsmSnapshot) Release() {}
+
+// raftElector is a replica in a Raft cluster of serve instances, the peers
+// are given by address and the address is the server id
+type raftElector struct {
+	r         *raft.Raft
+	fsm       *trackerFSM
+	transport *raft.NetworkTransport
+	lost      chan struct{}
+	timeout   time.Duration
+}
+
+func newRaftElector(opts electionOptions) (elector, error) {
+	if opts.bind == "" || opts.peers == "" {
+		return nil, fmt.Errorf("raft leader election needs -raft-bind and -raft-peers")
+	}
+	dir := filepath.Join(opts.dir, "raft")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveTCPAddr("tcp", opts.bind)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransport(opts.bind, addr, 3, 10*time.Second, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := raft.NewFileSnapshotStore(dir, 2, io.Discard)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(opts.bind)
+	config.LogLevel = "WARN"
+	// leadership is lost once the leader hasn't heard from a quorum
+	// for the lease
+	config.LeaderLeaseTimeout = opts.ttl / 2
+	if config.LeaderLeaseTimeout > config.HeartbeatTimeout {
+		config.LeaderLeaseTimeout = config.HeartbeatTimeout
+	}
+	logs := raft.NewInmemStore()
+	fsm := newTrackerFSM()
+	r, err := raft.NewRaft(config, fsm, logs, logs, snaps, transport)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	var servers []raft.Server
+	for _, peer := range strings.Split(opts.peers, ",") {
+		servers = append(servers, raft.Server{ID: raft.ServerID(peer), Address: raft.ServerAddress(peer)})
+	}
+	// every replica bootstraps the same configuration, those with
+	// state already are left alone
+	if err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil && err != raft.ErrCantBootstrap {
+		r.Shutdown()
+		transport.Close()
+		return nil, err
+	}
+	return &raftElector{r: r, fsm: fsm, transport: transport, lost: make(chan struct{}), timeout: opts.ttl}, nil
+}
+
+// Campaign implements elector, Raft campaigns by itself so this only waits
+// to be the leader and for every earlier entry to be applied
+func (e *raftElector) Campaign(ctx context.Context) error {
+	leader := e.r.LeaderCh()
+	for e.r.State() != raft.Leader {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-leader:
+		}
+	}
+	if err := e.r.Barrier(e.timeout).Error(); err != nil {
+		return err
+	}
+	go func() {
+		for e.r.State() == raft.Leader {
+			<-leader
+		}
+		close(e.lost)
+	}()
+	return nil
+}
+
+func (e *raftElector) Lost() <-chan struct{} {
+	return e.lost
+}
+
+func (e *raftElector) Resign(ctx context.Context) error {
+	if err := e.r.LeadershipTransfer().Error(); err != nil && err != raft.ErrNotLeader {
+		return err
+	}
+	return nil
+}
+
+func (e *raftElector) Close() error {
+	err := e.r.Shutdown().Error()
+	e.transport.Close()
+	return err
+}
+
+// replicate implements replicator, ctx's deadline bounds how long the op
+// may wait to be enqueued if it is sooner than the election's timeout
+func (e *raftElector) replicate(ctx context.Context, op raftOp) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeout := e.timeout
+	if d, ok := ctx.Deadline(); ok && time.Until(d) < timeout {
+		timeout = time.Until(d)
+	}
+	f := e.r.Apply(data, timeout)
+	if err := f.Error(); err != nil {
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+func (e *raftElector) storeFor(partition int32, inner store.Store) store.Store {
+	return raftStore{fsm: e.fsm, partition: partition, inner: inner}
+}
+
+// raftStore restores a partition from its replicated state, falling back
+// to inner for a partition the log doesn't have yet
+type raftStore struct {
+	fsm       *trackerFSM
+	partition int32
+	inner     store.Store
+}
+
+func (s raftStore) Load(ctx context.Context) (tracker.Snapshot, bool, error) {
+	if snap, ok := s.fsm.snapshot(s.partition); ok {
+		return snap, true, nil
+	}
+	if s.inner == nil {
+		return tracker.Snapshot{}, false, nil
+	}
+	return s.inner.Load(ctx)
+}
+
+// generation implements generationStore
+func (s raftStore) generation() int64 {
+	return s.fsm.generation(s.partition)
+}
+
+func (s raftStore) Save(ctx context.Context, snap tracker.Snapshot) error {
+	if s.inner == nil {
+		return nil
+	}
+	return s.inner.Save(ctx, snap)
+}
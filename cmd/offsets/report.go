@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// writeMarkdown renders results as a markdown table that can be pasted
+// straight into an issue or design doc.
+func writeMarkdown(w io.Writer, results []benchResult) error {
+	rows := [][]string{
+		{"Run", "Backend", "Messages", "Duration", "Throughput (msg/s)", "Peak heap (MiB)", "Peak pending", "Longest stall", "GCs", "Allocs", "Broker commits", "Commit failures", "Max commit lag"},
+	}
+	for _, r := range results {
+		backend := r.backend
+		if r.sizeHint > 0 {
+			backend = fmt.Sprintf("%v (hint %v)", r.backend, r.sizeHint)
+		}
+		rows = append(rows, []string{
+			r.name,
+			backend,
+			fmt.Sprint(r.numMsgs),
+			r.duration.Round(time.Millisecond).String(),
+			fmt.Sprintf("%.0f", r.throughput()),
+			fmt.Sprintf("%.1f", float64(r.peakHeap)/1024/1024),
+			fmt.Sprint(r.peakPending),
+			r.longestStall.Round(time.Millisecond).String(),
+			fmt.Sprint(r.numGC),
+			fmt.Sprint(r.allocs),
+			brokerCell(r, r.brokerCommits),
+			brokerCell(r, r.commitFailures),
+			brokerCell(r, r.maxLag),
+		})
+	}
+	if err := writeTable(w, rows); err != nil {
+		return err
+	}
+
+	rows = [][]string{
+		{"Run", "Breaker trips", "Open for", "Commits rejected", "Commit failures", "Max commit lag"},
+	}
+	for _, r := range results {
+		if !r.breaker {
+			continue
+		}
+		rows = append(rows, []string{
+			r.name,
+			fmt.Sprint(r.breakerTrips),
+			r.breakerOpen.Round(time.Millisecond).String(),
+			fmt.Sprint(r.breakerRejected),
+			fmt.Sprint(r.commitFailures),
+			fmt.Sprint(r.maxLag),
+		})
+	}
+	if err := writeExtraTable(w, rows); err != nil {
+		return err
+	}
+
+	// runs with chaos get a second table showing how the tracker coped
+	rows = [][]string{
+		{"Run", "Dropped", "Duplicated", "Reordered", "Duplicates ignored", "Final watermark"},
+	}
+	for _, r := range results {
+		if !r.chaos {
+			continue
+		}
+		final := fmt.Sprint(r.committed)
+		if r.stalled {
+			final += " (stalled)"
+		}
+		rows = append(rows, []string{
+			r.name,
+			fmt.Sprint(r.dropped),
+			fmt.Sprint(r.duplicated),
+			fmt.Sprint(r.reordered),
+			fmt.Sprint(r.duplicates),
+			final,
+		})
+	}
+	if err := writeExtraTable(w, rows); err != nil {
+		return err
+	}
+
+	rows = [][]string{
+		{"Run", "Restored from", "Restored watermark", "Redelivered", "Reprocessed", "Lost"},
+	}
+	for _, r := range results {
+		if !r.restart {
+			continue
+		}
+		rows = append(rows, []string{
+			r.name,
+			r.restoredFrom,
+			fmt.Sprint(r.restoredAt),
+			fmt.Sprint(r.redelivered),
+			fmt.Sprint(r.reprocessed),
+			fmt.Sprint(r.lost),
+		})
+	}
+	if err := writeExtraTable(w, rows); err != nil {
+		return err
+	}
+	if err := writeGroupTable(w, results); err != nil {
+		return err
+	}
+	if err := writeBallastTable(w, results); err != nil {
+		return err
+	}
+	if err := writeBufferTable(w, results); err != nil {
+		return err
+	}
+
+	rows = [][]string{
+		{"Run", "Budget (KiB)", "Max in flight", "Refused acks", "Peak held", "Pressure episodes", "Under pressure"},
+	}
+	limit := func(v int64) string {
+		if v == 0 {
+			return "-"
+		}
+		return fmt.Sprint(v)
+	}
+	for _, r := range results {
+		if r.budget == 0 && r.maxInFlight == 0 && !r.pressure {
+			continue
+		}
+		rows = append(rows, []string{
+			r.name,
+			limit(r.budget),
+			limit(r.maxInFlight),
+			fmt.Sprint(r.refused),
+			fmt.Sprint(r.peakHeld),
+			fmt.Sprint(r.pressureEpisodes),
+			r.underPressure.Round(time.Millisecond).String(),
+		})
+	}
+	if err := writeExtraTable(w, rows); err != nil {
+		return err
+	}
+
+	rows = [][]string{
+		{"Run", "Paused for", "Longest stall", "Final watermark"},
+	}
+	for _, r := range results {
+		if !r.pause {
+			continue
+		}
+		rows = append(rows, []string{
+			r.name,
+			r.paused.Round(time.Millisecond).String(),
+			r.longestStall.Round(time.Millisecond).String(),
+			fmt.Sprint(r.committed),
+		})
+	}
+	if err := writeExtraTable(w, rows); err != nil {
+		return err
+	}
+
+	rows = [][]string{
+		{"Run", "Stuck policy", "Expired", "Skipped", "Gaps skipped", "Gap offsets", "Absent", "Retried", "Nacked", "Dead lettered", "Final watermark"},
+	}
+	for _, r := range results {
+		if r.stuckPolicy == "" && r.nacked == 0 && r.retried == 0 && r.gapsSkipped == 0 && r.absent == 0 {
+			continue
+		}
+		policy := r.stuckPolicy
+		if policy == "" {
+			policy = "-"
+		}
+		rows = append(rows, []string{
+			r.name,
+			policy,
+			fmt.Sprint(r.expired),
+			fmt.Sprint(r.skipped),
+			fmt.Sprint(r.gapsSkipped),
+			fmt.Sprint(r.gapOffsets),
+			fmt.Sprint(r.absent),
+			fmt.Sprint(r.retried),
+			fmt.Sprint(r.nacked),
+			fmt.Sprint(r.deadLettered),
+			fmt.Sprint(r.committed),
+		})
+	}
+	return writeExtraTable(w, rows)
+}
+
+// writeBufferTable shows what each run's ack buffer cost in memory against
+// how long acks waited in it, for every run if any of them didn't use the
+// default full buffer
+func writeBufferTable(w io.Writer, results []benchResult) error {
+	rows := [][]string{
+		{"Run", "Ack buffer", "Buffer (KiB)", "Ack latency p50", "p99", "max"},
+	}
+	custom := false
+	for _, r := range results {
+		custom = custom || r.ackBuffer != "full"
+		rows = append(rows, []string{
+			r.name,
+			r.ackBuffer,
+			fmt.Sprintf("%.1f", float64(r.bufferBytes)/1024),
+			r.ackLatency.p50.Round(time.Microsecond).String(),
+			r.ackLatency.p99.Round(time.Microsecond).String(),
+			r.ackLatency.max.Round(time.Microsecond).String(),
+		})
+	}
+	if !custom {
+		return nil
+	}
+	return writeExtraTable(w, rows)
+}
+
+// writeBallastTable compares every run with a ballast to the same run
+// without one, when both are in results
+func writeBallastTable(w io.Writer, results []benchResult) error {
+	byName := make(map[string]benchResult, len(results))
+	for _, r := range results {
+		byName[r.name] = r
+	}
+	rows := [][]string{
+		{"Run", "Ballast (MiB)", "GCs", "GCs without", "Throughput (msg/s)", "Throughput without", "Peak heap (MiB)", "Peak heap without"},
+	}
+	for _, r := range results {
+		if r.ballast == 0 {
+			continue
+		}
+		row := []string{
+			r.name,
+			fmt.Sprint(r.ballast),
+			fmt.Sprint(r.numGC), "-",
+			fmt.Sprintf("%.0f", r.throughput()), "-",
+			fmt.Sprintf("%.1f", float64(r.peakHeap)/1024/1024), "-",
+		}
+		if base, ok := byName[r.baseline]; ok && base.ballast == 0 {
+			row[3] = fmt.Sprint(base.numGC)
+			row[5] = fmt.Sprintf("%.0f", base.throughput())
+			row[7] = fmt.Sprintf("%.1f", float64(base.peakHeap)/1024/1024)
+		}
+		rows = append(rows, row)
+	}
+	return writeExtraTable(w, rows)
+}
+
+// writeExtraTable writes a table that only applies to some runs, separated
+// from the previous one by a blank line.  Nothing is written if no run
+// added a row below the header.
+func writeExtraTable(w io.Writer, rows [][]string) error {
+	if len(rows) == 1 {
+		return nil
+	}
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	return writeTable(w, rows)
+}
+
+// brokerCell formats a broker measurement, which doesn't exist for runs
+// that didn't simulate the broker
+func brokerCell(r benchResult, v int64) string {
+	if !r.broker {
+		return "-"
+	}
+	return fmt.Sprint(v)
+}
+
+// writeTable writes rows as a markdown table, the first row is the header.
+// Columns are padded so the table is readable as plain text too.
+func writeTable(w io.Writer, rows [][]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for n, row := range rows {
+		for i, cell := range row {
+			if _, err := fmt.Fprintf(w, "| %-*s ", widths[i], cell); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w, "|"); err != nil {
+			return err
+		}
+		if n == 0 {
+			for i := range row {
+				if _, err := fmt.Fprintf(w, "|-%s-", strings.Repeat("-", widths[i])); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintln(w, "|"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonResult is the machine readable form of a benchResult, used to pass
+// results between processes by sweep
+type jsonResult struct {
+	Name          string        `json:"name"`
+	Backend       string        `json:"backend"`
+	SizeHint      int           `json:"size_hint,omitempty"`
+	Ballast       int           `json:"ballast_mib,omitempty"`
+	AckBuffer     string        `json:"ack_buffer"`
+	BufferBytes   int64         `json:"buffer_bytes"`
+	AckLatencyP99 time.Duration `json:"ack_latency_p99_ns"`
+	Compactions   int           `json:"compactions,omitempty"`
+	Refused       int64         `json:"refused_acks,omitempty"`
+	Paused        time.Duration `json:"paused_ns,omitempty"`
+	Skipped       int64         `json:"skipped,omitempty"`
+	GapOffsets    int64         `json:"gap_offsets,omitempty"`
+	Messages      int64         `json:"messages"`
+	Duration      time.Duration `json:"duration_ns"`
+	Throughput    float64       `json:"throughput"`
+	PeakHeap      uint64        `json:"peak_heap_bytes"`
+	PeakPending   int           `json:"peak_pending"`
+	LongestStall  time.Duration `json:"longest_stall_ns"`
+	NumGC         uint32        `json:"num_gc"`
+	Allocs        uint64        `json:"allocs"`
+	Committed     int64         `json:"committed"`
+	Stalled       bool          `json:"stalled,omitempty"`
+	Interrupted   bool          `json:"interrupted,omitempty"`
+	Group         string        `json:"group,omitempty"`
+}
+
+func writeJSON(w io.Writer, results []benchResult) error {
+	out := make([]jsonResult, 0, len(results))
+	for _, r := range results {
+		out = append(out, jsonResult{
+			Name:          r.name,
+			Backend:       r.backend,
+			SizeHint:      r.sizeHint,
+			Ballast:       r.ballast,
+			AckBuffer:     r.ackBuffer,
+			BufferBytes:   r.bufferBytes,
+			AckLatencyP99: r.ackLatency.p99,
+			Compactions:   r.compactions,
+			Refused:       r.refused,
+			Paused:        r.paused,
+			Skipped:       r.skipped,
+			GapOffsets:    r.gapOffsets,
+			Messages:      r.numMsgs,
+			Duration:      r.duration,
+			Throughput:    r.throughput(),
+			PeakHeap:      r.peakHeap,
+			PeakPending:   r.peakPending,
+			LongestStall:  r.longestStall,
+			NumGC:         r.numGC,
+			Allocs:        r.allocs,
+			Committed:     r.committed,
+			Stalled:       r.stalled,
+			Interrupted:   r.interrupted,
+			Group:         r.group,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
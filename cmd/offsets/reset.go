@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+)
+
+// ResetPolicy says where a partition starts when it has no committed
+// offset, or one outside the log, like Kafka's auto.offset.reset
+type ResetPolicy struct {
+	// Kind is earliest, latest, fail or offset, empty means earliest
+	Kind string
+	// Offset is the first offset processed under offset
+	Offset int64
+}
+
+// parseResetPolicy accepts earliest, latest, fail or the offset to start at
+func parseResetPolicy(s string) (ResetPolicy, error) {
+	switch s {
+	case "earliest", "latest", "fail":
+		return ResetPolicy{Kind: s}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return ResetPolicy{}, fmt.Errorf("bad offset reset policy %q, want earliest, latest, fail or an offset", s)
+	}
+	return ResetPolicy{Kind: "offset", Offset: n}, nil
+}
+
+func (p ResetPolicy) String() string {
+	if p.Kind == "offset" {
+		return strconv.FormatInt(p.Offset, 10)
+	}
+	if p.Kind == "" {
+		return "earliest"
+	}
+	return p.Kind
+}
+
+// logBounder is implemented by the brokers that know the extent of a
+// partition's log: start is its first offset and end the one the next
+// record gets
+type logBounder interface {
+	logBounds() (start, end int64, err error)
+}
+
+// resolve returns the watermark to resume from when committed is what the
+// broker and snapshot had, -1 for nothing.  why says what made it reset,
+// it is empty when committed stands.
+func (p ResetPolicy) resolve(committed int64, broker adapter.Broker) (watermark int64, why string, err error) {
+	lb, bounded := broker.(logBounder)
+	var start, end int64
+	if bounded {
+		if start, end, err = lb.logBounds(); err != nil {
+			return 0, "", err
+		}
+	}
+	switch {
+	case committed < 0:
+		why = "no committed offset"
+	case bounded && (committed+1 < start || committed+1 > end):
+		why = fmt.Sprintf("committed offset %d is outside the log [%d, %d)", committed, start, end)
+	default:
+		return committed, "", nil
+	}
+	switch p.Kind {
+	case "", "earliest":
+		return start - 1, why, nil
+	case "latest":
+		if !bounded {
+			return 0, why, fmt.Errorf("%s and a %T broker can't say where the log ends", why, broker)
+		}
+		return end - 1, why, nil
+	case "offset":
+		if bounded && (p.Offset < start || p.Offset > end) {
+			return 0, why, fmt.Errorf("%s and reset offset %d is outside the log [%d, %d)", why, p.Offset, start, end)
+		}
+		return p.Offset - 1, why, nil
+	}
+	return 0, why, fmt.Errorf("%s and the offset reset policy is %v", why, p)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/store"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// restartConfig describes a consumer restart in the middle of a run
+type restartConfig struct {
+	// after is how long into the run the consumer is torn down, zero
+	// means it never restarts
+	after time.Duration
+	// from is where the new consumer gets its state: "snapshot" restores
+	// the persisted tracker snapshot, "broker" only has the broker's
+	// committed offset to go on
+	from             string
+	snapshotInterval time.Duration
+	// storePath is where snapshots are persisted, a temporary file is
+	// used when it's empty
+	storePath string
+}
+
+func (c restartConfig) enabled() bool {
+	return c.after > 0
+}
+
+func (r *benchRun) setupRestart() error {
+	cfg := r.cfg.restart
+	if r.cfg.replay != nil || r.chaos != nil {
+		// redelivered messages get fresh processing times, which
+		// would make a replay meaningless, and chaos drops make the
+		// lost offset check impossible
+		return fmt.Errorf("restarts can't be combined with replay or chaos")
+	}
+	switch cfg.from {
+	case "broker":
+	case "snapshot":
+		if cfg.snapshotInterval <= 0 {
+			return fmt.Errorf("snapshot interval must be positive")
+		}
+		path := cfg.storePath
+		if path == "" {
+			f, err := os.CreateTemp("", "offsets-snapshot-*.json")
+			if err != nil {
+				return err
+			}
+			f.Close()
+			os.Remove(f.Name())
+			path = f.Name()
+			r.tempStore = path
+		}
+		r.store = store.File{Path: path}
+	default:
+		return fmt.Errorf("unknown restore source %q (available: broker, snapshot)", cfg.from)
+	}
+	r.seen = make([]uint8, r.numMsgs)
+	return nil
+}
+
+func (r *benchRun) shouldRestart(now time.Time) bool {
+	return r.cfg.restart.enabled() && !r.restarted && now.Sub(r.start) >= r.cfg.restart.after
+}
+
+// restart kills the live consumer, along with everything it was still
+// processing, then starts a new one from the persisted state and
+// redelivers every message that state doesn't know was acked.
+func (r *benchRun) restart() error {
+	r.stopConsumer()
+	r.restarted = true
+	fmt.Printf("consumer torn down at watermark %v\n", r.cur.tracker.Committed())
+
+	snap := tracker.Snapshot{Committed: -1}
+	if r.store != nil {
+		s, ok, err := r.store.Load(context.Background())
+		if err != nil {
+			return err
+		}
+		if ok {
+			snap = s
+		}
+	}
+	// the broker may have committed more than the last snapshot knew
+	// about, anything it has is done
+	if r.broker != nil && r.broker.Committed() > snap.Committed {
+		snap.Committed = r.broker.Committed()
+	}
+	b, err := backend.New(r.cfg.backend, r.cfg.sizeHint)
+	if err != nil {
+		return err
+	}
+	tracker := tracker.Restore(b, snap)
+
+	// redeliver every offset above the watermark that isn't pending
+	var redeliver []int64
+	ranges := snap.Pending
+	for o := tracker.Committed() + 1; o < r.numMsgs; o++ {
+		for len(ranges) > 0 && ranges[0].To < o {
+			ranges = ranges[1:]
+		}
+		if len(ranges) > 0 && ranges[0].From <= o || r.absent(o) {
+			continue
+		}
+		redeliver = append(redeliver, o)
+	}
+	r.res.restoredAt = tracker.Committed()
+	r.res.redelivered = int64(len(redeliver))
+	fmt.Printf("restored from %v at watermark %v with %v pending, redelivering %v messages\n",
+		r.cfg.restart.from, tracker.Committed(), b.Len(), len(redeliver))
+
+	// the worker models only know how to process offsets [0, n), so map
+	// those onto the offsets being redelivered
+	n := int64(len(redeliver))
+	r.cur = r.startConsumer(tracker, n)
+	deliver := r.cur.deliver
+	var start sync.WaitGroup
+	r.spawn(n,
+		func(i int64) time.Duration { return r.delay(redeliver[i]) },
+		func(i int64) { deliver(redeliver[i]) },
+		&start)
+	return nil
+}
+
+// verifyRestart checks that every committed offset was processed at least
+// once and counts how much work the restart repeated
+func (r *benchRun) verifyRestart() {
+	res := &r.res
+	if !r.restarted {
+		fmt.Printf("run finished before the restart was due\n")
+		res.restoredFrom = "(no restart)"
+	}
+	for o, n := range r.seen {
+		if n == 0 && int64(o) <= res.committed && !r.absent(int64(o)) {
+			res.lost++
+		}
+		if n > 1 {
+			res.reprocessed += int64(n - 1)
+		}
+	}
+	if res.lost > 0 {
+		fmt.Printf("LOST %v offsets: committed without being processed\n", res.lost)
+	} else {
+		fmt.Printf("no offsets lost, %v messages reprocessed\n", res.reprocessed)
+	}
+	if r.tempStore != "" {
+		os.Remove(r.tempStore)
+	}
+}
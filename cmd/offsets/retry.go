@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+)
+
+// retrier gives failed messages back to the application after a backoff,
+// until they have failed policy.attempts times and become dead letters.
+// It is only used from the ack loop.
+type retrier struct {
+	policy adapter.RetryPolicy
+	// jitter is the fraction of each delay that is random, so messages
+	// that failed together don't all come back together
+	jitter float64
+	rng    *rand.Rand
+	// attempts counts the failures of every message being retried
+	attempts  map[int64]int
+	redeliver func(offset int64, after time.Duration)
+	retries   int64
+}
+
+func newRetrier(policy adapter.RetryPolicy, jitter float64, rng *rand.Rand, redeliver func(offset int64, after time.Duration)) *retrier {
+	return &retrier{
+		policy:    policy,
+		jitter:    jitter,
+		rng:       rng,
+		attempts:  make(map[int64]int),
+		redeliver: redeliver,
+	}
+}
+
+// attempt returns how many times offset has failed so far
+func (r *retrier) attempt(offset int64) int {
+	return r.attempts[offset]
+}
+
+// failed schedules offset to be redelivered and returns true, or returns
+// false if it has run out of attempts.  The count is kept until succeeded,
+// so a message asking again after running out still gets false.
+func (r *retrier) failed(offset int64) bool {
+	n := r.attempts[offset] + 1
+	if n >= r.policy.Attempts {
+		return false
+	}
+	r.attempts[offset] = n
+	d := r.policy.Delay(n)
+	d -= time.Duration(r.jitter * r.rng.Float64() * float64(d))
+	r.retries++
+	r.redeliver(offset, d)
+	return true
+}
+
+// succeeded forgets offset, once it has been acked or dead lettered
+func (r *retrier) succeeded(offset int64) {
+	if len(r.attempts) > 0 {
+		delete(r.attempts, offset)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "math/rand"
+
+// The global math/rand source is protected by a mutex, so a million
+// workers calling rand.Intn end up measuring lock contention instead of
+// the committer.  Nothing in the simulation shares a source: a message's
+// processing time is derived from the run's seed and its offset, and the
+// stages that run in a single goroutine get a source of their own.
+
+// splitMix64 is the output function of the SplitMix64 generator, it maps
+// any input to a well mixed 64 bit value
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// offsetFloat returns a uniform value in [0, 1) that only depends on seed
+// and offset, so the same seed gives every message the same processing time
+// whichever worker model runs it
+func offsetFloat(seed, offset int64) float64 {
+	return float64(splitMix64(uint64(seed)^splitMix64(uint64(offset)))>>11) / (1 << 53)
+}
+
+// splitMixSource is a rand.Source64 with 8 bytes of state, unlike the
+// default source which is several KB
+type splitMixSource struct {
+	state uint64
+}
+
+func (s *splitMixSource) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	return splitMix64(s.state)
+}
+
+func (s *splitMixSource) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *splitMixSource) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+// streams for newRand, one per stage that needs randomness.  They also
+// salt the seed passed to offsetFloat for per-message choices other than
+// the processing time.
+const (
+	chaosStream = iota + 1
+	brokerStream
+	nackStream
+	retryStream
+	absentStream
+)
+
+// newRand returns a generator for a single goroutine.  stream picks one of
+// independent sequences for the same seed.
+func newRand(seed, stream int64) *rand.Rand {
+	return rand.New(&splitMixSource{state: splitMix64(uint64(seed) ^ uint64(stream))})
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxRSS returns the peak resident set size of an exited process in bytes
+func maxRSS(ps *os.ProcessState) uint64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		// darwin reports bytes
+		return uint64(ru.Maxrss)
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "os"
+
+// maxRSS isn't available on this platform
+func maxRSS(ps *os.ProcessState) uint64 {
+	return 0
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scenarioFile is the format read by bench -scenarios, e.g.
+//
+//	defaults:
+//	  messages: 1000000
+//	  max_delay: 1s
+//	scenarios:
+//	  - name: map-uniform
+//	    backend: map
+//	  - name: map-long-tail
+//	    backend: map
+//	    distribution: exponential
+//	  - name: map-head-of-line
+//	    backend: map
+//	    hol_offset: 10
+//	    hol_delay: 100s
+type scenarioFile struct {
+	// Defaults fills in any field a scenario leaves empty
+	Defaults  scenario   `yaml:"defaults"`
+	Scenarios []scenario `yaml:"scenarios"`
+}
+
+type scenario struct {
+	Name            string        `yaml:"name"`
+	Messages        int64         `yaml:"messages"`
+	MaxDelay        time.Duration `yaml:"max_delay"`
+	Distribution    string        `yaml:"distribution"`
+	Backend         string        `yaml:"backend"`
+	SizeHint        int           `yaml:"size_hint"`
+	Workers         string        `yaml:"workers"`
+	Seed            int64         `yaml:"seed"`
+	CommitLatency   time.Duration `yaml:"commit_latency"`
+	CommitRate      float64       `yaml:"commit_rate"`
+	CommitInterval  time.Duration `yaml:"commit_interval"`
+	CommitFail      float64       `yaml:"commit_fail"`
+	CommitAttempts  int           `yaml:"commit_attempts"`
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
+	ChaosDrop       float64       `yaml:"chaos_drop"`
+	ChaosDup        float64       `yaml:"chaos_dup"`
+	ChaosReorder    float64       `yaml:"chaos_reorder"`
+	ChaosDelay      time.Duration `yaml:"chaos_delay"`
+	RestartAfter    time.Duration `yaml:"restart_after"`
+	RestoreFrom     string        `yaml:"restore_from"`
+	HOLOffset       int64         `yaml:"hol_offset"`
+	HOLDelay        time.Duration `yaml:"hol_delay"`
+	Envelopes       bool          `yaml:"envelopes"`
+	Ballast         int           `yaml:"ballast"`
+	Groups          int           `yaml:"groups"`
+	AckBuffer       string        `yaml:"ack_buffer"`
+	CompactInterval time.Duration `yaml:"compact_interval"`
+	// PendingBudget is in KiB
+	PendingBudget   int64         `yaml:"pending_budget"`
+	MaxInFlight     int64         `yaml:"max_in_flight"`
+	PressurePending int           `yaml:"pressure_pending"`
+	PressureLag     int64         `yaml:"pressure_lag"`
+	PauseAt         time.Duration `yaml:"pause_at"`
+	PauseFor        time.Duration `yaml:"pause_for"`
+	StuckDeadline   time.Duration `yaml:"stuck_deadline"`
+	StuckPolicy     string        `yaml:"stuck_policy"`
+	MaxLag          int64         `yaml:"max_lag"`
+	MaxStall        time.Duration `yaml:"max_stall"`
+	AbsentRate      float64       `yaml:"absent_rate"`
+	NackRate        float64       `yaml:"nack_rate"`
+	DLQLatency      time.Duration `yaml:"dlq_latency"`
+	NackAttempts    int           `yaml:"nack_attempts"`
+	NackBackoff     time.Duration `yaml:"nack_backoff"`
+	NackJitter      float64       `yaml:"nack_jitter"`
+	// Replay is a trace file to replay instead of simulating processing
+	Replay string `yaml:"replay"`
+}
+
+// apply overrides the fields of cfg that are set in s
+func (s scenario) apply(cfg benchConfig) benchConfig {
+	if s.Name != "" {
+		cfg.name = s.Name
+	}
+	if s.Messages != 0 {
+		cfg.numMsgs = s.Messages
+	}
+	if s.MaxDelay != 0 {
+		cfg.maxDelay = s.MaxDelay
+	}
+	if s.Distribution != "" {
+		cfg.distribution = s.Distribution
+	}
+	if s.Backend != "" {
+		cfg.backend = s.Backend
+	}
+	if s.SizeHint != 0 {
+		cfg.sizeHint = s.SizeHint
+	}
+	if s.Workers != "" {
+		cfg.workers = s.Workers
+	}
+	if s.Seed != 0 {
+		cfg.seed = s.Seed
+	}
+	if s.CommitLatency != 0 {
+		cfg.brokerLatency = s.CommitLatency
+	}
+	if s.CommitRate != 0 {
+		cfg.brokerRate = s.CommitRate
+	}
+	if s.CommitInterval != 0 {
+		cfg.commitInterval = s.CommitInterval
+	}
+	if s.CommitFail != 0 {
+		cfg.commitFailRate = s.CommitFail
+	}
+	if s.CommitAttempts != 0 {
+		cfg.retry.Attempts = s.CommitAttempts
+	}
+	if s.BreakerFailures != 0 {
+		cfg.breakerFailures = s.BreakerFailures
+	}
+	if s.BreakerCooldown != 0 {
+		cfg.breakerCooldown = s.BreakerCooldown
+	}
+	if s.ChaosDrop != 0 {
+		cfg.chaos.dropRate = s.ChaosDrop
+	}
+	if s.ChaosDup != 0 {
+		cfg.chaos.dupRate = s.ChaosDup
+	}
+	if s.ChaosReorder != 0 {
+		cfg.chaos.reorderRate = s.ChaosReorder
+	}
+	if s.ChaosDelay != 0 {
+		cfg.chaos.reorderDelay = s.ChaosDelay
+	}
+	if s.RestartAfter != 0 {
+		cfg.restart.after = s.RestartAfter
+	}
+	if s.RestoreFrom != "" {
+		cfg.restart.from = s.RestoreFrom
+	}
+	if s.HOLOffset != 0 {
+		cfg.hol.offset = s.HOLOffset
+	}
+	if s.HOLDelay != 0 {
+		cfg.hol.delay = s.HOLDelay
+	}
+	if s.Envelopes {
+		cfg.envelopes = true
+	}
+	if s.Ballast != 0 {
+		cfg.ballast = s.Ballast
+	}
+	if s.Groups != 0 {
+		cfg.groups = s.Groups
+	}
+	if s.AckBuffer != "" {
+		cfg.ackBuffer = s.AckBuffer
+	}
+	if s.CompactInterval != 0 {
+		cfg.compactInterval = s.CompactInterval
+	}
+	if s.PendingBudget != 0 {
+		cfg.budget = s.PendingBudget
+	}
+	if s.MaxInFlight != 0 {
+		cfg.maxInFlight = s.MaxInFlight
+	}
+	if s.PressurePending != 0 {
+		cfg.pressurePending = s.PressurePending
+	}
+	if s.PressureLag != 0 {
+		cfg.pressureLag = s.PressureLag
+	}
+	if s.PauseAt != 0 {
+		cfg.pause.at = s.PauseAt
+	}
+	if s.PauseFor != 0 {
+		cfg.pause.length = s.PauseFor
+	}
+	if s.StuckDeadline != 0 {
+		cfg.stuckDeadline = s.StuckDeadline
+	}
+	if s.StuckPolicy != "" {
+		cfg.stuckPolicy = s.StuckPolicy
+	}
+	if s.MaxLag != 0 {
+		cfg.maxLag = s.MaxLag
+	}
+	if s.MaxStall != 0 {
+		cfg.maxStall = s.MaxStall
+	}
+	if s.AbsentRate != 0 {
+		cfg.absentRate = s.AbsentRate
+	}
+	if s.NackRate != 0 {
+		cfg.nackRate = s.NackRate
+	}
+	if s.DLQLatency != 0 {
+		cfg.dlqLatency = s.DLQLatency
+	}
+	if s.NackAttempts != 0 {
+		cfg.nackRetry.Attempts = s.NackAttempts
+	}
+	if s.NackBackoff != 0 {
+		cfg.nackRetry.Backoff = s.NackBackoff
+	}
+	if s.NackJitter != 0 {
+		cfg.nackJitter = s.NackJitter
+	}
+	return cfg
+}
+
+// load reads anything the scenario references from disk into cfg
+func (s scenario) load(cfg benchConfig) (benchConfig, error) {
+	if s.Replay != "" {
+		events, err := readTrace(s.Replay)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.replay = events
+	}
+	return cfg, nil
+}
+
+// loadScenarios reads a scenario file and returns one config per scenario.
+// Fields missing from a scenario come from the file's defaults and then
+// from base.
+func loadScenarios(path string, base benchConfig) ([]benchConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file scenarioFile
+	dec := yaml.NewDecoder(f)
+	// catch typos in field names instead of silently ignoring them
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Scenarios) == 0 {
+		return nil, fmt.Errorf("%s: no scenarios defined", path)
+	}
+
+	base, err = file.Defaults.load(file.Defaults.apply(base))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	cfgs := make([]benchConfig, 0, len(file.Scenarios))
+	for i, s := range file.Scenarios {
+		cfg, err := s.load(s.apply(base))
+		if err != nil {
+			return nil, err
+		}
+		if s.Name == "" {
+			cfg.name = fmt.Sprintf("scenario-%d", i+1)
+		}
+		if seen[cfg.name] {
+			return nil, fmt.Errorf("%s: duplicate scenario name %q", path, cfg.name)
+		}
+		seen[cfg.name] = true
+		if cfg.numMsgs <= 0 || cfg.maxDelay <= 0 {
+			return nil, fmt.Errorf("%s: scenario %q: messages and max_delay must be positive", path, cfg.name)
+		}
+		if cfg.commitInFlight > 0 && (cfg.commitFailRate > 0 || cfg.breakerFailures > 0) {
+			// neither the failures nor the breaker take concurrent commits
+			return nil, fmt.Errorf("%s: scenario %q: -commit-in-flight doesn't go with commit_fail or breaker_failures", path, cfg.name)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
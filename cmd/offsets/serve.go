@@ -0,0 +1,799 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/store"
+	"github.com/ideasculptor/offsets_test/tracker"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// serveCmd runs the tracker as a standalone sidecar: the process doing the
+// work acks offsets over the admin API or gRPC, and serve commits each
+// partition's watermark to the broker and persists its snapshot, resuming
+// from both when it starts again.
+func serveCmd(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	numPartitions := fs.Int("partitions", 1, "number of partitions to track, numbered from 0")
+	backendName := fs.String("backend", "map", "tracker backend ("+strings.Join(backend.Names(), ", ")+")")
+	brokerName := fs.String("broker", "memory", "where watermarks are committed ("+strings.Join(names(brokerAdapters), ", ")+")")
+	dir := fs.String("dir", "", "directory for snapshots and the file broker, nothing is persisted if empty")
+	kafkaBrokers := fs.String("kafka-brokers", "", "comma separated seed brokers of the kafka broker")
+	kafkaTopic := fs.String("kafka-topic", "", "topic whose partitions the kafka and zookeeper brokers commit")
+	kafkaGroup := fs.String("kafka-group", "", "consumer group the kafka and zookeeper brokers commit as")
+	cloudEvents := fs.String("cloudevents", "", "send watermark advances and stalls as cloudevents to this http(s):// URL or kafka://brokers/topic")
+	stallAfter := fs.Duration("stall-after", 30*time.Second, "with -cloudevents, a partition whose watermark is stuck this long with acks pending has stalled")
+	auditTopic := fs.String("audit-topic", "", "produce a record of every commit to this topic of -kafka-brokers")
+	zkServers := fs.String("zk-servers", "", "comma separated servers of the zookeeper broker, which keeps offsets where consumers before Kafka 0.9 did")
+	commitInterval := fs.Duration("commit-interval", time.Second, "how often watermarks are committed to the broker")
+	commitMaxAdvance := fs.Int64("commit-max-advance", 0, "commit before -commit-interval is up once a watermark is this far past its last commit, 0 disables")
+	retries := fs.Int("commit-attempts", 5, "attempts per broker commit before waiting for the next interval")
+	backoff := fs.Duration("commit-backoff", 10*time.Millisecond, "delay before retrying a failed commit, doubled on every retry")
+	snapshotInterval := fs.Duration("snapshot-interval", time.Second, "how often tracker snapshots are persisted to -dir")
+	lockSnapshots := fs.Bool("lock-snapshots", false, "lock the snapshots in -dir so that serve processes on one host can hand them over, as in a blue/green switch: whichever saved last owns them and the other's saves fail")
+	drainTimeout := fs.Duration("drain-timeout", 10*time.Second, "how long shutting down waits for partitions to drain their acks and commit")
+	offsetReset := fs.String("offset-reset", "earliest", "where a partition starts without a committed offset or with one outside the log: earliest, latest, fail or an offset")
+	seekTime := fs.String("seek-to-time", "", "on start, move every partition back or forward to the first offset at or after this time, as RFC 3339 or a duration ago like 2h (kafka only)")
+	idleTTL := fs.Duration("idle-ttl", 0, "close and persist partitions that get no acks for this long, they stay closed until serve restarts (0 never)")
+	admin := fs.String("admin", "localhost:8080", "address of the admin API, /metrics, /healthz, /readyz and /ws")
+	grpcAddr := fs.String("grpc", "", "serve the gRPC Offsets service on this address too")
+	election := fs.String("leader-election", "", "run hot/standby with the other replicas, only the leader tracks and commits ("+strings.Join(names(electors), ", ")+"), with etcd they share -dir, with raft the leader replicates its acks to them")
+	etcdEndpoints := fs.String("etcd-endpoints", "", "comma separated etcd endpoints for -leader-election etcd")
+	raftBind := fs.String("raft-bind", "", "address raft listens on for -leader-election raft")
+	raftPeers := fs.String("raft-peers", "", "comma separated raft addresses of every replica, this one included")
+	electionKey := fs.String("election-key", "/offsets_test/serve", "key prefix the replicas campaign under")
+	electionTTL := fs.Duration("election-ttl", 10*time.Second, "how long leadership outlives a leader that has gone away")
+	fs.Parse(args)
+
+	if *numPartitions <= 0 {
+		return fmt.Errorf("-partitions must be positive")
+	}
+	if *commitInterval <= 0 || *snapshotInterval <= 0 {
+		return fmt.Errorf("-commit-interval and -snapshot-interval must be positive")
+	}
+	reset, err := parseResetPolicy(*offsetReset)
+	if err != nil {
+		return err
+	}
+	var seekTo time.Time
+	if *seekTime != "" {
+		if seekTo, err = parseSeekTime(*seekTime, time.Now()); err != nil {
+			return err
+		}
+	}
+	newBroker, ok := brokerAdapters[*brokerName]
+	if !ok {
+		return fmt.Errorf("unknown broker %q (available: %s)", *brokerName, strings.Join(names(brokerAdapters), ", "))
+	}
+	if *dir != "" {
+		if err := os.MkdirAll(*dir, 0o755); err != nil {
+			return err
+		}
+	}
+	var el elector
+	if *election != "" {
+		newElector, ok := electors[*election]
+		if !ok {
+			return fmt.Errorf("unknown leader election %q (available: %s)", *election, strings.Join(names(electors), ", "))
+		}
+		if *dir == "" {
+			return fmt.Errorf("-leader-election needs -dir")
+		}
+		host, _ := os.Hostname()
+		if el, err = newElector(electionOptions{
+			endpoints: *etcdEndpoints,
+			key:       *electionKey,
+			ttl:       *electionTTL,
+			id:        fmt.Sprintf("%s-%d", host, os.Getpid()),
+			bind:      *raftBind,
+			peers:     *raftPeers,
+			dir:       *dir,
+		}); err != nil {
+			return err
+		}
+		defer el.Close()
+	}
+
+	opts := &brokerOptions{
+		dir:       *dir,
+		kafka:     kafkaOptions{brokers: *kafkaBrokers, topic: *kafkaTopic, group: *kafkaGroup, timeout: 10 * time.Second},
+		zkServers: *zkServers,
+		conns:     make(map[string]interface{ Close() }),
+	}
+	defer opts.close()
+	health := NewHealth()
+	cfg := servedConfig{
+		commitInterval:   *commitInterval,
+		commitMaxAdvance: *commitMaxAdvance,
+		retry:            adapter.RetryPolicy{Attempts: *retries, Backoff: *backoff, MaxBackoff: time.Second},
+		snapshotInterval: *snapshotInterval,
+		reset:            reset,
+	}
+	var audit *auditLog
+	if *auditTopic != "" {
+		if audit, err = newAuditLog(*kafkaBrokers, *auditTopic); err != nil {
+			return err
+		}
+		// the partitions are closed by the time this runs, so their
+		// final commits make it into the trail
+		defer audit.close(*drainTimeout)
+	}
+	var events *cloudEmitter
+	if *cloudEvents != "" {
+		host, _ := os.Hostname()
+		if events, err = newCloudEmitter(*cloudEvents, "/offsets_test/serve/"+host); err != nil {
+			return err
+		}
+		defer events.close(*drainTimeout)
+	}
+	rep, _ := el.(replicator)
+	reg := NewRegistry(func(key TrackerKey) (*servedPartition, error) {
+		var broker brokerAdapter
+		var err error
+		inStage(stageAdapter, func() { broker, err = newBroker(opts, key.Partition) })
+		if err != nil {
+			return nil, err
+		}
+		var st store.Store
+		if *dir != "" {
+			st = store.File{Path: snapshotPath(*dir, key.Partition)}
+			if *lockSnapshots {
+				st = store.NewLocked(snapshotPath(*dir, key.Partition))
+			}
+		}
+		pcfg := cfg
+		var onCommit []func(old, new int64)
+		if audit != nil {
+			onCommit = append(onCommit, audit.committed(key))
+		}
+		if events != nil {
+			onCommit = append(onCommit, events.advanced(key.String()))
+		}
+		if len(onCommit) > 0 {
+			pcfg.onCommit = func(old, new int64) {
+				for _, fn := range onCommit {
+					fn(old, new)
+				}
+			}
+		}
+		if rep != nil {
+			st = rep.storeFor(key.Partition, st)
+			pcfg.replicate = rep.replicate
+		}
+		b, err := backend.New(*backendName, 0)
+		if err != nil {
+			return nil, err
+		}
+		return startPartition(key.Partition, b, broker, st, health, pcfg)
+	})
+	reg.OnEvent = printLifecycle
+	reg.IdleTTL, reg.Clock = *idleTTL, cfg.clock
+
+	// the APIs come up before the partitions, /readyz says when they
+	// are there
+	if err := listenAdmin(*admin, reg.Partitions, health, nil); err != nil {
+		return err
+	}
+	if *grpcAddr != "" {
+		if err := serveGRPC(*grpcAddr, reg.Partitions); err != nil {
+			return err
+		}
+	}
+	shutdown := shutdownOnSignal()
+	var lost <-chan struct{}
+	if el != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		stopTailing := make(chan struct{})
+		if rep == nil {
+			// with raft the state comes in through the log instead
+			go tailSnapshots(*dir, *numPartitions, *snapshotInterval, stopTailing)
+		}
+		fmt.Printf("standing by for leadership\n")
+		err := el.Campaign(ctx)
+		close(stopTailing)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				// shut down while standing by
+				return nil
+			}
+			return err
+		}
+		fmt.Printf("elected leader\n")
+		lost = el.Lost()
+	}
+
+	for id := int32(0); id < int32(*numPartitions); id++ {
+		if _, err := reg.Open(TrackerKey{Group: *kafkaGroup, Topic: *kafkaTopic, Partition: id}); err != nil {
+			reg.CloseAll(context.Background())
+			return err
+		}
+	}
+	if !seekTo.IsZero() {
+		if err := reg.SeekToTime(context.Background(), seekTo); err != nil {
+			reg.CloseAll(context.Background())
+			return err
+		}
+	}
+	health.SetReady(true)
+	dumpOnSignal(newStateDumper(reg.Partitions))
+	stopExpiring := make(chan struct{})
+	go reg.ExpireIdle(stopExpiring)
+	if events != nil && *stallAfter > 0 {
+		go events.watchStalls(reg.Partitions, *stallAfter, stopExpiring)
+	}
+
+	select {
+	case <-shutdown:
+	case <-lost:
+		// another replica may be leader already, so stop without
+		// committing anything more
+		health.SetReady(false)
+		close(stopExpiring)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		reg.CloseAll(ctx)
+		return fmt.Errorf("lost leadership")
+	}
+	health.SetReady(false)
+	close(stopExpiring)
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	reg.CloseAll(ctx)
+	if el != nil {
+		// let a standby take over right away rather than after the ttl
+		if err := el.Resign(ctx); err != nil {
+			fmt.Printf("resigning leadership: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// snapshotPath is where serve keeps the snapshot of a partition in dir
+func snapshotPath(dir string, partition int32) string {
+	return filepath.Join(dir, fmt.Sprintf("partition-%d.snapshot.json", partition))
+}
+
+// printLifecycle reports where each partition of serve resumed and stopped
+func printLifecycle(e LifecycleEvent) {
+	switch e.Kind {
+	case Opened:
+		fmt.Printf("%v resumed at watermark %v\n", e.Key, e.Committed)
+	case Closed, Expired:
+		if e.Err != nil {
+			fmt.Printf("%v: %v\n", e.Key, e.Err)
+		}
+		how := "stopped"
+		if e.Kind == Expired {
+			how = "expired idle"
+		}
+		fmt.Printf("%v %v at watermark %v with %v pending, broker at %v\n", e.Key, how, e.Committed, e.Pending, e.Broker)
+	}
+}
+
+// brokerAdapters build the broker a partition's watermark is committed to
+var brokerAdapters = map[string]func(opts *brokerOptions, partition int32) (brokerAdapter, error){
+	// memory forgets everything on exit, which is only any use with
+	// snapshots to resume from
+	"memory": func(opts *brokerOptions, partition int32) (brokerAdapter, error) {
+		return adapter.NewSimBroker(0, 0), nil
+	},
+	"file": func(opts *brokerOptions, partition int32) (brokerAdapter, error) {
+		if opts.dir == "" {
+			return nil, fmt.Errorf("the file broker needs -dir")
+		}
+		return openFileBroker(filepath.Join(opts.dir, fmt.Sprintf("partition-%d.committed.json", partition)))
+	},
+	"kafka": func(opts *brokerOptions, partition int32) (brokerAdapter, error) {
+		if opts.kafkaClient == nil {
+			cl, err := opts.kafka.client()
+			if err != nil {
+				return nil, err
+			}
+			opts.kafkaClient = cl
+		}
+		return newKafkaBroker(opts.kafkaClient, opts.kafka, partition)
+	},
+}
+
+// brokerOptions are serve's settings for the broker adapters, and what they
+// share between partitions
+type brokerOptions struct {
+	// dir is serve's -dir
+	dir   string
+	kafka kafkaOptions
+	// kafkaClient is opened by the first kafka broker
+	kafkaClient *kgo.Client
+	zkServers   string
+	// conns are what the adapters built in by tag open for the first
+	// partition and share with the others, keyed by adapter
+	conns map[string]interface{ Close() }
+}
+
+func (o *brokerOptions) close() {
+	if o.kafkaClient != nil {
+		o.kafkaClient.Close()
+	}
+	for _, conn := range o.conns {
+		conn.Close()
+	}
+}
+
+// brokerAdapter is a Broker that can say what was committed before, so a
+// partition resumes from it
+type brokerAdapter interface {
+	adapter.Broker
+	Committed() int64
+}
+
+// commitFence refuses commits for generations older than the newest it
+// has seen, for brokers that can't fence commits themselves.  Its zero
+// value is ready to use, it is safe to use from any goroutine.
+type commitFence struct {
+	// newest is accessed atomically
+	newest int64
+}
+
+// admit moves the fence on to generation, or returns a
+// *tracker.StaleGenerationError if it has seen a newer one
+func (f *commitFence) admit(generation int64) error {
+	for {
+		cur := atomic.LoadInt64(&f.newest)
+		if generation < cur {
+			return &tracker.StaleGenerationError{Generation: generation, Current: cur}
+		}
+		if atomic.CompareAndSwapInt64(&f.newest, cur, generation) {
+			return nil
+		}
+	}
+}
+
+// fileBroker commits to a file, standing in for a broker that keeps
+// offsets durably
+type fileBroker struct {
+	store store.File
+	// committed is accessed atomically
+	committed int64
+	fence     commitFence
+}
+
+func openFileBroker(path string) (*fileBroker, error) {
+	b := &fileBroker{store: store.File{Path: path}, committed: -1}
+	s, ok, err := b.store.Load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		b.committed = s.Committed
+	}
+	return b, nil
+}
+
+func (b *fileBroker) Commit(ctx context.Context, offset int64) error {
+	if err := b.store.Save(ctx, tracker.Snapshot{Committed: offset}); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&b.committed, offset)
+	return nil
+}
+
+// CommitGeneration implements adapter.GenerationBroker
+func (b *fileBroker) CommitGeneration(ctx context.Context, generation, offset int64) error {
+	if err := b.fence.admit(generation); err != nil {
+		return err
+	}
+	return b.Commit(ctx, offset)
+}
+
+func (b *fileBroker) Committed() int64 {
+	return atomic.LoadInt64(&b.committed)
+}
+
+// orReal returns c, or tracker.RealClock if c is nil
+func orReal(c tracker.Clock) tracker.Clock {
+	if c == nil {
+		return tracker.RealClock
+	}
+	return c
+}
+
+// afterFunc is time.AfterFunc on clock, f runs in its own goroutine
+func afterFunc(clock tracker.Clock, d time.Duration, f func()) {
+	t := clock.NewTimer(d)
+	go func() {
+		<-t.C()
+		f()
+	}()
+}
+
+type servedConfig struct {
+	commitInterval time.Duration
+	// commitMaxAdvance commits early once the watermark is this far past
+	// the last commit, see coalesce
+	commitMaxAdvance int64
+	retry            adapter.RetryPolicy
+	snapshotInterval time.Duration
+	// reset applies when there is no committed offset to resume from,
+	// or it is outside the log
+	reset ResetPolicy
+	// replicate, if set, has to accept every ack, seek and fence
+	// before the tracker takes it
+	replicate func(ctx context.Context, op raftOp) error
+	// onCommit is the committer's
+	onCommit func(old, new int64)
+	// clock runs the partition's commits, snapshots and pauses, nil is
+	// RealClock
+	clock tracker.Clock
+	// hooks are the tracker's
+	hooks tracker.Hooks
+}
+
+// servedPartition is one partition of serve: a tracker fed by the acks that
+// come in over the APIs, with its watermark committed to a broker and its
+// snapshot persisted to a store
+type servedPartition struct {
+	id      int32
+	tracker *tracker.Tracker
+	broker  brokerAdapter
+	// store is nil when nothing is persisted
+	store     store.Store
+	health    *Health
+	replicate func(ctx context.Context, op raftOp) error
+	clock     tracker.Clock
+	acks      chan int64
+	// closing is closed once Close has started, Ack holds closeMu for
+	// reading while it sends so that Close can wait out the acks
+	// already on their way
+	closing chan struct{}
+	closeMu sync.RWMutex
+	// acked is when the last ack came in, in unix nanoseconds, it is
+	// accessed atomically
+	acked int64
+	*pauser
+	cmt *adapter.Committer
+	// calls are run by the ack loop, which is the only goroutine that
+	// may touch the tracker
+	calls chan func()
+	// stopped is done once stop tears the partition down
+	stopped context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// startPartition restores a partition from store and broker, whichever is
+// further along, and starts tracking it
+func startPartition(id int32, b backend.Backend, broker brokerAdapter, store store.Store, health *Health, cfg servedConfig) (*servedPartition, error) {
+	snap := tracker.Snapshot{Committed: -1}
+	if store != nil {
+		s, ok, err := store.Load(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			snap = s
+		}
+	}
+	if broker.Committed() > snap.Committed {
+		snap.Committed = broker.Committed()
+	}
+	w, why, err := cfg.reset.resolve(snap.Committed, broker)
+	if err != nil {
+		return nil, err
+	}
+	if why != "" && w != snap.Committed {
+		// whatever was pending belongs to a log that isn't there
+		fmt.Printf("partition %v: %v, resetting to %v (%v)\n", id, why, w+1, cfg.reset)
+		snap = tracker.Snapshot{Committed: w}
+	}
+	clock := orReal(cfg.clock)
+	p := &servedPartition{
+		id:        id,
+		tracker:   tracker.Restore(b, snap),
+		broker:    broker,
+		store:     store,
+		health:    health,
+		replicate: cfg.replicate,
+		clock:     clock,
+		acks:      make(chan int64, 4096),
+		closing:   make(chan struct{}),
+		pauser:    newPauser(),
+		calls:     make(chan func()),
+	}
+	if g, ok := store.(generationStore); ok {
+		// a replica taking over stays fenced off from the zombies its
+		// predecessor was
+		if err := p.tracker.Fence(g.generation()); err != nil {
+			return nil, err
+		}
+	}
+	p.acked = p.clock.Now().UnixNano()
+	p.pauser.clock = p.clock
+	p.tracker.SetClock(p.clock)
+	p.tracker.Hooks = cfg.hooks
+	p.stopped, p.stop = context.WithCancel(context.Background())
+	p.cmt = adapter.NewCommitter(p.tracker, broker, cfg.commitInterval)
+	p.cmt.Retry, p.cmt.Paused, p.cmt.OnCommit, p.cmt.Clock = cfg.retry, p.isPaused, cfg.onCommit, p.clock
+	p.cmt.Report = func(err error) { health.Set(checkCommit, err) }
+	// a snapshot or a reset may be ahead of the broker
+	p.cmt.Last = broker.Committed()
+	if cfg.commitMaxAdvance > 0 {
+		coalesce(p.tracker, p.cmt, cfg.commitMaxAdvance)
+	}
+	if p.replicate != nil {
+		// the replicas start the partition where this one did
+		op := raftOp{Kind: opRestore, Partition: id, Snapshot: &snap, Generation: p.tracker.Generation()}
+		if err := p.replicate(context.Background(), op); err != nil {
+			return nil, err
+		}
+	}
+	p.wg.Add(2)
+	go inStage(stageTracker, func() {
+		defer p.wg.Done()
+		p.ackLoop(cfg.snapshotInterval)
+	})
+	go inStage(stageCommitter, func() {
+		defer p.wg.Done()
+		p.cmt.Run(p.stopped)
+	})
+	return p, nil
+}
+
+// coalesce has c commit t's watermark early once it is maxAdvance past the
+// last commit, as well as every interval, on top of whatever OnAdvance t
+// already calls
+func coalesce(t *tracker.Tracker, c *adapter.Committer, maxAdvance int64) {
+	c.MaxAdvance = maxAdvance
+	onAdvance := t.Hooks.OnAdvance
+	if onAdvance == nil {
+		// a look every tenth of the way keeps the overshoot small
+		t.Hooks.AdvanceBy = maxAdvance / 10
+	}
+	t.Hooks.OnAdvance = func(from, to int64) {
+		if onAdvance != nil {
+			onAdvance(from, to)
+		}
+		c.Advanced(from, to)
+	}
+}
+
+func (p *servedPartition) ackLoop(snapshotInterval time.Duration) {
+	var snapshots <-chan time.Time
+	if p.store != nil {
+		snapshots = p.clock.NewTimer(snapshotInterval).C()
+	}
+	var stalls <-chan time.Time
+	if d := p.tracker.Hooks.StallAfter; d > 0 && p.tracker.Hooks.OnStall != nil {
+		ticker := p.clock.NewTicker(d/4 + time.Millisecond)
+		defer ticker.Stop()
+		stalls = ticker.C()
+	}
+	for {
+		// while paused acks stay in the channel
+		acks := p.acks
+		if p.isPaused() {
+			acks = nil
+		}
+		select {
+		case <-p.stopped.Done():
+			return
+		case <-p.wake:
+		case fn := <-p.calls:
+			fn()
+		case offset := <-acks:
+			// without a budget or in-flight limit the tracker never
+			// refuses an ack
+			p.tracker.Ack(offset)
+		case <-snapshots:
+			inStage(stageFlusher, func() { p.health.Set(checkPersist, p.store.Save(p.stopped, p.tracker.Snapshot())) })
+			// from the end of the save, like the bench
+			snapshots = p.clock.NewTimer(snapshotInterval).C()
+		case now := <-stalls:
+			if !p.isPaused() {
+				p.tracker.CheckStall(now)
+			}
+		}
+	}
+}
+
+// Close stops taking acks, feeds the tracker every ack still in the
+// channel, commits the watermark, saves the snapshot and tears the
+// partition down.  It returns how many offsets were left pending.  If ctx
+// is done first the partition is torn down without waiting for the rest,
+// and Close returns ctx's error.
+func (p *servedPartition) Close(ctx context.Context) (int, error) {
+	select {
+	case <-p.closing:
+		return 0, adapter.ErrClosed
+	default:
+	}
+	close(p.closing)
+	// wait out the acks that made it past the check in Ack, after
+	// this nothing more goes into the channel
+	p.closeMu.Lock()
+	p.closeMu.Unlock()
+
+	var snap tracker.Snapshot
+	var pending int
+	drained := make(chan error, 1)
+	go func() {
+		drained <- p.call(ctx, func() {
+		drain:
+			for {
+				select {
+				case offset := <-p.acks:
+					p.tracker.Ack(offset)
+				default:
+					break drain
+				}
+			}
+			snap = p.tracker.Snapshot()
+			pending = p.tracker.Pending()
+		})
+	}()
+	var err error
+	select {
+	case err = <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = p.cmt.Flush(ctx, p.stopped)
+		if p.store != nil {
+			if serr := p.store.Save(ctx, snap); err == nil {
+				err = serr
+			}
+		}
+	}
+	p.stop()
+	p.wg.Wait()
+	return pending, err
+}
+
+// call runs fn on the ack loop and waits for it.  ctx only bounds the
+// wait for the loop to take fn, once it has fn runs to the end.
+func (p *servedPartition) call(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case p.calls <- func() { fn(); close(done) }:
+	case <-p.stopped.Done():
+		return adapter.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+// Status implements Partition
+func (p *servedPartition) Status(ctx context.Context) (PartitionStatus, error) {
+	var s PartitionStatus
+	err := p.call(ctx, func() {
+		snap := p.tracker.Snapshot()
+		s.Committed = snap.Committed
+		s.Pending = p.tracker.Pending()
+		s.Gaps, s.GapCount = snap.Gaps(maxAdminGaps)
+	})
+	s.Paused = p.isPaused()
+	return s, err
+}
+
+// Committed implements Partition
+func (p *servedPartition) Committed() int64 {
+	return p.tracker.Committed()
+}
+
+// Ack implements Partition, it blocks while the ack channel is full until
+// ctx is done
+func (p *servedPartition) Ack(ctx context.Context, offset int64) error {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	select {
+	case <-p.closing:
+		return adapter.ErrClosed
+	default:
+	}
+	atomic.StoreInt64(&p.acked, p.clock.Now().UnixNano())
+	if p.replicate != nil {
+		if err := p.replicate(ctx, raftOp{Kind: opAck, Partition: p.id, Offset: offset}); err != nil {
+			return err
+		}
+	}
+	select {
+	case p.acks <- offset:
+		return nil
+	case <-p.closing:
+		return adapter.ErrClosed
+	case <-p.stopped.Done():
+		return adapter.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Fence moves the partition on to generation, see Tracker.Fence.  A newer
+// generation is replicated first, like an ack.
+func (p *servedPartition) Fence(ctx context.Context, generation int64) error {
+	if p.replicate != nil && generation > p.tracker.Generation() {
+		if err := p.replicate(ctx, raftOp{Kind: opFence, Partition: p.id, Generation: generation}); err != nil {
+			return err
+		}
+	}
+	return p.tracker.Fence(generation)
+}
+
+// AckGeneration is Ack for an offset processed under generation.  The
+// generation is checked as the ack is queued: one fenced off by then is
+// refused, one queued before a newer generation comes along still counts.
+func (p *servedPartition) AckGeneration(ctx context.Context, generation, offset int64) error {
+	if err := p.Fence(ctx, generation); err != nil {
+		return err
+	}
+	return p.Ack(ctx, offset)
+}
+
+// lastAck returns when the last ack came in, or when the partition started
+// if none has
+func (p *servedPartition) lastAck() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&p.acked))
+}
+
+// SeekTo implements Partition
+func (p *servedPartition) SeekTo(ctx context.Context, committed int64) error {
+	if p.replicate != nil {
+		if err := p.replicate(ctx, raftOp{Kind: opSeek, Partition: p.id, Offset: committed}); err != nil {
+			return err
+		}
+	}
+	return p.call(ctx, func() { p.tracker.SeekTo(committed) })
+}
+
+// Oldest implements Partition, serve doesn't know when offsets were handed
+// out or to whom
+func (p *servedPartition) Oldest(ctx context.Context, n int) ([]Outstanding, error) {
+	var offsets []int64
+	if err := p.call(ctx, func() { offsets = oldestOffsets(p.tracker.Snapshot(), n) }); err != nil {
+		return nil, err
+	}
+	out := make([]Outstanding, len(offsets))
+	for i, o := range offsets {
+		out[i] = Outstanding{Offset: o}
+	}
+	return out, nil
+}
+
+// Flush implements Partition.  The snapshot is saved after the commit so
+// that it is never behind the broker, and on the ack loop like the
+// periodic ones so that an older one can't land after it.
+func (p *servedPartition) Flush(ctx context.Context) error {
+	if err := p.cmt.Flush(ctx, p.stopped); err != nil {
+		return err
+	}
+	if p.store == nil {
+		return nil
+	}
+	var err error
+	if cerr := p.call(ctx, func() {
+		inStage(stageFlusher, func() { err = p.store.Save(ctx, p.tracker.Snapshot()) })
+		p.health.Set(checkPersist, err)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ideasculptor/offsets_test/store"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// shutdownOnSignal returns a channel that is closed on the first SIGINT or
+// SIGTERM.  A second signal kills the process as usual, for a shutdown that
+// hangs.
+func shutdownOnSignal() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		fmt.Printf("got %v, shutting down\n", sig)
+		close(stop)
+	}()
+	return stop
+}
+
+// shutdown stops the live consumer taking acks, commits its watermark and
+// persists its snapshot, so that a consumer started later picks up where
+// this one stopped.  Acks still in the buffer are left there, as they
+// would be lost with the process.
+func (r *benchRun) shutdown() error {
+	c := r.cur
+	c.Pause()
+	var snap tracker.Snapshot
+	var pending int
+	if err := c.call(context.Background(), func() {
+		snap = c.tracker.Snapshot()
+		pending = c.tracker.Pending()
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("stopped ingesting acks at watermark %v with %v pending\n", snap.Committed, pending)
+	if c.cmt != nil {
+		if err := c.cmt.Flush(context.Background(), c.stopped); err != nil {
+			// the snapshot still has it, and the broker has what it had
+			fmt.Printf("final commit failed: %v\n", err)
+		} else {
+			fmt.Printf("committed watermark %v to the broker\n", r.broker.Committed())
+		}
+	}
+	// a temporary store is removed at the end of the run, there's no
+	// point saving to it
+	path := r.cfg.restart.storePath
+	if path == "" {
+		fmt.Printf("no -snapshot-file, the snapshot isn't persisted\n")
+		return nil
+	}
+	if err := (store.File{Path: path}).Save(context.Background(), snap); err != nil {
+		return err
+	}
+	fmt.Printf("saved snapshot to %v\n", path)
+	return nil
+}
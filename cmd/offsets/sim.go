@@ -0,0 +1,96 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// simResult is what simulate measured
+type simResult struct {
+	// committed is the watermark every tick, the last is the final one
+	committed []int64
+	// elapsed is the simulated time the run took
+	elapsed      time.Duration
+	longestStall time.Duration
+	expired      int64
+	gapsSkipped  int64
+}
+
+// simulate runs cfg's workload through t without waiting for anything: a
+// single timing wheel stands in for the workers, and clock is moved on a
+// wheel tick at a time with the acks due in each tick delivered in offset
+// order.  The same cfg always gives the same result, and a million
+// messages take a fraction of a second, which makes it fit for unit
+// tests.  Deadlines, MaxStall and StallAfter are checked every tick and
+// the watermark sampled every cfg.tick.  The run ends once every offset is
+// committed, or once nothing is left that could move the watermark.  t
+// must not have acked anything yet.
+func simulate(cfg benchConfig, t *tracker.Tracker, clock *tracker.FakeClock) (simResult, error) {
+	var res simResult
+	delay, err := cfg.delayFunc()
+	if err != nil {
+		return res, err
+	}
+	t.SetClock(clock)
+	onExpire, onGapSkip := t.OnExpire, t.OnGapSkip
+	t.OnExpire = func(offset int64, stuck time.Duration) {
+		res.expired++
+		if onExpire != nil {
+			onExpire(offset, stuck)
+		}
+	}
+	t.OnGapSkip = func(gap tracker.Range) {
+		res.gapsSkipped++
+		if onGapSkip != nil {
+			onGapSkip(gap)
+		}
+	}
+
+	w := &timerWheel{}
+	for offset := int64(0); offset < cfg.numMsgs; offset++ {
+		// round up so nothing completes early, like wheelWorkers
+		d := delay(offset)
+		w.schedule(offset, int64((d+wheelResolution-1)/wheelResolution))
+	}
+	ack := func(offset int64) {
+		// there's no one to hold a refused ack for, so the tracker
+		// mustn't have a Budget or MaxInFlight
+		t.Ack(offset)
+	}
+	tick := cfg.tick
+	if tick <= 0 {
+		tick = 250 * time.Millisecond
+	}
+	var moved, sampled time.Duration
+	last := t.Committed()
+	for ticks := int64(1); t.Committed() < cfg.numMsgs-1; ticks++ {
+		clock.Advance(wheelResolution)
+		w.advance(ticks, ack)
+		now := clock.Now()
+		if t.Deadline > 0 {
+			if _, err := t.Expire(now); err != nil {
+				return res, err
+			}
+		}
+		t.SkipStalled(now)
+		t.CheckStall(now)
+
+		res.elapsed = time.Duration(ticks) * wheelResolution
+		if c := t.Committed(); c != last {
+			last, moved = c, res.elapsed
+		} else if stall := res.elapsed - moved; stall > res.longestStall {
+			res.longestStall = stall
+		}
+		if res.elapsed-sampled >= tick {
+			res.committed = append(res.committed, last)
+			sampled = res.elapsed
+		}
+		if w.len() == 0 && t.Pending() == 0 {
+			// nothing left that could move the watermark
+			break
+		}
+	}
+	res.committed = append(res.committed, t.Committed())
+	return res, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+func simConfig() benchConfig {
+	return benchConfig{
+		numMsgs:      1000000,
+		maxDelay:     time.Second,
+		distribution: "exponential",
+		seed:         42,
+		tick:         100 * time.Millisecond,
+	}
+}
+
+func runSim(t *testing.T, name string, cfg benchConfig, setup func(*tracker.Tracker)) simResult {
+	t.Helper()
+	b, _ := backend.New(name, 0)
+	tr := tracker.New(b, -1)
+	if setup != nil {
+		setup(tr)
+	}
+	res, err := simulate(cfg, tr, tracker.NewFakeClock(time.Unix(0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// TestSimulation runs a million messages through every backend on a fake
+// clock and checks that the same seed gives the same run
+func TestSimulation(t *testing.T) {
+	cfg := simConfig()
+	var first simResult
+	for i, name := range append(backend.Names(), backend.Names()[0]) {
+		res := runSim(t, name, cfg, nil)
+		if got := res.committed[len(res.committed)-1]; got != cfg.numMsgs-1 {
+			t.Fatalf("%s: final watermark %d, want %d", name, got, cfg.numMsgs-1)
+		}
+		if res.elapsed > cfg.maxDelay+wheelResolution {
+			t.Errorf("%s: took %v of simulated time, want at most %v", name, res.elapsed, cfg.maxDelay)
+		}
+		if i == 0 {
+			first = res
+		} else if !reflect.DeepEqual(res, first) {
+			t.Errorf("%s: %+v differs from %+v", name, res, first)
+		}
+	}
+}
+
+// TestSimulatedDeadline checks that a stuck offset is given up on right at
+// its deadline, only a fake clock can pin that down
+func TestSimulatedDeadline(t *testing.T) {
+	cfg := simConfig()
+	cfg.numMsgs = 10000
+	cfg.hol = holConfig{offset: 10, delay: time.Hour}
+	res := runSim(t, "map", cfg, func(tr *tracker.Tracker) {
+		tr.Deadline = 2 * time.Second
+		tr.StuckPolicy = tracker.StuckSkip
+	})
+	if res.expired != 1 {
+		t.Errorf("%d offsets expired, want 1", res.expired)
+	}
+	if got := res.committed[len(res.committed)-1]; got != cfg.numMsgs-1 {
+		t.Errorf("final watermark %d, want %d", got, cfg.numMsgs-1)
+	}
+	// stuck from the moment offset 9 was acked until the deadline
+	if res.longestStall < 2*time.Second-cfg.maxDelay || res.longestStall > 2*time.Second {
+		t.Errorf("longest stall %v, want a little under the 2s deadline", res.longestStall)
+	}
+}
+
+// TestSimulatedHooks runs a stuck offset past the hooks: the stall is
+// reported once, advances come in steps of at least AdvanceBy and the gaps
+// opened never overlap
+func TestSimulatedHooks(t *testing.T) {
+	cfg := simConfig()
+	cfg.numMsgs = 10000
+	cfg.hol = holConfig{offset: 10, delay: 3 * time.Second}
+	var stalls []int64
+	var advances [][2]int64
+	var gaps []tracker.Range
+	runSim(t, "map", cfg, func(tr *tracker.Tracker) {
+		tr.Hooks = tracker.Hooks{
+			AdvanceBy:     1000,
+			OnAdvance:     func(from, to int64) { advances = append(advances, [2]int64{from, to}) },
+			OnGapDetected: func(gap tracker.Range) { gaps = append(gaps, gap) },
+			StallAfter:    time.Second,
+			OnStall:       func(committed int64, pending int, stuck time.Duration) { stalls = append(stalls, committed) },
+		}
+	})
+	if !reflect.DeepEqual(stalls, []int64{9}) {
+		t.Errorf("stalls at %v, want just the one at 9", stalls)
+	}
+	from := int64(-1)
+	for _, a := range advances {
+		if a[0] != from || a[1]-a[0] < 1000 {
+			t.Errorf("advanced %v after %d, want steps of 1000 or more", a, from)
+		}
+		from = a[1]
+	}
+	if cfg.numMsgs-1-from >= 1000 {
+		t.Errorf("last advance to %d, more than 1000 short of the end", from)
+	}
+	last := int64(-1)
+	for _, g := range gaps {
+		if g.From <= last || g.To < g.From {
+			t.Fatalf("gap %v after %d", g, last)
+		}
+		last = g.To
+	}
+	if len(gaps) == 0 || gaps[0].From > 10 {
+		t.Errorf("gaps %v, want one over offset 10", gaps)
+	}
+}
+
+// TestCooperativeRebalance has consumers join and leave while the
+// partitions are being worked on.  Only the partitions that have to move
+// may move, and no partition, nor all of them together, may ever commit
+// less than before.
+func TestCooperativeRebalance(t *testing.T) {
+	// a third consumer takes one partition from each of the others
+	owners := []int{0, 0, 0, 1, 1, 1}
+	next := stickyAssign(owners, []int{0, 1, 2})
+	moved := 0
+	for p, m := range next {
+		if m != owners[p] {
+			moved++
+			if m != 2 {
+				t.Errorf("partition %d went to consumer %d, not the new one", p, m)
+			}
+		}
+	}
+	if moved != 2 {
+		t.Errorf("%d partitions moved to the new consumer, want 2: %v", moved, next)
+	}
+
+	cfg := simConfig()
+	cfg.numMsgs = 20000
+	cfg.maxDelay = 500 * time.Millisecond
+	rc := rebalanceConfig{
+		partitions: 6,
+		members:    2,
+		changes: []membershipChange{
+			{at: 100 * time.Millisecond, delta: 1},
+			{at: 200 * time.Millisecond, delta: 2},
+			// one leaves while the last handoffs are still going on
+			{at: 220 * time.Millisecond, delta: -1},
+			{at: 400 * time.Millisecond, delta: -3},
+		},
+		handoff: 50 * time.Millisecond,
+	}
+	res, err := simulateRebalance(cfg, rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, c := range res.committed {
+		if c != cfg.numMsgs-1 {
+			t.Errorf("partition %d committed %d, want %d", p, c, cfg.numMsgs-1)
+		}
+	}
+	if res.rebalances != 4 || res.moved == 0 || res.kept == 0 {
+		t.Errorf("%d rebalances moved %d partitions and kept %d, want 4 moving some and keeping some", res.rebalances, res.moved, res.kept)
+	}
+	if res.redelivered == 0 {
+		t.Error("nothing was redelivered, the handoffs can't have happened mid-partition")
+	}
+	if again, _ := simulateRebalance(cfg, rc); !reflect.DeepEqual(again, res) {
+		t.Error("the same rebalance came out differently")
+	}
+}
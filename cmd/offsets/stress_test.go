@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/ideasculptor/offsets_test/adapter"
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/store"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// TestPartitionStress hammers a served partition of every backend from
+// hundreds of goroutines at once, then closes it while they are still at
+// it.  It's meant for go test -race, which turns any unsynchronized access
+// to the tracker into a failure, but it also catches deadlocks and
+// watermarks that come out wrong.
+func TestPartitionStress(t *testing.T) {
+	const ackers, pokers, n = 100, 100, 2000
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			store := store.File{Path: filepath.Join(t.TempDir(), "snapshot.json")}
+			p, err := startPartition(0, b, adapter.NewSimBroker(0, 0), store, NewHealth(), servedConfig{
+				commitInterval:   time.Millisecond,
+				retry:            adapter.RetryPolicy{Attempts: 1},
+				snapshotInterval: 10 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var acking, poking sync.WaitGroup
+			acking.Add(ackers)
+			for i := 0; i < ackers; i++ {
+				go func(i int) {
+					defer acking.Done()
+					// every offset once, interleaved between ackers
+					for o := int64(i); o < n; o += ackers {
+						p.Ack(context.Background(), o)
+					}
+				}(i)
+			}
+			stop := make(chan struct{})
+			poking.Add(pokers)
+			for i := 0; i < pokers; i++ {
+				go func(i int) {
+					defer poking.Done()
+					for j := 0; ; j++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						var err error
+						switch (i + j) % 6 {
+						case 0:
+							_, err = p.Status(context.Background())
+						case 1:
+							p.Committed()
+						case 2:
+							_, err = p.Oldest(context.Background(), 10)
+						case 3:
+							err = p.Flush(context.Background())
+						case 4:
+							p.Pause()
+							p.Resume()
+						case 5:
+							// a redelivery
+							err = p.Ack(context.Background(), int64(j%n))
+						}
+						if err != nil && !errors.Is(err, adapter.ErrClosed) {
+							t.Errorf("poker %d: %v", i, err)
+							return
+						}
+					}
+				}(i)
+			}
+
+			acking.Wait()
+			deadline := time.Now().Add(10 * time.Second)
+			for p.Committed() != n-1 {
+				if time.Now().After(deadline) {
+					t.Fatalf("watermark stuck at %d, want %d", p.Committed(), n-1)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			// close with the pokers still going, everything they do
+			// after that must fail with ErrClosed rather than hang
+			if _, err := p.Close(context.Background()); err != nil && !errors.Is(err, adapter.ErrClosed) {
+				t.Fatal(err)
+			}
+			close(stop)
+			poking.Wait()
+			if got := p.broker.Committed(); got != n-1 {
+				t.Errorf("broker has %d, want %d", got, n-1)
+			}
+			snap, ok, err := store.Load(context.Background())
+			if err != nil || !ok || snap.Committed != n-1 {
+				t.Errorf("snapshot %+v (%v, %v), want committed %d", snap, ok, err, n-1)
+			}
+		})
+	}
+}
+
+// TestCloseDrainsAcks closes a paused partition with acks still waiting in
+// its channel, they must all make it into the final commit and snapshot
+func TestCloseDrainsAcks(t *testing.T) {
+	store := store.File{Path: filepath.Join(t.TempDir(), "snapshot.json")}
+	var commits [][2]int64
+	p, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(0, 0), store, NewHealth(), servedConfig{
+		commitInterval:   time.Hour,
+		retry:            adapter.RetryPolicy{Attempts: 1},
+		snapshotInterval: time.Hour,
+		onCommit:         func(old, new int64) { commits = append(commits, [2]int64{old, new}) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Pause()
+	for o := int64(99); o >= 0; o-- {
+		if err := p.Ack(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := p.Close(context.Background())
+	if err != nil || pending != 0 {
+		t.Fatalf("Close = %d, %v, want nothing pending", pending, err)
+	}
+	if got := p.broker.Committed(); got != 99 {
+		t.Errorf("broker has %d, want 99", got)
+	}
+	if want := [][2]int64{{-1, 99}}; !reflect.DeepEqual(commits, want) {
+		t.Errorf("commits seen %v, want %v", commits, want)
+	}
+	if snap, _, _ := store.Load(context.Background()); snap.Committed != 99 {
+		t.Errorf("snapshot has %d, want 99", snap.Committed)
+	}
+	if err := p.Ack(context.Background(), 100); !errors.Is(err, adapter.ErrClosed) {
+		t.Errorf("ack after Close = %v, want ErrClosed", err)
+	}
+}
+
+// TestFlushPersists flushes a partition long before its intervals are up,
+// the broker and the store must both have the watermark once Flush returns
+func TestFlushPersists(t *testing.T) {
+	store := store.File{Path: filepath.Join(t.TempDir(), "snapshot.json")}
+	p, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(0, 0), store, NewHealth(), servedConfig{
+		commitInterval:   time.Hour,
+		retry:            adapter.RetryPolicy{Attempts: 1},
+		snapshotInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(context.Background())
+	for o := int64(0); o < 10; o++ {
+		if err := p.Ack(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s, _ := p.Status(context.Background()); s.Committed != 9; s, _ = p.Status(context.Background()) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.broker.Committed(); got != 9 {
+		t.Errorf("broker has %d, want 9", got)
+	}
+	if snap, _, _ := store.Load(context.Background()); snap.Committed != 9 {
+		t.Errorf("snapshot has %d, want 9", snap.Committed)
+	}
+}
+
+// TestCancelledFlush gives up on a broker that takes forever once the
+// caller's context is done, and the partition still closes
+func TestCancelledFlush(t *testing.T) {
+	p, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(time.Hour, 0), nil, NewHealth(), servedConfig{
+		commitInterval: time.Hour,
+		retry:          adapter.RetryPolicy{Attempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Ack(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	for s, _ := p.Status(context.Background()); s.Committed != 0; s, _ = p.Status(context.Background()) {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush = %v, want the deadline", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close = %v, want the deadline", err)
+	}
+	if got := p.broker.Committed(); got != -1 {
+		t.Errorf("broker has %d, the commit should have been given up", got)
+	}
+}
+
+// TestReplicatedPartition feeds a served partition's acks through a raft
+// FSM, as the leader does, and starts another partition from the FSM, as a
+// replica taking over does: it has to have the whole pending set
+func TestReplicatedPartition(t *testing.T) {
+	fsm := newTrackerFSM()
+	replicate := func(ctx context.Context, op raftOp) error {
+		data, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if err, ok := fsm.Apply(&raft.Log{Data: data}).(error); ok {
+			return err
+		}
+		return nil
+	}
+	cfg := servedConfig{
+		commitInterval:   time.Hour,
+		retry:            adapter.RetryPolicy{Attempts: 1},
+		snapshotInterval: time.Hour,
+		replicate:        replicate,
+	}
+	store := raftStore{fsm: fsm, partition: 0}
+	p, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(0, 0), store, NewHealth(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []int64{0, 1, 2, 5, 6, 9} {
+		if err := p.Ack(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := p.tracker.Snapshot()
+
+	next, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(0, 0), store, NewHealth(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close(context.Background())
+	var got tracker.Snapshot
+	next.call(context.Background(), func() { got = next.tracker.Snapshot() })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("took over with %+v, want %+v", got, want)
+	}
+}
+
+// TestReplicatedFence fails over a partition after a Fence, the replica
+// taking over must still refuse the old generation, also once it restores
+// from a Raft snapshot
+func TestReplicatedFence(t *testing.T) {
+	fsm := newTrackerFSM()
+	replicate := func(ctx context.Context, op raftOp) error {
+		data, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if err, ok := fsm.Apply(&raft.Log{Data: data}).(error); ok {
+			return err
+		}
+		return nil
+	}
+	cfg := servedConfig{
+		commitInterval:   time.Hour,
+		retry:            adapter.RetryPolicy{Attempts: 1},
+		snapshotInterval: time.Hour,
+		replicate:        replicate,
+	}
+	ctx := context.Background()
+	p, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(0, 0), raftStore{fsm: fsm, partition: 0}, NewHealth(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AckGeneration(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Fence(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a Raft snapshot keeps the generation too
+	snap, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTrackerFSM()
+	if err := restored.Restore(io.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	for name, fsm := range map[string]*trackerFSM{"log": fsm, "snapshot": restored} {
+		next, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(0, 0), raftStore{fsm: fsm, partition: 0}, NewHealth(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := next.tracker.Generation(); got != 3 {
+			t.Errorf("%s: took over at generation %d, want 3", name, got)
+		}
+		if err := next.AckGeneration(ctx, 1, 1); !errors.Is(err, tracker.ErrStaleGeneration) {
+			t.Errorf("%s: zombie ack = %v, want ErrStaleGeneration", name, err)
+		}
+		next.Close(ctx)
+	}
+}
+
+// TestCloudEvents sends a served partition's commits to an HTTP endpoint
+// as cloudevents
+func TestCloudEvents(t *testing.T) {
+	type event struct {
+		header http.Header
+		data   advancedData
+		err    error
+	}
+	got := make(chan event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e := event{header: req.Header}
+		e.err = json.NewDecoder(req.Body).Decode(&e.data)
+		got <- e
+	}))
+	defer srv.Close()
+	events, err := newCloudEmitter(srv.URL, "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := startPartition(0, backend.NewMap(0), adapter.NewSimBroker(0, 0), nil, NewHealth(), servedConfig{
+		commitInterval:   time.Hour,
+		retry:            adapter.RetryPolicy{Attempts: 1},
+		snapshotInterval: time.Hour,
+		onCommit:         events.advanced("partition 0"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for o := int64(0); o < 10; o++ {
+		p.Ack(context.Background(), o)
+	}
+	if _, err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events.close(time.Second)
+
+	e := <-got
+	if h := e.header; h.Get("ce-specversion") != "1.0" || h.Get("ce-type") != eventAdvanced || h.Get("ce-source") != "/test" || h.Get("ce-subject") != "partition 0" {
+		t.Errorf("headers %v aren't those of the advanced event", h)
+	}
+	if e.err != nil || e.data != (advancedData{Old: -1, New: 9}) {
+		t.Errorf("data %+v, %v, want -1 to 9", e.data, e.err)
+	}
+}
+
+// TestServedGeneration fences off a zombie's acks and commits once the
+// partition has moved on to a newer generation
+func TestServedGeneration(t *testing.T) {
+	broker := adapter.NewSimBroker(0, 0)
+	p, err := startPartition(0, backend.NewMap(0), broker, nil, NewHealth(), servedConfig{
+		commitInterval:   time.Hour,
+		retry:            adapter.RetryPolicy{Attempts: 1},
+		snapshotInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := p.AckGeneration(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Fence(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AckGeneration(ctx, 1, 1); !errors.Is(err, tracker.ErrStaleGeneration) {
+		t.Errorf("zombie ack = %v, want ErrStaleGeneration", err)
+	}
+	for p.Committed() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := broker.Committed(); got != 0 {
+		t.Errorf("broker committed = %d, want 0", got)
+	}
+	// the broker has seen generation 2 now, a zombie partition still on
+	// the first one can't commit over it
+	zombie, err := startPartition(0, backend.NewMap(0), broker, nil, NewHealth(), servedConfig{
+		commitInterval:   time.Hour,
+		retry:            adapter.RetryPolicy{Attempts: 1},
+		snapshotInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := zombie.AckGeneration(ctx, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for zombie.Committed() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := zombie.Flush(ctx); !errors.Is(err, tracker.ErrStaleGeneration) {
+		t.Errorf("zombie flush = %v, want ErrStaleGeneration", err)
+	}
+	for _, p := range []*servedPartition{p, zombie} {
+		p.Close(ctx)
+	}
+}
+
+// TestExpireIdle closes a partition that goes IdleTTL without an ack on
+// the registry's clock
+func TestExpireIdle(t *testing.T) {
+	clock := tracker.NewFakeClock(time.Unix(0, 0))
+	reg := NewRegistry(func(key TrackerKey) (*servedPartition, error) {
+		return startPartition(key.Partition, backend.NewMap(0), adapter.NewSimBroker(0, 0), nil, NewHealth(), servedConfig{
+			commitInterval:   time.Hour,
+			retry:            adapter.RetryPolicy{Attempts: 1},
+			snapshotInterval: time.Hour,
+			clock:            clock,
+		})
+	})
+	expired := make(chan TrackerKey, 1)
+	reg.OnEvent = func(e LifecycleEvent) {
+		if e.Kind == Expired {
+			expired <- e.Key
+		}
+	}
+	reg.IdleTTL, reg.Clock = time.Minute, clock
+	key := TrackerKey{Partition: 3}
+	if _, err := reg.Open(key); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reg.ExpireIdle(stop)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	// the committer's ticker, then the registry's
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	if got := <-expired; got != key {
+		t.Errorf("expired %v, want %v", got, key)
+	}
+	if _, ok := reg.Get(key); ok {
+		t.Error("the expired partition is still tracked")
+	}
+}
+
+// TestAdminTopics tells apart partitions with the same number of different
+// topics
+func TestAdminTopics(t *testing.T) {
+	reg := NewRegistry(func(key TrackerKey) (*servedPartition, error) {
+		return startPartition(key.Partition, backend.NewMap(0), adapter.NewSimBroker(0, 0), nil, NewHealth(), servedConfig{
+			commitInterval:   time.Hour,
+			retry:            adapter.RetryPolicy{Attempts: 1},
+			snapshotInterval: time.Hour,
+		})
+	})
+	defer reg.CloseAll(context.Background())
+	for _, topic := range []string{"a", "b"} {
+		if _, err := reg.Open(TrackerKey{Topic: topic}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(NewAdminHandler(reg.Partitions))
+	defer srv.Close()
+	get := func(path string) *http.Response {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("/partitions")
+	var statuses []PartitionStatus
+	err := json.NewDecoder(resp.Body).Decode(&statuses)
+	resp.Body.Close()
+	if err != nil || len(statuses) != 2 || statuses[0].Topic != "a" || statuses[1].Topic != "b" {
+		t.Errorf("listed %+v, %v, want partition 0 of a and of b", statuses, err)
+	}
+	for path, want := range map[string]int{
+		"/partitions/0":         http.StatusBadRequest,
+		"/partitions/0?topic=b": http.StatusOK,
+		"/partitions/0?topic=c": http.StatusNotFound,
+		"/safepoint":            http.StatusBadRequest,
+		"/safepoint?topic=a":    http.StatusOK,
+	} {
+		resp := get(path)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+import "github.com/ideasculptor/offsets_test/tracker"
+
+// stuckPolicies maps the names -stuck-policy accepts to the tracker's policies
+var stuckPolicies = map[string]tracker.StuckPolicy{
+	"block":      tracker.StuckBlock,
+	"skip":       tracker.StuckSkip,
+	"deadletter": tracker.StuckDeadLetter,
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Charts are drawn as SVG by hand, so a report needs nothing but a browser
+// and the binary nothing but the standard library.
+
+// svgSeries is a line of a chart, points are x, y
+type svgSeries struct {
+	name   string
+	color  string
+	points [][2]float64
+}
+
+// svgBar is the color of a bar chart's bars
+const svgBar = "#4878a8"
+
+const (
+	svgWidth, svgHeight = 720, 260
+	// the margins leave room for the title and the axis labels
+	svgLeft, svgRight, svgTop, svgBottom = 70, 20, 30, 40
+)
+
+// svgLineChart draws series over a shared x axis, each scaled to the
+// largest y of them all
+func svgLineChart(title, xUnit, yUnit string, series []svgSeries) string {
+	var maxX, maxY float64
+	for _, s := range series {
+		for _, p := range s.points {
+			maxX, maxY = max(maxX, p[0]), max(maxY, p[1])
+		}
+	}
+	var b strings.Builder
+	svgStart(&b, title)
+	svgAxes(&b, maxX, maxY, xUnit, yUnit)
+	for i, s := range series {
+		var pts []string
+		for _, p := range s.points {
+			x, y := svgX(p[0], maxX), svgY(p[1], maxY)
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", x, y))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`+"\n", s.color, strings.Join(pts, " "))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="%s" font-size="12">%s</text>`+"\n", svgLeft+10+120*i, svgTop-8, s.color, html.EscapeString(s.name))
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// svgBarChart draws a bar per label
+func svgBarChart(title, yUnit string, labels []string, values []float64) string {
+	var maxY float64
+	for _, v := range values {
+		maxY = max(maxY, v)
+	}
+	var b strings.Builder
+	svgStart(&b, title)
+	svgAxes(&b, 0, maxY, "", yUnit)
+	plotWidth := float64(svgWidth - svgLeft - svgRight)
+	slot := plotWidth / float64(max(len(values), 1))
+	for i, v := range values {
+		x := float64(svgLeft) + slot*float64(i)
+		y := svgY(v, maxY)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %.0f</title></rect>`+"\n",
+			x+1, y, slot-2, float64(svgHeight-svgBottom)-y, svgBar, html.EscapeString(labels[i]), v)
+		// label every bar while they fit, otherwise every few
+		if every := max(len(values)/12, 1); i%every == 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="10" text-anchor="middle">%s</text>`+"\n", x+slot/2, svgHeight-svgBottom+14, html.EscapeString(labels[i]))
+		}
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+func svgStart(b *strings.Builder, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif">`+"\n", svgWidth, svgHeight, svgWidth, svgHeight)
+	fmt.Fprintf(b, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	fmt.Fprintf(b, `<text x="%d" y="16" font-size="14" font-weight="bold">%s</text>`+"\n", svgLeft, html.EscapeString(title))
+}
+
+// svgAxes draws the axes with a few grid lines, leaving out the x labels
+// if maxX is zero
+func svgAxes(b *strings.Builder, maxX, maxY float64, xUnit, yUnit string) {
+	const grid = 4
+	for i := 0; i <= grid; i++ {
+		v := maxY * float64(i) / grid
+		y := svgY(v, maxY)
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`+"\n", svgLeft, y, svgWidth-svgRight, y)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" font-size="10" text-anchor="end">%s</text>`+"\n", svgLeft-4, y+3, compactNumber(v))
+		if maxX > 0 {
+			x := svgX(maxX*float64(i)/grid, maxX)
+			fmt.Fprintf(b, `<text x="%.1f" y="%d" font-size="10" text-anchor="middle">%s</text>`+"\n", x, svgHeight-svgBottom+14, compactNumber(maxX*float64(i)/grid))
+		}
+	}
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", svgLeft, svgHeight-svgBottom, svgWidth-svgRight, svgHeight-svgBottom)
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", svgLeft, svgTop, svgLeft, svgHeight-svgBottom)
+	fmt.Fprintf(b, `<text x="%d" y="%d" font-size="11" text-anchor="middle">%s</text>`+"\n", (svgWidth+svgLeft-svgRight)/2, svgHeight-8, html.EscapeString(xUnit))
+	fmt.Fprintf(b, `<text x="12" y="%d" font-size="11" text-anchor="middle" transform="rotate(-90 12 %d)">%s</text>`+"\n", (svgHeight+svgTop-svgBottom)/2, (svgHeight+svgTop-svgBottom)/2, html.EscapeString(yUnit))
+}
+
+func svgX(v, maxX float64) float64 {
+	if maxX <= 0 {
+		return svgLeft
+	}
+	return svgLeft + v/maxX*float64(svgWidth-svgLeft-svgRight)
+}
+
+func svgY(v, maxY float64) float64 {
+	bottom := float64(svgHeight - svgBottom)
+	if maxY <= 0 {
+		return bottom
+	}
+	return bottom - v/maxY*float64(svgHeight-svgTop-svgBottom)
+}
+
+// compactNumber writes v short enough for an axis, 1.5k or 2.3M
+func compactNumber(v float64) string {
+	switch {
+	case v >= 1e9:
+		return fmt.Sprintf("%.3gG", v/1e9)
+	case v >= 1e6:
+		return fmt.Sprintf("%.3gM", v/1e6)
+	case v >= 1e3:
+		return fmt.Sprintf("%.3gk", v/1e3)
+	}
+	return fmt.Sprintf("%.3g", v)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sweepRun is one child process of a sweep
+type sweepRun struct {
+	// procs is empty when GOMAXPROCS isn't being swept
+	procs          string
+	gogc, memLimit string
+	results        []jsonResult
+	// peakRSS is the child's maximum resident set size in bytes, zero
+	// when the platform doesn't report it
+	peakRSS uint64
+}
+
+// sweepCmd reruns the same bench under every combination of GOMAXPROCS,
+// GOGC and GOMEMLIMIT.  Every run is a fresh process, both because the
+// runtime only reads these at startup and so the heap left behind by one
+// run can't skew the next.
+func sweepCmd(args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	gogc := fs.String("gogc", "50,100,200,400,off", "comma separated GOGC values to run")
+	memLimits := fs.String("gomemlimit", "off", "comma separated GOMEMLIMIT values to run, e.g. off,256MiB,1GiB")
+	procList := fs.String("gomaxprocs", "", "comma separated GOMAXPROCS values to run, auto for powers of two up to NumCPU, empty to leave it alone")
+	markdown := fs.String("markdown", "-", "write the sweep table to this file (- for stdout)")
+	verbose := fs.Bool("v", false, "show the output of every run")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sweep [flags] [-- bench flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	benchArgs := fs.Args()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "offsets-sweep-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	procs := []string{""}
+	if *procList == "auto" {
+		procs = nil
+		for n := 1; ; n *= 2 {
+			if n >= runtime.NumCPU() {
+				procs = append(procs, strconv.Itoa(runtime.NumCPU()))
+				break
+			}
+			procs = append(procs, strconv.Itoa(n))
+		}
+	} else if *procList != "" {
+		procs = splitList(*procList)
+	}
+
+	// every run gets the same seed, so they all process the same messages
+	// in the same time unless the bench flags pick a seed themselves
+	seed := strconv.FormatInt(time.Now().UnixNano(), 10)
+	var runs []sweepRun
+	for _, p := range procs {
+		for _, g := range splitList(*gogc) {
+			for _, limit := range splitList(*memLimits) {
+				run := sweepRun{procs: p, gogc: g, memLimit: limit}
+				out := fmt.Sprintf("%s/run-%d.json", tmp, len(runs))
+				cmd := exec.Command(exe, append([]string{"bench", "-json", out, "-seed", seed}, benchArgs...)...)
+				cmd.Env = append(os.Environ(), "GOGC="+g, "GOMEMLIMIT="+limit)
+				if p != "" {
+					cmd.Env = append(cmd.Env, "GOMAXPROCS="+p)
+				}
+				cmd.Stdout, cmd.Stderr = io.Discard, os.Stderr
+				if *verbose {
+					cmd.Stdout = os.Stderr
+				}
+				fmt.Fprintf(os.Stderr, "running %v\n", run.label())
+				if err := cmd.Run(); err != nil {
+					return fmt.Errorf("%v: %w", run.label(), err)
+				}
+				run.peakRSS = maxRSS(cmd.ProcessState)
+				if run.results, err = readJSONResults(out); err != nil {
+					return err
+				}
+				runs = append(runs, run)
+			}
+		}
+	}
+	return writeOutput(*markdown, func(w io.Writer) error {
+		if err := writeSweep(w, runs); err != nil {
+			return err
+		}
+		if procs[0] == "" {
+			return nil
+		}
+		return writeScaling(w, runs)
+	})
+}
+
+// label describes the environment of the run
+func (r sweepRun) label() string {
+	l := fmt.Sprintf("GOGC=%v GOMEMLIMIT=%v", r.gogc, r.memLimit)
+	if r.procs != "" {
+		l = "GOMAXPROCS=" + r.procs + " " + l
+	}
+	return l
+}
+
+func readJSONResults(path string) ([]jsonResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var results []jsonResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return results, nil
+}
+
+func writeSweep(w io.Writer, runs []sweepRun) error {
+	rows := [][]string{
+		{"GOMAXPROCS", "GOGC", "GOMEMLIMIT", "Run", "Duration", "Throughput (msg/s)", "GCs", "Peak heap (MiB)", "Peak RSS (MiB)"},
+	}
+	for _, run := range runs {
+		rss := "-"
+		if run.peakRSS > 0 {
+			rss = fmt.Sprintf("%.1f", float64(run.peakRSS)/1024/1024)
+		}
+		for _, r := range run.results {
+			procs := run.procs
+			if procs == "" {
+				procs = "default"
+			}
+			rows = append(rows, []string{
+				procs,
+				run.gogc,
+				run.memLimit,
+				r.Name,
+				r.Duration.Round(time.Millisecond).String(),
+				fmt.Sprintf("%.0f", r.Throughput),
+				fmt.Sprint(r.NumGC),
+				fmt.Sprintf("%.1f", float64(r.PeakHeap)/1024/1024),
+				// RSS is per process, so runs sharing a process
+				// share the figure
+				rss,
+			})
+		}
+	}
+	return writeTable(w, rows)
+}
+
+// scalingBarWidth is the length of the bar for the highest throughput
+const scalingBarWidth = 40
+
+// writeScaling plots throughput against GOMAXPROCS for every run, as a
+// text bar chart in a code block so it survives being pasted into markdown.
+// The speedup is relative to the first GOMAXPROCS value.
+func writeScaling(w io.Writer, runs []sweepRun) error {
+	// group the results by everything but GOMAXPROCS, keeping the order
+	// the runs were made in
+	type point struct {
+		procs      string
+		throughput float64
+	}
+	var keys []string
+	series := make(map[string][]point)
+	max := 0.0
+	for _, run := range runs {
+		for _, r := range run.results {
+			key := fmt.Sprintf("%v (GOGC=%v GOMEMLIMIT=%v)", r.Name, run.gogc, run.memLimit)
+			if _, ok := series[key]; !ok {
+				keys = append(keys, key)
+			}
+			series[key] = append(series[key], point{run.procs, r.Throughput})
+			if r.Throughput > max {
+				max = r.Throughput
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n```\n")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v\n", key)
+		points := series[key]
+		for _, p := range points {
+			n := 0
+			if max > 0 {
+				n = int(p.throughput / max * scalingBarWidth)
+			}
+			speedup := 0.0
+			if points[0].throughput > 0 {
+				speedup = p.throughput / points[0].throughput
+			}
+			fmt.Fprintf(w, "  GOMAXPROCS=%-4v %-*s %10.0f msg/s %6.2fx\n",
+				p.procs, scalingBarWidth, strings.Repeat("#", n), p.throughput, speedup)
+		}
+	}
+	_, err := fmt.Fprintf(w, "```\n")
+	return err
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// traceMagic starts every trace file so we don't try to replay garbage
+const traceMagic = "offsets-trace v1\n"
+
+// traceEvent is a single ack as seen by the committer
+type traceEvent struct {
+	offset int64
+	// at is the time since the start of the run
+	at time.Duration
+}
+
+// writeTrace saves events in the order they were acked.  The format is the
+// magic header, the number of events, then each event as a pair of
+// uvarints: the offset and the time since the previous event.
+func writeTrace(path string, events []traceEvent) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	buf := make([]byte, binary.MaxVarintLen64)
+	put := func(v uint64) {
+		n := binary.PutUvarint(buf, v)
+		w.Write(buf[:n])
+	}
+	w.WriteString(traceMagic)
+	put(uint64(len(events)))
+	var last time.Duration
+	for _, e := range events {
+		put(uint64(e.offset))
+		put(uint64(e.at - last))
+		last = e.at
+	}
+	// bufio.Writer remembers the first error, so checking Flush is enough
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readTrace loads a trace written by writeTrace.  Offsets in a trace are
+// always a permutation of [0, len(events)).
+func readTrace(path string) ([]traceEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != traceMagic {
+		return nil, fmt.Errorf("%s: not a trace file", path)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	events := make([]traceEvent, 0, n)
+	seen := make([]bool, n)
+	var at time.Duration
+	for i := uint64(0); i < n; i++ {
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: event %d: %w", path, i, unexpectedEOF(err))
+		}
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: event %d: %w", path, i, unexpectedEOF(err))
+		}
+		if offset >= n || seen[offset] {
+			return nil, fmt.Errorf("%s: event %d: offset %d is out of range or repeated", path, i, offset)
+		}
+		seen[offset] = true
+		at += time.Duration(delta)
+		events = append(events, traceEvent{offset: int64(offset), at: at})
+	}
+	return events, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// replayWorkers returns a worker model that acks offsets in exactly the
+// order and at the times they were recorded instead of sleeping for a
+// random delay.  A single goroutine does the replay so the order can't be
+// perturbed by the scheduler.
+func replayWorkers(events []traceEvent) workerModel {
+	return func(numMsgs int64, _ func(int64) time.Duration, deliver func(int64), start *sync.WaitGroup) {
+		go func() {
+			start.Wait()
+			begin := time.Now()
+			for _, e := range events {
+				if wait := e.at - time.Since(begin); wait > 0 {
+					time.Sleep(wait)
+				}
+				deliver(e.offset)
+			}
+		}()
+		fmt.Printf("replaying %v recorded acks\n", len(events))
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A mirrored cluster gives its copy of a record an offset of its own, so a
+// watermark committed on one cluster means nothing on the other.  The
+// mirror says now and then which source offset it copied to which target
+// offset, like MirrorMaker's offset syncs, and an OffsetMap keeps those
+// pairs to translate watermarks across on failover.
+
+// OffsetSync says the record at Source was mirrored to Target
+type OffsetSync struct {
+	Source int64 `json:"source"`
+	Target int64 `json:"target"`
+}
+
+// defaultMaxSyncs is how many syncs an OffsetMap keeps unless told
+// otherwise
+const defaultMaxSyncs = 1024
+
+// OffsetMap maps offsets of a partition between its source and mirrored
+// cluster.  The syncs it keeps get sparser the older they are, recent
+// watermarks translate precisely and old ones conservatively.
+type OffsetMap struct {
+	// Max is how many syncs are kept, zero is defaultMaxSyncs
+	Max   int
+	syncs []OffsetSync
+}
+
+// Record adds a sync, which has to be past the last one on both clusters
+func (m *OffsetMap) Record(source, target int64) error {
+	if n := len(m.syncs); n > 0 {
+		last := m.syncs[n-1]
+		if source <= last.Source || target <= last.Target {
+			return fmt.Errorf("sync %d:%d isn't past the last one, %d:%d", source, target, last.Source, last.Target)
+		}
+	}
+	m.syncs = append(m.syncs, OffsetSync{Source: source, Target: target})
+	max := m.Max
+	if max <= 0 {
+		max = defaultMaxSyncs
+	}
+	if len(m.syncs) > max {
+		m.thin()
+	}
+	return nil
+}
+
+// thin drops every other sync of the older half
+func (m *OffsetMap) thin() {
+	half := len(m.syncs) / 2
+	kept := m.syncs[:0]
+	for i, s := range m.syncs {
+		if i >= half || i%2 == 0 {
+			kept = append(kept, s)
+		}
+	}
+	m.syncs = kept
+}
+
+// Syncs returns the syncs kept, oldest first
+func (m *OffsetMap) Syncs() []OffsetSync {
+	return append([]OffsetSync{}, m.syncs...)
+}
+
+// Translate returns the target cluster's watermark for a source watermark.
+// It is never past where the source was: the records after the last sync
+// at or below the watermark may have been mirrored anywhere after it, so
+// a consumer failing over resumes from there and sees them again rather
+// than miss them.  Without such a sync it is -1, the whole partition.
+func (m *OffsetMap) Translate(watermark int64) int64 {
+	return translateWatermark(m.syncs, watermark, func(s OffsetSync) (int64, int64) { return s.Source, s.Target })
+}
+
+// Reverse is Translate from the target cluster back to the source, for
+// failing back
+func (m *OffsetMap) Reverse(watermark int64) int64 {
+	return translateWatermark(m.syncs, watermark, func(s OffsetSync) (int64, int64) { return s.Target, s.Source })
+}
+
+func translateWatermark(syncs []OffsetSync, watermark int64, from func(OffsetSync) (int64, int64)) int64 {
+	// the first sync past the watermark
+	i := sort.Search(len(syncs), func(i int) bool {
+		f, _ := from(syncs[i])
+		return f > watermark
+	})
+	if i < len(syncs) {
+		if f, t := from(syncs[i]); f == watermark+1 {
+			// the next record is known, everything before it is
+			// done
+			return t - 1
+		}
+	}
+	if i == 0 {
+		return -1
+	}
+	_, t := from(syncs[i-1])
+	return t
+}
+
+// LoadOffsetMap reads a map saved by Save, a file that isn't there is an
+// empty map
+func LoadOffsetMap(path string) (*OffsetMap, error) {
+	m := &OffsetMap{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.syncs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Save writes the map to path as JSON, through a temporary file like
+// store.File does
+func (m *OffsetMap) Save(path string) error {
+	data, err := json.Marshal(m.syncs)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// translateCmd records offset syncs in a map file and translates
+// watermarks with it
+func translateCmd(args []string) error {
+	fs := flag.NewFlagSet("translate", flag.ExitOnError)
+	path := fs.String("map", "offsets.map.json", "file the offset syncs of the partition are kept in")
+	record := fs.String("record", "", "add the comma separated source:target syncs to the map")
+	watermark := fs.Int64("watermark", -2, "watermark to translate, from the source cluster to the target unless -reverse")
+	reverse := fs.Bool("reverse", false, "translate from the target cluster back to the source")
+	max := fs.Int("max-syncs", defaultMaxSyncs, "how many syncs the map keeps, older ones are thinned out")
+	fs.Parse(args)
+
+	m, err := LoadOffsetMap(*path)
+	if err != nil {
+		return err
+	}
+	m.Max = *max
+	if *record != "" {
+		for _, s := range strings.Split(*record, ",") {
+			src, tgt, ok := strings.Cut(s, ":")
+			if !ok {
+				return fmt.Errorf("bad sync %q, want source:target", s)
+			}
+			source, err := strconv.ParseInt(src, 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad sync %q: %w", s, err)
+			}
+			target, err := strconv.ParseInt(tgt, 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad sync %q: %w", s, err)
+			}
+			if err := m.Record(source, target); err != nil {
+				return err
+			}
+		}
+		if err := m.Save(*path); err != nil {
+			return err
+		}
+		fmt.Printf("%v holds %v syncs\n", *path, len(m.syncs))
+	}
+	if *watermark < -1 {
+		return nil
+	}
+	if *reverse {
+		fmt.Printf("target watermark %v is source watermark %v\n", *watermark, m.Reverse(*watermark))
+	} else {
+		fmt.Printf("source watermark %v is target watermark %v\n", *watermark, m.Translate(*watermark))
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboard is bench's -tui: a screen that redraws every frame with a row
+// per run, instead of a line per tick.  What the runs print goes to a log
+// at the bottom of it and is printed in full once the dashboard is gone.
+type dashboard struct {
+	term  io.Writer
+	start time.Time
+	mu    sync.Mutex
+	rows  []*dashRow
+	log   []string
+}
+
+// dashRow is what a run looked like at the last frame
+type dashRow struct {
+	name string
+	p    Partition
+	// committed and pending were last read at at, done is set once the
+	// run's consumer is gone
+	committed int64
+	pending   int
+	gaps      int
+	paused    bool
+	done      bool
+	at        time.Time
+	// movedAt is when the watermark last moved, how long the gap at it
+	// has been holding it up
+	movedAt time.Time
+	// throughput is the msg/s of the last frames, oldest first
+	throughput []float64
+}
+
+const (
+	dashFrame   = 250 * time.Millisecond
+	dashHistory = 40
+	dashLogRows = 8
+)
+
+// startDashboard takes over stdout until stop is called
+func startDashboard() (d *dashboard, stop func(), err error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	d = &dashboard{term: os.Stdout, start: time.Now()}
+	os.Stdout = pw
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		s := bufio.NewScanner(pr)
+		for s.Scan() {
+			d.mu.Lock()
+			d.log = append(d.log, s.Text())
+			d.mu.Unlock()
+		}
+	}()
+	// the alternate screen, without a cursor, keeps the terminal's
+	// scrollback as it was
+	fmt.Fprint(d.term, "\x1b[?1049h\x1b[?25l")
+	quit := make(chan struct{})
+	drawn := make(chan struct{})
+	go func() {
+		defer close(drawn)
+		ticker := time.NewTicker(dashFrame)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case now := <-ticker.C:
+				d.draw(now)
+			}
+		}
+	}()
+	return d, func() {
+		close(quit)
+		<-drawn
+		os.Stdout = d.term.(*os.File)
+		pw.Close()
+		<-read
+		fmt.Fprint(d.term, "\x1b[?25h\x1b[?1049l")
+		for _, line := range d.log {
+			fmt.Fprintln(d.term, line)
+		}
+	}, nil
+}
+
+// track adds a row for the run called name, or points its row at p if the
+// run restarted its consumer
+func (d *dashboard) track(name string, p Partition) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range d.rows {
+		if r.name == name {
+			r.p, r.done = p, false
+			return
+		}
+	}
+	now := time.Now()
+	d.rows = append(d.rows, &dashRow{name: name, p: p, committed: -1, at: now, movedAt: now})
+}
+
+// draw reads every run and redraws the screen
+func (d *dashboard) draw(now time.Time) {
+	d.mu.Lock()
+	rows := append([]*dashRow{}, d.rows...)
+	d.mu.Unlock()
+	for _, r := range rows {
+		r.update(now)
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	var b strings.Builder
+	// home and clear, then draw from the top
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "offsets bench  %v  heap %.1f MiB  sys %.1f MiB  GCs %v  goroutines %v\r\n\r\n",
+		now.Sub(d.start).Round(time.Second), float64(m.HeapAlloc)/1024/1024, float64(m.Sys)/1024/1024, m.NumGC, runtime.NumGoroutine())
+	fmt.Fprintf(&b, "%-24s %12s %10s %8s %10s %10s  %s\r\n", "RUN", "WATERMARK", "PENDING", "GAPS", "GAP AGE", "MSG/S", "THROUGHPUT")
+	for _, r := range rows {
+		age := "-"
+		if r.pending > 0 && !r.done {
+			age = now.Sub(r.movedAt).Round(10 * time.Millisecond).String()
+		}
+		state := ""
+		switch {
+		case r.done:
+			state = "  done"
+		case r.paused:
+			state = "  paused"
+		}
+		rate := 0.0
+		if len(r.throughput) > 0 {
+			rate = r.throughput[len(r.throughput)-1]
+		}
+		fmt.Fprintf(&b, "%-24s %12v %10v %8v %10s %10.0f  %s%s\r\n",
+			truncate(r.name, 24), r.committed, r.pending, r.gaps, age, rate, sparkline(r.throughput), state)
+	}
+
+	d.mu.Lock()
+	log := d.log
+	if len(log) > dashLogRows {
+		log = log[len(log)-dashLogRows:]
+	}
+	b.WriteString("\r\n")
+	for _, line := range log {
+		b.WriteString(truncate(line, 160) + "\r\n")
+	}
+	d.mu.Unlock()
+	io.WriteString(d.term, b.String())
+}
+
+// update reads the row's consumer, once it's stopped the row keeps what it
+// read last
+func (r *dashRow) update(now time.Time) {
+	if r.done {
+		return
+	}
+	s, err := r.p.Status(context.Background())
+	if err != nil {
+		r.done = true
+		return
+	}
+	if s.Committed != r.committed {
+		r.movedAt = now
+	}
+	if r.committed >= 0 {
+		rate := float64(s.Committed-r.committed) / now.Sub(r.at).Seconds()
+		r.throughput = append(r.throughput, rate)
+		if len(r.throughput) > dashHistory {
+			r.throughput = r.throughput[1:]
+		}
+	}
+	r.committed, r.pending, r.gaps, r.paused, r.at = s.Committed, s.Pending, s.GapCount, s.Paused, now
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws values as a bar each, scaled to the largest
+func sparkline(values []float64) string {
+	var top float64
+	for _, v := range values {
+		if v > top {
+			top = v
+		}
+	}
+	out := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if top > 0 && v > 0 {
+			level = int(v / top * float64(len(sparks)-1))
+		}
+		out[i] = sparks[level]
+	}
+	return string(out)
+}
+
+// truncate cuts s down to n runes
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A goroutine per message costs a couple of KB of stack each, so with a
+// million messages the goroutines dwarf the pending set we are trying to
+// measure.  The wheel worker model instead keeps every message as a 16
+// byte entry in a hierarchical timing wheel and fires its ack when the
+// simulated processing time is up, using one goroutine per wheel.
+
+const (
+	// wheelResolution is the length of a tick, completion times are
+	// rounded up to it
+	wheelResolution = time.Millisecond
+	// each level has 1<<wheelBits slots, and a slot in level n spans
+	// 1<<(n*wheelBits) ticks.  Four levels cover 2^32 ticks which is
+	// about 50 days at 1ms.
+	wheelBits   = 8
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+)
+
+type wheelEntry struct {
+	offset int64
+	// at is the tick the entry fires at
+	at int64
+}
+
+// timerWheel is a hierarchical timing wheel.  Entries due within the next
+// wheelSlots ticks sit in level 0, one slot per tick, and entries further
+// out sit in coarser slots in higher levels.  Whenever a level wraps
+// around, the next slot of the level above is cascaded down.  It isn't
+// safe for concurrent use.
+type timerWheel struct {
+	levels [wheelLevels][wheelSlots][]wheelEntry
+	// now is the last tick that has been processed
+	now int64
+	n   int
+}
+
+// schedule adds an entry that fires ticks from now, at the earliest on
+// the next tick
+func (w *timerWheel) schedule(offset int64, ticks int64) {
+	if ticks < 1 {
+		ticks = 1
+	}
+	w.place(wheelEntry{offset: offset, at: w.now + ticks})
+	w.n++
+}
+
+// place puts e in the lowest level whose span covers it
+func (w *timerWheel) place(e wheelEntry) {
+	delta := e.at - w.now
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(uint(level+1)*wheelBits) {
+		level++
+	}
+	slot := (e.at >> (uint(level) * wheelBits)) & wheelMask
+	w.levels[level][slot] = append(w.levels[level][slot], e)
+}
+
+// advance processes every tick up to and including to, calling fire for
+// each entry that is due
+func (w *timerWheel) advance(to int64, fire func(offset int64)) {
+	for w.now < to && w.n > 0 {
+		w.now++
+		t := w.now
+		// when a level wraps, its entries for the coming span are in
+		// the next slot of the level above
+		for level := 1; level < wheelLevels; level++ {
+			shift := uint(level) * wheelBits
+			if t&(1<<shift-1) != 0 {
+				break
+			}
+			slot := (t >> shift) & wheelMask
+			entries := w.levels[level][slot]
+			// drop the slice rather than reusing it, a slot in a
+			// high level can be huge and won't be needed for a
+			// long time
+			w.levels[level][slot] = nil
+			for _, e := range entries {
+				w.place(e)
+			}
+		}
+		slot := t & wheelMask
+		for _, e := range w.levels[0][slot] {
+			fire(e.offset)
+		}
+		w.n -= len(w.levels[0][slot])
+		w.levels[0][slot] = w.levels[0][slot][:0]
+	}
+	if w.n == 0 {
+		w.now = to
+	}
+}
+
+func (w *timerWheel) len() int {
+	return w.n
+}
+
+// wheelWorkers splits the offsets between GOMAXPROCS timing wheels, each
+// driven by a single goroutine
+func wheelWorkers(numMsgs int64, delay func(offset int64) time.Duration, deliver func(offset int64), start *sync.WaitGroup) {
+	shards := int64(runtime.GOMAXPROCS(0))
+	for shard := int64(0); shard < shards; shard++ {
+		w := &timerWheel{}
+		for offset := shard; offset < numMsgs; offset += shards {
+			// round up so nothing completes early
+			d := delay(offset)
+			w.schedule(offset, int64((d+wheelResolution-1)/wheelResolution))
+		}
+		go func(w *timerWheel) {
+			start.Wait()
+			begin := time.Now()
+			ticker := time.NewTicker(wheelResolution)
+			defer ticker.Stop()
+			// the ticker drops ticks when we fall behind, so catch up
+			// on real time rather than counting ticks
+			for w.len() > 0 {
+				<-ticker.C
+				w.advance(int64(time.Since(begin)/wheelResolution), deliver)
+			}
+		}(w)
+	}
+	fmt.Printf("scheduled %v messages on %v timing wheels\n", numMsgs, shards)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsCommand is what a client sends over the control channel, e.g.
+//
+//	{"cmd": "pause", "partition": 0}
+//	{"cmd": "seek", "topic": "orders", "partition": 0, "offset": 41}
+//
+// Topic is only needed if the partition's number is tracked for several.
+type wsCommand struct {
+	Cmd       string `json:"cmd"`
+	Topic     string `json:"topic,omitempty"`
+	Partition int32  `json:"partition"`
+	// Offset is the watermark to seek to
+	Offset int64 `json:"offset"`
+}
+
+// wsMessage is what the server sends: the state of every partition on
+// every tick, and the result of every command
+type wsMessage struct {
+	Type       string            `json:"type"`
+	Partitions []PartitionStatus `json:"partitions,omitempty"`
+	Cmd        *wsCommand        `json:"cmd,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// NewControlHandler returns a WebSocket handler that streams the state of
+// the partitions returned by partitions every interval and runs the
+// commands pause, resume, seek and flush sent by the client.  Gaps aren't
+// streamed, see the admin API for those.
+func NewControlHandler(partitions func() map[TrackerKey]Partition, interval time.Duration) websocket.Handler {
+	return func(ws *websocket.Conn) {
+		defer ws.Close()
+		// replies and state share the connection, so only this
+		// goroutine writes to it
+		results := make(chan wsMessage)
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				var cmd wsCommand
+				if err := websocket.JSON.Receive(ws, &cmd); err != nil {
+					return
+				}
+				msg := wsMessage{Type: "result", Cmd: &cmd}
+				if err := runCommand(ws.Request().Context(), partitions(), cmd); err != nil {
+					msg.Error = err.Error()
+				}
+				select {
+				case results <- msg:
+				case <-ws.Request().Context().Done():
+					return
+				}
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			var msg wsMessage
+			select {
+			case <-closed:
+				return
+			case msg = <-results:
+			case <-ticker.C:
+				msg = wsMessage{Type: "state", Partitions: statuses(ws.Request().Context(), partitions())}
+			}
+			if err := websocket.JSON.Send(ws, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func runCommand(ctx context.Context, parts map[TrackerKey]Partition, cmd wsCommand) error {
+	_, p, err := findPartition(parts, cmd.Topic, cmd.Partition)
+	if err != nil {
+		return err
+	}
+	switch cmd.Cmd {
+	case "pause":
+		p.Pause()
+	case "resume":
+		p.Resume()
+	case "seek":
+		return p.SeekTo(ctx, cmd.Offset)
+	case "flush":
+		return p.Flush(ctx)
+	default:
+		return fmt.Errorf("unknown command %q (available: pause, resume, seek, flush)", cmd.Cmd)
+	}
+	return nil
+}
+
+// statuses returns the status of every partition that answers, sorted and
+// without gaps
+func statuses(ctx context.Context, parts map[TrackerKey]Partition) []PartitionStatus {
+	out := make([]PartitionStatus, 0, len(parts))
+	for _, k := range sortedKeys(parts) {
+		s, err := partitionStatus(ctx, k, parts[k])
+		if err != nil {
+			continue
+		}
+		s.Gaps = nil
+		out = append(out, s)
+	}
+	return out
+}
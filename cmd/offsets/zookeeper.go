@@ -0,0 +1,122 @@
+//go:build !nozookeeper
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// zookeeper is where consumers kept their offsets before Kafka did
+func init() {
+	brokerAdapters["zookeeper"] = func(opts *brokerOptions, partition int32) (brokerAdapter, error) {
+		conn, ok := opts.conns["zookeeper"].(*zk.Conn)
+		if !ok {
+			var err error
+			if conn, err = connectZK(opts.zkServers, opts.kafka.timeout); err != nil {
+				return nil, err
+			}
+			opts.conns["zookeeper"] = conn
+		}
+		return newZKBroker(conn, opts.kafka, partition)
+	}
+}
+
+// zkBroker commits a partition's watermark to ZooKeeper where consumers
+// from before Kafka 0.9 kept their offsets,
+//
+//	/consumers/<group>/offsets/<topic>/<partition>
+//
+// as the next offset to consume in decimal, one past the watermark.  It is
+// for moving those consumers over while they still read their offsets from
+// there.
+type zkBroker struct {
+	conn *zk.Conn
+	path string
+	// committed is accessed atomically
+	committed int64
+}
+
+// zkPath is the legacy layout's path of a partition's offset
+func zkPath(group, topic string, partition int32) string {
+	return path.Join("/consumers", group, "offsets", topic, strconv.Itoa(int(partition)))
+}
+
+// connectZK connects to the comma separated servers
+func connectZK(servers string, timeout time.Duration) (*zk.Conn, error) {
+	if servers == "" {
+		return nil, fmt.Errorf("the zookeeper broker needs -zk-servers")
+	}
+	conn, _, err := zk.Connect(strings.Split(servers, ","), timeout, zk.WithLogInfo(false))
+	return conn, err
+}
+
+// newZKBroker returns a broker for partition of opts.topic, starting from
+// what the group has in ZooKeeper
+func newZKBroker(conn *zk.Conn, opts kafkaOptions, partition int32) (*zkBroker, error) {
+	if opts.topic == "" || opts.group == "" {
+		return nil, fmt.Errorf("the zookeeper broker needs -kafka-topic and -kafka-group")
+	}
+	b := &zkBroker{conn: conn, path: zkPath(opts.group, opts.topic, partition), committed: -1}
+	data, _, err := conn.Get(b.path)
+	if errors.Is(err, zk.ErrNoNode) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", b.path, err)
+	}
+	next, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.path, err)
+	}
+	b.committed = next - 1
+	return b, nil
+}
+
+// Commit implements Broker, the ZooKeeper client can't be interrupted so
+// ctx is only checked before the write
+func (b *zkBroker) Commit(ctx context.Context, offset int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data := []byte(strconv.FormatInt(offset+1, 10))
+	_, err := b.conn.Set(b.path, data, -1)
+	if errors.Is(err, zk.ErrNoNode) {
+		err = b.create(data)
+	}
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&b.committed, offset)
+	return nil
+}
+
+// create makes the partition's node and whatever parents it is missing
+func (b *zkBroker) create(data []byte) error {
+	acl := zk.WorldACL(zk.PermAll)
+	parts := strings.Split(strings.TrimPrefix(b.path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		parent := "/" + strings.Join(parts[:i], "/")
+		if _, err := b.conn.Create(parent, nil, 0, acl); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return fmt.Errorf("creating %s: %w", parent, err)
+		}
+	}
+	_, err := b.conn.Create(b.path, data, 0, acl)
+	if errors.Is(err, zk.ErrNodeExists) {
+		// someone else made it in the meantime
+		_, err = b.conn.Set(b.path, data, -1)
+	}
+	return err
+}
+
+func (b *zkBroker) Committed() int64 {
+	return atomic.LoadInt64(&b.committed)
+}
@@ -0,0 +1,5 @@
+// Package offsetspb holds the gRPC service that lets other processes use the
+// tracker as a sidecar, generated from offsets.proto.
+package offsetspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative offsets.proto
@@ -0,0 +1,426 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: offsets.proto
+
+package offsetspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Watermark struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Partition     int32                  `protobuf:"varint,1,opt,name=partition,proto3" json:"partition,omitempty"`
+	Committed     int64                  `protobuf:"varint,2,opt,name=committed,proto3" json:"committed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Watermark) Reset() {
+	*x = Watermark{}
+	mi := &file_offsets_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Watermark) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Watermark) ProtoMessage() {}
+
+func (x *Watermark) ProtoReflect() protoreflect.Message {
+	mi := &file_offsets_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Watermark.ProtoReflect.Descriptor instead.
+func (*Watermark) Descriptor() ([]byte, []int) {
+	return file_offsets_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Watermark) GetPartition() int32 {
+	if x != nil {
+		return x.Partition
+	}
+	return 0
+}
+
+func (x *Watermark) GetCommitted() int64 {
+	if x != nil {
+		return x.Committed
+	}
+	return 0
+}
+
+type WatermarksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Partitions    []int32                `protobuf:"varint,1,rep,packed,name=partitions,proto3" json:"partitions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatermarksRequest) Reset() {
+	*x = WatermarksRequest{}
+	mi := &file_offsets_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatermarksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatermarksRequest) ProtoMessage() {}
+
+func (x *WatermarksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_offsets_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatermarksRequest.ProtoReflect.Descriptor instead.
+func (*WatermarksRequest) Descriptor() ([]byte, []int) {
+	return file_offsets_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *WatermarksRequest) GetPartitions() []int32 {
+	if x != nil {
+		return x.Partitions
+	}
+	return nil
+}
+
+type WatermarksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Watermarks    []*Watermark           `protobuf:"bytes,1,rep,name=watermarks,proto3" json:"watermarks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatermarksResponse) Reset() {
+	*x = WatermarksResponse{}
+	mi := &file_offsets_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatermarksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatermarksResponse) ProtoMessage() {}
+
+func (x *WatermarksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_offsets_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatermarksResponse.ProtoReflect.Descriptor instead.
+func (*WatermarksResponse) Descriptor() ([]byte, []int) {
+	return file_offsets_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *WatermarksResponse) GetWatermarks() []*Watermark {
+	if x != nil {
+		return x.Watermarks
+	}
+	return nil
+}
+
+type AckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Partition     int32                  `protobuf:"varint,1,opt,name=partition,proto3" json:"partition,omitempty"`
+	Offsets       []int64                `protobuf:"varint,2,rep,packed,name=offsets,proto3" json:"offsets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckRequest) Reset() {
+	*x = AckRequest{}
+	mi := &file_offsets_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckRequest) ProtoMessage() {}
+
+func (x *AckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_offsets_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckRequest.ProtoReflect.Descriptor instead.
+func (*AckRequest) Descriptor() ([]byte, []int) {
+	return file_offsets_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AckRequest) GetPartition() int32 {
+	if x != nil {
+		return x.Partition
+	}
+	return 0
+}
+
+func (x *AckRequest) GetOffsets() []int64 {
+	if x != nil {
+		return x.Offsets
+	}
+	return nil
+}
+
+type AckResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckResponse) Reset() {
+	*x = AckResponse{}
+	mi := &file_offsets_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckResponse) ProtoMessage() {}
+
+func (x *AckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_offsets_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckResponse.ProtoReflect.Descriptor instead.
+func (*AckResponse) Descriptor() ([]byte, []int) {
+	return file_offsets_proto_rawDescGZIP(), []int{4}
+}
+
+type FlushRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Partitions    []int32                `protobuf:"varint,1,rep,packed,name=partitions,proto3" json:"partitions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlushRequest) Reset() {
+	*x = FlushRequest{}
+	mi := &file_offsets_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlushRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlushRequest) ProtoMessage() {}
+
+func (x *FlushRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_offsets_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlushRequest.ProtoReflect.Descriptor instead.
+func (*FlushRequest) Descriptor() ([]byte, []int) {
+	return file_offsets_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FlushRequest) GetPartitions() []int32 {
+	if x != nil {
+		return x.Partitions
+	}
+	return nil
+}
+
+type FlushResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlushResponse) Reset() {
+	*x = FlushResponse{}
+	mi := &file_offsets_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlushResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlushResponse) ProtoMessage() {}
+
+func (x *FlushResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_offsets_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlushResponse.ProtoReflect.Descriptor instead.
+func (*FlushResponse) Descriptor() ([]byte, []int) {
+	return file_offsets_proto_rawDescGZIP(), []int{6}
+}
+
+var File_offsets_proto protoreflect.FileDescriptor
+
+const file_offsets_proto_rawDesc = "" +
+	"\n" +
+	"\roffsets.proto\x12\n" +
+	"offsets.v1\"G\n" +
+	"\tWatermark\x12\x1c\n" +
+	"\tpartition\x18\x01 \x01(\x05R\tpartition\x12\x1c\n" +
+	"\tcommitted\x18\x02 \x01(\x03R\tcommitted\"3\n" +
+	"\x11WatermarksRequest\x12\x1e\n" +
+	"\n" +
+	"partitions\x18\x01 \x03(\x05R\n" +
+	"partitions\"K\n" +
+	"\x12WatermarksResponse\x125\n" +
+	"\n" +
+	"watermarks\x18\x01 \x03(\v2\x15.offsets.v1.WatermarkR\n" +
+	"watermarks\"D\n" +
+	"\n" +
+	"AckRequest\x12\x1c\n" +
+	"\tpartition\x18\x01 \x01(\x05R\tpartition\x12\x18\n" +
+	"\aoffsets\x18\x02 \x03(\x03R\aoffsets\"\r\n" +
+	"\vAckResponse\".\n" +
+	"\fFlushRequest\x12\x1e\n" +
+	"\n" +
+	"partitions\x18\x01 \x03(\x05R\n" +
+	"partitions\"\x0f\n" +
+	"\rFlushResponse2\x9b\x02\n" +
+	"\aOffsets\x12N\n" +
+	"\rGetWatermarks\x12\x1d.offsets.v1.WatermarksRequest\x1a\x1e.offsets.v1.WatermarksResponse\x12J\n" +
+	"\x10StreamWatermarks\x12\x1d.offsets.v1.WatermarksRequest\x1a\x15.offsets.v1.Watermark0\x01\x126\n" +
+	"\x03Ack\x12\x16.offsets.v1.AckRequest\x1a\x17.offsets.v1.AckResponse\x12<\n" +
+	"\x05Flush\x12\x18.offsets.v1.FlushRequest\x1a\x19.offsets.v1.FlushResponseB0Z.github.com/ideasculptor/offsets_test/offsetspbb\x06proto3"
+
+var (
+	file_offsets_proto_rawDescOnce sync.Once
+	file_offsets_proto_rawDescData []byte
+)
+
+func file_offsets_proto_rawDescGZIP() []byte {
+	file_offsets_proto_rawDescOnce.Do(func() {
+		file_offsets_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_offsets_proto_rawDesc), len(file_offsets_proto_rawDesc)))
+	})
+	return file_offsets_proto_rawDescData
+}
+
+var file_offsets_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_offsets_proto_goTypes = []any{
+	(*Watermark)(nil),          // 0: offsets.v1.Watermark
+	(*WatermarksRequest)(nil),  // 1: offsets.v1.WatermarksRequest
+	(*WatermarksResponse)(nil), // 2: offsets.v1.WatermarksResponse
+	(*AckRequest)(nil),         // 3: offsets.v1.AckRequest
+	(*AckResponse)(nil),        // 4: offsets.v1.AckResponse
+	(*FlushRequest)(nil),       // 5: offsets.v1.FlushRequest
+	(*FlushResponse)(nil),      // 6: offsets.v1.FlushResponse
+}
+var file_offsets_proto_depIdxs = []int32{
+	0, // 0: offsets.v1.WatermarksResponse.watermarks:type_name -> offsets.v1.Watermark
+	1, // 1: offsets.v1.Offsets.GetWatermarks:input_type -> offsets.v1.WatermarksRequest
+	1, // 2: offsets.v1.Offsets.StreamWatermarks:input_type -> offsets.v1.WatermarksRequest
+	3, // 3: offsets.v1.Offsets.Ack:input_type -> offsets.v1.AckRequest
+	5, // 4: offsets.v1.Offsets.Flush:input_type -> offsets.v1.FlushRequest
+	2, // 5: offsets.v1.Offsets.GetWatermarks:output_type -> offsets.v1.WatermarksResponse
+	0, // 6: offsets.v1.Offsets.StreamWatermarks:output_type -> offsets.v1.Watermark
+	4, // 7: offsets.v1.Offsets.Ack:output_type -> offsets.v1.AckResponse
+	6, // 8: offsets.v1.Offsets.Flush:output_type -> offsets.v1.FlushResponse
+	5, // [5:9] is the sub-list for method output_type
+	1, // [1:5] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_offsets_proto_init() }
+func file_offsets_proto_init() {
+	if File_offsets_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_offsets_proto_rawDesc), len(file_offsets_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_offsets_proto_goTypes,
+		DependencyIndexes: file_offsets_proto_depIdxs,
+		MessageInfos:      file_offsets_proto_msgTypes,
+	}.Build()
+	File_offsets_proto = out.File
+	file_offsets_proto_goTypes = nil
+	file_offsets_proto_depIdxs = nil
+}
@@ -0,0 +1,239 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: offsets.proto
+
+package offsetspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Offsets_GetWatermarks_FullMethodName    = "/offsets.v1.Offsets/GetWatermarks"
+	Offsets_StreamWatermarks_FullMethodName = "/offsets.v1.Offsets/StreamWatermarks"
+	Offsets_Ack_FullMethodName              = "/offsets.v1.Offsets/Ack"
+	Offsets_Flush_FullMethodName            = "/offsets.v1.Offsets/Flush"
+)
+
+// OffsetsClient is the client API for Offsets service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OffsetsClient interface {
+	GetWatermarks(ctx context.Context, in *WatermarksRequest, opts ...grpc.CallOption) (*WatermarksResponse, error)
+	StreamWatermarks(ctx context.Context, in *WatermarksRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Watermark], error)
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error)
+	Flush(ctx context.Context, in *FlushRequest, opts ...grpc.CallOption) (*FlushResponse, error)
+}
+
+type offsetsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOffsetsClient(cc grpc.ClientConnInterface) OffsetsClient {
+	return &offsetsClient{cc}
+}
+
+func (c *offsetsClient) GetWatermarks(ctx context.Context, in *WatermarksRequest, opts ...grpc.CallOption) (*WatermarksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WatermarksResponse)
+	err := c.cc.Invoke(ctx, Offsets_GetWatermarks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *offsetsClient) StreamWatermarks(ctx context.Context, in *WatermarksRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Watermark], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Offsets_ServiceDesc.Streams[0], Offsets_StreamWatermarks_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatermarksRequest, Watermark]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Offsets_StreamWatermarksClient = grpc.ServerStreamingClient[Watermark]
+
+func (c *offsetsClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AckResponse)
+	err := c.cc.Invoke(ctx, Offsets_Ack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *offsetsClient) Flush(ctx context.Context, in *FlushRequest, opts ...grpc.CallOption) (*FlushResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FlushResponse)
+	err := c.cc.Invoke(ctx, Offsets_Flush_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// OffsetsServer is the server API for Offsets service.
+// All implementations must embed UnimplementedOffsetsServer
+// for forward compatibility.
+type OffsetsServer interface {
+	GetWatermarks(context.Context, *WatermarksRequest) (*WatermarksResponse, error)
+	StreamWatermarks(*WatermarksRequest, grpc.ServerStreamingServer[Watermark]) error
+	Ack(context.Context, *AckRequest) (*AckResponse, error)
+	Flush(context.Context, *FlushRequest) (*FlushResponse, error)
+	mustEmbedUnimplementedOffsetsServer()
+}
+
+// UnimplementedOffsetsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedOffsetsServer struct{}
+
+func (UnimplementedOffsetsServer) GetWatermarks(context.Context, *WatermarksRequest) (*WatermarksResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetWatermarks not implemented")
+}
+func (UnimplementedOffsetsServer) StreamWatermarks(*WatermarksRequest, grpc.ServerStreamingServer[Watermark]) error {
+	return status.Error(codes.Unimplemented, "method StreamWatermarks not implemented")
+}
+func (UnimplementedOffsetsServer) Ack(context.Context, *AckRequest) (*AckResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Ack not implemented")
+}
+func (UnimplementedOffsetsServer) Flush(context.Context, *FlushRequest) (*FlushResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Flush not implemented")
+}
+func (UnimplementedOffsetsServer) mustEmbedUnimplementedOffsetsServer() {}
+func (UnimplementedOffsetsServer) testEmbeddedByValue()                 {}
+
+// UnsafeOffsetsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OffsetsServer will
+// result in compilation errors.
+type UnsafeOffsetsServer interface {
+	mustEmbedUnimplementedOffsetsServer()
+}
+
+func RegisterOffsetsServer(s grpc.ServiceRegistrar, srv OffsetsServer) {
+	// If the following call panics, it indicates UnimplementedOffsetsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Offsets_ServiceDesc, srv)
+}
+
+func _Offsets_GetWatermarks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WatermarksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OffsetsServer).GetWatermarks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Offsets_GetWatermarks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OffsetsServer).GetWatermarks(ctx, req.(*WatermarksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Offsets_StreamWatermarks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatermarksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OffsetsServer).StreamWatermarks(m, &grpc.GenericServerStream[WatermarksRequest, Watermark]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Offsets_StreamWatermarksServer = grpc.ServerStreamingServer[Watermark]
+
+func _Offsets_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OffsetsServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Offsets_Ack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OffsetsServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Offsets_Flush_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FlushRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OffsetsServer).Flush(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Offsets_Flush_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OffsetsServer).Flush(ctx, req.(*FlushRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Offsets_ServiceDesc is the grpc.ServiceDesc for Offsets service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Offsets_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "offsets.v1.Offsets",
+	HandlerType: (*OffsetsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetWatermarks",
+			Handler:    _Offsets_GetWatermarks_Handler,
+		},
+		{
+			MethodName: "Ack",
+			Handler:    _Offsets_Ack_Handler,
+		},
+		{
+			MethodName: "Flush",
+			Handler:    _Offsets_Flush_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamWatermarks",
+			Handler:       _Offsets_StreamWatermarks_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "offsets.proto",
+}
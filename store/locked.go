@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// Locked is a File that processes on one host can share, like the old
+// and new deployment of a blue/green switch handing over the checkpoint.
+// Saves and loads hold an advisory lock on a file next to the snapshot,
+// and a save fails with ErrTakenOver if another process has saved since
+// this store last loaded or saved: whoever saved last owns the
+// checkpoint, and the process it was taken from learns so with its next
+// save instead of overwriting the newer one.
+type Locked struct {
+	File
+	mu sync.Mutex
+	// seen is the snapshot file as this store last left it, nil if
+	// there was none
+	seen os.FileInfo
+}
+
+// ErrTakenOver is returned by Locked.Save once another process has
+// saved the checkpoint
+var ErrTakenOver = errors.New("checkpoint taken over by another process")
+
+// NewLocked returns a Locked store for the snapshot at path
+func NewLocked(path string) *Locked {
+	return &Locked{File: File{Path: path}}
+}
+
+// Save implements Store, waiting for the lock doesn't heed ctx
+func (s *Locked) Save(ctx context.Context, snap tracker.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.Path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fi, err := s.stat()
+	if err != nil {
+		return err
+	}
+	// every save renames a new file into place, so it is the same file
+	// only if nobody has saved since
+	if fi != nil && (s.seen == nil || !os.SameFile(fi, s.seen)) {
+		return fmt.Errorf("%s: %w", s.Path, ErrTakenOver)
+	}
+	if err := s.File.Save(ctx, snap); err != nil {
+		return err
+	}
+	s.seen, err = s.stat()
+	return err
+}
+
+func (s *Locked) Load(ctx context.Context) (tracker.Snapshot, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := lockFile(s.Path+".lock", false)
+	if err != nil {
+		return tracker.Snapshot{}, false, err
+	}
+	defer unlock()
+	snap, ok, err := s.File.Load(ctx)
+	if err != nil {
+		return snap, ok, err
+	}
+	s.seen, err = s.stat()
+	return snap, ok, err
+}
+
+// stat returns the snapshot file's info, nil if there is none
+func (s *Locked) stat() (os.FileInfo, error) {
+	fi, err := os.Stat(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return fi, err
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// The outbox pattern keeps the watermark in the application's own
+// database, written in the same transaction as the results of the
+// messages it covers, so the two are never apart: a crash either loses
+// both or neither.  The broker commit comes after the transaction and may
+// be lost, so on startup Reconcile goes by the database and puts the
+// broker right.  It is for sinks that can't take part in Kafka
+// transactions.
+
+// Execer is what Outbox.Save writes with, a *sql.Tx or a *sql.DB
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Querier is what Outbox.Load reads with, a *sql.Tx or a *sql.DB
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Outbox keeps the snapshots of partitions in a table of the
+// application's database, one row each:
+//
+//	CREATE TABLE offsets (
+//		partition_id INTEGER PRIMARY KEY,
+//		committed    BIGINT NOT NULL,
+//		snapshot     TEXT NOT NULL
+//	)
+//
+// committed is the watermark, for queries, and snapshot the whole
+// snapshot as JSON, as File keeps it.
+type Outbox struct {
+	// Table is the name of the table, it goes into the statements as
+	// it is
+	Table     string
+	Partition int32
+	// Numbered uses $1-style placeholders, as Postgres wants, instead
+	// of ?
+	Numbered bool
+}
+
+// Save writes s in tx, which should be the transaction that writes the
+// results of the offsets s covers.  It updates the partition's row, or
+// inserts it if there is none, which works on any database without an
+// upsert of its own.
+func (o Outbox) Save(ctx context.Context, tx Execer, s tracker.Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET committed = %s, snapshot = %s WHERE partition_id = %s",
+		o.Table, o.arg(1), o.arg(2), o.arg(3)), s.Committed, string(data), o.Partition)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (partition_id, committed, snapshot) VALUES (%s, %s, %s)",
+		o.Table, o.arg(1), o.arg(2), o.arg(3)), o.Partition, s.Committed, string(data))
+	return err
+}
+
+// Load returns the partition's snapshot, ok is false if it has no row
+func (o Outbox) Load(ctx context.Context, q Querier) (tracker.Snapshot, bool, error) {
+	var s tracker.Snapshot
+	var data string
+	err := q.QueryRowContext(ctx, fmt.Sprintf("SELECT snapshot FROM %s WHERE partition_id = %s", o.Table, o.arg(1)), o.Partition).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return s, false, fmt.Errorf("%s partition %d: %w: %w", o.Table, o.Partition, ErrCorruptSnapshot, err)
+	}
+	if err := checkSnapshot(s); err != nil {
+		return s, false, fmt.Errorf("%s partition %d: %w", o.Table, o.Partition, err)
+	}
+	return s, true, nil
+}
+
+// Reconcile returns the snapshot a consumer of the partition starts from,
+// given the offset the broker has committed.  The database is the truth:
+// a broker behind it missed the commit after the last transaction and is
+// committed to catch up with commit, a broker ahead of it has offsets
+// whose results were rolled back, and the consumer has to go back for
+// them.  Only a partition the database has never seen starts from the
+// broker.
+func (o Outbox) Reconcile(ctx context.Context, q Querier, brokerCommitted int64, commit func(ctx context.Context, offset int64) error) (tracker.Snapshot, error) {
+	s, ok, err := o.Load(ctx, q)
+	if err != nil {
+		return s, err
+	}
+	if !ok {
+		return tracker.Snapshot{Committed: brokerCommitted}, nil
+	}
+	if s.Committed > brokerCommitted {
+		if err := commit(ctx, s.Committed); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// arg is the nth placeholder, counting from 1
+func (o Outbox) arg(n int) string {
+	if o.Numbered {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
@@ -0,0 +1,97 @@
+// Package store keeps tracker snapshots somewhere a restarted consumer can
+// find them.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// Store persists tracker snapshots so a restarted consumer can carry on
+// without reprocessing what it had already acked.
+type Store interface {
+	// Save persists the snapshot, giving up with ctx's error if it is
+	// done before the snapshot is written
+	Save(ctx context.Context, s tracker.Snapshot) error
+	// Load returns the last saved snapshot, ok is false if nothing has
+	// been saved yet
+	Load(ctx context.Context) (s tracker.Snapshot, ok bool, err error)
+}
+
+// File keeps the snapshot as JSON in the single file at Path
+type File struct {
+	Path string
+}
+
+// Save writes the snapshot to a temporary file and renames it over the
+// old one, so a crash mid-write never leaves a truncated snapshot behind.
+func (f File) Save(ctx context.Context, s tracker.Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(tmp).Encode(s); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// the old snapshot stays if ctx ran out while this one was written
+	if err := ctx.Err(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+func (f File) Load(ctx context.Context) (tracker.Snapshot, bool, error) {
+	var s tracker.Snapshot
+	if err := ctx.Err(); err != nil {
+		return s, false, err
+	}
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, false, fmt.Errorf("%s: %w: %w", f.Path, ErrCorruptSnapshot, err)
+	}
+	if err := checkSnapshot(s); err != nil {
+		return s, false, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return s, true, nil
+}
+
+// ErrCorruptSnapshot is returned by Load for a snapshot that can't be read
+// or isn't one Snapshot could have taken
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// checkSnapshot returns ErrCorruptSnapshot unless the ranges of s are
+// sorted, apart and above the watermark, as Tracker.Snapshot leaves them
+func checkSnapshot(s tracker.Snapshot) error {
+	for _, ranges := range [][]tracker.Range{s.Pending, s.Holes} {
+		last := s.Committed
+		for _, r := range ranges {
+			if r.From > r.To || r.From <= last {
+				return fmt.Errorf("%w: range %d-%d out of place", ErrCorruptSnapshot, r.From, r.To)
+			}
+			last = r.To
+		}
+	}
+	return nil
+}
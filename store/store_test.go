@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// TestCorruptSnapshot refuses snapshots that are garbage or that no
+// tracker could have taken
+func TestCorruptSnapshot(t *testing.T) {
+	for name, data := range map[string]string{
+		"truncated":   `{"committed": 10, "pend`,
+		"overlapping": `{"committed": 10, "pending": [{"from": 12, "to": 15}, {"from": 14, "to": 20}]}`,
+		"below":       `{"committed": 10, "holes": [{"from": 5, "to": 6}]}`,
+	} {
+		s := File{Path: filepath.Join(t.TempDir(), "snapshot.json")}
+		if err := os.WriteFile(s.Path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Load(context.Background()); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("%s: Load = %v, want ErrCorruptSnapshot", name, err)
+		}
+	}
+}
+
+// TestLockedStoreHandoff hands a checkpoint from one process's store to
+// another's, after which the old one may no longer save
+func TestLockedStoreHandoff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	blue, green := NewLocked(path), NewLocked(path)
+	if _, ok, err := blue.Load(context.Background()); ok || err != nil {
+		t.Fatalf("blue loaded %v, %v from nothing", ok, err)
+	}
+	if err := blue.Save(context.Background(), tracker.Snapshot{Committed: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blue.Save(context.Background(), tracker.Snapshot{Committed: 20}); err != nil {
+		t.Fatal(err)
+	}
+	snap, ok, err := green.Load(context.Background())
+	if err != nil || !ok || snap.Committed != 20 {
+		t.Fatalf("green loaded %+v, %v, %v, want blue's last", snap, ok, err)
+	}
+	if err := green.Save(context.Background(), tracker.Snapshot{Committed: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blue.Save(context.Background(), tracker.Snapshot{Committed: 25}); !errors.Is(err, ErrTakenOver) {
+		t.Errorf("blue saved after the handoff: %v", err)
+	}
+	if snap, _, _ := green.Load(context.Background()); snap.Committed != 30 {
+		t.Errorf("checkpoint at %d, want green's 30", snap.Committed)
+	}
+}
+
+// fakeDB is a database/sql driver that knows just the statements of an
+// Outbox, with the rows kept by partition.  A transaction works on a copy
+// of them that its commit puts back.
+type fakeDB struct {
+	mu   sync.Mutex
+	rows map[int64][2]driver.Value
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	db *fakeDB
+	// tx is the copy a transaction works on, nil outside of one
+	tx map[int64][2]driver.Value
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.tx = make(map[int64][2]driver.Value)
+	for k, v := range c.db.rows {
+		c.tx[k] = v
+	}
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.rows, c.tx = c.tx, nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.tx = nil
+	return nil
+}
+
+// with runs f on the rows c sees
+func (c *fakeConn) with(f func(rows map[int64][2]driver.Value)) {
+	if c.tx != nil {
+		f(c.tx)
+		return
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	f(c.db.rows)
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var n int64
+	c.with(func(rows map[int64][2]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "UPDATE"):
+			if _, ok := rows[args[2].Value.(int64)]; ok {
+				rows[args[2].Value.(int64)] = [2]driver.Value{args[0].Value, args[1].Value}
+				n = 1
+			}
+		case strings.HasPrefix(query, "INSERT"):
+			rows[args[0].Value.(int64)] = [2]driver.Value{args[1].Value, args[2].Value}
+			n = 1
+		}
+	})
+	return driver.RowsAffected(n), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r := &fakeRows{}
+	c.with(func(rows map[int64][2]driver.Value) {
+		if row, ok := rows[args[0].Value.(int64)]; ok {
+			r.snapshot = []driver.Value{row[1]}
+		}
+	})
+	return r, nil
+}
+
+// fakeRows is the snapshot column of at most one row
+type fakeRows struct {
+	snapshot []driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"snapshot"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.snapshot == nil {
+		return io.EOF
+	}
+	copy(dest, r.snapshot)
+	r.snapshot = nil
+	return nil
+}
+
+// TestOutbox saves snapshots in transactions and reconciles the broker
+// with them: a broker behind is committed to, one ahead is overruled
+func TestOutbox(t *testing.T) {
+	db := sql.OpenDB(&fakeDB{rows: make(map[int64][2]driver.Value)})
+	defer db.Close()
+	ctx := context.Background()
+	o := Outbox{Table: "offsets", Partition: 3}
+	commit := func(context.Context, int64) error {
+		t.Error("committed to a broker that needs nothing")
+		return nil
+	}
+	if s, err := o.Reconcile(ctx, db, 5, commit); err != nil || s.Committed != 5 {
+		t.Fatalf("reconciled %+v, %v without a row, want the broker's 5", s, err)
+	}
+	for _, s := range []tracker.Snapshot{{Committed: 10}, {Committed: 20, Pending: []tracker.Range{{From: 22, To: 23}}}} {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := o.Save(ctx, tx, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a transaction that rolls back takes its watermark with it
+	tx, _ := db.BeginTx(ctx, nil)
+	if err := o.Save(ctx, tx, tracker.Snapshot{Committed: 30}); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	want := tracker.Snapshot{Committed: 20, Pending: []tracker.Range{{From: 22, To: 23}}}
+	var committed []int64
+	s, err := o.Reconcile(ctx, db, 15, func(_ context.Context, offset int64) error {
+		committed = append(committed, offset)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(s, want) || !reflect.DeepEqual(committed, []int64{20}) {
+		t.Errorf("reconciled %+v, %v, committed %v, want %+v and the broker caught up", s, err, committed, want)
+	}
+	if s, err := o.Reconcile(ctx, db, 25, commit); err != nil || s.Committed != 20 {
+		t.Errorf("reconciled %+v, %v with the broker ahead, want the database's 20", s, err)
+	}
+}
@@ -0,0 +1,95 @@
+package tracker
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A real partition has offsets that are never delivered to the consumer:
+// transaction markers, and the gaps left behind by log compaction.  Without
+// being told, the tracker would wait for them forever.  The adapter knows
+// about them from what it fetches and declares them with Absent, the
+// watermark then steps over them as if they had been acked.
+
+// Absent declares that the offsets in r will never be delivered.  Offsets
+// at or below the watermark are ignored, and acks that turn up for the rest
+// count as duplicates.  It is an error to declare an offset that has
+// already been acked.  Like Ack it must be called from the acking
+// goroutine.
+func (t *Tracker) Absent(r Range) error {
+	if invariants {
+		defer t.checkInvariants("Absent")
+	}
+	c := t.watermark()
+	if r.From <= c {
+		r.From = c + 1
+	}
+	if r.From > r.To {
+		return nil
+	}
+	if t.pending.Len() > 0 {
+		for o := r.From; o <= r.To; o++ {
+			if t.pending.Has(o) {
+				return fmt.Errorf("offset %d was acked, it can't be absent", o)
+			}
+		}
+	}
+	t.holes = addRange(t.holes, r)
+	if r.From == c+1 {
+		t.setCommitted(t.advance(c+1) - 1)
+	}
+	return nil
+}
+
+// addRange inserts r into the sorted ranges, merging it with any it
+// overlaps or touches
+func addRange(ranges []Range, r Range) []Range {
+	// the first range that ends at or after r.From-1 is the first
+	// that could merge with r
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].To >= r.From-1 })
+	j := i
+	for j < len(ranges) && ranges[j].From <= r.To+1 {
+		if ranges[j].From < r.From {
+			r.From = ranges[j].From
+		}
+		if ranges[j].To > r.To {
+			r.To = ranges[j].To
+		}
+		j++
+	}
+	if i == j {
+		ranges = append(ranges, Range{})
+		copy(ranges[i+1:], ranges[i:])
+		ranges[i] = r
+		return ranges
+	}
+	ranges[i] = r
+	return append(ranges[:i+1], ranges[j:]...)
+}
+
+// advance is pending.advance that also steps over holes
+func (t *Tracker) advance(next int64) int64 {
+	next = t.pending.Advance(next)
+	for len(t.holes) > 0 && t.holes[0].From <= next {
+		if t.holes[0].To >= next {
+			next = t.pending.Advance(t.holes[0].To + 1)
+			if next-1 > t.highest {
+				t.highest = next - 1
+			}
+		}
+		t.holes = t.holes[1:]
+	}
+	return next
+}
+
+// inHole reports whether offset was declared absent
+func (t *Tracker) inHole(offset int64) bool {
+	i := sort.Search(len(t.holes), func(i int) bool { return t.holes[i].To >= offset })
+	return i < len(t.holes) && t.holes[i].From <= offset
+}
+
+// Holes returns the ranges declared absent that are still above the
+// watermark.  Like Ack it must be called from the acking goroutine.
+func (t *Tracker) Holes() []Range {
+	return t.holes
+}
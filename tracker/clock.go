@@ -0,0 +1,189 @@
+package tracker
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it, so that anything timed can be
+// tested without waiting for it.  The tracker and the adapter's committer
+// and brokers take one, nil means RealClock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer and NewTicker are time's, on this clock
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a *time.Timer of some Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is a *time.Ticker of some Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// RealClock is the wall clock
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+func (t realTicker) Stop()                 { t.t.Stop() }
+
+// orReal returns c, or RealClock if c is nil
+func orReal(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+// FakeClock is a Clock that only moves when told to, its timers and
+// tickers fire as Advance passes them.  It is safe to use from any
+// goroutine.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	// added is signalled whenever a timer or ticker starts, for
+	// BlockUntil
+	added *sync.Cond
+}
+
+// fakeWaiter is a timer, or a ticker if period is set
+type fakeWaiter struct {
+	clock  *FakeClock
+	c      chan time.Time
+	at     time.Time
+	period time.Duration
+}
+
+// NewFakeClock returns a fake clock that reads now until it is advanced
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.added = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock d forward and fires whatever came due on the
+// way.  Like time's, a ticker that isn't read drops ticks instead of
+// queueing them.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			kept = append(kept, w)
+			continue
+		}
+		select {
+		case w.c <- c.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.at.After(c.now) {
+				w.at = w.at.Add(w.period)
+			}
+			kept = append(kept, w)
+		}
+	}
+	c.waiters = kept
+}
+
+// BlockUntil waits until n timers and tickers are waiting on the clock,
+// so a test knows the goroutine it is driving has got as far as its
+// next wait before it calls Advance
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.added.Wait()
+	}
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.start(d, 0)
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	return fakeTicker{c.start(d, d)}
+}
+
+// fakeTicker is a fakeWaiter with a period, its Stop has a ticker's
+// signature
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop() { t.fakeWaiter.Stop() }
+
+func (c *FakeClock) start(d, period time.Duration) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{clock: c, c: make(chan time.Time, 1), at: c.now.Add(d), period: period}
+	if d <= 0 {
+		w.c <- c.now
+		return w
+	}
+	c.waiters = append(c.waiters, w)
+	c.added.Broadcast()
+	return w
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.remove()
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	w.remove()
+	w.at, w.period = w.clock.now.Add(d), d
+	w.clock.waiters = append(w.clock.waiters, w)
+	w.clock.added.Broadcast()
+}
+
+// remove takes w off its clock and reports whether it was on it, the
+// clock must be locked
+func (w *fakeWaiter) remove() bool {
+	for i, o := range w.clock.waiters {
+		if o == w {
+			w.clock.waiters = append(w.clock.waiters[:i], w.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
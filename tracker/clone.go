@@ -0,0 +1,123 @@
+package tracker
+
+import (
+	"sync/atomic"
+
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+// Clone forks the tracker: the clone starts out with t's watermark,
+// pending offsets, absent ranges, holds and settings, callbacks and DLQ
+// included, and from then on the two go their own ways.  It is cheap, the
+// pending set is only copied by whichever of them changes it first, so a
+// test or a speculative pipeline can try a sequence of acks on a fork and
+// throw it away without the live watermark noticing.  Like Ack it must be
+// called from t's acking goroutine, the clone may be used from another.
+func (t *Tracker) Clone() *Tracker {
+	c := &Tracker{
+		committed:   t.watermark(),
+		duplicates:  t.Duplicates(),
+		generation:  t.Generation(),
+		Budget:      t.Budget,
+		MaxInFlight: t.MaxInFlight,
+		PendingHigh: t.PendingHigh,
+		LagHigh:     t.LagHigh,
+		Deadline:    t.Deadline,
+		StuckPolicy: t.StuckPolicy,
+		OnExpire:    t.OnExpire,
+		DLQ:         t.DLQ,
+		MaxLag:      t.MaxLag,
+		MaxStall:    t.MaxStall,
+		OnGapSkip:   t.OnGapSkip,
+		Clock:       t.Clock,
+		Hooks:       t.Hooks,
+		notified:    t.notified,
+		skipped:     append([]int64(nil), t.skipped...),
+		gaps:        append([]Range(nil), t.gaps...),
+		holes:       append([]Range(nil), t.holes...),
+		highest:     t.highest,
+		start:       t.start,
+		checked:     t.checked,
+		pressured:   t.pressured,
+		pressure:    make(chan PressureEvent, 1),
+
+		advancedFrom: t.advancedFrom,
+		stalled:      t.stalled,
+		acks:         t.acks,
+		heldSince:    t.heldSince,
+		holds:        append([]*Hold(nil), t.holds...),
+		holdAt:       atomic.LoadInt64(&t.holdAt),
+		events:       append([]observed(nil), t.events...),
+		lastObserved: t.lastObserved,
+		latestEvent:  t.latestEvent,
+	}
+	if t.Seen != nil {
+		c.Seen = t.Seen.Clone()
+	}
+	if len(t.meta) > 0 {
+		c.meta = make(map[int64]interface{}, len(t.meta))
+		for o, meta := range t.meta {
+			c.meta[o] = meta
+		}
+	}
+	cow, ok := t.pending.(*cowBackend)
+	if !ok {
+		cow = &cowBackend{Backend: t.pending, owners: new(int32)}
+		*cow.owners = 1
+		t.pending = cow
+	}
+	atomic.AddInt32(cow.owners, 1)
+	c.pending = &cowBackend{Backend: cow.Backend, owners: cow.owners}
+	return c
+}
+
+// cowBackend shares a backend between the trackers Clone made of each
+// other until one of them writes to it, which makes it a copy of its own
+// first.  A tracker that is the last owner writes in place.
+type cowBackend struct {
+	backend.Backend
+	// owners counts the cowBackends sharing backend, it is accessed
+	// atomically as they may belong to trackers on different goroutines
+	owners *int32
+}
+
+// own makes sure the backend isn't shared before it is written to
+func (c *cowBackend) own() {
+	if atomic.LoadInt32(c.owners) == 1 {
+		return
+	}
+	b := c.Backend.Clone()
+	atomic.AddInt32(c.owners, -1)
+	c.Backend, c.owners = b, new(int32)
+	*c.owners = 1
+}
+
+func (c *cowBackend) Add(offset int64) bool {
+	// a redelivery changes nothing, so it needn't copy
+	if c.Backend.Has(offset) {
+		return false
+	}
+	c.own()
+	return c.Backend.Add(offset)
+}
+
+func (c *cowBackend) Advance(next int64) int64 {
+	if !c.Backend.Has(next) {
+		return next
+	}
+	c.own()
+	return c.Backend.Advance(next)
+}
+
+func (c *cowBackend) Compact() bool {
+	comp, ok := c.Backend.(backend.Compacter)
+	if !ok {
+		return false
+	}
+	c.own()
+	return comp.Compact()
+}
+
+func (c *cowBackend) Clone() backend.Backend {
+	return c.Backend.Clone()
+}
@@ -0,0 +1,100 @@
+package tracker
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// formatRange renders r as "from-to", or just the offset for a single
+// one.  It isn't Range's String so that %v keeps printing ranges as the
+// pairs they are.
+func formatRange(r Range) string {
+	if r.From == r.To {
+		return fmt.Sprint(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// maxStringRanges is how many pending ranges String lists before it only
+// counts the rest
+const maxStringRanges = 8
+
+// String renders the watermark and the pending ranges on one line, e.g.
+// "committed=1040 pending=[1042-1044, 1100]", for logs and test
+// failures.  Like Ack it must be called from the acking goroutine.
+func (t *Tracker) String() string {
+	snap := t.Snapshot()
+	var b strings.Builder
+	fmt.Fprintf(&b, "committed=%d pending=[", snap.Committed)
+	writeRanges(&b, snap.Pending, maxStringRanges)
+	b.WriteString("]")
+	return b.String()
+}
+
+// writeRanges writes up to limit of ranges comma separated, and how many
+// were left out, limit zero means all of them
+func writeRanges(w io.Writer, ranges []Range, limit int) {
+	for i, r := range ranges {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(w, ", … %d more", len(ranges)-limit)
+			return
+		}
+		if i > 0 {
+			io.WriteString(w, ", ")
+		}
+		io.WriteString(w, formatRange(r))
+	}
+}
+
+// DebugDump writes everything the tracker knows, a line per item with
+// every range in full, for the moment String's summary isn't enough to
+// see why the watermark is stuck.  Like Ack it must be called from the
+// acking goroutine.
+func (t *Tracker) DebugDump(w io.Writer) {
+	snap := t.Snapshot()
+	stats := t.Stats()
+	fmt.Fprintf(w, "committed %d, highest acked %d, generation %d\n", snap.Committed, stats.Highest, t.Generation())
+	fmt.Fprintf(w, "%d acks, %d duplicates, %d pending\n", stats.Acks, stats.Duplicates, stats.Pending)
+	if at, ok := t.HeldAt(); ok {
+		fmt.Fprintf(w, "held at %d by %d holds, the acks are at %d\n", at, len(t.holds), t.watermark())
+	}
+	list := func(name string, ranges []Range) {
+		fmt.Fprintf(w, "%s (%d): ", name, len(ranges))
+		if len(ranges) == 0 {
+			io.WriteString(w, "none")
+		}
+		writeRanges(w, ranges, 0)
+		io.WriteString(w, "\n")
+	}
+	// the gaps are what the watermark is waiting on, the pending ranges
+	// what is waiting on them
+	gaps, _ := snap.Gaps(math.MaxInt)
+	list("gaps", gaps)
+	if len(gaps) > 0 {
+		fmt.Fprintf(w, "waiting at %s for %v", formatRange(gaps[0]), stats.OldestGapAge.Round(time.Millisecond))
+		// the offset right behind the gap, by whoever acked it
+		if meta, ok := t.meta[gaps[0].To+1]; ok {
+			fmt.Fprintf(w, ", %d behind it: %v", gaps[0].To+1, meta)
+		}
+		io.WriteString(w, "\n")
+	}
+	list("pending", snap.Pending)
+	if len(t.meta) > 0 {
+		offsets := make([]int64, 0, len(t.meta))
+		for o := range t.meta {
+			offsets = append(offsets, o)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		fmt.Fprintf(w, "metadata (%d):\n", len(offsets))
+		for _, o := range offsets {
+			fmt.Fprintf(w, "  %d: %v\n", o, t.meta[o])
+		}
+	}
+	list("absent", snap.Holes)
+	list("skipped for lag or stall", t.gaps)
+	list("given up on", toRanges(append([]int64(nil), t.skipped...)))
+}
@@ -0,0 +1,61 @@
+package tracker
+
+import "fmt"
+
+// DeadLetter identifies a message the consumer gave up on
+type DeadLetter struct {
+	Offset int64
+	// Reason is ReasonNack or ReasonDeadline
+	Reason string
+	// Payload is whatever was passed to Nack, typically a reference to
+	// the message rather than the message itself.  It is nil for
+	// expired offsets.
+	Payload interface{}
+}
+
+const (
+	ReasonNack     = "nack"
+	ReasonDeadline = "deadline"
+)
+
+// DeadLetterProducer publishes dead letters, in real life to a DLQ topic.
+// Publish is called from the acking goroutine and the watermark doesn't
+// move past the offset until it returns nil.
+type DeadLetterProducer interface {
+	Publish(DeadLetter) error
+}
+
+// Nack marks offset as failed for good.  It is published to the DLQ, if
+// there is one, and then treated as acked and recorded in Skipped.  If
+// publishing fails the error is returned and the offset stays unacked, so
+// the watermark can't move past a message that was neither processed nor
+// dead lettered.  Like Ack it must be called from the acking goroutine,
+// and it may return ErrTryAgain for the same reasons.
+func (t *Tracker) Nack(offset int64, payload interface{}) error {
+	if invariants {
+		defer t.checkInvariants("Nack")
+	}
+	c := t.watermark()
+	// an offset that is already acked or absent was never failed, so it
+	// is a duplicate like any other and isn't dead lettered
+	if offset <= c || t.pending.Has(offset) || len(t.holes) > 0 && t.inHole(offset) {
+		return t.Ack(offset)
+	}
+	if offset != c+1 {
+		// check before publishing so a refused nack isn't published
+		// again when it is retried
+		if err := t.admit(offset, c); err != nil {
+			return err
+		}
+	}
+	if t.DLQ != nil {
+		if err := t.DLQ.Publish(DeadLetter{Offset: offset, Reason: ReasonNack, Payload: payload}); err != nil {
+			return fmt.Errorf("dead lettering offset %d: %w", offset, err)
+		}
+	}
+	if err := t.Ack(offset); err != nil {
+		return err
+	}
+	t.skipped = append(t.skipped, offset)
+	return nil
+}
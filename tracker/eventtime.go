@@ -0,0 +1,76 @@
+package tracker
+
+import "time"
+
+// Windowed aggregations need to know when a window is complete, which the
+// offsets can't tell them: messages are processed out of order, and their
+// event times aren't in offset order either.  A tracker told the event
+// time of every message as it is delivered keeps the event-time
+// watermark: every message observed with an event time before it has
+// been committed.  A message delivered later may still be older, late
+// data is up to the application.
+
+// observed is a message Observe was told about
+type observed struct {
+	offset int64
+	at     time.Time
+}
+
+// Observe records the event time of the message at offset as it is
+// delivered, before it is processed.  Messages must be observed in offset
+// order, as a partition delivers them, a redelivery of one already
+// observed is ignored.  Like Ack it must be called from the acking
+// goroutine.
+func (t *Tracker) Observe(offset int64, eventTime time.Time) {
+	if offset <= t.lastObserved || offset <= t.Committed() {
+		return
+	}
+	t.lastObserved = offset
+	if eventTime.After(t.latestEvent) {
+		t.latestEvent = eventTime
+	}
+	t.dropCommitted()
+	// the ones behind that aren't any earlier can never be the minimum,
+	// this one outlasts them
+	for n := len(t.events); n > 0 && !t.events[n-1].at.Before(eventTime); n-- {
+		t.events = t.events[:n-1]
+	}
+	t.events = append(t.events, observed{offset: offset, at: eventTime})
+}
+
+// EventTime returns the event-time watermark: the earliest event time of
+// the messages observed above the watermark, or the latest of them all if
+// they have all been committed.  With nothing observed it is the zero
+// time.  Messages past the watermark hold it back until it passes them,
+// acked or not, which keeps it in step with what a restart replays.  Like
+// Ack it must be called from the acking goroutine.
+func (t *Tracker) EventTime() time.Time {
+	t.dropCommitted()
+	if len(t.events) > 0 {
+		return t.events[0].at
+	}
+	return t.latestEvent
+}
+
+// dropCommitted forgets the observed messages the watermark has passed
+func (t *Tracker) dropCommitted() {
+	c := t.Committed()
+	i := 0
+	for i < len(t.events) && t.events[i].offset <= c {
+		i++
+	}
+	if i == len(t.events) {
+		// start over at the front of the array rather than creep along it
+		t.events = t.events[:0]
+		return
+	}
+	t.events = t.events[i:]
+}
+
+// resetEvents forgets what Observe was told, for a watermark that moves
+// back to committed and has the messages above it delivered again
+func (t *Tracker) resetEvents(committed int64) {
+	t.events = t.events[:0]
+	t.lastObserved = committed
+	t.latestEvent = time.Time{}
+}
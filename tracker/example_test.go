@@ -0,0 +1,47 @@
+package tracker_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ideasculptor/offsets_test/backend"
+	"github.com/ideasculptor/offsets_test/tracker"
+)
+
+// Acks arrive in whatever order the messages finished, the watermark only
+// moves past offsets once everything below them is acked
+func ExampleTracker() {
+	t := tracker.New(backend.NewMap(0), -1)
+	for _, offset := range []int64{1, 2, 0, 4} {
+		if err := t.Ack(offset); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("acked %d, watermark %d, %d pending\n", offset, t.Committed(), t.Pending())
+	}
+	// Output:
+	// acked 1, watermark -1, 1 pending
+	// acked 2, watermark -1, 2 pending
+	// acked 0, watermark 2, 0 pending
+	// acked 4, watermark 2, 1 pending
+}
+
+// A snapshot carries the acks above the watermark across a restart, so
+// they needn't be processed again
+func ExampleTracker_snapshot() {
+	t := tracker.New(backend.NewMap(0), 9)
+	for _, offset := range []int64{10, 12, 13, 15} {
+		t.Ack(offset)
+	}
+	data, _ := json.Marshal(t.Snapshot())
+	fmt.Println(string(data))
+
+	var s tracker.Snapshot
+	json.Unmarshal(data, &s)
+	restored := tracker.Restore(backend.NewMap(0), s)
+	restored.Ack(11)
+	fmt.Println(restored.Committed())
+	// Output:
+	// {"committed":10,"pending":[{"from":12,"to":13},{"from":15,"to":15}]}
+	// 13
+}
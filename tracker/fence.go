@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// After a rebalance the old owner of a partition can carry on for a while
+// without knowing it lost it, acking and committing over the new owner.
+// Generations fence it off: every assignment gets a higher one, and acks
+// and commits made for an older one are refused.
+
+// ErrStaleGeneration is what every *StaleGenerationError is to errors.Is
+var ErrStaleGeneration = errors.New("stale generation")
+
+// StaleGenerationError is returned for acks and commits made for a
+// generation that a newer one has fenced off
+type StaleGenerationError struct {
+	// Generation is the one the ack or commit was made for, Current the
+	// newest seen
+	Generation, Current int64
+}
+
+func (e *StaleGenerationError) Error() string {
+	return fmt.Sprintf("generation %d is stale, the current one is %d", e.Generation, e.Current)
+}
+
+func (e *StaleGenerationError) Is(target error) bool { return target == ErrStaleGeneration }
+
+// Generation returns the tracker's generation, zero until Fence is called
+func (t *Tracker) Generation() int64 {
+	return atomic.LoadInt64(&t.generation)
+}
+
+// Fence moves the tracker on to generation, after which AckGeneration
+// refuses acks for any older one.  Going back to an older generation fails
+// with a *StaleGenerationError.  It is safe to call from any goroutine.
+func (t *Tracker) Fence(generation int64) error {
+	for {
+		cur := atomic.LoadInt64(&t.generation)
+		if generation < cur {
+			return &StaleGenerationError{Generation: generation, Current: cur}
+		}
+		if atomic.CompareAndSwapInt64(&t.generation, cur, generation) {
+			return nil
+		}
+	}
+}
+
+// AckGeneration is Ack for an offset processed under generation.  An ack
+// for an older generation fails with a *StaleGenerationError and changes
+// nothing, one for a newer generation fences the tracker first.
+func (t *Tracker) AckGeneration(generation, offset int64) error {
+	if err := t.Fence(generation); err != nil {
+		return err
+	}
+	return t.Ack(offset)
+}
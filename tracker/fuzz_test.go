@@ -0,0 +1,114 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+// FuzzTracker runs the tracker through the operations encoded in data, two
+// bytes each: an opcode and an offset.  Along the way it checks the
+// watermark against a model and the snapshot for overlapping or stale
+// ranges.  Run it with
+//
+//	go test -fuzz FuzzTracker
+func FuzzTracker(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 2, 0, 1})
+	f.Add([]byte{0, 5, 3, 2, 0, 0, 2, 0, 0, 1})
+	f.Add([]byte{1, 0, 0, 3, 2, 0, 0, 1, 0, 2})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, name := range backend.Names() {
+			fuzzTracker(t, name, data)
+		}
+	})
+}
+
+func fuzzTracker(t *testing.T, name string, data []byte) {
+	b, _ := backend.New(name, 0)
+	tracker := New(b, -1)
+	// acked holds the offsets acked or nacked, absent those declared
+	// absent
+	acked, absent := make(map[int64]bool), make(map[int64]bool)
+	done := func(o int64) bool { return acked[o] || absent[o] }
+	last := int64(-1)
+	for len(data) >= 2 {
+		op, offset := data[0]%4, int64(data[1])
+		data = data[2:]
+		switch op {
+		case 0:
+			if err := tracker.Ack(offset); err != nil {
+				t.Fatalf("%s: ack %d: %v", name, offset, err)
+			}
+			acked[offset] = true
+		case 1:
+			if err := tracker.Nack(offset, nil); err != nil {
+				t.Fatalf("%s: nack %d: %v", name, offset, err)
+			}
+			acked[offset] = true
+		case 2:
+			// a restart from the snapshot into a fresh backend
+			b, _ := backend.New(name, 0)
+			tracker = Restore(b, tracker.Snapshot())
+		case 3:
+			// the next byte, if any, is the length of the range
+			r := Range{From: offset, To: offset}
+			if len(data) > 0 {
+				r.To += int64(data[0] % 8)
+				data = data[1:]
+			}
+			clash := false
+			for o := r.From; o <= r.To; o++ {
+				if o > last && acked[o] && !absent[o] {
+					clash = true
+				}
+			}
+			err := tracker.Absent(r)
+			if clash != (err != nil) {
+				t.Fatalf("%s: absent %v: got error %v, want one: %v", name, r, err, clash)
+			}
+			if err == nil {
+				for o := r.From; o <= r.To; o++ {
+					if o > last && !acked[o] {
+						absent[o] = true
+					}
+				}
+			}
+		}
+
+		c := tracker.Committed()
+		if c < last {
+			t.Fatalf("%s: watermark went back from %d to %d", name, last, c)
+		}
+		want := last
+		for done(want + 1) {
+			want++
+		}
+		if c != want {
+			t.Fatalf("%s: watermark %d, want %d", name, c, want)
+		}
+		last = c
+		checkSnapshot(t, name, tracker.Snapshot())
+	}
+}
+
+// checkSnapshot fails if s has ranges at or below the watermark, ranges out
+// of order or overlapping, or offsets both pending and absent
+func checkSnapshot(t *testing.T, name string, s Snapshot) {
+	t.Helper()
+	for _, ranges := range [][]Range{s.Pending, s.Holes} {
+		prev := s.Committed
+		for _, r := range ranges {
+			if r.From <= prev || r.To < r.From {
+				t.Fatalf("%s: bad range %v after %d in %+v", name, r, prev, s)
+			}
+			prev = r.To
+		}
+	}
+	for _, p := range s.Pending {
+		for _, h := range s.Holes {
+			if p.From <= h.To && h.From <= p.To {
+				t.Fatalf("%s: %v is both pending and absent (%v) in %+v", name, p, h, s)
+			}
+		}
+	}
+}
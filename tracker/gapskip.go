@@ -0,0 +1,54 @@
+package tracker
+
+import "time"
+
+// Gap skipping is for consumers that would rather lose a few messages than
+// fall behind: instead of waiting for the offsets directly above the
+// watermark, the tracker gives up on them and moves the watermark through
+// the acks piled up behind.  An ack for a skipped offset that turns up
+// later counts as a duplicate.
+
+// skipGap gives up on the offsets between the watermark and the lowest
+// pending one, but none above limit, and returns them.  The pending set
+// must not be empty.
+func (t *Tracker) skipGap(limit int64) Range {
+	gap := Range{From: t.watermark() + 1, To: t.pending.Lowest() - 1}
+	if gap.To > limit {
+		gap.To = limit
+	}
+	t.gaps = append(t.gaps, gap)
+	if t.OnGapSkip != nil {
+		t.OnGapSkip(gap)
+	}
+	t.setCommitted(t.advance(gap.To+1) - 1)
+	return gap
+}
+
+// skipLagging skips just enough to bring the watermark within MaxLag of
+// the highest ack
+func (t *Tracker) skipLagging() {
+	for t.pending.Len() > 0 && t.highest-t.watermark() > t.MaxLag {
+		t.skipGap(t.highest - t.MaxLag)
+	}
+}
+
+// SkipStalled skips the gap the watermark is waiting on if it has been
+// waiting on it since before now-MaxStall, and reports whether it did.
+// Only a gap with acks piled up behind it is skipped.  Like Ack it must be called from the
+// acking goroutine, typically on a timer.
+func (t *Tracker) SkipStalled(now time.Time) bool {
+	if invariants {
+		defer t.checkInvariants("SkipStalled")
+	}
+	if t.MaxStall <= 0 || t.pending.Len() == 0 || now.Sub(t.heldSince) < t.MaxStall {
+		return false
+	}
+	t.skipGap(t.highest)
+	return true
+}
+
+// Gaps returns the ranges of offsets skipped for MaxLag or MaxStall, oldest
+// first.  Like Ack it must be called from the acking goroutine.
+func (t *Tracker) Gaps() []Range {
+	return t.gaps
+}
@@ -0,0 +1,77 @@
+package tracker
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Holds cap the watermark for applications that checkpoint state of their
+// own: hold the last offset a checkpoint covers while it is written, and
+// the watermark goes no further until the hold is released, however far
+// the acks have got by then.  Acks past a hold are tracked as usual, only
+// Committed and Snapshot don't show them, so releasing the hold moves the
+// watermark straight on to where the acks are.
+
+// noHold is holdAt while nothing is held
+const noHold = math.MaxInt64
+
+// Hold is a cap on the watermark, see Tracker.Hold
+type Hold struct {
+	offset int64
+}
+
+// Offset returns the highest the watermark may go while h is held
+func (h *Hold) Offset() int64 {
+	return h.offset
+}
+
+// Hold caps the watermark at offset until the hold it returns is
+// released.  There may be any number of holds, the lowest one wins, and
+// they hold against everything that moves the watermark, SeekTo and the
+// stuck and gap policies included.  Offset must not be below the
+// watermark, that fails with ErrOffsetBelowWatermark.  Like Ack it must be
+// called from the acking goroutine.
+func (t *Tracker) Hold(offset int64) (*Hold, error) {
+	if offset < t.Committed() {
+		return nil, ErrOffsetBelowWatermark
+	}
+	h := &Hold{offset: offset}
+	t.holds = append(t.holds, h)
+	t.setHoldAt()
+	return h, nil
+}
+
+// Release lifts h, a hold that has been released already is ignored.
+// Like Ack it must be called from the acking goroutine.
+func (t *Tracker) Release(h *Hold) {
+	for i, held := range t.holds {
+		if held == h {
+			t.holds = append(t.holds[:i], t.holds[i+1:]...)
+			t.setHoldAt()
+			return
+		}
+	}
+}
+
+// HeldAt returns the offset of the lowest hold, ok is false if there is
+// none.  It may be called from any goroutine.
+func (t *Tracker) HeldAt() (offset int64, ok bool) {
+	offset = atomic.LoadInt64(&t.holdAt)
+	return offset, offset != noHold
+}
+
+// setHoldAt publishes the lowest hold for Committed
+func (t *Tracker) setHoldAt() {
+	at := int64(noHold)
+	for _, h := range t.holds {
+		if h.offset < at {
+			at = h.offset
+		}
+	}
+	atomic.StoreInt64(&t.holdAt, at)
+}
+
+// watermark is the watermark as the acks have it, holds or not
+func (t *Tracker) watermark() int64 {
+	return atomic.LoadInt64(&t.committed)
+}
@@ -0,0 +1,77 @@
+package tracker
+
+import "time"
+
+// Hooks let an application act on the tracker's progress, e.g. to log,
+// alert or checkpoint, without wrapping its loops.  They are called from
+// the acking goroutine in the middle of an ack, so they must be quick and
+// must not call back into the tracker.  Any of them may be nil.
+type Hooks struct {
+	// OnAdvance is called with the watermark it was last called with and
+	// the new one, once the watermark has moved by AdvanceBy or more.
+	// Zero means on every move.
+	AdvanceBy int64
+	OnAdvance func(from, to int64)
+	// OnGapDetected is called when an ack lands above offsets that
+	// haven't been acked yet, with the range of them it opened up
+	OnGapDetected func(gap Range)
+	// OnStall is called by CheckStall once the watermark has waited on a
+	// gap with acks pending behind it for StallAfter, and not again until
+	// it has moved
+	StallAfter time.Duration
+	OnStall    func(committed int64, pending int, stuck time.Duration)
+}
+
+// advanced calls OnAdvance if the watermark has moved far enough, it is
+// called by setCommitted
+func (t *Tracker) advanced(committed int64) {
+	t.stalled = false
+	if committed < t.advancedFrom {
+		// a seek back starts the count over
+		t.advancedFrom = committed
+		return
+	}
+	if t.Hooks.OnAdvance == nil || committed == t.advancedFrom || committed-t.advancedFrom < t.Hooks.AdvanceBy {
+		return
+	}
+	from := t.advancedFrom
+	t.advancedFrom = committed
+	t.Hooks.OnAdvance(from, committed)
+}
+
+// opened calls OnGapDetected for the offsets between the highest ack so
+// far and offset, bar those declared absent
+func (t *Tracker) opened(offset int64) {
+	if t.Hooks.OnGapDetected == nil {
+		return
+	}
+	gap := Range{From: t.highest + 1, To: offset - 1}
+	for _, h := range t.holes {
+		if h.From <= gap.From && gap.From <= h.To {
+			gap.From = h.To + 1
+		}
+		if h.From <= gap.To && gap.To <= h.To {
+			gap.To = h.From - 1
+		}
+	}
+	if gap.From <= gap.To {
+		t.Hooks.OnGapDetected(gap)
+	}
+}
+
+// CheckStall calls OnStall if the watermark has been held up by a gap for
+// StallAfter as of now, and reports whether it did.  Like Ack it must be
+// called from the acking goroutine, typically on a timer.
+func (t *Tracker) CheckStall(now time.Time) bool {
+	h := t.Hooks
+	if h.OnStall == nil || h.StallAfter <= 0 || t.stalled || t.pending.Len() == 0 {
+		return false
+	}
+	stuck := now.Sub(t.heldSince)
+	if stuck < h.StallAfter {
+		return false
+	}
+	t.stalled = true
+	h.OnStall(t.watermark(), t.pending.Len(), stuck)
+	return true
+}
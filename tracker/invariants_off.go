@@ -0,0 +1,9 @@
+//go:build !invariants
+// +build !invariants
+
+package tracker
+
+// invariants is off, see invariants_on.go
+const invariants = false
+
+func (t *Tracker) checkInvariants(op string) {}
@@ -0,0 +1,85 @@
+//go:build invariants
+// +build invariants
+
+package tracker
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Building with -tags invariants checks the tracker's internal state after
+// every operation, and panics on the first inconsistency.  Every check
+// looks at the offset the watermark waits on and walks the whole pending
+// set while it is small.  Past fullCheckUpTo offsets a walk only comes
+// once as many operations have gone by as there were offsets pending at
+// the last, so a long run with a large pending set costs a few times what
+// it would without the checks rather than growing with its square.  It is
+// for tests and canaries, not for anything that cares about throughput:
+//
+//	go test -tags invariants ./...
+const invariants = true
+
+// fullCheckUpTo is the most offsets pending for every check to walk them
+const fullCheckUpTo = 256
+
+// checkInvariants panics unless the watermark hasn't gone back since the
+// last check, no pending or absent offset is at or below it, the offset it
+// waits on is neither, the absent ranges are sorted and disjoint, and only
+// pending offsets have metadata.  op names the operation for the panic
+// message, only SeekTo and Reset may move the watermark back.
+func (t *Tracker) checkInvariants(op string) {
+	c := t.watermark()
+	fail := func(format string, args ...interface{}) {
+		panic(fmt.Sprintf("tracker invariant broken after %s at watermark %d: %s", op, c, fmt.Sprintf(format, args...)))
+	}
+	if c < t.checked && op != "SeekTo" && op != "Reset" {
+		fail("watermark went back from %d", t.checked)
+	}
+	t.checked = c
+	if t.pending.Has(c + 1) {
+		fail("offset %d is pending", c+1)
+	}
+	if t.inHole(c + 1) {
+		fail("offset %d is absent", c+1)
+	}
+	if t.checks > 0 {
+		t.checks--
+		return
+	}
+	if n := t.pending.Len(); n > fullCheckUpTo {
+		t.checks = int64(n)
+	}
+	offsets := t.pending.Offsets()
+	if len(offsets) != t.pending.Len() {
+		fail("backend has %d offsets but says %d", len(offsets), t.pending.Len())
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	if len(offsets) > 0 {
+		if offsets[0] <= c+1 {
+			fail("offset %d is pending", offsets[0])
+		}
+		if t.pending.Lowest() != offsets[0] {
+			fail("backend's lowest is %d, not %d", t.pending.Lowest(), offsets[0])
+		}
+	}
+	prev := c
+	for _, h := range t.holes {
+		if h.From <= prev+1 || h.To < h.From {
+			// the first hole can't start at c+1 either, the watermark
+			// would have stepped over it
+			fail("absent range %v after %d", h, prev)
+		}
+		prev = h.To
+	}
+	for _, o := range offsets {
+		if t.inHole(o) {
+			fail("offset %d is both pending and absent", o)
+		}
+	}
+	for o := range t.meta {
+		if !t.pending.Has(o) {
+			fail("offset %d has metadata but isn't pending", o)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package tracker
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anishathalye/porcupine"
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+// The tracker's concurrency contract is a single acking goroutine with
+// Committed callable from anywhere, the SyncTracker's any number of acking
+// goroutines.  The tests here record histories of exactly that and check
+// them with porcupine against a sequential model, so a watermark published
+// before its acks, or one that goes stale, shows up as a history that
+// can't be linearized.
+
+// linearOffsets is how many offsets a history acks, the model keeps them
+// in a fixed size bitmask so that its states are values
+const linearOffsets = 128
+
+type linearState [linearOffsets / 64]uint64
+
+type linearInput struct {
+	// ack is the offset acked, or -1 for a call to Committed
+	ack int64
+}
+
+var trackerModel = porcupine.Model{
+	Init: func() interface{} { return linearState{} },
+	Step: func(state, input, output interface{}) (bool, interface{}) {
+		s, in := state.(linearState), input.(linearInput)
+		if in.ack >= 0 {
+			s[in.ack/64] |= 1 << (in.ack % 64)
+			return true, s
+		}
+		c := int64(-1)
+		for c+1 < linearOffsets && s[(c+1)/64]&(1<<((c+1)%64)) != 0 {
+			c++
+		}
+		return output.(int64) == c, s
+	},
+}
+
+// linearHistory records ackers goroutines sharing out a shuffle of the
+// offsets between them and acking them with ack, while readers call
+// committed
+func linearHistory(seed int64, ackers int, ack func(offset int64), committed func() int64) []porcupine.Operation {
+	const readers, reads = 4, 200
+	offsets := rand.New(rand.NewSource(seed)).Perm(linearOffsets)
+
+	start := time.Now()
+	now := func() int64 { return int64(time.Since(start)) }
+	var mu sync.Mutex
+	var history []porcupine.Operation
+	record := func(op porcupine.Operation) {
+		mu.Lock()
+		history = append(history, op)
+		mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(ackers + readers)
+	for i := 0; i < ackers; i++ {
+		go func(client int) {
+			defer wg.Done()
+			for j := client; j < len(offsets); j += ackers {
+				o := int64(offsets[j])
+				call := now()
+				ack(o)
+				record(porcupine.Operation{ClientId: client, Input: linearInput{ack: o}, Call: call, Return: now()})
+			}
+		}(i)
+	}
+	for i := ackers; i < ackers+readers; i++ {
+		go func(client int) {
+			defer wg.Done()
+			for j := 0; j < reads; j++ {
+				call := now()
+				c := committed()
+				record(porcupine.Operation{ClientId: client, Input: linearInput{ack: -1}, Output: c, Call: call, Return: now()})
+			}
+		}(i)
+	}
+	wg.Wait()
+	return history
+}
+
+func TestCommittedIsLinearizable(t *testing.T) {
+	for _, name := range backend.Names() {
+		for seed := int64(1); seed <= 5; seed++ {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			history := linearHistory(seed, 1, func(o int64) { tracker.Ack(o) }, tracker.Committed)
+			if res := porcupine.CheckOperationsTimeout(trackerModel, history, 10*time.Second); res == porcupine.Illegal {
+				t.Errorf("%s, seed %d: history of %d operations isn't linearizable", name, seed, len(history))
+			}
+		}
+	}
+}
+
+// TestSyncIsLinearizable has several goroutines ack on a SyncTracker at
+// once
+func TestSyncIsLinearizable(t *testing.T) {
+	for _, name := range backend.Names() {
+		for seed := int64(1); seed <= 5; seed++ {
+			b, _ := backend.New(name, 0)
+			tracker := NewSync(New(b, -1))
+			history := linearHistory(seed, 4, func(o int64) { tracker.Ack(o) }, tracker.Committed)
+			if res := porcupine.CheckOperationsTimeout(trackerModel, history, 10*time.Second); res == porcupine.Illegal {
+				t.Errorf("%s, seed %d: history of %d operations isn't linearizable", name, seed, len(history))
+			}
+		}
+	}
+}
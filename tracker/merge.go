@@ -0,0 +1,140 @@
+package tracker
+
+import (
+	"fmt"
+
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+// Merge folds other's state into t, as when a revoked instance hands its
+// state to the partition's new owner.  Afterwards t has acked every offset
+// either of them had acked and knows every offset either had declared
+// absent, and its watermark is as far as that allows: at least the higher
+// of the two, further if the pending offsets carry on from there.  Merge
+// bypasses Budget and MaxInFlight, it is an error for an offset to be
+// acked in one and absent in the other.  Like Ack it must be called from
+// t's acking goroutine, and nothing may touch other meanwhile, which is
+// left as it was.
+func (t *Tracker) Merge(other *Tracker) error {
+	if invariants {
+		defer t.checkInvariants("Merge")
+	}
+	// check both ways round before changing anything
+	for _, o := range other.pending.Offsets() {
+		if t.inHole(o) {
+			return fmt.Errorf("offset %d is acked in one tracker and absent in the other", o)
+		}
+	}
+	for _, o := range t.pending.Offsets() {
+		if other.inHole(o) {
+			return fmt.Errorf("offset %d is acked in one tracker and absent in the other", o)
+		}
+	}
+
+	if oc := other.watermark(); oc > t.watermark() {
+		// everything up to other's watermark is done
+		t.SeekTo(oc)
+	}
+	c := t.watermark()
+	for _, h := range other.holes {
+		if h.To <= c {
+			continue
+		}
+		if h.From <= c {
+			h.From = c + 1
+		}
+		t.holes = addRange(t.holes, h)
+	}
+	for _, o := range other.pending.Offsets() {
+		if o > c {
+			t.pending.Add(o)
+		}
+	}
+	for o, meta := range other.meta {
+		if _, ok := t.meta[o]; !ok && o > c {
+			if t.meta == nil {
+				t.meta = make(map[int64]interface{})
+			}
+			t.meta[o] = meta
+		}
+	}
+	if other.highest > t.highest {
+		t.highest = other.highest
+	}
+	t.setCommitted(t.advance(c+1) - 1)
+	return nil
+}
+
+// Split is the inverse of Merge: it hands everything from offset at on
+// over to a new tracker on backend b, for a new owner or a second worker to
+// take responsibility for.  t keeps the offsets below at, acks from at on
+// belong to the new tracker, whose watermark starts at at-1, or t's if that
+// is already past it.  The new tracker has none of t's settings.  Like Ack
+// it must be called from t's acking goroutine.
+func (t *Tracker) Split(at int64, b backend.Backend) *Tracker {
+	if invariants {
+		defer t.checkInvariants("Split")
+	}
+	c := t.watermark()
+	start := at - 1
+	if c > start {
+		start = c
+	}
+	upper := New(b, start)
+
+	// backends can only be emptied from the bottom, so take everything
+	// out and put back what stays
+	offsets := t.pending.Offsets()
+	for t.pending.Len() > 0 {
+		t.pending.Advance(t.pending.Lowest())
+	}
+	for _, o := range offsets {
+		if o < at {
+			t.pending.Add(o)
+			continue
+		}
+		upper.pending.Add(o)
+		if o > upper.highest {
+			upper.highest = o
+		}
+	}
+	var lower []Range
+	for _, h := range t.holes {
+		if h.From < at {
+			l := h
+			if l.To >= at {
+				l.To = at - 1
+			}
+			lower = append(lower, l)
+		}
+		if h.To >= at {
+			if h.From < at {
+				h.From = at
+			}
+			upper.holes = append(upper.holes, h)
+		}
+	}
+	t.holes = lower
+	for o, meta := range t.meta {
+		if o >= at {
+			if upper.meta == nil {
+				upper.meta = make(map[int64]interface{})
+			}
+			upper.meta[o] = meta
+			delete(t.meta, o)
+		}
+	}
+	if t.highest >= at {
+		t.highest = at - 1
+		if c > t.highest {
+			t.highest = c
+		}
+	}
+	// with t's watermark past at, ranges may start right after start
+	upper.setCommitted(upper.advance(start+1) - 1)
+	if upper.watermark() > upper.highest {
+		upper.highest = upper.watermark()
+	}
+	upper.checked = upper.watermark()
+	return upper
+}
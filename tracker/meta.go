@@ -0,0 +1,46 @@
+package tracker
+
+// AckMeta is Ack with metadata for offset, e.g. the worker that processed
+// it, its key or a trace ID.  It is kept for as long as offset is pending,
+// so that Meta and DebugDump can say what is waiting behind a gap, and
+// dropped once the watermark passes it.  An offset acked again while
+// pending gets the new metadata.  Metadata costs a map entry per pending
+// offset on top of the backend, and isn't part of snapshots.
+func (t *Tracker) AckMeta(offset int64, meta interface{}) error {
+	if err := t.Ack(offset); err != nil {
+		return err
+	}
+	if meta == nil || offset <= t.watermark() || !t.pending.Has(offset) {
+		return nil
+	}
+	if t.meta == nil {
+		t.meta = make(map[int64]interface{})
+	}
+	t.meta[offset] = meta
+	return nil
+}
+
+// Meta returns the metadata offset was acked with by AckMeta, ok is false
+// if it had none or the watermark has passed it.  Like Ack it must be
+// called from the acking goroutine.
+func (t *Tracker) Meta(offset int64) (meta interface{}, ok bool) {
+	meta, ok = t.meta[offset]
+	return meta, ok
+}
+
+// dropMeta forgets the metadata of the offsets in (from, to], the
+// watermark having moved from from to to.  A short move deletes its
+// offsets one by one, a long one scans the metadata instead.
+func (t *Tracker) dropMeta(from, to int64) {
+	if to-from <= int64(len(t.meta)) {
+		for o := from + 1; o <= to; o++ {
+			delete(t.meta, o)
+		}
+		return
+	}
+	for o := range t.meta {
+		if o <= to {
+			delete(t.meta, o)
+		}
+	}
+}
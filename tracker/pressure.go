@@ -0,0 +1,51 @@
+package tracker
+
+// PressureEvent reports the tracker crossing its pressure thresholds.  An
+// adapter would pause fetching from the broker when pressure starts and
+// resume when it clears.
+type PressureEvent struct {
+	// On is true when pressure starts and false when it clears
+	On bool
+	// Pending is the size of the pending set at the time
+	Pending int
+	// Lag is how far the highest acked offset is above the watermark
+	Lag int64
+}
+
+// Pressure returns the channel pressure events are sent on.  Pressure
+// starts once the pending set reaches PendingHigh or the lag reaches
+// LagHigh, and clears once both are below half of their threshold, so a
+// consumer hovering around a threshold doesn't flap between paused and
+// running.
+//
+// The channel only holds the latest event: Ack never blocks on it, and an
+// event nobody has received yet is replaced by the next one.
+func (t *Tracker) Pressure() <-chan PressureEvent {
+	return t.pressure
+}
+
+// checkPressure is called by Ack whenever the state may have changed
+func (t *Tracker) checkPressure(committed int64) {
+	if t.PendingHigh == 0 && t.LagHigh == 0 {
+		return
+	}
+	pending, lag := t.pending.Len(), t.highest-committed
+	over := func(v, high int64) bool { return high > 0 && v >= high }
+	under := func(v, high int64) bool { return high == 0 || v < high/2 }
+	switch {
+	case !t.pressured && (over(int64(pending), int64(t.PendingHigh)) || over(lag, t.LagHigh)):
+		t.pressured = true
+	case t.pressured && under(int64(pending), int64(t.PendingHigh)) && under(lag, t.LagHigh):
+		t.pressured = false
+	default:
+		return
+	}
+	ev := PressureEvent{On: t.pressured, Pending: pending, Lag: lag}
+	// only Ack sends, so once the stale event is out of the way there
+	// is room for this one
+	select {
+	case <-t.pressure:
+	default:
+	}
+	t.pressure <- ev
+}
@@ -0,0 +1,62 @@
+package tracker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ideasculptor/offsets_test/backend"
+	"pgregory.net/rapid"
+)
+
+// watermarkOf is the definition the tracker has to live up to: the largest
+// n such that every offset <= n was acked, starting from committed
+func watermarkOf(acked map[int64]bool, committed int64) int64 {
+	for acked[committed+1] {
+		committed++
+	}
+	return committed
+}
+
+// TestWatermarkProperties checks every backend against the definition of
+// the watermark for random ack sequences, redeliveries included
+func TestWatermarkProperties(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			rapid.Check(t, func(rt *rapid.T) {
+				n := rapid.Int64Range(1, 300).Draw(rt, "n")
+				acks := rapid.SliceOfN(rapid.Int64Range(0, n-1), 0, 600).Draw(rt, "acks")
+
+				b, _ := backend.New(name, 0)
+				tracker := New(b, -1)
+				acked := make(map[int64]bool)
+				last := tracker.Committed()
+				for _, o := range acks {
+					if err := tracker.Ack(o); err != nil {
+						rt.Fatalf("ack %d: %v", o, err)
+					}
+					acked[o] = true
+					c := tracker.Committed()
+					if c < last {
+						rt.Fatalf("watermark went back from %d to %d acking %d", last, c, o)
+					}
+					if want := watermarkOf(acked, -1); c != want {
+						rt.Fatalf("watermark %d after acking %d, want %d", c, o, want)
+					}
+					last = c
+				}
+
+				// the same acks in any other order end up in the same
+				// state
+				shuffled := rapid.Permutation(acks).Draw(rt, "shuffled")
+				b2, _ := backend.New(name, 0)
+				other := New(b2, -1)
+				for _, o := range shuffled {
+					other.Ack(o)
+				}
+				if got, want := other.Snapshot(), tracker.Snapshot(); !reflect.DeepEqual(got, want) {
+					rt.Fatalf("reordered acks give %+v, want %+v", got, want)
+				}
+			})
+		})
+	}
+}
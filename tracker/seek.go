@@ -0,0 +1,77 @@
+package tracker
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// There is no Seek(offset): go vet expects any method called Seek to have
+// io.Seeker's signature, so it is called SeekTo.
+
+// SeekTo moves the watermark to committed, for reprocessing or skipping
+// ahead.  Pending offsets at or below it are dropped and those above it
+// are kept, so if they continue on from committed the watermark moves on
+// through them.  Going back forgets the event times observed, the
+// messages are to be observed again as they are redelivered.  Like Ack it
+// must be called from the acking goroutine.
+func (t *Tracker) SeekTo(committed int64) {
+	if invariants {
+		defer t.checkInvariants("SeekTo")
+	}
+	back := committed < t.watermark()
+	next := committed + 1
+	if t.pending.Len() > 0 {
+		offsets := t.pending.Offsets()
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		for _, o := range offsets {
+			if o > committed {
+				break
+			}
+			// advance removes the whole run starting at o, which
+			// may carry on past committed
+			if t.pending.Has(o) {
+				if end := t.pending.Advance(o); end > next {
+					next = end
+				}
+			}
+		}
+	}
+	if committed > t.highest {
+		t.highest = committed
+	}
+	if back {
+		t.resetEvents(committed)
+	}
+	t.setCommitted(t.advance(next) - 1)
+	if !back {
+		t.dropCommitted()
+	}
+}
+
+// Reset forgets everything the tracker has been told since it was made:
+// the watermark goes back to where New or Restore started it, and pending
+// offsets and their metadata, absent ranges, holds, the offsets Seen and
+// the event times observed, skipped offsets and gaps and the ack and
+// duplicate counts are all dropped.  With SeekTo after it, it starts over
+// from anywhere.  Like Ack it must be called from the acking goroutine.
+func (t *Tracker) Reset() {
+	if invariants {
+		defer t.checkInvariants("Reset")
+	}
+	// backends can only be emptied from the bottom
+	for t.pending.Len() > 0 {
+		t.pending.Advance(t.pending.Lowest())
+	}
+	t.Compact()
+	t.holes, t.skipped, t.gaps, t.meta, t.holds = nil, nil, nil, nil, nil
+	t.setHoldAt()
+	t.resetEvents(t.start)
+	if t.Seen != nil {
+		t.Seen = t.Seen.empty()
+	}
+	atomic.StoreInt64(&t.duplicates, 0)
+	t.acks = 0
+	t.highest, t.notified = t.start, t.start
+	t.pressured = false
+	t.setCommitted(t.start)
+}
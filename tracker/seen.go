@@ -0,0 +1,170 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// A restarted consumer gets redelivered whatever the broker had not
+// committed, and a consumer that seeks back gets everything again.  The
+// watermark alone only says an offset below it was acked, so a tracker
+// given a SeenFilter also remembers the offsets acked recently in a bloom
+// filter, snapshots included, for SeenBefore to tell an application which
+// messages it has probably handled already.
+
+// SeenFilter is a bloom filter over the last offsets acked, about window
+// of them.  It is two filters of window/2 offsets each: acks go into the
+// current one, and once that is full it replaces the previous one and a
+// new one is started, so the oldest half of the window is forgotten at
+// once rather than one offset at a time, which a bloom filter can't.
+// Like the tracker it belongs to it is not safe for concurrent use.
+type SeenFilter struct {
+	// k is the number of hashes per offset, half the number of offsets
+	// per generation
+	k    int
+	half int64
+	// cur takes the acks, n counting them, prev is the generation before
+	cur, prev []uint64
+	n         int64
+}
+
+// NewSeenFilter returns a filter for the last window offsets acked that
+// says an offset that wasn't acked was with probability falsePositive
+func NewSeenFilter(window int64, falsePositive float64) *SeenFilter {
+	half := window / 2
+	if half < 1 {
+		half = 1
+	}
+	// the usual sizing for a bloom filter of half offsets, both
+	// generations together get about twice the false positives
+	bits := math.Ceil(-float64(half) * math.Log(falsePositive/2) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(bits / float64(half) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	words := (int(bits) + 63) / 64
+	return &SeenFilter{k: k, half: half, cur: make([]uint64, words), prev: make([]uint64, words)}
+}
+
+// Add records offset as acked
+func (f *SeenFilter) Add(offset int64) {
+	if f.n == f.half {
+		f.prev, f.cur = f.cur, f.prev
+		for i := range f.cur {
+			f.cur[i] = 0
+		}
+		f.n = 0
+	}
+	bits := uint64(len(f.cur)) * 64
+	h1, h2 := seenHashes(offset)
+	for i := 0; i < f.k; i++ {
+		b := (h1 + uint64(i)*h2) % bits
+		f.cur[b/64] |= 1 << (b % 64)
+	}
+	f.n++
+}
+
+// Has reports whether offset was probably added within the window, it is
+// never false for one that was
+func (f *SeenFilter) Has(offset int64) bool {
+	return f.has(f.cur, offset) || f.has(f.prev, offset)
+}
+
+func (f *SeenFilter) has(gen []uint64, offset int64) bool {
+	bits := uint64(len(gen)) * 64
+	h1, h2 := seenHashes(offset)
+	for i := 0; i < f.k; i++ {
+		b := (h1 + uint64(i)*h2) % bits
+		if gen[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a copy of f that goes its own way
+func (f *SeenFilter) Clone() *SeenFilter {
+	c := *f
+	c.cur = append([]uint64(nil), f.cur...)
+	c.prev = append([]uint64(nil), f.prev...)
+	return &c
+}
+
+// empty returns a filter sized like f with nothing in it
+func (f *SeenFilter) empty() *SeenFilter {
+	return &SeenFilter{k: f.k, half: f.half, cur: make([]uint64, len(f.cur)), prev: make([]uint64, len(f.prev))}
+}
+
+// seenHashes returns the two hashes of offset the filter's k are made
+// from, splitmix64 being plenty for offsets that count up
+func seenHashes(offset int64) (uint64, uint64) {
+	mix := func(x uint64) uint64 {
+		x += 0x9e3779b97f4a7c15
+		x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+		x = (x ^ x>>27) * 0x94d049bb133111eb
+		return x ^ x>>31
+	}
+	h1 := mix(uint64(offset))
+	// odd, so that the k bits don't cycle early
+	return h1, mix(h1) | 1
+}
+
+// seenJSON is how a SeenFilter goes into a snapshot, the generations as
+// little-endian words, base64 encoded
+type seenJSON struct {
+	K    int    `json:"k"`
+	Half int64  `json:"half"`
+	N    int64  `json:"n"`
+	Cur  []byte `json:"cur"`
+	Prev []byte `json:"prev"`
+}
+
+// MarshalJSON implements json.Marshaler, for Snapshot.Seen
+func (f *SeenFilter) MarshalJSON() ([]byte, error) {
+	words := func(gen []uint64) []byte {
+		b := make([]byte, 8*len(gen))
+		for i, w := range gen {
+			binary.LittleEndian.PutUint64(b[8*i:], w)
+		}
+		return b
+	}
+	return json.Marshal(seenJSON{K: f.k, Half: f.half, N: f.n, Cur: words(f.cur), Prev: words(f.prev)})
+}
+
+// errBadSeenFilter is returned for a snapshot's filter that no
+// NewSeenFilter could have made
+var errBadSeenFilter = errors.New("bad seen filter")
+
+// UnmarshalJSON implements json.Unmarshaler, it fails with
+// errBadSeenFilter for a filter MarshalJSON couldn't have written
+func (f *SeenFilter) UnmarshalJSON(data []byte) error {
+	var s seenJSON
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s.K < 1 || s.Half < 1 || s.N < 0 || s.N > s.Half || len(s.Cur) == 0 || len(s.Cur)%8 != 0 || len(s.Prev) != len(s.Cur) {
+		return errBadSeenFilter
+	}
+	words := func(b []byte) []uint64 {
+		gen := make([]uint64, len(b)/8)
+		for i := range gen {
+			gen[i] = binary.LittleEndian.Uint64(b[8*i:])
+		}
+		return gen
+	}
+	*f = SeenFilter{k: s.K, half: s.Half, n: s.N, cur: words(s.Cur), prev: words(s.Prev)}
+	return nil
+}
+
+// SeenBefore reports whether offset has probably been acked before: it is
+// at or below the watermark, pending, or in Seen, which may be wrong about
+// an offset that never was at the rate Seen was made with.  Like Ack it
+// must be called from the acking goroutine.
+func (t *Tracker) SeenBefore(offset int64) bool {
+	if offset <= t.watermark() || t.pending.Has(offset) {
+		return true
+	}
+	return t.Seen != nil && t.Seen.Has(offset)
+}
@@ -0,0 +1,127 @@
+package tracker
+
+import (
+	"sort"
+
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+// Range is an inclusive range of offsets
+type Range struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// Snapshot is the complete state of a tracker: the watermark and every
+// acked offset above it.  Pending offsets are stored as ranges since acks
+// tend to arrive in runs.
+type Snapshot struct {
+	Committed int64   `json:"committed"`
+	Pending   []Range `json:"pending,omitempty"`
+	// Holes are the offsets above the watermark declared absent
+	Holes []Range `json:"holes,omitempty"`
+	// Seen is the tracker's SeenFilter, if it has one
+	Seen *SeenFilter `json:"seen,omitempty"`
+}
+
+// toRanges collapses a list of distinct offsets into sorted ranges
+func toRanges(offsets []int64) []Range {
+	if len(offsets) == 0 {
+		return nil
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	ranges := []Range{{From: offsets[0], To: offsets[0]}}
+	for _, o := range offsets[1:] {
+		last := &ranges[len(ranges)-1]
+		if o == last.To+1 {
+			last.To = o
+		} else {
+			ranges = append(ranges, Range{From: o, To: o})
+		}
+	}
+	return ranges
+}
+
+// Snapshot captures the tracker state.  Like Ack it must be called from the
+// acking goroutine.
+func (t *Tracker) Snapshot() Snapshot {
+	s := Snapshot{
+		Committed: t.watermark(),
+		Pending:   toRanges(t.pending.Offsets()),
+		Holes:     append([]Range(nil), t.holes...),
+	}
+	if t.Seen != nil {
+		// the copy is as big as the window, but the snapshot may be
+		// saved from another goroutine while the acks go on
+		s.Seen = t.Seen.Clone()
+	}
+	if held := t.Committed(); held < s.Committed {
+		// the acks past a hold are only pending to whoever restores
+		// the snapshot, holds aren't part of it
+		s.Pending = append([]Range{{From: held + 1, To: s.Committed}}, s.Pending...)
+		s.Committed = held
+	}
+	return s
+}
+
+// Restore returns a tracker with the state from s, using b to store
+// the pending offsets.
+func Restore(b backend.Backend, s Snapshot) *Tracker {
+	t := New(b, s.Committed)
+	for _, r := range s.Pending {
+		for o := r.From; o <= r.To; o++ {
+			if o > s.Committed {
+				b.Add(o)
+			}
+		}
+	}
+	t.holes = append([]Range(nil), s.Holes...)
+	if s.Seen != nil {
+		t.Seen = s.Seen.Clone()
+	}
+	if n := len(s.Pending); n > 0 {
+		t.highest = s.Pending[n-1].To
+	}
+	// a snapshot never has the offset after the watermark pending, but
+	// it doesn't hurt to be sure
+	t.committed = t.advance(s.Committed+1) - 1
+	if t.committed > t.highest {
+		t.highest = t.committed
+	}
+	t.checked, t.advancedFrom = t.committed, t.committed
+	t.checkInvariants("Restore")
+	return t
+}
+
+// Gaps returns the first limit ranges missing from s between the
+// watermark and the highest pending offset, and how many there are in all.
+// Offsets declared absent aren't waited for, so they aren't gaps.
+func (s Snapshot) Gaps(limit int) ([]Range, int) {
+	var gaps []Range
+	n := 0
+	holes := s.Holes
+	next := s.Committed + 1
+	for _, r := range s.Pending {
+		for from := next; from < r.From; {
+			// step over the holes between next and r.From
+			for len(holes) > 0 && holes[0].To < from {
+				holes = holes[1:]
+			}
+			to := r.From - 1
+			if len(holes) > 0 && holes[0].From <= from {
+				from = holes[0].To + 1
+				continue
+			}
+			if len(holes) > 0 && holes[0].From <= to {
+				to = holes[0].From - 1
+			}
+			if n < limit {
+				gaps = append(gaps, Range{From: from, To: to})
+			}
+			n++
+			from = to + 1
+		}
+		next = r.To + 1
+	}
+	return gaps, n
+}
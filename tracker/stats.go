@@ -0,0 +1,39 @@
+package tracker
+
+import "time"
+
+// Stats is a summary of a tracker for logging, see Tracker.Stats
+type Stats struct {
+	Committed int64 `json:"committed"`
+	// Highest is the highest offset acked so far
+	Highest int64 `json:"highest"`
+	Pending int   `json:"pending"`
+	// Gaps counts the ranges of offsets the watermark is waiting on, and
+	// OldestGapAge is how long it has been waiting at the lowest of them
+	Gaps         int           `json:"gaps"`
+	OldestGapAge time.Duration `json:"oldest_gap_age"`
+	// Acks counts every ack taken, Duplicates those of them that changed
+	// nothing
+	Acks       int64 `json:"acks"`
+	Duplicates int64 `json:"duplicates"`
+}
+
+// Stats returns a summary of the tracker as of now.  With nothing pending
+// it takes no more than reading a few fields, otherwise it counts the gaps
+// like Snapshot does, which is cheap enough to log every few seconds but
+// doesn't belong on the ack path.  Like Ack it must be called from the
+// acking goroutine.
+func (t *Tracker) Stats() Stats {
+	s := Stats{
+		Committed:  t.Committed(),
+		Highest:    t.highest,
+		Pending:    t.pending.Len(),
+		Acks:       t.acks,
+		Duplicates: t.Duplicates(),
+	}
+	if s.Pending > 0 {
+		_, s.Gaps = t.Snapshot().Gaps(0)
+		s.OldestGapAge = t.now().Sub(t.heldSince)
+	}
+	return s
+}
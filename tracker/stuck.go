@@ -0,0 +1,65 @@
+package tracker
+
+import (
+	"fmt"
+	"time"
+)
+
+// StuckPolicy is what Expire does with an offset that has held up the
+// watermark for longer than the tracker's Deadline
+type StuckPolicy int
+
+const (
+	// StuckBlock keeps waiting for the offset, the expiry is only
+	// reported
+	StuckBlock StuckPolicy = iota
+	// StuckSkip gives up on the offset as if it had been acked and
+	// records it in Skipped
+	StuckSkip
+	// StuckDeadLetter publishes the offset to the DLQ and skips it once
+	// that succeeds, a failed publish is retried on the next Expire
+	StuckDeadLetter
+)
+
+// Expire applies the stuck policy if the offset the watermark is waiting
+// on has been blocking it since before now-Deadline, and reports whether
+// the offset was given up on.  Only an offset with acks piled up behind it
+// counts as blocking.  Like Ack it must be called from the acking
+// goroutine, typically on a timer.
+//
+// The offset after a skipped one gets a full Deadline of its own, as the
+// tracker can't tell how long it has really been outstanding.
+func (t *Tracker) Expire(now time.Time) (bool, error) {
+	if invariants {
+		defer t.checkInvariants("Expire")
+	}
+	stuck := now.Sub(t.heldSince)
+	if t.Deadline <= 0 || t.pending.Len() == 0 || stuck < t.Deadline {
+		return false, nil
+	}
+	offset := t.watermark() + 1
+	if t.OnExpire != nil && offset != t.notified {
+		t.OnExpire(offset, stuck)
+	}
+	t.notified = offset
+	switch t.StuckPolicy {
+	case StuckBlock:
+		return false, nil
+	case StuckDeadLetter:
+		if t.DLQ == nil {
+			return false, fmt.Errorf("offset %d expired with no dead letter queue", offset)
+		}
+		if err := t.DLQ.Publish(DeadLetter{Offset: offset, Reason: ReasonDeadline}); err != nil {
+			return false, fmt.Errorf("dead lettering offset %d: %w", offset, err)
+		}
+	}
+	t.skipped = append(t.skipped, offset)
+	t.setCommitted(t.advance(offset+1) - 1)
+	return true, nil
+}
+
+// Skipped returns the offsets Expire gave up on, oldest first.  Like Ack it
+// must be called from the acking goroutine.
+func (t *Tracker) Skipped() []int64 {
+	return t.skipped
+}
@@ -0,0 +1,240 @@
+package tracker
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// SyncTracker is a Tracker any number of goroutines may ack on at once,
+// for workers that ack directly instead of funnelling their acks into one
+// goroutine.  Every call but Committed, HeldAt, Generation and Duplicates
+// takes a lock, a cost the plain Tracker spares a consumer that funnels
+// its acks.  It shares the backends, hooks and snapshots of the Tracker it
+// wraps.
+type SyncTracker struct {
+	mu sync.Mutex
+	t  *Tracker
+}
+
+// NewSync wraps t, which is set up as the caller wants it and mustn't be
+// used other than through the SyncTracker or its Do from then on
+func NewSync(t *Tracker) *SyncTracker {
+	return &SyncTracker{t: t}
+}
+
+// Do runs f with the tracker locked, for whatever SyncTracker doesn't
+// wrap, e.g. changing its settings or merging another tracker into it.
+// f must not keep t.
+func (s *SyncTracker) Do(f func(t *Tracker)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.t)
+}
+
+// Ack is Tracker.Ack
+func (s *SyncTracker) Ack(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Ack(offset)
+}
+
+// AckMeta is Tracker.AckMeta
+func (s *SyncTracker) AckMeta(offset int64, meta interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.AckMeta(offset, meta)
+}
+
+// AckGeneration is Tracker.AckGeneration
+func (s *SyncTracker) AckGeneration(generation, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.AckGeneration(generation, offset)
+}
+
+// Nack is Tracker.Nack, DLQ is published to with the lock held
+func (s *SyncTracker) Nack(offset int64, payload interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Nack(offset, payload)
+}
+
+// Absent is Tracker.Absent
+func (s *SyncTracker) Absent(r Range) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Absent(r)
+}
+
+// Fence is Tracker.Fence
+func (s *SyncTracker) Fence(generation int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Fence(generation)
+}
+
+// Expire is Tracker.Expire
+func (s *SyncTracker) Expire(now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Expire(now)
+}
+
+// SkipStalled is Tracker.SkipStalled
+func (s *SyncTracker) SkipStalled(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.SkipStalled(now)
+}
+
+// CheckStall is Tracker.CheckStall
+func (s *SyncTracker) CheckStall(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.CheckStall(now)
+}
+
+// Compact is Tracker.Compact, the lock is held while the backend copies
+func (s *SyncTracker) Compact() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Compact()
+}
+
+// SeekTo is Tracker.SeekTo
+func (s *SyncTracker) SeekTo(committed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.SeekTo(committed)
+}
+
+// Reset is Tracker.Reset
+func (s *SyncTracker) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Reset()
+}
+
+// Hold is Tracker.Hold
+func (s *SyncTracker) Hold(offset int64) (*Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Hold(offset)
+}
+
+// Release is Tracker.Release
+func (s *SyncTracker) Release(h *Hold) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Release(h)
+}
+
+// HeldAt is Tracker.HeldAt, it doesn't wait for the lock
+func (s *SyncTracker) HeldAt() (int64, bool) {
+	return s.t.HeldAt()
+}
+
+// SeenBefore is Tracker.SeenBefore
+func (s *SyncTracker) SeenBefore(offset int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.SeenBefore(offset)
+}
+
+// Observe is Tracker.Observe, messages observed by different goroutines
+// must still be observed in offset order
+func (s *SyncTracker) Observe(offset int64, eventTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Observe(offset, eventTime)
+}
+
+// EventTime is Tracker.EventTime
+func (s *SyncTracker) EventTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.EventTime()
+}
+
+// Committed is Tracker.Committed, it doesn't wait for the lock
+func (s *SyncTracker) Committed() int64 {
+	return s.t.Committed()
+}
+
+// Generation is Tracker.Generation, it doesn't wait for the lock
+func (s *SyncTracker) Generation() int64 {
+	return s.t.Generation()
+}
+
+// Duplicates is Tracker.Duplicates, it doesn't wait for the lock
+func (s *SyncTracker) Duplicates() int64 {
+	return s.t.Duplicates()
+}
+
+// Pressure is Tracker.Pressure
+func (s *SyncTracker) Pressure() <-chan PressureEvent {
+	return s.t.Pressure()
+}
+
+// Pending is Tracker.Pending
+func (s *SyncTracker) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Pending()
+}
+
+// Meta is Tracker.Meta
+func (s *SyncTracker) Meta(offset int64) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Meta(offset)
+}
+
+// Snapshot is Tracker.Snapshot
+func (s *SyncTracker) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Snapshot()
+}
+
+// Stats is Tracker.Stats
+func (s *SyncTracker) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Stats()
+}
+
+// Gaps is a copy of Tracker.Gaps
+func (s *SyncTracker) Gaps() []Range {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Range(nil), s.t.Gaps()...)
+}
+
+// Holes is a copy of Tracker.Holes
+func (s *SyncTracker) Holes() []Range {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Range(nil), s.t.Holes()...)
+}
+
+// Skipped is a copy of Tracker.Skipped
+func (s *SyncTracker) Skipped() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.t.Skipped()...)
+}
+
+func (s *SyncTracker) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.String()
+}
+
+// DebugDump is Tracker.DebugDump
+func (s *SyncTracker) DebugDump(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.DebugDump(w)
+}
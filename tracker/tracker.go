@@ -0,0 +1,300 @@
+// Package tracker turns the acks of a partition's messages, which arrive
+// in whatever order the messages finished processing, into the watermark
+// that is safe to commit.
+package tracker
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+// Tracker turns out-of-order acks into a commit watermark: the largest
+// offset n such that every offset <= n has been acked.  Ack must only be
+// called from a single goroutine, but Committed may be called from
+// anywhere.  SyncTracker wraps one for acking from many goroutines.
+type Tracker struct {
+	pending backend.Backend
+	// committed is accessed atomically so that other goroutines can
+	// watch progress without going through the acking goroutine
+	committed int64
+	// duplicates counts acks for offsets that were already acked, it is
+	// accessed atomically too
+	duplicates int64
+	// generation is accessed atomically as well, see Fence
+	generation int64
+
+	// Budget is the most memory in bytes the pending offsets may need,
+	// as estimated by the backend's Live.  Once it is reached, acks that
+	// would add to the pending set are refused with ErrTryAgain.  Zero
+	// means no limit.
+	Budget int64
+	// MaxInFlight bounds the reorder window: acks for offsets more than
+	// MaxInFlight above the watermark are refused with ErrWindowExceeded.
+	// Zero means no limit.
+	MaxInFlight int64
+	// PendingHigh and LagHigh are the thresholds for pressure events, see
+	// Pressure.  Zero disables either.
+	PendingHigh int
+	LagHigh     int64
+	// Deadline is how long an offset may hold up the watermark before
+	// Expire applies StuckPolicy to it, zero means forever.  OnExpire,
+	// if set, is called for every offset that expires.
+	Deadline    time.Duration
+	StuckPolicy StuckPolicy
+	OnExpire    func(offset int64, stuck time.Duration)
+	// DLQ receives offsets that are nacked or expire under
+	// StuckDeadLetter, see Nack
+	DLQ DeadLetterProducer
+	// MaxLag and MaxStall give up on at-least-once for a bounded lag: a
+	// gap is skipped once the highest ack is more than MaxLag above the
+	// watermark, or the watermark has waited on it for MaxStall, see
+	// SkipStalled.  Zero disables either.  OnGapSkip, if set, is called
+	// with every range skipped.
+	MaxLag    int64
+	MaxStall  time.Duration
+	OnGapSkip func(gap Range)
+	// Seen, if set, remembers the offsets acked recently for
+	// SeenBefore, and goes into snapshots with them
+	Seen *SeenFilter
+	// Clock is where the tracker gets the time from for Deadline,
+	// MaxStall and StallAfter, the real clock if nil
+	Clock Clock
+	// Hooks are called as the watermark moves, gaps open and progress
+	// stalls
+	Hooks Hooks
+
+	// notified is the last offset OnExpire was called for, skipped the
+	// offsets given up on and gaps the ranges skipped for lagging
+	notified int64
+	skipped  []int64
+	gaps     []Range
+	// holes are the sorted ranges above the watermark declared absent
+	holes []Range
+	// highest is the highest offset acked so far
+	highest int64
+	// start is the watermark the tracker was made with, Reset goes back
+	// to it
+	start int64
+	// checked is the watermark at the last checkInvariants, and checks
+	// counts down the ones left until the next that walks everything
+	checked   int64
+	checks    int64
+	pressured bool
+	pressure  chan PressureEvent
+
+	// advancedFrom is the watermark OnAdvance was last called with,
+	// stalled is set once OnStall has been called for the current stall
+	advancedFrom int64
+	stalled      bool
+	// acks counts the acks taken, heldSince is when the watermark
+	// started waiting on the gap it is at, see Stats
+	acks      int64
+	heldSince time.Time
+	// meta is what pending offsets were acked with by AckMeta, nil
+	// until it is first called
+	meta map[int64]interface{}
+	// holds cap the watermark, see Hold, and holdAt is the lowest of
+	// them for Committed, it is accessed atomically
+	holds  []*Hold
+	holdAt int64
+	// events are the messages observed above the watermark that may
+	// still be the earliest, in offset order, see Observe.
+	// lastObserved is the highest offset observed and latestEvent the
+	// latest event time.
+	events       []observed
+	lastObserved int64
+	latestEvent  time.Time
+}
+
+// ErrTryAgain is returned by Ack when the pending set is over budget.  The
+// ack wasn't recorded: the caller should hold on to it, stop taking on new
+// work, and ack it again once the watermark has moved.  The offset the
+// watermark is waiting on is never refused, so progress is always possible.
+var ErrTryAgain = errors.New("pending set is over budget, try again later")
+
+// ErrWindowExceeded is returned by Ack when the offset is beyond
+// MaxInFlight.  It is an ErrTryAgain as well, to errors.Is, and is to be
+// handled the same way.
+var ErrWindowExceeded error = tryAgainError("offset is beyond the reorder window, try again later")
+
+// tryAgainError is a refusal that errors.Is also matches as ErrTryAgain
+type tryAgainError string
+
+func (e tryAgainError) Error() string { return string(e) }
+
+func (e tryAgainError) Is(target error) bool { return target == ErrTryAgain }
+
+// ErrOffsetBelowWatermark is returned for a commit of an offset the
+// broker has already committed past
+var ErrOffsetBelowWatermark = errors.New("offset is below the watermark")
+
+// New returns a tracker whose watermark starts at committed, so the
+// first offset it expects is committed + 1.
+func New(b backend.Backend, committed int64) *Tracker {
+	return &Tracker{
+		pending:   b,
+		committed: committed,
+		highest:   committed,
+		start:     committed,
+		pressure:  make(chan PressureEvent, 1),
+		heldSince: time.Now(),
+		notified:  committed,
+		checked:   committed,
+		holdAt:    noHold,
+
+		advancedFrom: committed,
+		lastObserved: committed,
+	}
+}
+
+// Ack marks offset as processed and advances the watermark as far as the
+// acked offsets allow.  It doesn't allocate once the backend has grown to
+// the reorder window, the benchmarks in tracker_test.go hold it to that.
+// The only errors are ErrTryAgain, when Budget is set, and
+// ErrWindowExceeded, when MaxInFlight is.
+//
+// Acking an offset that is already committed or pending, as happens when
+// messages are redelivered after a rebalance, changes nothing but the
+// count returned by Duplicates.
+func (t *Tracker) Ack(offset int64) error {
+	if invariants {
+		defer t.checkInvariants("Ack")
+	}
+	t.acks++
+	c := atomic.LoadInt64(&t.committed)
+	if offset == c+1 {
+		if t.Seen != nil {
+			t.Seen.Add(offset)
+		}
+		// the common case: the offset the watermark is waiting on can't
+		// be pending, so skip storing it only to remove it again
+		if offset > t.highest {
+			t.highest = offset
+		}
+		next := t.advance(offset + 1)
+		t.setCommitted(next - 1)
+		return nil
+	}
+	if offset <= c {
+		atomic.AddInt64(&t.duplicates, 1)
+		return nil
+	}
+	if len(t.holes) > 0 && t.inHole(offset) {
+		atomic.AddInt64(&t.duplicates, 1)
+		return nil
+	}
+	if err := t.admit(offset, c); err != nil {
+		// a redelivery of a pending offset costs nothing, so it's
+		// ignored rather than refused
+		if t.pending.Has(offset) {
+			atomic.AddInt64(&t.duplicates, 1)
+			return nil
+		}
+		// it will be counted when it is acked again
+		t.acks--
+		return err
+	}
+	if !t.pending.Add(offset) {
+		atomic.AddInt64(&t.duplicates, 1)
+		return nil
+	}
+	if t.Seen != nil {
+		t.Seen.Add(offset)
+	}
+	if offset > t.highest {
+		if offset > t.highest+1 {
+			t.opened(offset)
+		}
+		t.highest = offset
+	}
+	// iterate the pending set from committed + 1, looking for
+	// sequential values that can be committed
+	next := t.advance(c + 1)
+	if next != c+1 {
+		t.setCommitted(next - 1)
+	} else {
+		if t.pending.Len() == 1 {
+			// the first gap since the watermark last moved
+			t.heldSince = t.now()
+		}
+		t.checkPressure(c)
+	}
+	if t.MaxLag > 0 && t.highest-t.watermark() > t.MaxLag {
+		t.skipLagging()
+	}
+	return nil
+}
+
+// admit returns ErrWindowExceeded or ErrTryAgain if offset, which is above
+// c+1, may not be added to the pending set
+func (t *Tracker) admit(offset, c int64) error {
+	if t.MaxInFlight > 0 && offset-c > t.MaxInFlight {
+		return ErrWindowExceeded
+	}
+	if t.Budget > 0 && t.pending.Live() >= t.Budget {
+		return ErrTryAgain
+	}
+	return nil
+}
+
+// setCommitted moves the watermark to committed
+func (t *Tracker) setCommitted(committed int64) {
+	if len(t.meta) > 0 {
+		if from := atomic.LoadInt64(&t.committed); committed > from {
+			t.dropMeta(from, committed)
+		}
+	}
+	atomic.StoreInt64(&t.committed, committed)
+	if t.pending.Len() > 0 {
+		t.heldSince = t.now()
+	}
+	t.advanced(committed)
+	t.checkPressure(committed)
+}
+
+func (t *Tracker) now() time.Time {
+	return orReal(t.Clock).Now()
+}
+
+// SetClock sets Clock and restarts the time the watermark has been held
+// up for on it, New and Restore having read the real clock.  Like Ack it
+// must be called from the acking goroutine.
+func (t *Tracker) SetClock(c Clock) {
+	t.Clock = c
+	t.heldSince = t.now()
+}
+
+// Committed returns the current watermark, capped by the lowest Hold
+func (t *Tracker) Committed() int64 {
+	c := t.watermark()
+	if h := atomic.LoadInt64(&t.holdAt); h < c {
+		return h
+	}
+	return c
+}
+
+// Duplicates returns the number of acks that were ignored because their
+// offset had already been acked
+func (t *Tracker) Duplicates() int64 {
+	return atomic.LoadInt64(&t.duplicates)
+}
+
+// Pending returns the number of acked offsets waiting on a gap.  Like Ack
+// it must be called from the acking goroutine.
+func (t *Tracker) Pending() int {
+	return t.pending.Len()
+}
+
+// Compact asks the backend to give back memory left over from when more
+// offsets were pending, and reports whether it did.  Like Ack it must be
+// called from the acking goroutine, and as it may copy the whole pending
+// set it belongs on a timer rather than the ack path.
+func (t *Tracker) Compact() bool {
+	if c, ok := t.pending.(backend.Compacter); ok {
+		return c.Compact()
+	}
+	return false
+}
@@ -0,0 +1,988 @@
+package tracker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ideasculptor/offsets_test/backend"
+)
+
+// ackOrders are the patterns the hot path is measured with.  Acks arrive in
+// blocks of window offsets, each block in reverse, so all but one ack per
+// block lands in the pending set.  A window of 1 is perfectly in order.
+var ackOrders = []struct {
+	name   string
+	window int64
+}{
+	{"inorder", 1},
+	{"pairs", 2},
+	{"window64", 64},
+	{"window4096", 4096},
+}
+
+// ackOffset returns the i'th offset acked when acks are reversed in blocks
+// of window
+func ackOffset(i, window int64) int64 {
+	return i/window*window + window - 1 - i%window
+}
+
+func BenchmarkAck(b *testing.B) {
+	for _, name := range backend.Names() {
+		for _, order := range ackOrders {
+			b.Run(fmt.Sprintf("%s/%s", name, order.name), func(b *testing.B) {
+				backend, err := backend.New(name, int(order.window))
+				if err != nil {
+					b.Fatal(err)
+				}
+				t := New(backend, -1)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := int64(0); i < int64(b.N); i++ {
+					t.Ack(ackOffset(i, order.window))
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkAckReversed acks b.N offsets from the last down, so every ack
+// but the last lands in the pending set
+func BenchmarkAckReversed(b *testing.B) {
+	for _, name := range backend.Names() {
+		b.Run(name, func(b *testing.B) {
+			backend, _ := backend.New(name, b.N)
+			t := New(backend, -1)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := int64(b.N) - 1; i >= 0; i-- {
+				t.Ack(i)
+			}
+		})
+	}
+}
+
+// BenchmarkAckRandom acks blocks of 4096 offsets, each in a random order
+func BenchmarkAckRandom(b *testing.B) {
+	const window = 4096
+	perm := rand.New(rand.NewSource(1)).Perm(window)
+	for _, name := range backend.Names() {
+		b.Run(name, func(b *testing.B) {
+			backend, _ := backend.New(name, window)
+			t := New(backend, -1)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := int64(0); i < int64(b.N); i++ {
+				t.Ack(i/window*window + int64(perm[i%window]))
+			}
+		})
+	}
+}
+
+// BenchmarkAdvance measures the backend on its own moving the watermark
+// through a run of 4096 pending offsets, each op is one offset
+func BenchmarkAdvance(b *testing.B) {
+	const run = 4096
+	for _, name := range backend.Names() {
+		b.Run(name, func(b *testing.B) {
+			backend, _ := backend.New(name, run)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := int64(0); i < int64(b.N); i += run {
+				b.StopTimer()
+				for o := i + 1; o < i+run; o++ {
+					backend.Add(o)
+				}
+				b.StartTimer()
+				backend.Advance(i + 1)
+			}
+		})
+	}
+}
+
+// pendingTracker returns a tracker waiting on offset 0 with every other
+// offset of the next 2*n pending, so its snapshot has n ranges
+func pendingTracker(name string, n int64) *Tracker {
+	backend, _ := backend.New(name, int(2*n))
+	t := New(backend, -1)
+	for o := int64(1); o < 2*n; o += 2 {
+		t.Ack(o)
+	}
+	return t
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	for _, name := range backend.Names() {
+		b.Run(name, func(b *testing.B) {
+			t := pendingTracker(name, 10000)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				t.Snapshot()
+			}
+		})
+	}
+}
+
+func BenchmarkRestore(b *testing.B) {
+	for _, name := range backend.Names() {
+		b.Run(name, func(b *testing.B) {
+			snap := pendingTracker(name, 10000).Snapshot()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				backend, _ := backend.New(name, 0)
+				Restore(backend, snap)
+			}
+		})
+	}
+}
+
+// TestAckDoesNotAllocate fails if Ack allocates once the backend has seen a
+// few reorder windows' worth of acks
+func TestAckDoesNotAllocate(t *testing.T) {
+	if invariants {
+		t.Skip("the invariant checks allocate")
+	}
+	for _, name := range backend.Names() {
+		for _, order := range ackOrders {
+			backend, err := backend.New(name, int(order.window))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracker := New(backend, -1)
+			var i int64
+			ack := func() {
+				tracker.Ack(ackOffset(i, order.window))
+				i++
+			}
+			for i < 4*order.window {
+				ack()
+			}
+			if allocs := testing.AllocsPerRun(int(100*order.window), ack); allocs != 0 {
+				t.Errorf("%s/%s: %v allocs per ack, want 0", name, order.name, allocs)
+			}
+		}
+	}
+}
+
+// ackAll acks every offset in order and fails the test on an error
+func ackAll(t *testing.T, tracker *Tracker, offsets ...int64) {
+	t.Helper()
+	for _, o := range offsets {
+		if err := tracker.Ack(o); err != nil {
+			t.Fatalf("ack %d: %v", o, err)
+		}
+	}
+}
+
+// span returns the offsets from..to
+func span(from, to int64) []int64 {
+	var offsets []int64
+	for o := from; o <= to; o++ {
+		offsets = append(offsets, o)
+	}
+	return offsets
+}
+
+// TestRedeliveryAfterRebalance hands a partition to a new owner that
+// restores the old owner's snapshot, while the broker redelivers from a
+// watermark it learned of before the last commit
+func TestRedeliveryAfterRebalance(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			old := New(b, -1)
+			ackAll(t, old, span(0, 9)...)
+			ackAll(t, old, span(12, 15)...)
+			snap := old.Snapshot()
+
+			b, _ = backend.New(name, 0)
+			tracker := Restore(b, snap)
+			// the broker only had 4 committed, so 5-9 come again as
+			// well as the pending 12-15
+			ackAll(t, tracker, span(5, 15)...)
+			if got := tracker.Duplicates(); got != 9 {
+				t.Errorf("duplicates = %d, want 9", got)
+			}
+			if got := tracker.Committed(); got != 15 {
+				t.Errorf("committed = %d, want 15", got)
+			}
+			if got := tracker.Pending(); got != 0 {
+				t.Errorf("pending = %d, want 0", got)
+			}
+		})
+	}
+}
+
+// TestRedeliveryIsNoOp replays every ack a second time mid-stream and
+// checks the tracker ends up exactly where it would have without them
+func TestRedeliveryIsNoOp(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			once := New(b, -1)
+			b, _ = backend.New(name, 0)
+			twice := New(b, -1)
+			var acked []int64
+			for i := int64(0); i < 1000; i++ {
+				o := ackOffset(i, 64)
+				if o%7 == 0 {
+					// leave gaps so some duplicates hit the pending set
+					continue
+				}
+				acked = append(acked, o)
+				ackAll(t, once, o)
+				ackAll(t, twice, o)
+				if i%100 == 99 {
+					ackAll(t, twice, acked...)
+				}
+			}
+			if got, want := twice.Snapshot(), once.Snapshot(); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("snapshot with redeliveries = %v, want %v", got, want)
+			}
+			if once.Duplicates() != 0 || twice.Duplicates() == 0 {
+				t.Errorf("duplicates = %d and %d, want 0 and more", once.Duplicates(), twice.Duplicates())
+			}
+		})
+	}
+}
+
+// TestDuplicateNotRefused checks a redelivered pending offset is ignored
+// even when new acks are being refused
+func TestDuplicateNotRefused(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			ackAll(t, tracker, 10)
+			tracker.MaxInFlight = 5
+			if err := tracker.Ack(11); !errors.Is(err, ErrWindowExceeded) {
+				t.Errorf("ack 11 = %v, want ErrWindowExceeded", err)
+			}
+			if err := tracker.Ack(10); err != nil {
+				t.Errorf("redelivered ack 10 = %v, want nil", err)
+			}
+			if got := tracker.Duplicates(); got != 1 {
+				t.Errorf("duplicates = %d, want 1", got)
+			}
+		})
+	}
+}
+
+// TestAbsent declares a transaction marker and a compacted gap, before and
+// after the acks around them arrive
+func TestAbsent(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			ackAll(t, tracker, span(0, 4)...)
+			ackAll(t, tracker, 6, 7, 8)
+			// the marker at 5 is declared after 6-8 are pending
+			if err := tracker.Absent(Range{From: 5, To: 5}); err != nil {
+				t.Fatal(err)
+			}
+			if got := tracker.Committed(); got != 8 {
+				t.Errorf("committed = %d, want 8", got)
+			}
+			// the compacted gap is declared before the ack after it
+			if err := tracker.Absent(Range{From: 9, To: 99}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tracker.Absent(Range{From: 101, To: 101}); err != nil {
+				t.Fatal(err)
+			}
+			ackAll(t, tracker, 50, 100, 102)
+			if got := tracker.Committed(); got != 102 {
+				t.Errorf("committed = %d, want 102", got)
+			}
+			if got := tracker.Duplicates(); got != 1 {
+				t.Errorf("duplicates = %d, want 1 for the ack inside the gap", got)
+			}
+			if err := tracker.Absent(Range{From: 110, To: 120}); err != nil {
+				t.Fatal(err)
+			}
+			ackAll(t, tracker, 105)
+			if err := tracker.Absent(Range{From: 104, To: 106}); err == nil {
+				t.Error("declaring acked offset 105 absent succeeded")
+			}
+			if got, want := fmt.Sprint(tracker.Holes()), "[{110 120}]"; got != want {
+				t.Errorf("holes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAddRange(t *testing.T) {
+	var ranges []Range
+	for _, r := range []Range{{10, 12}, {20, 20}, {0, 2}, {14, 15}, {13, 13}, {3, 4}, {30, 40}, {16, 31}} {
+		ranges = addRange(ranges, r)
+	}
+	if got, want := fmt.Sprint(ranges), "[{0 4} {10 40}]"; got != want {
+		t.Errorf("ranges = %v, want %v", got, want)
+	}
+}
+
+func TestSeekTo(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			ackAll(t, tracker, 0, 1, 5, 6, 7, 10, 20)
+			// 5-7 are dropped, 8 is the next offset expected
+			tracker.SeekTo(7)
+			if got := tracker.Committed(); got != 7 {
+				t.Errorf("committed = %d, want 7", got)
+			}
+			// 10 carries on from 9, 20 doesn't
+			tracker.SeekTo(9)
+			if got := tracker.Committed(); got != 10 {
+				t.Errorf("committed = %d, want 10", got)
+			}
+			tracker.SeekTo(2)
+			ackAll(t, tracker, span(3, 19)...)
+			if got := tracker.Committed(); got != 20 {
+				t.Errorf("committed after reprocessing = %d, want 20", got)
+			}
+			if got := tracker.Pending(); got != 0 {
+				t.Errorf("pending = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			ackAll(t, tracker, span(0, 4)...)
+			ackAll(t, tracker, 8, 30)
+			ob, _ := backend.New(name, 0)
+			other := New(ob, -1)
+			ackAll(t, other, span(0, 6)...)
+			ackAll(t, other, 9, 20)
+			if err := other.Absent(Range{From: 21, To: 25}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tracker.Merge(other); err != nil {
+				t.Fatal(err)
+			}
+			// other's watermark, and nothing carries on from it yet
+			if got := tracker.Committed(); got != 6 {
+				t.Errorf("committed = %d, want 6", got)
+			}
+			ackAll(t, tracker, 7)
+			ackAll(t, tracker, span(10, 19)...)
+			ackAll(t, tracker, span(26, 29)...)
+			if got := tracker.Committed(); got != 30 {
+				t.Errorf("committed = %d, want 30", got)
+			}
+			if got := tracker.Pending(); got != 0 {
+				t.Errorf("pending = %d, want 0", got)
+			}
+
+			cb, _ := backend.New(name, 0)
+			conflicting := New(cb, 30)
+			if err := conflicting.Absent(Range{From: 40, To: 40}); err != nil {
+				t.Fatal(err)
+			}
+			ackAll(t, tracker, 40)
+			if err := tracker.Merge(conflicting); err == nil {
+				t.Error("merging acked offset 40 with it absent succeeded")
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			ackAll(t, tracker, 0, 1, 5, 6, 10, 11, 25)
+			if err := tracker.Absent(Range{From: 8, To: 12}); err == nil {
+				t.Fatal("declaring acked offsets absent succeeded")
+			}
+			if err := tracker.Absent(Range{From: 15, To: 22}); err != nil {
+				t.Fatal(err)
+			}
+			ub, _ := backend.New(name, 0)
+			upper := tracker.Split(10, ub)
+			if got := upper.Committed(); got != 11 {
+				t.Errorf("upper committed = %d, want 11", got)
+			}
+			if got, want := fmt.Sprint(upper.Snapshot()), "{11 [{25 25}] [{15 22}] <nil>}"; got != want {
+				t.Errorf("upper snapshot = %v, want %v", got, want)
+			}
+			if got, want := fmt.Sprint(tracker.Snapshot()), "{1 [{5 6}] [] <nil>}"; got != want {
+				t.Errorf("lower snapshot = %v, want %v", got, want)
+			}
+			// each finishes its own range
+			ackAll(t, tracker, 2, 3, 4, 7, 8, 9)
+			if got := tracker.Committed(); got != 9 {
+				t.Errorf("lower committed = %d, want 9", got)
+			}
+			ackAll(t, upper, 12, 13, 14, 23, 24)
+			if got := upper.Committed(); got != 25 {
+				t.Errorf("upper committed = %d, want 25", got)
+			}
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			ackAll(t, tracker, 0, 1, 5, 6, 10)
+			fork := tracker.Clone()
+			ackAll(t, fork, 2, 3, 4)
+			if got := fork.Committed(); got != 6 {
+				t.Errorf("fork committed = %d, want 6", got)
+			}
+			if got, want := fmt.Sprint(tracker.Snapshot()), "{1 [{5 6} {10 10}] [] <nil>}"; got != want {
+				t.Errorf("acking the fork changed the original to %v, want %v", got, want)
+			}
+			// a fork of a fork, then the original moves on
+			second := fork.Clone()
+			ackAll(t, tracker, span(2, 9)...)
+			if got := tracker.Committed(); got != 10 {
+				t.Errorf("committed = %d, want 10", got)
+			}
+			for _, f := range []*Tracker{fork, second} {
+				if got, want := fmt.Sprint(f.Snapshot()), "{6 [{10 10}] [] <nil>}"; got != want {
+					t.Errorf("acking the original changed a fork to %v, want %v", got, want)
+				}
+			}
+			ackAll(t, second, 7, 8, 9)
+			if got := fork.Committed(); got != 6 {
+				t.Errorf("acking the second fork moved the first to %d", got)
+			}
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, 9)
+			ackAll(t, tracker, span(10, 20)...)
+			ackAll(t, tracker, 15, 25, 30)
+			if err := tracker.Absent(Range{From: 40, To: 50}); err != nil {
+				t.Fatal(err)
+			}
+			tracker.Reset()
+			if got, want := fmt.Sprint(tracker.Snapshot()), "{9 [] [] <nil>}"; got != want {
+				t.Errorf("snapshot after Reset = %v, want %v", got, want)
+			}
+			if got := tracker.Duplicates(); got != 0 {
+				t.Errorf("duplicates = %d, want 0", got)
+			}
+			// everything is processed again
+			ackAll(t, tracker, span(10, 25)...)
+			if got := tracker.Committed(); got != 25 {
+				t.Errorf("committed = %d, want 25", got)
+			}
+			if got := tracker.Duplicates(); got != 0 {
+				t.Errorf("duplicates after reprocessing = %d, want 0", got)
+			}
+		})
+	}
+}
+
+// TestGenerationFencing has a zombie keep acking for its old generation
+// after the new owner has taken over
+func TestGenerationFencing(t *testing.T) {
+	tracker := New(backend.NewMap(0), -1)
+	if err := tracker.AckGeneration(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	// the new owner arrives with generation 2
+	if err := tracker.AckGeneration(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	var stale *StaleGenerationError
+	if err := tracker.AckGeneration(1, 2); !errors.As(err, &stale) || stale.Current != 2 {
+		t.Errorf("zombie ack = %v, want a stale generation error", err)
+	}
+	if got := tracker.Committed(); got != 1 {
+		t.Errorf("committed = %d, want 1, the zombie's ack must not count", got)
+	}
+	if !errors.Is(stale, ErrStaleGeneration) {
+		t.Errorf("%v is not ErrStaleGeneration", stale)
+	}
+	if err := tracker.Fence(1); !errors.As(err, &stale) {
+		t.Errorf("fencing back to generation 1 = %v, want a stale generation error", err)
+	}
+}
+
+// TestSyncTracker acks from many goroutines at once, each taking every
+// workers'th offset, on every backend
+func TestSyncTracker(t *testing.T) {
+	const workers, n = 8, 10000
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			s := NewSync(New(b, -1))
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for offset := int64(w); offset < n; offset += workers {
+						if err := s.Ack(offset); err != nil {
+							t.Error(err)
+							return
+						}
+						if offset%1000 == int64(w) {
+							s.Stats()
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			if got := s.Committed(); got != n-1 {
+				t.Errorf("committed %d, want %d", got, n-1)
+			}
+			if got := s.Pending(); got != 0 {
+				t.Errorf("%d pending, want 0", got)
+			}
+		})
+	}
+}
+
+// TestStats follows the summary through a gap opening and closing
+func TestStats(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	b, _ := backend.New("map", 0)
+	tracker := New(b, -1)
+	tracker.Clock = clock
+	ackAll(t, tracker, 0, 1, 4, 5, 7, 1)
+	clock.Advance(3 * time.Second)
+	want := Stats{Committed: 1, Highest: 7, Pending: 3, Gaps: 2, OldestGapAge: 3 * time.Second, Acks: 6, Duplicates: 1}
+	if got := tracker.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	// the watermark moves on to the next gap, which it has only just
+	// started waiting at
+	ackAll(t, tracker, 2, 3)
+	clock.Advance(time.Second)
+	want = Stats{Committed: 5, Highest: 7, Pending: 1, Gaps: 1, OldestGapAge: time.Second, Acks: 8, Duplicates: 1}
+	if got := tracker.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	ackAll(t, tracker, 6)
+	want = Stats{Committed: 7, Highest: 7, Acks: 9, Duplicates: 1}
+	if got := tracker.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+// TestTrackerString renders the pending ranges compactly, and only the
+// first few of many
+func TestTrackerString(t *testing.T) {
+	b, _ := backend.New("map", 0)
+	tracker := New(b, 1039)
+	ackAll(t, tracker, 1040, 1042, 1043, 1044, 1100)
+	if got, want := tracker.String(), "committed=1040 pending=[1042-1044, 1100]"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+	for o := int64(1200); o < 1220; o += 2 {
+		ackAll(t, tracker, o)
+	}
+	if got, want := tracker.String(), "committed=1040 pending=[1042-1044, 1100, 1200, 1202, 1204, 1206, 1208, 1210, … 4 more]"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+	var dump strings.Builder
+	tracker.DebugDump(&dump)
+	for _, line := range []string{"committed 1040, highest acked 1218", "gaps (12): 1041, 1045-1099, 1101-1199, 1201,", "waiting at 1041 for"} {
+		if !strings.Contains(dump.String(), line) {
+			t.Errorf("dump has no %q:\n%s", line, dump.String())
+		}
+	}
+}
+
+// TestAckMeta keeps metadata while its offset is pending, through a merge
+// and a split, and drops it once the watermark passes
+func TestAckMeta(t *testing.T) {
+	tracker := New(backend.NewMap(0), -1)
+	for _, o := range []int64{0, 2, 3, 5} {
+		if err := tracker.AckMeta(o, fmt.Sprintf("worker %d", o%2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := tracker.Meta(0); ok {
+		t.Error("offset 0 was committed straight away but has metadata")
+	}
+	if meta, ok := tracker.Meta(2); !ok || meta != "worker 0" {
+		t.Errorf("meta of 2 = %v, %v, want worker 0", meta, ok)
+	}
+	var dump strings.Builder
+	tracker.DebugDump(&dump)
+	for _, line := range []string{"waiting at 1 for 0s, 2 behind it: worker 0", "metadata (3):\n  2: worker 0\n  3: worker 1\n  5: worker 1\n"} {
+		if !strings.Contains(dump.String(), line) {
+			t.Errorf("dump has no %q:\n%s", line, dump.String())
+		}
+	}
+
+	upper := tracker.Split(4, backend.NewMap(0))
+	if _, ok := tracker.Meta(5); ok {
+		t.Error("5 went to the upper tracker but its metadata stayed")
+	}
+	if meta, _ := upper.Meta(5); meta != "worker 1" {
+		t.Errorf("upper's meta of 5 = %v, want worker 1", meta)
+	}
+	if err := tracker.Merge(upper); err != nil {
+		t.Fatal(err)
+	}
+	// a redelivery while pending replaces the metadata
+	if err := tracker.AckMeta(5, "worker 2"); err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := tracker.Meta(5); meta != "worker 2" {
+		t.Errorf("meta of 5 = %v after the redelivery, want worker 2", meta)
+	}
+	ackAll(t, tracker, 1)
+	if _, ok := tracker.Meta(3); ok || tracker.Committed() != 3 {
+		t.Errorf("committed %d, metadata of 3 kept: %v", tracker.Committed(), ok)
+	}
+	tracker.Reset()
+	if _, ok := tracker.Meta(5); ok {
+		t.Error("Reset kept the metadata of 5")
+	}
+}
+
+// TestHold keeps the watermark at the lowest hold while the acks go on,
+// and lets it catch up with them once the holds are released
+func TestHold(t *testing.T) {
+	tracker := New(backend.NewMap(0), -1)
+	ackAll(t, tracker, span(0, 4)...)
+	if _, err := tracker.Hold(3); !errors.Is(err, ErrOffsetBelowWatermark) {
+		t.Errorf("hold below the watermark = %v, want ErrOffsetBelowWatermark", err)
+	}
+	low, err := tracker.Hold(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, _ := tracker.Hold(14)
+	ackAll(t, tracker, span(5, 20)...)
+	if got := tracker.Committed(); got != 9 {
+		t.Errorf("committed %d, want held at 9", got)
+	}
+	snap := tracker.Snapshot()
+	if want := (Snapshot{Committed: 9, Pending: []Range{{10, 20}}}); !reflect.DeepEqual(snap, want) {
+		t.Errorf("snapshot %+v, want %+v", snap, want)
+	}
+	if got := Restore(backend.NewMap(0), snap).Committed(); got != 20 {
+		t.Errorf("restored committed %d, want 20 as holds aren't kept", got)
+	}
+	tracker.Release(low)
+	tracker.Release(low)
+	if at, ok := tracker.HeldAt(); !ok || at != 14 || tracker.Committed() != 14 {
+		t.Errorf("held at %d, %v, committed %d, want all 14", at, ok, tracker.Committed())
+	}
+	tracker.Release(high)
+	if _, ok := tracker.HeldAt(); ok || tracker.Committed() != 20 {
+		t.Errorf("committed %d, held %v after releasing everything, want 20", tracker.Committed(), ok)
+	}
+}
+
+// TestFakeClock fires timers and tickers only as the clock is advanced
+// past them, dropping the ticks nobody read
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	timer := clock.NewTimer(time.Second)
+	ticker := clock.NewTicker(300 * time.Millisecond)
+	fired := func(c <-chan time.Time) bool {
+		select {
+		case <-c:
+			return true
+		default:
+			return false
+		}
+	}
+	clock.Advance(999 * time.Millisecond)
+	if fired(timer.C()) {
+		t.Error("timer fired early")
+	}
+	if !fired(ticker.C()) || fired(ticker.C()) {
+		t.Error("ticker should have one tick waiting, the other two dropped")
+	}
+	clock.Advance(time.Millisecond)
+	if !fired(timer.C()) {
+		t.Error("timer didn't fire")
+	}
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	if fired(ticker.C()) || fired(timer.C()) {
+		t.Error("stopped ticker or spent timer fired")
+	}
+}
+
+// TestSeenBefore remembers the acks of the last window through a snapshot
+// and a seek back, and forgets the ones before it
+func TestSeenBefore(t *testing.T) {
+	const window = 1000
+	tracker := New(backend.NewMap(0), -1)
+	tracker.Seen = NewSeenFilter(window, 0.01)
+	ackAll(t, tracker, span(0, 2999)...)
+	data, err := json.Marshal(tracker.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+	restored := Restore(backend.NewMap(0), snap)
+	restored.SeekTo(-1)
+	for o := int64(3000 - window/2); o < 3000; o++ {
+		if !restored.SeenBefore(o) {
+			t.Fatalf("offset %d was acked but isn't seen", o)
+		}
+	}
+	// the first third has long left the window, all that is left of it
+	// is false positives
+	var seen int
+	for o := int64(0); o < window; o++ {
+		if restored.SeenBefore(o) {
+			seen++
+		}
+	}
+	if seen > window/20 {
+		t.Errorf("%d of the %d offsets out of the window seen, want about 1%%", seen, window)
+	}
+	if restored.SeenBefore(5000) {
+		t.Error("an offset never acked is seen")
+	}
+}
+
+// TestEventTime holds the event-time watermark at the earliest message not
+// yet committed, whatever order the event times come in
+func TestEventTime(t *testing.T) {
+	tracker := New(backend.NewMap(0), -1)
+	if got := tracker.EventTime(); !got.IsZero() {
+		t.Errorf("event time %v with nothing observed, want zero", got)
+	}
+	at := func(sec int64) time.Time { return time.Unix(1000+sec, 0) }
+	// offset 2 is the earliest event, 3 the latest
+	for o, sec := range []int64{5, 4, 1, 9, 6} {
+		tracker.Observe(int64(o), at(sec))
+	}
+	ackAll(t, tracker, 0, 1, 3)
+	if got := tracker.EventTime(); !got.Equal(at(1)) {
+		t.Errorf("event time %v, want 2's %v", got, at(1))
+	}
+	ackAll(t, tracker, 2)
+	if got := tracker.EventTime(); !got.Equal(at(6)) {
+		t.Errorf("event time %v, want 4's %v", got, at(6))
+	}
+	ackAll(t, tracker, 4)
+	if got := tracker.EventTime(); !got.Equal(at(9)) {
+		t.Errorf("event time %v with everything committed, want the latest %v", got, at(9))
+	}
+	// a redelivery changes nothing, a seek back forgets it all
+	tracker.Observe(3, at(0))
+	if got := tracker.EventTime(); !got.Equal(at(9)) {
+		t.Errorf("event time %v after a redelivery, want %v", got, at(9))
+	}
+	tracker.SeekTo(1)
+	tracker.Observe(2, at(1))
+	if got := tracker.EventTime(); !got.Equal(at(1)) {
+		t.Errorf("event time %v after seeking back, want the redelivered %v", got, at(1))
+	}
+}
+
+// TestEventTimeForward keeps the event times observed above the watermark
+// when a seek or a merge moves it forward
+func TestEventTimeForward(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(1000+sec, 0) }
+	observed := func() *Tracker {
+		tracker := New(backend.NewMap(0), -1)
+		for o := int64(0); o < 10; o++ {
+			tracker.Observe(o, at(o))
+		}
+		return tracker
+	}
+
+	tracker := observed()
+	ackAll(t, tracker, 0, 1)
+	tracker.SeekTo(4)
+	tracker.Observe(10, at(10))
+	if got := tracker.EventTime(); !got.Equal(at(5)) {
+		t.Errorf("event time %v after seeking forward, want 5's %v", got, at(5))
+	}
+
+	tracker = observed()
+	ackAll(t, tracker, 0, 1, 2)
+	if err := tracker.Merge(New(backend.NewMap(0), 3)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tracker.EventTime(); !got.Equal(at(4)) {
+		t.Errorf("event time %v after merging, want 4's %v", got, at(4))
+	}
+}
+
+// TestExpireAfterIdle counts an offset's Deadline from when it opened a
+// gap, not from when the watermark last moved: a tracker left idle for an
+// hour doesn't give up on the first offset to arrive out of order
+func TestExpireAfterIdle(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	tracker := New(backend.NewMap(0), -1)
+	tracker.SetClock(clock)
+	tracker.Deadline, tracker.StuckPolicy = time.Minute, StuckSkip
+	ackAll(t, tracker, span(0, 100)...)
+	clock.Advance(time.Hour)
+	ackAll(t, tracker, 102)
+	if skipped, err := tracker.Expire(clock.Now()); skipped || err != nil {
+		t.Fatalf("expire = %v, %v as the gap opens, want nothing skipped", skipped, err)
+	}
+	clock.Advance(time.Minute)
+	if skipped, err := tracker.Expire(clock.Now()); !skipped || err != nil {
+		t.Fatalf("expire = %v, %v a deadline after the gap opened, want 101 skipped", skipped, err)
+	}
+	if got := tracker.Committed(); got != 102 {
+		t.Errorf("committed %d, want 102", got)
+	}
+}
+
+// TestMaxStallAfterIdle counts MaxStall from when the gap opened, so a
+// tracker left idle doesn't skip a gap the moment it appears
+func TestMaxStallAfterIdle(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	tracker := New(backend.NewMap(0), -1)
+	tracker.SetClock(clock)
+	tracker.MaxStall = time.Minute
+	ackAll(t, tracker, 0)
+	clock.Advance(time.Hour)
+	ackAll(t, tracker, 2)
+	if tracker.SkipStalled(clock.Now()) {
+		t.Fatal("gap skipped as it opened")
+	}
+	clock.Advance(time.Minute)
+	if !tracker.SkipStalled(clock.Now()) {
+		t.Fatal("gap not skipped a MaxStall after it opened")
+	}
+	if got, want := tracker.Gaps(), []Range{{From: 1, To: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gaps %v, want %v", got, want)
+	}
+}
+
+// TestStallAfterIdle doesn't take a tracker left idle for stalled when the
+// first ack after the break lands out of order
+func TestStallAfterIdle(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	tracker := New(backend.NewMap(0), -1)
+	tracker.SetClock(clock)
+	var stalls []time.Duration
+	tracker.Hooks = Hooks{StallAfter: time.Minute, OnStall: func(committed int64, pending int, stuck time.Duration) {
+		stalls = append(stalls, stuck)
+	}}
+	ackAll(t, tracker, 0)
+	clock.Advance(time.Hour)
+	ackAll(t, tracker, 2)
+	if tracker.CheckStall(clock.Now()) {
+		t.Fatal("stalled as the gap opened")
+	}
+	clock.Advance(time.Minute)
+	if !tracker.CheckStall(clock.Now()) {
+		t.Fatal("not stalled a StallAfter after the gap opened")
+	}
+	if want := []time.Duration{time.Minute}; !reflect.DeepEqual(stalls, want) {
+		t.Errorf("stalls %v, want %v", stalls, want)
+	}
+}
+
+// TestBudgetRecovers takes acks again once the pending set that went over
+// Budget has drained, on every backend
+func TestBudgetRecovers(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			tracker.Budget = 1200
+			// every other offset, so nothing merges or advances
+			o := int64(1)
+			for ; ; o += 2 {
+				if o > 1<<20 {
+					t.Fatal("never over budget")
+				}
+				err := tracker.Ack(o)
+				if errors.Is(err, ErrTryAgain) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for e := int64(0); e < o; e += 2 {
+				ackAll(t, tracker, e)
+			}
+			if got := tracker.Pending(); got != 0 {
+				t.Fatalf("%d pending after the gaps filled", got)
+			}
+			if err := tracker.Ack(tracker.Committed() + 2); err != nil {
+				t.Errorf("ack with nothing pending = %v, want it taken", err)
+			}
+		})
+	}
+}
+
+// deadLetters records what is published to it
+type deadLetters []DeadLetter
+
+func (d *deadLetters) Publish(l DeadLetter) error {
+	*d = append(*d, l)
+	return nil
+}
+
+// TestNackAfterAck nacks offsets that are already acked, pending or
+// absent, none of them may be dead lettered or recorded as skipped
+func TestNackAfterAck(t *testing.T) {
+	for _, name := range backend.Names() {
+		t.Run(name, func(t *testing.T) {
+			b, _ := backend.New(name, 0)
+			tracker := New(b, -1)
+			var dlq deadLetters
+			tracker.DLQ = &dlq
+			ackAll(t, tracker, 0, 1, 3, 4)
+			if err := tracker.Absent(Range{From: 5, To: 6}); err != nil {
+				t.Fatal(err)
+			}
+			for _, o := range []int64{1, 3, 5} {
+				if err := tracker.Nack(o, nil); err != nil {
+					t.Fatalf("Nack(%d) = %v", o, err)
+				}
+			}
+			if len(dlq) != 0 {
+				t.Errorf("dead lettered %v, want nothing", dlq)
+			}
+			if got := tracker.Skipped(); len(got) != 0 {
+				t.Errorf("skipped %v, want nothing", got)
+			}
+			if got := tracker.Duplicates(); got != 3 {
+				t.Errorf("duplicates = %d, want 3", got)
+			}
+			// a real failure is still dead lettered
+			if err := tracker.Nack(2, "payload"); err != nil {
+				t.Fatal(err)
+			}
+			if len(dlq) != 1 || dlq[0].Offset != 2 {
+				t.Errorf("dead lettered %v, want offset 2", dlq)
+			}
+			if got := tracker.Committed(); got != 6 {
+				t.Errorf("committed = %d, want 6", got)
+			}
+		})
+	}
+}